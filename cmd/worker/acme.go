@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/certs"
+	"github.com/niquet/rate-limited-worker/internal/config"
+)
+
+// certsConfig maps the TLS and ACME settings onto the certificate store's
+func certsConfig(cfg *config.Config) certs.Config {
+	c := certs.Config{
+		CertFile:     cfg.TLSCertFile,
+		KeyFile:      cfg.TLSKeyFile,
+		ClientCAFile: cfg.TLSClientCAFile,
+	}
+	if cfg.ACMEEnabled() {
+		c.ACME = &certs.ACMEConfig{
+			Hosts:        cfg.ACMEHosts,
+			Email:        cfg.ACMEEmail,
+			CacheDir:     cfg.ACMECacheDir,
+			DirectoryURL: cfg.ACMEDirectoryURL,
+		}
+	}
+	return c
+}
+
+// newACMEServer answers HTTP-01 challenges on the ACME HTTP port and
+// redirects other GET and HEAD requests to the HTTPS port. Other methods
+// are refused, so clients do not take the redirect as a success.
+func newACMEServer(cfg *config.Config, certStore *certs.Store) *http.Server {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if cfg.Port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(cfg.Port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.ACMEHTTPPort),
+		Handler:           certStore.HTTPHandler(redirect),
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+	}
+}
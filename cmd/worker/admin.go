@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/certs"
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/listen"
+	"github.com/niquet/rate-limited-worker/internal/router"
+)
+
+// Listeners a route may be served on
+const (
+	listenerPublic = "public"
+	listenerAdmin  = "admin"
+)
+
+// onAdminListener reports whether route is served on the admin listener
+func onAdminListener(cfg *config.Config, route router.Route) bool {
+	listener, _ := route.Option(optionListener)
+	return cfg.AdminAddr != "" && listener == listenerAdmin
+}
+
+// splitRoutes separates the routes served on the admin listener from the
+// others. Without one, every route is public.
+func splitRoutes(cfg *config.Config, routes []router.Route) (public, admin []router.Route, err error) {
+	for _, route := range routes {
+		switch listener, _ := route.Option(optionListener); listener {
+		case "", listenerPublic, listenerAdmin:
+		default:
+			return nil, nil, fmt.Errorf("route %s: option %s must be %s or %s, got %q",
+				route.Path, optionListener, listenerPublic, listenerAdmin, listener)
+		}
+		if onAdminListener(cfg, route) {
+			admin = append(admin, route)
+		} else {
+			public = append(public, route)
+		}
+	}
+	return public, admin, nil
+}
+
+// openListener listens on the network and address a listener setting
+// names, taking systemd's socket from sockets
+func openListener(network, addr string, sockets *listen.Sockets) (net.Listener, error) {
+	switch network {
+	case "unix":
+		return listen.Unix(addr)
+	case "systemd":
+		if lis, ok := sockets.Take(addr); ok {
+			return lis, nil
+		}
+		// The main server also takes the first socket left, whatever its
+		// name
+		if addr == "http" {
+			if lis, ok := sockets.TakeFirst(); ok {
+				return lis, nil
+			}
+		}
+		return nil, fmt.Errorf("systemd passed no socket named %s", addr)
+	default:
+		return net.Listen(network, addr)
+	}
+}
+
+// newAdminServer serves the admin routes with the admin timeouts, over TLS
+// with the main server's certificate when it listens on TCP. Under ACME,
+// clients must then name one of its hosts.
+func newAdminServer(cfg *config.Config, handler http.Handler, certStore *certs.Store, lis net.Listener) *http.Server {
+	server := &http.Server{
+		Handler:        handler,
+		ReadTimeout:    time.Duration(cfg.AdminReadTimeout),
+		WriteTimeout:   time.Duration(cfg.AdminWriteTimeout),
+		IdleTimeout:    time.Duration(cfg.AdminIdleTimeout),
+		MaxHeaderBytes: int(cfg.MaxHeaderBytes),
+	}
+	if certStore != nil && lis.Addr().Network() == "tcp" {
+		server.TLSConfig = certStore.TLSConfig()
+	}
+	return server
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/alert"
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/sink"
+)
+
+// startAlerting evaluates the enabled alert rules until ctx is done.
+// Denials stand in for bans.
+func startAlerting(ctx context.Context, cfg *config.Config, svc *service.Service, sinks []*sink.Batcher) {
+	var rules []alert.Rule
+	if cfg.AlertErrorRate > 0 {
+		window, minRequests := time.Duration(cfg.AlertErrorRateWindow), int64(cfg.AlertErrorRateMinRequests)
+		rules = append(rules, alert.Rule{
+			Name:      "error_rate",
+			Summary:   fmt.Sprintf("share of requests answered 5xx over %s", cfg.AlertErrorRateWindow),
+			Threshold: cfg.AlertErrorRate,
+			Value: func() float64 {
+				// Too few requests make the share swing on single errors
+				rate, requests := svc.ErrorRate(window)
+				if requests < minRequests {
+					return 0
+				}
+				return rate
+			},
+		})
+	}
+	if cfg.AlertSinkQueueDepth > 0 {
+		for _, s := range sinks {
+			rules = append(rules, alert.Rule{
+				Name:      "sink_queue_" + s.Name(),
+				Summary:   fmt.Sprintf("events queued for the %s sink", s.Name()),
+				Threshold: float64(cfg.AlertSinkQueueDepth),
+				Value:     func() float64 { return float64(s.QueueLength()) },
+			})
+		}
+	}
+	if cfg.AlertHeapBytes > 0 {
+		rules = append(rules, alert.Rule{
+			Name:      "heap_in_use",
+			Summary:   "bytes of heap in use",
+			Threshold: float64(cfg.AlertHeapBytes),
+			Value: func() float64 {
+				var mem runtime.MemStats
+				runtime.ReadMemStats(&mem)
+				return float64(mem.HeapInuse)
+			},
+		})
+	}
+	if cfg.AlertDenialsPerMinute > 0 {
+		rules = append(rules, alert.Rule{
+			Name:      "denials",
+			Summary:   "401, 403 and 429 responses per minute",
+			Threshold: cfg.AlertDenialsPerMinute,
+			Value:     denialRate(svc),
+		})
+	}
+	if cfg.AnomalyThreshold > 0 {
+		for _, signal := range service.AnomalySignals {
+			rules = append(rules, alert.Rule{
+				Name:      "anomaly_" + signal,
+				Summary:   fmt.Sprintf("standard deviations of the latest minute's %s from its average", strings.ReplaceAll(signal, "_", " ")),
+				Threshold: cfg.AnomalyThreshold,
+				Value:     func() float64 { return svc.AnomalyScore(signal) },
+			})
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	var notifiers []alert.Notifier
+	if cfg.AlertWebhookURL != "" {
+		notifiers = append(notifiers, alert.RateLimit(alert.Webhook{URL: cfg.AlertWebhookURL}, cfg.AlertWebhookRateLimit))
+	}
+	if cfg.AlertSlackWebhookURL != "" {
+		notifiers = append(notifiers, alert.RateLimit(alert.Slack{WebhookURL: cfg.AlertSlackWebhookURL}, cfg.AlertSlackRateLimit))
+	}
+	if cfg.AlertPagerDutyRoutingKey != "" {
+		notifiers = append(notifiers, alert.RateLimit(
+			alert.PagerDuty{RoutingKey: cfg.AlertPagerDutyRoutingKey, Source: serviceName},
+			cfg.AlertPagerDutyRateLimit,
+		))
+	}
+
+	slog.Info("Starting alert evaluator", "rules", len(rules), "notifiers", len(notifiers))
+	evaluator := alert.NewEvaluator(rules, notifiers, time.Duration(cfg.AlertCooldown))
+	go evaluator.Run(ctx, time.Duration(cfg.AlertInterval))
+}
+
+// denialRate returns a rule value reporting denials per minute since the
+// previous evaluation
+func denialRate(svc *service.Service) func() float64 {
+	var mu sync.Mutex
+	last, lastAt := svc.GetRequestCounts().Denied, time.Now()
+
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+
+		denied, now := svc.GetRequestCounts().Denied, time.Now()
+		rate := float64(denied-last) / now.Sub(lastAt).Minutes()
+		last, lastAt = denied, now
+		return rate
+	}
+}
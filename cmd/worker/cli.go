@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/audit"
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/handlers"
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/router"
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"serve", "Run the worker (default)", runServe},
+	{"validate-config", "Check the configuration and referenced files, then exit", runValidateConfig},
+	{"config", "Configuration tools; 'config schema' prints the config file JSON Schema", runConfig},
+	{"version", "Print version information", runVersion},
+	{"export", "Download stats from a running worker", runExport},
+	{"report", "Print or, with -send, mail the digest of a running worker", runReport},
+	{"migrate", "Apply storage migrations", runMigrate},
+}
+
+func main() {
+	args := os.Args[1:]
+
+	switch {
+	case len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help"):
+		usage(os.Stdout)
+		return
+	case len(args) == 0 || strings.HasPrefix(args[0], "-"):
+		// Without a subcommand the worker serves, as it always has
+		os.Exit(runServe(args))
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			os.Exit(cmd.run(args[1:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", serviceName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", serviceName)
+}
+
+// loadConfig parses the config file flag and one flag per config key,
+// then loads the configuration with flags taking precedence. The returned
+// reloader reads the same file and flags again.
+func loadConfig(name string, args []string) (*config.Config, *config.Reloader, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML, TOML or JSON config file")
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg, err := config.LoadWithFlags(*configFile, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, config.NewReloader(*configFile, flags), nil
+}
+
+func runServe(args []string) int {
+	cfg, reloader, err := loadConfig("serve", args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load configuration:", err)
+		return 2
+	}
+	serve(cfg, reloader)
+	return 0
+}
+
+// runValidateConfig loads everything serve would load at startup without
+// binding a port or exporting telemetry
+func runValidateConfig(args []string) int {
+	cfg, _, err := loadConfig("validate-config", args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 1
+	}
+
+	fail := func(what string, err error) int {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %s: %v\n", what, err)
+		return 1
+	}
+
+	if _, _, err := otlpConfig(cfg); err != nil {
+		return fail("OTLP exporter", err)
+	}
+
+	eventTypes := service.NewEventTypeRegistry(cfg.EventTypeStrictness == "strict")
+	if cfg.EventTypesFile != "" {
+		defs, err := service.LoadEventTypeDefinitions(cfg.EventTypesFile)
+		if err != nil {
+			return fail("event types", err)
+		}
+		for _, def := range defs {
+			if err := eventTypes.Register(def); err != nil {
+				return fail("event type "+def.Name, err)
+			}
+		}
+	}
+
+	apiKeys, err := loadAPIKeys(cfg.APIKeys, cfg.APIKeysFile)
+	if err != nil {
+		return fail("API keys", err)
+	}
+	adminKeys, err := loadAPIKeys(cfg.AdminAPIKeys, cfg.AdminAPIKeysFile)
+	if err != nil {
+		return fail("admin API keys", err)
+	}
+
+	var jwtVerifier *middleware.JWTVerifier
+	if cfg.JWTEnabled() {
+		if jwtVerifier, err = newJWTVerifier(cfg); err != nil {
+			return fail("JWT", err)
+		}
+	}
+
+	for name, list := range map[string][]string{
+		"trusted proxies": cfg.TrustedProxies,
+		"IP allow list":   cfg.IPAllowList,
+		"IP deny list":    cfg.IPDenyList,
+	} {
+		if _, err := middleware.ParsePrefixes(list); err != nil {
+			return fail(name, err)
+		}
+	}
+
+	routes := defaultRoutes(cfg)
+	if cfg.RoutesFile != "" {
+		if routes, err = router.LoadRoutes(cfg.RoutesFile); err != nil {
+			return fail("routes", err)
+		}
+	}
+
+	svc := service.New(service.WithEventTypes(eventTypes))
+	ipFilter := middleware.NewIPFilter(nil, nil)
+	maintenanceMode := middleware.NewMaintenanceMode(false, "", 0)
+	registry := newRouteRegistry(routeDeps{
+		cfg:           cfg,
+		svc:           svc,
+		handler:       handlers.New(svc),
+		cors:          middleware.NewCORSPolicy(corsConfig(cfg)),
+		keyStore:      middleware.NewStaticKeyStore(apiKeys),
+		adminKeyStore: middleware.NewStaticKeyStore(adminKeys),
+		jwtVerifier:   jwtVerifier,
+		auditLog:      audit.New(io.Discard),
+		ipFilter:      ipFilter,
+		maintenance:   maintenanceMode,
+	})
+	if err := registry.Build(http.NewServeMux(), routes); err != nil {
+		return fail("routes", err)
+	}
+
+	fmt.Println("Configuration is valid")
+	return 0
+}
+
+// runConfig dispatches configuration tools. Only schema exists so far; it
+// prints the JSON Schema for config files, e.g. for editor integration.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "schema" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config schema\n", serviceName)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config schema", flag.ContinueOnError)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	schema, err := config.Schema()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to build schema:", err)
+		return 1
+	}
+	fmt.Println(string(schema))
+	return 0
+}
+
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	fmt.Printf("%s %s (%s, %s/%s)\n", serviceName, version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" || s.Key == "vcs.time" || s.Key == "vcs.modified" {
+				fmt.Printf("%s: %s\n", s.Key, s.Value)
+			}
+		}
+	}
+	return 0
+}
+
+// runExport fetches the stats of a running worker in one of the formats
+// the stats endpoint negotiates
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:8080", "base URL of the running worker")
+	format := fs.String("format", "json", "output format: json, ndjson or protobuf")
+	output := fs.String("output", "", "file to write to instead of stdout")
+	timeout := fs.Duration("timeout", 10*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	accept, ok := map[string]string{
+		"json":     middleware.FormatJSON,
+		"ndjson":   middleware.FormatNDJSON,
+		"protobuf": middleware.FormatProtobuf,
+	}[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown format %q\n", *format)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *url+"/api/stats", nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Export failed:", err)
+		return 1
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Export failed:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "Export failed: worker returned", resp.Status)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Export failed:", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		fmt.Fprintln(os.Stderr, "Export failed:", err)
+		return 1
+	}
+	return 0
+}
+
+// runMigrate exists so deployment tooling can call it unconditionally.
+// The worker keeps its state in memory, so there is nothing to migrate yet.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	fmt.Println("No migrations to apply: the worker has no persistent storage")
+	return 0
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// debugHandler serves pprof and expvar under /debug/. Mutex and block
+// profiling are enabled as well so contention on the session map shows up.
+func debugHandler(svc *service.Service) http.Handler {
+	runtime.SetMutexProfileFraction(5)
+	runtime.SetBlockProfileRate(1000)
+
+	expvar.Publish("worker", expvar.Func(func() interface{} {
+		return svc.GetHealthMetrics(context.Background())
+	}))
+
+	debug := http.NewServeMux()
+	debug.HandleFunc("/debug/pprof/", pprof.Index)
+	debug.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	debug.Handle("/debug/vars", expvar.Handler())
+
+	return debug
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/pkg/enrich"
+)
+
+// enricherOptions returns the service options adding the enrichers
+// registered with package enrich, and their names. Builds add enrichers by
+// importing the packages that register them, for example from a file of
+// their own in this package:
+//
+//	import _ "example.com/worker-enrichers/customer"
+func enricherOptions() ([]service.Option, []string) {
+	registered := enrich.Registered()
+	opts := make([]service.Option, 0, len(registered))
+	names := make([]string, 0, len(registered))
+	for _, r := range registered {
+		opts = append(opts, service.WithEnricher(r.Name, r.Enricher))
+		names = append(names, r.Name)
+	}
+	return opts, names
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/niquet/rate-limited-worker/internal/certs"
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/stream"
+	trackingv1 "github.com/niquet/rate-limited-worker/proto/tracking/v1"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// newGRPCServer serves the event feed, over TLS with the HTTP server's
+// certificate when it has one. Subscribers authenticate as admin clients
+// do, but always present credentials.
+func newGRPCServer(cfg *config.Config, hub *stream.Hub, certStore *certs.Store, keyStore *middleware.StaticKeyStore, jwtVerifier *middleware.JWTVerifier) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.StreamInterceptor(streamAuth(keyStore, jwtVerifier, cfg.JWTTenantClaim, cfg.TLSAdminClientCert)),
+	}
+	if certStore != nil {
+		// gRPC requires HTTP/2, which ALPN must agree on
+		tlsConfig := certStore.TLSConfig()
+		tlsConfig.NextProtos = []string{"h2"}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(opts...)
+	trackingv1.RegisterEventServiceServer(server, stream.NewServer(hub))
+	return server
+}
+
+// streamAuth checks the bearer token of the authorization metadata as the
+// admin_auth middleware checks the header: against the identity provider
+// when JWT is configured, the admin API keys otherwise. A token carrying
+// tenantClaim limits the stream to that tenant. With requireClientCert, the
+// connection must also have presented a verified client certificate.
+func streamAuth(keyStore *middleware.StaticKeyStore, jwtVerifier *middleware.JWTVerifier, tenantClaim string, requireClientCert bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if requireClientCert && !verifiedClientCert(ss.Context()) {
+			return status.Error(codes.PermissionDenied, "client certificate required")
+		}
+
+		token := bearerToken(ss.Context())
+		if token == "" {
+			return status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+		if jwtVerifier != nil {
+			claims, err := jwtVerifier.Verify(ss.Context(), token)
+			if err != nil {
+				return status.Error(codes.Unauthenticated, "invalid token")
+			}
+			if tenant, _ := claims[tenantClaim].(string); tenant != "" {
+				ss = &tenantStream{ServerStream: ss, ctx: service.ContextWithTenant(ss.Context(), tenant)}
+			}
+			return handler(srv, ss)
+		}
+		if _, ok := keyStore.Lookup(token); !ok {
+			return status.Error(codes.Unauthenticated, "invalid API key")
+		}
+		return handler(srv, ss)
+	}
+}
+
+// tenantStream carries the tenant a subscriber is limited to
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
+
+func verifiedClientCert(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.VerifiedChains) > 0
+}
+
+func bearerToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+// stopGRPC stops the server gracefully, or abruptly once ctx is done
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("gRPC server forced to shutdown", "error", ctx.Err())
+		server.Stop()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/influx"
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/sink"
+)
+
+// startInflux pushes aggregate stats in the InfluxDB line protocol every
+// InfluxInterval until ctx is done, when InfluxURL is set
+func startInflux(ctx context.Context, cfg *config.Config, svc *service.Service, sinks []*sink.Batcher) {
+	if cfg.InfluxURL == "" {
+		return
+	}
+	w, err := influx.NewWriter(cfg.InfluxURL, cfg.InfluxToken)
+	if err != nil {
+		slog.Error("Not pushing stats to InfluxDB", "error", err)
+		return
+	}
+
+	host, _ := os.Hostname()
+	interval := time.Duration(cfg.InfluxInterval)
+	slog.Info("Pushing stats to InfluxDB", "interval", interval.String())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				points := influxPoints(ctx, svc, sinks, cfg.SinkQueueSize, host, now)
+				if err := w.Write(ctx, points); err != nil && ctx.Err() == nil {
+					slog.Warn("Failed to push stats to InfluxDB", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// influxPoints gathers the stats of each tenant, the response counts and
+// the sink queue lengths. Rates are over the last minute.
+func influxPoints(ctx context.Context, svc *service.Service, sinks []*sink.Batcher, queueSize int, host string, now time.Time) []influx.Point {
+	var points []influx.Point
+
+	// Without tenancy there is one set of stats, under no tenant
+	tenants := svc.Tenants()
+	if len(tenants) == 0 {
+		tenants = []string{""}
+	}
+	for _, tenant := range tenants {
+		tenantCtx := ctx
+		if tenant != "" {
+			tenantCtx = service.ContextWithTenant(ctx, tenant)
+		}
+		stats := svc.GetStats(tenantCtx)
+		rates := svc.GetRates(tenantCtx, time.Minute)
+		points = append(points, influx.Point{
+			Measurement: "worker_stats",
+			Tags:        map[string]string{"host": host, "tenant": tenant},
+			Fields: map[string]interface{}{
+				"clicks_per_minute":   rates.ClicksPerMinute,
+				"events_per_minute":   rates.EventsPerMinute,
+				"sessions_per_minute": rates.SessionsPerMinute,
+				"active_sessions":     stats.ActiveSessions,
+				"total_clicks":        stats.TotalClicks,
+				"page_views":          stats.PageViews,
+				"total_sessions":      stats.TotalSessions,
+			},
+			Time: now,
+		})
+	}
+
+	counts := svc.GetRequestCounts()
+	points = append(points, influx.Point{
+		Measurement: "worker_responses",
+		Tags:        map[string]string{"host": host},
+		Fields: map[string]interface{}{
+			"success":       counts.Success,
+			"redirect":      counts.Redirect,
+			"client_errors": counts.ClientErrors,
+			"server_errors": counts.ServerErrors,
+			"denied":        counts.Denied,
+		},
+		Time: now,
+	})
+
+	for _, s := range sinks {
+		points = append(points, influx.Point{
+			Measurement: "worker_sink_queue",
+			Tags:        map[string]string{"host": host, "sink": s.Name()},
+			Fields: map[string]interface{}{
+				"length":   int64(s.QueueLength()),
+				"capacity": int64(queueSize),
+			},
+			Time: now,
+		})
+	}
+	return points
+}
@@ -5,21 +5,36 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/niquet/rate-limited-worker/internal/audit"
+	"github.com/niquet/rate-limited-worker/internal/certs"
 	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/geoip"
 	"github.com/niquet/rate-limited-worker/internal/handlers"
+	"github.com/niquet/rate-limited-worker/internal/ingest"
+	"github.com/niquet/rate-limited-worker/internal/listen"
+	"github.com/niquet/rate-limited-worker/internal/logfile"
 	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/router"
 	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/stream"
 	"github.com/niquet/rate-limited-worker/internal/telemetry"
 
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -27,79 +42,402 @@ const (
 	version     = "v1.0.0"
 )
 
-func main() {
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		slog.Error("Failed to load configuration", "error", err)
-		os.Exit(1)
-	}
+// logLevel is shared by the default logger so reloads can change it
+var logLevel = new(slog.LevelVar)
 
-	// Setup structured logging
-	setupLogging(cfg.LogLevel)
+// recentErrors keeps the latest error lines for the admin dashboard
+var recentErrors = telemetry.NewRecentErrors(50)
 
-	// Initialize OpenTelemetry
-	shutdown, err := telemetry.SetupOTelSDK(context.Background(), serviceName, version, cfg.OTELEndpoint)
+// serve runs the worker until it receives SIGINT or SIGTERM. SIGHUP
+// reloads the reloadable part of the configuration and SIGUSR1 steps
+// through log levels.
+func serve(cfg *config.Config, reloader *config.Reloader) {
+	config.SetCurrent(cfg)
+
+	// Setup structured logging
+	closeLogs, err := setupLogging(cfg)
 	if err != nil {
-		slog.Error("Failed to setup OpenTelemetry", "error", err)
+		slog.Error("Failed to set up log outputs", "error", err)
 		os.Exit(1)
 	}
+	defer closeLogs()
+	slog.Info("Loaded configuration", "environment", cfg.Environment, "config", cfg)
+
+	// Initialize OpenTelemetry. Serving never waits on it: a pipeline that
+	// fails to start is left off, and exporters retry failed exports.
+	shutdown := func(context.Context) error { return nil }
+	if cfg.TelemetryEnabled {
+		otelEndpoint, otlp, err := otlpConfig(cfg)
+		if err != nil {
+			slog.Error("Invalid OTLP exporter configuration", "error", err)
+			os.Exit(1)
+		}
+		shutdown = telemetry.Start(serviceName, version, otelEndpoint, time.Duration(cfg.TelemetryErrorInterval),
+			telemetry.WithEnvironment(cfg.Environment),
+			telemetry.WithMetricsExporter(cfg.MetricsExporter),
+			telemetry.WithStatsD(telemetry.StatsDConfig{
+				Addr:     cfg.StatsDAddr,
+				Prefix:   cfg.StatsDPrefix,
+				Flavor:   cfg.StatsDFlavor,
+				Tags:     cfg.StatsDTags,
+				Interval: time.Duration(cfg.StatsDInterval),
+			}),
+			telemetry.WithRuntimeMetrics(cfg.RuntimeMetrics),
+			telemetry.WithLogs(cfg.OTLPLogsEnabled()),
+			telemetry.WithExemplarFilter(cfg.MetricsExemplarFilter),
+			telemetry.WithHistogramBuckets(map[string][]float64{
+				service.MetricHTTPRequestDuration: cfg.HTTPLatencyBuckets,
+				service.MetricCursorPositions:     cfg.CursorPositionBuckets,
+				service.MetricSessionEvents:       cfg.SessionEventBuckets,
+				service.MetricSessionDuration:     cfg.SessionDurationBuckets,
+				service.MetricSessionClicks:       cfg.SessionClickBuckets,
+			}),
+			telemetry.WithOTLP(otlp),
+			telemetry.WithSampling(telemetry.SamplingConfig{
+				Sampler:     cfg.TraceSampler,
+				Ratio:       cfg.TraceSampleRatio,
+				RouteRatios: cfg.TraceRouteSampleRatios,
+				Errors:      cfg.TraceSampleErrors,
+				Tail: telemetry.TailSamplingConfig{
+					Enabled:   cfg.TraceTailSampling,
+					Latency:   time.Duration(cfg.TraceTailLatency),
+					Wait:      time.Duration(cfg.TraceTailWait),
+					MaxTraces: cfg.TraceTailMaxTraces,
+				},
+			}))
+	} else {
+		slog.Warn("Telemetry disabled, traces and metrics are not recorded")
+	}
 	defer func() {
-		if err := shutdown(context.Background()); err != nil {
+		// Exporters retry against an unreachable collector, so flushing is
+		// bounded like the rest of shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownGracePeriod))
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
 			slog.Error("Failed to shutdown OpenTelemetry", "error", err)
 		}
 	}()
 
+	// Load custom event types
+	eventTypes := service.NewEventTypeRegistry(cfg.EventTypeStrictness == "strict")
+	if cfg.EventTypesFile != "" {
+		defs, err := service.LoadEventTypeDefinitions(cfg.EventTypesFile)
+		if err != nil {
+			slog.Error("Failed to load event types", "error", err)
+			os.Exit(1)
+		}
+		for _, def := range defs {
+			if err := eventTypes.Register(def); err != nil {
+				slog.Error("Invalid event type definition", "name", def.Name, "error", err)
+				os.Exit(1)
+			}
+		}
+		slog.Info("Loaded custom event types", "count", len(defs), "file", cfg.EventTypesFile)
+	}
+
+	var experiments []service.Experiment
+	if cfg.ExperimentsFile != "" {
+		loaded, err := service.LoadExperiments(cfg.ExperimentsFile)
+		if err != nil {
+			slog.Error("Failed to load experiments", "error", err)
+			os.Exit(1)
+		}
+		experiments = loaded
+		slog.Info("Loaded experiments", "count", len(experiments), "file", cfg.ExperimentsFile)
+	}
+
+	var goals []service.Goal
+	if cfg.GoalsFile != "" {
+		loaded, err := service.LoadGoals(cfg.GoalsFile)
+		if err != nil {
+			slog.Error("Failed to load goals", "error", err)
+			os.Exit(1)
+		}
+		goals = loaded
+		slog.Info("Loaded goals", "count", len(goals), "file", cfg.GoalsFile)
+	}
+
+	// Locating clients is optional; without a database events carry no geo
+	// fields
+	var geoDB *geoip.DB
+	if cfg.GeoIPDatabase != "" {
+		db, err := geoip.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			slog.Error("Failed to open GeoIP database", "error", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		geoDB = db
+		slog.Info("Loaded GeoIP database", "file", cfg.GeoIPDatabase)
+	}
+
 	// Initialize service layer
-	svc := service.New()
+	sloWindows := make([]time.Duration, len(cfg.SLOWindows))
+	for i, w := range cfg.SLOWindows {
+		sloWindows[i] = time.Duration(w)
+	}
+	svcOptions := []service.Option{
+		service.WithEventTypes(eventTypes),
+		service.WithMaxMetricRoutes(cfg.MetricsMaxRoutes),
+		service.WithEventSampling(cfg.EventSpanSampleRates, cfg.EventMetricSampleRates),
+		service.WithMetricBatching(cfg.MetricsBatchSize, time.Duration(cfg.MetricsBatchInterval)),
+		service.WithIdleThreshold(time.Duration(cfg.SessionIdleThreshold)),
+		service.WithBotFilter(cfg.BotFilter, cfg.BotMaxEventRate),
+		service.WithGeoIP(geoDB),
+		service.WithConsentPolicy(cfg.ConsentPolicy),
+		service.WithExperiments(experiments),
+		service.WithGoals(goals),
+		service.WithPrivacy(service.PrivacyConfig{
+			IPMode:       cfg.PrivacyIPMode,
+			IPHashKey:    []byte(cfg.PrivacyIPHashKey),
+			StripQuery:   cfg.PrivacyStripQuery,
+			RedactFields: cfg.PrivacyRedactFields,
+		}),
+		service.WithPageNormalization(service.PageConfig{
+			TrackingParams: cfg.PageTrackingParams,
+			Templates:      cfg.PageTemplates,
+		}),
+		service.WithSLOs(service.SLOConfig{
+			AvailabilityTarget: cfg.SLOAvailabilityTarget,
+			LatencyTarget:      cfg.SLOLatencyTarget,
+			LatencyThreshold:   time.Duration(cfg.SLOLatencyThreshold),
+			Windows:            sloWindows,
+		}),
+	}
+	if cfg.TenantSource != "none" {
+		svcOptions = append(svcOptions, service.WithTenancy(cfg.Tenants, cfg.MaxTenants))
+	}
+	if cfg.AnomalyThreshold > 0 {
+		svcOptions = append(svcOptions, service.WithAnomalyDetection(service.AnomalyConfig{
+			Threshold: cfg.AnomalyThreshold,
+			Alpha:     cfg.AnomalyAlpha,
+			Warmup:    time.Duration(cfg.AnomalyWarmup),
+		}))
+	}
+	if cfg.ReplayEnabled {
+		svcOptions = append(svcOptions, service.WithReplay(service.ReplayConfig{
+			MaxSessionBytes: int64(cfg.ReplayMaxSessionBytes),
+			MaxBytes:        int64(cfg.ReplayMaxBytes),
+			Retention:       time.Duration(cfg.ReplayRetention),
+		}))
+	}
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		slog.Error("Failed to create sinks", "error", err)
+		os.Exit(1)
+	}
+	for _, s := range sinks {
+		svcOptions = append(svcOptions, service.WithSink(s))
+		// Erasure requests reach the sinks that can delete what they stored
+		if erase, ok := s.Eraser(); ok {
+			svcOptions = append(svcOptions, service.WithEraser(s.Name(), erase))
+		}
+	}
+	if len(sinks) > 0 {
+		slog.Info("Forwarding events to sinks", "sinks", cfg.Sinks)
+	}
+	// The gRPC event feed receives processed events as the sinks do
+	var hub *stream.Hub
+	if cfg.GRPCPort != 0 {
+		hub = stream.NewHub(stream.Config{
+			BufferSize:     cfg.StreamBufferSize,
+			MaxSubscribers: cfg.StreamMaxSubscribers,
+		})
+		svcOptions = append(svcOptions, service.WithSink(hub))
+	}
+	if opts, names := enricherOptions(); len(opts) > 0 {
+		svcOptions = append(svcOptions, opts...)
+		slog.Info("Registered enrichers", "enrichers", names)
+	}
+	svc := service.New(svcOptions...)
 
-	// Setup HTTP handlers with middleware
-	mux := http.NewServeMux()
+	// MQTT ingestion feeds the service alongside the HTTP routes
+	var mqttIngest *ingest.MQTT
+	if len(cfg.MQTTBrokers) > 0 {
+		clientID := cfg.MQTTClientID
+		if clientID == "" {
+			host, _ := os.Hostname()
+			clientID = "rate-limited-worker-" + host
+		}
+		mqttIngest = ingest.NewMQTT(ingest.MQTTConfig{
+			Brokers:     cfg.MQTTBrokers,
+			ClientID:    clientID,
+			Username:    cfg.MQTTUsername,
+			Password:    cfg.MQTTPassword,
+			Topics:      cfg.MQTTTopics,
+			QoS:         byte(cfg.MQTTQoS),
+			TenantLevel: cfg.MQTTTenantTopicLevel,
+		}, ingest.NewPipeline(svc, decodeLimits))
+		mqttIngest.Start()
+		slog.Info("Ingesting events over MQTT", "client_id", clientID, "topics", cfg.MQTTTopics)
+	}
 
-	// Wrap handlers with OTEL and custom middleware
-	handler := handlers.New(svc)
-
-	// Static files
-	fs := http.FileServer(http.Dir("./web/static/"))
-	mux.Handle("/static/", middleware.Chain(
-		http.StripPrefix("/static/", fs),
-		middleware.RequestLogger(),
-		middleware.CORS(),
-	))
-
-	// Main page
-	mux.Handle("/", middleware.Chain(
-		http.HandlerFunc(handler.HomePage),
-		middleware.RequestLogger(),
-		middleware.CORS(),
-		middleware.MetricsCollector(svc),
-	))
-
-	// API endpoints
-	mux.Handle("/api/track", middleware.Chain(
-		http.HandlerFunc(handler.TrackEvent),
-		middleware.RequestLogger(),
-		middleware.CORS(),
-		middleware.MetricsCollector(svc),
-	))
-
-	mux.Handle("/api/health", middleware.Chain(
-		http.HandlerFunc(handler.HealthCheck),
-		middleware.RequestLogger(),
-	))
-
-	// Wrap the entire mux with OTEL HTTP instrumentation
-	otelHandler := otelhttp.NewHandler(mux, "worker-server",
-		otelhttp.WithServerName(serviceName),
+	trustedProxies, err := middleware.ParsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		slog.Error("Invalid trusted proxy configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// IP access lists apply to public routes ahead of any other processing
+	ipAllow, err := middleware.ParsePrefixes(cfg.IPAllowList)
+	if err != nil {
+		slog.Error("Invalid IP allow list", "error", err)
+		os.Exit(1)
+	}
+	ipDeny, err := middleware.ParsePrefixes(cfg.IPDenyList)
+	if err != nil {
+		slog.Error("Invalid IP deny list", "error", err)
+		os.Exit(1)
+	}
+	ipFilter := middleware.NewIPFilter(ipAllow, ipDeny)
+
+	// Maintenance mode takes public routes offline; health and admin stay up
+	maintenanceMode := middleware.NewMaintenanceMode(
+		cfg.MaintenanceMode,
+		cfg.MaintenanceMessage,
+		time.Duration(cfg.MaintenanceRetryAfter),
+	)
+
+	// Load API keys. Admin keys are kept apart so keys handed out for
+	// ingestion never reach admin routes.
+	apiKeys, err := loadAPIKeys(cfg.APIKeys, cfg.APIKeysFile)
+	if err != nil {
+		slog.Error("Failed to load API keys", "error", err)
+		os.Exit(1)
+	}
+	keyStore := middleware.NewStaticKeyStore(apiKeys)
+	if cfg.RequireTrackingAuth && keyStore.Len() == 0 {
+		slog.Error("Tracking authentication required but no API keys configured")
+		os.Exit(1)
+	}
+	adminKeys, err := loadAPIKeys(cfg.AdminAPIKeys, cfg.AdminAPIKeysFile)
+	if err != nil {
+		slog.Error("Failed to load admin API keys", "error", err)
+		os.Exit(1)
+	}
+	adminKeyStore := middleware.NewStaticKeyStore(adminKeys)
+
+	// Admin calls are written to a dedicated, hash-chained audit stream
+	auditLog := audit.New(os.Stderr)
+	if cfg.AuditLogFile != "" {
+		auditLog, err = audit.Open(cfg.AuditLogFile)
+		if err != nil {
+			slog.Error("Failed to open audit log", "error", err)
+			os.Exit(1)
+		}
+	}
+	defer auditLog.Close()
+
+	// Admin routes use the identity provider when JWT is configured and
+	// fall back to the admin API keys otherwise. They are only served
+	// without either when ADMIN_AUTH=none puts them on a private listener.
+	var jwtVerifier *middleware.JWTVerifier
+	switch {
+	case cfg.JWTEnabled():
+		jwtVerifier, err = newJWTVerifier(cfg)
+		if err != nil {
+			slog.Error("Failed to setup JWT authentication", "error", err)
+			os.Exit(1)
+		}
+	case adminKeyStore.Len() == 0 && cfg.AdminAuth != "none":
+		slog.Error("Admin authentication required but no admin API keys configured")
+		os.Exit(1)
+	}
+
+	reporter := newReporter(cfg, svc)
+
+	handler := handlers.New(svc,
+		handlers.WithDecodeLimits(cfg.DisallowUnknownFields, cfg.MaxCustomFields),
+		handlers.WithIPFilter(ipFilter),
+		handlers.WithMaintenance(maintenanceMode),
+		handlers.WithLogLevel(logLevel),
+		handlers.WithConfig(config.Current),
+		handlers.WithRecentErrors(recentErrors),
+		handlers.WithReporter(reporter),
 	)
 
+	// Routes and their middleware come from a declarative table so the
+	// wiring can be changed without recompiling
+	routes := defaultRoutes(cfg)
+	if cfg.RoutesFile != "" {
+		routes, err = router.LoadRoutes(cfg.RoutesFile)
+		if err != nil {
+			slog.Error("Failed to load route table", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Loaded route table", "routes", len(routes), "file", cfg.RoutesFile)
+	}
+
+	corsPolicy := middleware.NewCORSPolicy(corsConfig(cfg))
+
+	registry := newRouteRegistry(routeDeps{
+		cfg:           cfg,
+		svc:           svc,
+		handler:       handler,
+		cors:          corsPolicy,
+		keyStore:      keyStore,
+		adminKeyStore: adminKeyStore,
+		jwtVerifier:   jwtVerifier,
+		auditLog:      auditLog,
+		ipFilter:      ipFilter,
+		maintenance:   maintenanceMode,
+	})
+
+	// Operational routes move to the admin listener when there is one
+	publicRoutes, adminRoutes, err := splitRoutes(cfg, routes)
+	if err != nil {
+		slog.Error("Invalid route table", "error", err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	if err := registry.Build(mux, publicRoutes); err != nil {
+		slog.Error("Invalid route table", "error", err)
+		os.Exit(1)
+	}
+	adminMux := http.NewServeMux()
+	if err := registry.Build(adminMux, adminRoutes); err != nil {
+		slog.Error("Invalid route table", "error", err)
+		os.Exit(1)
+	}
+	if cfg.DebugEndpoints {
+		slog.Warn("Debug endpoints enabled", "path", "/debug/")
+	}
+
+	otelHandler := instrument(mux, "worker-server", trustedProxies)
+
+	// HTTP/2 is negotiated via ALPN over TLS; h2c serves it over cleartext
+	// for deployments behind a trusted proxy that terminates TLS.
+	h2Server := &http2.Server{
+		MaxConcurrentStreams: uint32(cfg.HTTP2MaxConcurrentStreams),
+	}
+	var rootHandler http.Handler = otelHandler
+	if cfg.H2C && !cfg.TLSEnabled() {
+		rootHandler = h2c.NewHandler(otelHandler, h2Server)
+	}
+
 	// Create HTTP server
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Port),
-		Handler:      otelHandler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           rootHandler,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		MaxHeaderBytes:    int(cfg.MaxHeaderBytes),
+	}
+	// The certificate is served from memory so renewals can replace it
+	var certStore *certs.Store
+	if cfg.TLSEnabled() {
+		certStore, err = certs.Load(certsConfig(cfg))
+		if err != nil {
+			slog.Error("Failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = certStore.TLSConfig()
+	}
+	if err := http2.ConfigureServer(server, h2Server); err != nil {
+		slog.Error("Failed to configure HTTP/2", "error", err)
+		os.Exit(1)
 	}
 
 	// Add server attributes to traces
@@ -111,19 +449,216 @@ func main() {
 	)
 	span.End()
 
+	// Sockets passed by systemd are adopted up front, and the admin
+	// listener takes its own before the main server takes the first left
+	sockets, err := listen.Systemd()
+	if err != nil {
+		slog.Error("Failed to adopt systemd sockets", "error", err)
+		os.Exit(1)
+	}
+	var adminLis net.Listener
+	if cfg.AdminAddr != "" {
+		network, addr := cfg.AdminListener()
+		adminLis, err = openListener(network, addr, sockets)
+		if err != nil {
+			slog.Error("Failed to listen for admin routes", "error", err)
+			os.Exit(1)
+		}
+		if cfg.TLSAdminClientCert && adminLis.Addr().Network() != "tcp" {
+			slog.Error("Admin client certificates need TLS, which the admin listener is not served with", "network", adminLis.Addr().Network())
+			os.Exit(1)
+		}
+	}
+	network, addr := cfg.HTTPListener()
+	lis, err := openListener(network, addr, sockets)
+	if err != nil {
+		slog.Error("Failed to listen for HTTP", "error", err)
+		os.Exit(1)
+	}
+	if unused := sockets.Close(); len(unused) > 0 {
+		slog.Warn("Closed unused sockets passed by systemd", "names", unused)
+	}
+
 	// Start server in goroutine
-	serverErr := make(chan error, 1)
+	serverErr := make(chan error, 5)
 	go func() {
 		slog.Info("Starting HTTP server",
-			"port", cfg.Port,
+			"addr", lis.Addr().String(),
+			"network", lis.Addr().Network(),
 			"service", serviceName,
-			"version", version)
+			"version", version,
+			"tls", cfg.TLSEnabled(),
+			"h2c", cfg.H2C && !cfg.TLSEnabled())
 
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if cfg.TLSEnabled() {
+			err = server.ServeTLS(lis, "", "")
+		} else {
+			err = server.Serve(lis)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			serverErr <- err
 		}
 	}()
 
+	// Apply reloaded settings to the components that hold them
+	reloader.OnReload(func(old, next *config.Config) {
+		corsPolicy.Set(corsConfig(next))
+
+		// The log level and lists are only reapplied when they changed in the
+		// config so changes made through the admin API survive unrelated reloads
+		if old.LogLevel != next.LogLevel {
+			logLevel.Set(parseLogLevel(next.LogLevel))
+		}
+		if !slices.Equal(old.IPAllowList, next.IPAllowList) || !slices.Equal(old.IPDenyList, next.IPDenyList) {
+			allow, errAllow := middleware.ParsePrefixes(next.IPAllowList)
+			deny, errDeny := middleware.ParsePrefixes(next.IPDenyList)
+			if err := errors.Join(errAllow, errDeny); err != nil {
+				slog.Error("Keeping previous IP rules", "error", err)
+			} else {
+				ipFilter.Set(allow, deny)
+			}
+		}
+
+		if !maps.Equal(old.EventSpanSampleRates, next.EventSpanSampleRates) || !maps.Equal(old.EventMetricSampleRates, next.EventMetricSampleRates) {
+			svc.SetEventSampling(next.EventSpanSampleRates, next.EventMetricSampleRates)
+		}
+		if old.ReplayRetention != next.ReplayRetention {
+			svc.SetReplayRetention(time.Duration(next.ReplayRetention))
+		}
+
+		if old.APIKeys != next.APIKeys || old.APIKeysFile != next.APIKeysFile {
+			keys, err := loadAPIKeys(next.APIKeys, next.APIKeysFile)
+			switch {
+			case err != nil:
+				slog.Error("Keeping previous API keys", "error", err)
+			case next.RequireTrackingAuth && len(keys) == 0:
+				slog.Error("Keeping previous API keys, tracking authentication requires at least one")
+			default:
+				keyStore.Set(keys)
+			}
+		}
+		if old.AdminAPIKeys != next.AdminAPIKeys || old.AdminAPIKeysFile != next.AdminAPIKeysFile {
+			keys, err := loadAPIKeys(next.AdminAPIKeys, next.AdminAPIKeysFile)
+			switch {
+			case err != nil:
+				slog.Error("Keeping previous admin API keys", "error", err)
+			case jwtVerifier == nil && next.AdminAuth != "none" && len(keys) == 0:
+				slog.Error("Keeping previous admin API keys, admin authentication requires at least one")
+			default:
+				adminKeyStore.Set(keys)
+			}
+		}
+	})
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "error", err)
+				continue
+			}
+			slog.Info("Reloaded configuration", "trigger", "sighup")
+		}
+	}()
+
+	usr1 := make(chan os.Signal, 1)
+	notifyLogLevelCycle(usr1)
+	go func() {
+		for range usr1 {
+			level := handler.CycleLogLevel()
+			slog.Warn("Log level changed", "level", level.String(), "trigger", "sigusr1")
+		}
+	}()
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go reloader.WatchFile(watchCtx, time.Duration(cfg.ConfigWatchInterval))
+	go reloader.WatchRemote(watchCtx, time.Duration(cfg.ConfigWatchInterval))
+
+	startAlerting(watchCtx, cfg, svc, sinks)
+	startInflux(watchCtx, cfg, svc, sinks)
+	startReports(watchCtx, cfg, reporter)
+	go expireSessions(watchCtx, svc, time.Duration(cfg.SessionCleanupInterval))
+	if cfg.AnomalyThreshold > 0 {
+		go detectAnomalies(watchCtx, svc)
+	}
+	if geoDB != nil {
+		go geoDB.Watch(watchCtx, time.Duration(cfg.GeoIPWatchInterval))
+	}
+	if certStore != nil {
+		go certStore.Watch(watchCtx, time.Duration(cfg.TLSWatchInterval))
+	}
+
+	// A dedicated metrics listener keeps scrapes off the public port
+	var metricsServer *http.Server
+	if cfg.PrometheusEnabled() && cfg.MetricsPort != 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", telemetry.MetricsHandler())
+		metricsServer = &http.Server{
+			Addr:              fmt.Sprintf(":%d", cfg.MetricsPort),
+			Handler:           metricsMux,
+			ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		}
+		go func() {
+			slog.Info("Starting metrics server", "port", cfg.MetricsPort)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- fmt.Errorf("metrics server: %w", err)
+			}
+		}()
+	}
+
+	// ACME challenges are answered over plain HTTP on a port of their own
+	var acmeServer *http.Server
+	if cfg.ACMEEnabled() {
+		acmeServer = newACMEServer(cfg, certStore)
+		go func() {
+			slog.Info("Starting ACME challenge server", "port", cfg.ACMEHTTPPort, "hosts", cfg.ACMEHosts)
+			if err := acmeServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- fmt.Errorf("ACME challenge server: %w", err)
+			}
+		}()
+	}
+
+	// Admin routes on a listener of their own are unreachable through the
+	// public ingress
+	var adminServer *http.Server
+	if adminLis != nil {
+		adminServer = newAdminServer(cfg, instrument(adminMux, "worker-admin-server", trustedProxies), certStore, adminLis)
+		go func() {
+			slog.Info("Starting admin server", "addr", adminLis.Addr().String(), "network", adminLis.Addr().Network(), "routes", len(adminRoutes), "tls", adminServer.TLSConfig != nil)
+			var err error
+			if adminServer.TLSConfig != nil {
+				err = adminServer.ServeTLS(adminLis, "", "")
+			} else {
+				err = adminServer.Serve(adminLis)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- fmt.Errorf("admin server: %w", err)
+			}
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	if hub != nil {
+		grpcServer = newGRPCServer(cfg, hub, certStore, adminKeyStore, jwtVerifier)
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.GRPCPort))
+		if err != nil {
+			slog.Error("Failed to listen for gRPC", "error", err)
+			os.Exit(1)
+		}
+		if !cfg.TLSEnabled() {
+			slog.Warn("gRPC event feed served without TLS, subscriber credentials cross the network in the clear")
+		}
+		go func() {
+			slog.Info("Starting gRPC server", "port", cfg.GRPCPort, "tls", cfg.TLSEnabled())
+			if err := grpcServer.Serve(lis); err != nil {
+				serverErr <- fmt.Errorf("gRPC server: %w", err)
+			}
+		}()
+	}
+
 	// Wait for shutdown signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -137,36 +672,242 @@ func main() {
 
 	// Graceful shutdown
 	slog.Info("Server shutting down...")
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownGracePeriod))
 	defer cancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		slog.Error("Server forced to shutdown", "error", err)
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Metrics server forced to shutdown", "error", err)
+		}
+	}
+	if acmeServer != nil {
+		if err := acmeServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("ACME challenge server forced to shutdown", "error", err)
+		}
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Admin server forced to shutdown", "error", err)
+		}
+	}
+
+	if grpcServer != nil {
+		// Ending the subscriptions ends their streams, which graceful
+		// shutdown waits for
+		hub.Close()
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+
+	if mqttIngest != nil {
+		mqttIngest.Close()
+	}
+
+	// Buffered metrics are recorded before telemetry flushes them
+	svc.Close()
+	closeSinks(shutdownCtx, sinks)
 
 	slog.Info("Server exited")
 }
 
-func setupLogging(level string) {
-	var logLevel slog.Level
-	switch level {
-	case "DEBUG":
-		logLevel = slog.LevelDebug
-	case "INFO":
-		logLevel = slog.LevelInfo
-	case "WARN":
-		logLevel = slog.LevelWarn
-	case "ERROR":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo
+// instrument wraps a server's mux with OTEL HTTP instrumentation. Request
+// IDs and panic recovery run inside it so they land on the server span.
+func instrument(mux *http.ServeMux, operation string, trustedProxies []netip.Prefix) http.Handler {
+	appHandler := middleware.Chain(mux,
+		middleware.RequestID(),
+		middleware.RealIP(trustedProxies),
+		middleware.Recover(),
+	)
+	return otelhttp.NewHandler(appHandler, operation,
+		otelhttp.WithServerName(serviceName),
+	)
+}
+
+// otlpConfig maps the OTLP settings onto the exporter configuration. The
+// default endpoint is the gRPC port, so OTLP/HTTP without an explicit
+// endpoint targets the collector's HTTP port instead.
+func otlpConfig(cfg *config.Config) (string, telemetry.OTLPConfig, error) {
+	headers, err := telemetry.ParseHeaders(cfg.OTELHeaders)
+	if err != nil {
+		return "", telemetry.OTLPConfig{}, fmt.Errorf("OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+
+	endpoint := cfg.OTELEndpoint
+	if cfg.OTELProtocol == telemetry.ProtocolHTTPProtobuf && cfg.Source("otel_endpoint") == config.SourceDefault {
+		endpoint = "localhost:4318"
+	}
+
+	otlp := telemetry.OTLPConfig{
+		Protocol:        cfg.OTELProtocol,
+		Insecure:        cfg.OTELInsecure,
+		CAFile:          cfg.OTELCertificate,
+		ClientCertFile:  cfg.OTELClientCertificate,
+		ClientKeyFile:   cfg.OTELClientKey,
+		Headers:         headers,
+		Timeout:         time.Duration(cfg.OTELTimeout),
+		Retry:           cfg.OTELRetry,
+		RetryMaxElapsed: time.Duration(cfg.OTELRetryMaxElapsed),
+	}
+	if cfg.OTELCompression == "gzip" {
+		otlp.Compression = "gzip"
+	}
+	return endpoint, otlp, nil
+}
+
+// loadAPIKeys merges the keys listed inline with those of the keys file
+func loadAPIKeys(inline, file string) ([]middleware.APIKey, error) {
+	keys, err := middleware.ParseAPIKeys(inline)
+	if err != nil {
+		return nil, err
 	}
 
+	if file != "" {
+		fileKeys, err := middleware.LoadAPIKeysFile(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
+func newJWTVerifier(cfg *config.Config) (*middleware.JWTVerifier, error) {
+	jwtCfg := middleware.JWTConfig{
+		HMACSecret:   []byte(cfg.JWTSecret),
+		JWKSURL:      cfg.JWTJWKSURL,
+		JWKSCacheTTL: time.Duration(cfg.JWTJWKSCacheTTL),
+		Issuer:       cfg.JWTIssuer,
+		Audience:     cfg.JWTAudience,
+		Leeway:       time.Duration(cfg.JWTLeeway),
+	}
+
+	if cfg.JWTPublicKeyFile != "" {
+		key, err := middleware.LoadRSAPublicKey(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		jwtCfg.RSAPublicKey = key
+	}
+
+	return middleware.NewJWTVerifier(jwtCfg)
+}
+
+// decodeLimits returns whether unknown event fields are rejected and the
+// cap on custom fields, from the active configuration
+func decodeLimits() (bool, int) {
+	cfg := config.Current()
+	return cfg.DisallowUnknownFields, cfg.MaxCustomFields
+}
+
+// expireSessions drops sessions idle for the active session timeout, which
+// records them in the session histograms, and recordings and erasure
+// statuses past their retention every interval until ctx is done
+func expireSessions(ctx context.Context, svc *service.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			svc.CleanupOldSessions(time.Duration(config.Current().SessionTimeout))
+			svc.CleanupOldReplays()
+			svc.CleanupOldErasures()
+		}
+	}
+}
+
+// anomalyCheckInterval is how often finished minutes are scored for
+// anomalies
+const anomalyCheckInterval = 10 * time.Second
+
+// detectAnomalies scores each finished minute until ctx is done
+func detectAnomalies(ctx context.Context, svc *service.Service) {
+	ticker := time.NewTicker(anomalyCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			svc.DetectAnomalies(ctx, now)
+		}
+	}
+}
+
+// setupLogging writes logs to stdout and to the file and syslog outputs
+// configured. The returned function closes the file and the syslog
+// connection.
+func setupLogging(cfg *config.Config) (func(), error) {
+	logLevel.Set(parseLogLevel(cfg.LogLevel))
+
 	opts := &slog.HandlerOptions{
 		Level: logLevel,
 	}
 
-	handler := slog.NewJSONHandler(os.Stdout, opts)
-	logger := slog.New(handler)
+	// Lines logged within a request carry their trace and span IDs, and
+	// errors are kept for the dashboard
+	outputs := []slog.Handler{
+		telemetry.NewLogHandler(slog.NewJSONHandler(os.Stdout, opts)),
+		recentErrors.Handler(),
+	}
+	closeLogs := func() {}
+	if cfg.LogFile != "" {
+		f, err := logfile.Open(logfile.Config{
+			Path:       cfg.LogFile,
+			MaxSize:    int64(cfg.LogFileMaxSize),
+			MaxAge:     time.Duration(cfg.LogFileMaxAge),
+			MaxBackups: cfg.LogFileMaxBackups,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+		outputs = append(outputs, telemetry.NewLogHandler(slog.NewJSONHandler(f, opts)))
+		closeLogs = func() { f.Close() }
+	}
+	if cfg.LogSyslog != "" {
+		network, addr, err := cfg.SyslogAddr()
+		if err != nil {
+			return nil, err
+		}
+		h, closeSyslog, err := telemetry.NewSyslogHandler(network, addr, serviceName, opts)
+		if err != nil {
+			closeLogs()
+			return nil, fmt.Errorf("syslog: %w", err)
+		}
+		outputs = append(outputs, telemetry.NewLogHandler(h))
+		closeFile := closeLogs
+		closeLogs = func() {
+			if err := closeSyslog(); err != nil {
+				fmt.Fprintf(os.Stderr, "close syslog: %v\n", err)
+			}
+			closeFile()
+		}
+	}
+	if cfg.OTLPLogsEnabled() {
+		// The bridge starts exporting once telemetry is up
+		outputs = append(outputs, telemetry.NewLogBridge(serviceName, logLevel))
+	}
+	logger := slog.New(telemetry.NewTeeHandler(outputs...))
 	slog.SetDefault(logger)
+	return closeLogs, nil
+}
+
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "INFO":
+		return slog.LevelInfo
+	case "WARN":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
 }
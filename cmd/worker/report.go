@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/report"
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// newReporter builds the digest reporter from the REPORT_* settings
+func newReporter(cfg *config.Config, svc *service.Service) *report.Reporter {
+	return report.New(svc, report.Config{
+		Name:        serviceName,
+		Schedule:    cfg.ReportSchedule,
+		TopElements: cfg.ReportTopElements,
+		SMTP: report.SMTPConfig{
+			Addr:     cfg.ReportSMTPAddr,
+			Username: cfg.ReportSMTPUsername,
+			Password: cfg.ReportSMTPPassword,
+			From:     cfg.ReportFrom,
+			To:       cfg.ReportTo,
+		},
+	})
+}
+
+// startReports mails a digest on the configured schedule until ctx is
+// done, when ReportSchedule is set
+func startReports(ctx context.Context, cfg *config.Config, r *report.Reporter) {
+	if cfg.ReportSchedule == "" {
+		return
+	}
+	slog.Info("Sending reports", "schedule", cfg.ReportSchedule, "recipients", len(cfg.ReportTo))
+	go r.Run(ctx)
+}
+
+// runReport fetches the digest of a running worker as HTML, or has the
+// worker mail it with -send. The worker's SMTP settings are used, so they
+// need not be known here.
+func runReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:8080", "base URL of the running worker")
+	token := fs.String("token", os.Getenv("WORKER_ADMIN_TOKEN"), "admin API key or JWT; defaults to $WORKER_ADMIN_TOKEN")
+	send := fs.Bool("send", false, "mail the report to the configured recipients instead of printing it")
+	output := fs.String("output", "", "file to write the HTML to instead of stdout")
+	timeout := fs.Duration("timeout", 30*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	method := http.MethodGet
+	if *send {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, *url+"/admin/report", nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Report failed:", err)
+		return 1
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Report failed:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		fmt.Fprintf(os.Stderr, "Report failed: worker returned %s: %s\n", resp.Status, strings.TrimSpace(string(msg)))
+		return 1
+	}
+	if *send {
+		fmt.Println("Report sent")
+		return 0
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Report failed:", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		fmt.Fprintln(os.Stderr, "Report failed:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/audit"
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/handlers"
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/router"
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/telemetry"
+)
+
+// Per-route options understood by the built-in middleware
+const (
+	optionTimeout      = "timeout"
+	optionMaxBodySize  = "max_body_size"
+	optionCacheControl = "cache_control"
+	optionCacheTTL     = "cache_ttl"
+	optionFormats      = "formats"
+
+	// optionSecurityProfile selects the SECURITY_ROUTE_HEADERS entry when it
+	// is keyed by something other than the route path
+	optionSecurityProfile = "security_profile"
+
+	// optionListener is listenerAdmin for routes served on ADMIN_ADDR when
+	// it is set, listenerPublic by default
+	optionListener = "listener"
+
+	// optionStreaming is "true" for routes whose responses are streamed,
+	// which the timeout would buffer. Their timeout lifts the server's
+	// write deadline instead.
+	optionStreaming = "streaming"
+)
+
+// streamingRoute reports whether route streams its responses
+func streamingRoute(route router.Route) bool {
+	value, _ := route.Option(optionStreaming)
+	streaming, _ := strconv.ParseBool(value)
+	return streaming
+}
+
+// routeDeps holds the shared state the named handlers and middleware close over
+type routeDeps struct {
+	cfg      *config.Config
+	svc      *service.Service
+	handler  *handlers.Handler
+	keyStore *middleware.StaticKeyStore
+	// Keys accepted by admin routes only
+	adminKeyStore *middleware.StaticKeyStore
+	jwtVerifier   *middleware.JWTVerifier
+	auditLog      *audit.Logger
+	cors          *middleware.CORSPolicy
+	ipFilter      *middleware.IPFilter
+	maintenance   *middleware.MaintenanceMode
+}
+
+// newRouteRegistry names every handler and middleware a route table may use
+func newRouteRegistry(d routeDeps) *router.Registry {
+	cfg := d.cfg
+	reg := router.NewRegistry()
+
+	reg.Handle("static", http.StripPrefix("/static/", http.FileServer(http.Dir("./web/static/"))))
+	reg.HandleFunc("home", d.handler.HomePage)
+	reg.HandleFunc("track", d.handler.TrackEvent)
+	reg.HandleFunc("segment_track", d.handler.SegmentTrack)
+	reg.HandleFunc("segment_page", d.handler.SegmentPage)
+	reg.HandleFunc("segment_identify", d.handler.SegmentIdentify)
+	reg.HandleFunc("segment_batch", d.handler.SegmentBatch)
+	reg.HandleFunc("webhook_ingest", d.handler.IngestWebhook)
+	reg.HandleFunc("ga4_collect", d.handler.GA4Collect)
+	reg.HandleFunc("ga4_debug", d.handler.GA4Debug)
+	reg.HandleFunc("stats", d.handler.Stats)
+	reg.HandleFunc("rates", d.handler.Rates)
+	reg.HandleFunc("anomalies", d.handler.Anomalies)
+	reg.HandleFunc("heatmap", d.handler.Heatmap)
+	reg.HandleFunc("experiment_assignments", d.handler.ExperimentAssignments)
+	reg.HandleFunc("session_replay", d.handler.SessionReplay)
+	reg.HandleFunc("flow_next", d.handler.FlowNext)
+	reg.HandleFunc("flow_paths", d.handler.FlowPaths)
+	reg.HandleFunc("health", d.handler.HealthCheck)
+	reg.HandleFunc("event_types", d.handler.EventTypes)
+	reg.HandleFunc("event_type", d.handler.EventType)
+	reg.HandleFunc("ip_rules", d.handler.IPRules)
+	reg.HandleFunc("maintenance", d.handler.Maintenance)
+	reg.HandleFunc("log_level", d.handler.LogLevel)
+	reg.HandleFunc("config", d.handler.Config)
+	reg.HandleFunc("dashboard", d.handler.Dashboard)
+	reg.HandleFunc("report", d.handler.Report)
+	reg.HandleFunc("sessions", d.handler.Sessions)
+	reg.HandleFunc("admin_health", d.handler.AdminHealth)
+	reg.HandleFunc("subject_export", d.handler.SubjectExport)
+	reg.HandleFunc("erasures", d.handler.Erasures)
+	reg.HandleFunc("erasure", d.handler.Erasure)
+	reg.Handle("grafana_dashboard", telemetry.GrafanaDashboardHandler(serviceName))
+	if cfg.DebugEndpoints {
+		reg.Handle("debug", debugHandler(d.svc))
+	}
+	if cfg.PrometheusEnabled() {
+		reg.Handle("prometheus", telemetry.MetricsHandler())
+	}
+
+	// Middleware shared by every route is built once
+	cors := middleware.DynamicCORS(d.cors)
+	ipAccess := middleware.IPAccess(d.ipFilter)
+	maintenance := middleware.Maintenance(d.maintenance)
+	csrf := middleware.CSRF(middleware.CSRFConfig{CookieSecure: cfg.TLSEnabled()})
+
+	static := func(mw middleware.Middleware) router.MiddlewareFactory {
+		return func(router.Route) (middleware.Middleware, error) { return mw, nil }
+	}
+
+	reg.Middleware("logger", static(middleware.RequestLogger()))
+	reg.Middleware("ip_access", static(ipAccess))
+	reg.Middleware("maintenance", static(maintenance))
+	reg.Middleware("cors", static(cors))
+	reg.Middleware("metrics", static(middleware.MetricsCollector(d.svc)))
+	reg.Middleware("etag", static(middleware.ETag()))
+	reg.Middleware("audit", static(middleware.Audit(d.auditLog)))
+	reg.Middleware("compress", static(middleware.Compress()))
+	reg.Middleware("dedup", static(middleware.Deduplicate()))
+
+	// Security headers, optionally overridden per route
+	reg.Middleware("security", func(route router.Route) (middleware.Middleware, error) {
+		profile, ok := route.Option(optionSecurityProfile)
+		if !ok {
+			profile = route.Path
+		}
+		return middleware.Security(middleware.SecurityPolicy{
+			ContentSecurityPolicy:     cfg.ContentSecurityPolicy,
+			HSTSMaxAge:                time.Duration(cfg.HSTSMaxAge),
+			HSTSIncludeSubdomains:     cfg.HSTSIncludeSubdomains,
+			HSTSPreload:               cfg.HSTSPreload,
+			FrameOptions:              cfg.FrameOptions,
+			ReferrerPolicy:            cfg.ReferrerPolicy,
+			CrossOriginOpenerPolicy:   cfg.CrossOriginOpenerPolicy,
+			CrossOriginEmbedderPolicy: cfg.CrossOriginEmbedderPolicy,
+			CrossOriginResourcePolicy: cfg.CrossOriginResourcePolicy,
+			PermissionsPolicy:         cfg.PermissionsPolicy,
+			Overrides:                 cfg.SecurityRouteHeaders[profile],
+		}), nil
+	})
+
+	reg.Middleware("max_body", func(route router.Route) (middleware.Middleware, error) {
+		limit := cfg.MaxBodySize
+		if value, ok := route.Option(optionMaxBodySize); ok {
+			var err error
+			if limit, err = config.ParseByteSize(value); err != nil {
+				return nil, fmt.Errorf("option %s: %w", optionMaxBodySize, err)
+			}
+		}
+		return middleware.MaxBodySize(int64(limit)), nil
+	})
+
+	reg.Middleware("timeout", func(route router.Route) (middleware.Middleware, error) {
+		if streamingRoute(route) {
+			return middleware.NoWriteDeadline(), nil
+		}
+		value, ok := route.Option(optionTimeout)
+		if !ok {
+			return middleware.Timeout(cfg.RouteTimeout(route.Path)), nil
+		}
+		d, err := config.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("option %s: %w", optionTimeout, err)
+		}
+		return middleware.Timeout(time.Duration(d)), nil
+	})
+
+	reg.Middleware("cache_control", func(route router.Route) (middleware.Middleware, error) {
+		value, ok := route.Option(optionCacheControl)
+		if !ok {
+			value = cfg.CacheControl[route.Path]
+		}
+		return middleware.CacheControl(value), nil
+	})
+
+	// Formats are listed per route as a comma separated option, JSON only
+	// by default
+	reg.Middleware("negotiate", func(route router.Route) (middleware.Middleware, error) {
+		formats := []string{middleware.FormatJSON}
+		if value, ok := route.Option(optionFormats); ok {
+			formats = strings.Split(value, ",")
+			for i := range formats {
+				formats[i] = strings.TrimSpace(formats[i])
+			}
+		}
+		return middleware.Negotiate(middleware.NegotiationConfig{
+			Formats:  formats,
+			Versions: cfg.APIVersions,
+		}), nil
+	})
+
+	// Each route gets its own response cache
+	reg.Middleware("response_cache", func(route router.Route) (middleware.Middleware, error) {
+		ttl := cfg.ResponseCacheTTL
+		if value, ok := route.Option(optionCacheTTL); ok {
+			var err error
+			if ttl, err = config.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("option %s: %w", optionCacheTTL, err)
+			}
+		}
+		return middleware.ResponseCache(middleware.ResponseCacheConfig{
+			TTL:        time.Duration(ttl),
+			MaxEntries: cfg.ResponseCacheMaxEntries,
+		}), nil
+	})
+
+	// The homepage issues the CSRF cookie whenever protection is enabled;
+	// other routes only check the token when listed in CSRF_ROUTES, after
+	// their authentication so authenticated API clients are exempt
+	reg.Middleware("csrf_cookie", func(router.Route) (middleware.Middleware, error) {
+		if !cfg.CSRFEnabled {
+			return nil, nil
+		}
+		return csrf, nil
+	})
+	reg.Middleware("csrf", func(route router.Route) (middleware.Middleware, error) {
+		if !cfg.CSRFProtected(route.Path) {
+			return nil, nil
+		}
+		return csrf, nil
+	})
+
+	reg.Middleware("tracking_auth", func(router.Route) (middleware.Middleware, error) {
+		if !cfg.RequireTrackingAuth {
+			return nil, nil
+		}
+		return middleware.APIKeyAuth(d.keyStore), nil
+	})
+	// Segment clients send the API key as their write key
+	reg.Middleware("segment_auth", func(router.Route) (middleware.Middleware, error) {
+		if !cfg.RequireTrackingAuth {
+			return nil, nil
+		}
+		return middleware.WriteKeyAuth(d.keyStore), nil
+	})
+	// The Measurement Protocol sends the API key as its API secret
+	reg.Middleware("ga4_auth", func(router.Route) (middleware.Middleware, error) {
+		if !cfg.RequireTrackingAuth {
+			return nil, nil
+		}
+		return middleware.QueryKeyAuth(d.keyStore, "api_secret"), nil
+	})
+	// Webhook senders often cannot set headers, so the key may be in the URL
+	reg.Middleware("webhook_auth", func(router.Route) (middleware.Middleware, error) {
+		if !cfg.RequireTrackingAuth {
+			return nil, nil
+		}
+		return middleware.QueryKeyAuth(d.keyStore, "api_key"), nil
+	})
+
+	// Tenant resolution comes before the response cache, which keys on it
+	reg.Middleware("tenant", func(router.Route) (middleware.Middleware, error) {
+		if cfg.TenantSource == "none" {
+			return nil, nil
+		}
+		trustedProxies, err := middleware.ParsePrefixes(cfg.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		return middleware.Tenant(middleware.TenantConfig{
+			Source:         cfg.TenantSource,
+			Header:         cfg.TenantHeader,
+			TrustedProxies: trustedProxies,
+			Tenants:        cfg.Tenants,
+			Keys:           d.keyStore,
+		}), nil
+	})
+
+	// With SIGNING_SECRET set, every ingestion route only accepts signed
+	// requests, including the Segment, GA4 and webhook ones whose usual
+	// senders cannot sign; a proxy must sign for them
+	reg.Middleware("signature", func(router.Route) (middleware.Middleware, error) {
+		if cfg.SigningSecret == "" {
+			return nil, nil
+		}
+		return middleware.HMACSignature(
+			[]byte(cfg.SigningSecret),
+			time.Duration(cfg.SignatureMaxSkew),
+		), nil
+	})
+
+	// Admin routes use the identity provider when JWT is configured and
+	// fall back to the admin API keys otherwise
+	reg.Middleware("admin_client_cert", func(router.Route) (middleware.Middleware, error) {
+		if !cfg.TLSAdminClientCert {
+			return nil, nil
+		}
+		return middleware.ClientCertAuth(), nil
+	})
+	// With ADMIN_AUTH=none, reaching the admin listener is authorization
+	// enough
+	reg.Middleware("admin_auth", func(route router.Route) (middleware.Middleware, error) {
+		switch {
+		case cfg.AdminAuth == "none" && onAdminListener(cfg, route):
+			return nil, nil
+		case d.jwtVerifier != nil:
+			return middleware.JWTAuth(d.jwtVerifier), nil
+		case d.adminKeyStore.Len() > 0:
+			return middleware.APIKeyAuth(d.adminKeyStore), nil
+		default:
+			// Only routes on the admin listener may go without credentials
+			return nil, fmt.Errorf("admin_auth needs JWT or admin API keys off the admin listener")
+		}
+	})
+
+	return reg
+}
+
+// corsConfig translates the CORS settings, which can change on reload
+func corsConfig(cfg *config.Config) middleware.CORSConfig {
+	return middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   cfg.CORSExposedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           time.Duration(cfg.CORSMaxAge),
+	}
+}
+
+// defaultRoutes is the built-in route table used when no ROUTES_FILE is set
+func defaultRoutes(cfg *config.Config) []router.Route {
+	public := []string{"logger", "ip_access", "maintenance", "security", "cors"}
+	admin := []string{"logger", "security", "admin_client_cert", "admin_auth", "audit", "timeout"}
+	adminOptions := map[string]string{
+		optionSecurityProfile: "/admin/",
+		optionListener:        listenerAdmin,
+	}
+
+	routes := []router.Route{
+		{
+			Path:       "/static/",
+			Handler:    "static",
+			Middleware: with(public, "cache_control", "etag"),
+		},
+		{
+			Path:       "/",
+			Handler:    "home",
+			Middleware: with(public, "metrics", "timeout", "csrf_cookie"),
+		},
+		{
+			Path:       "/api/track",
+			Handler:    "track",
+			Middleware: with(public, "metrics", "max_body", "timeout", "tracking_auth", "csrf", "tenant", "signature"),
+		},
+		{
+			Path:       "/api/stats",
+			Handler:    "stats",
+			Middleware: with(public, "metrics", "timeout", "tenant", "compress", "negotiate", "cache_control", "etag", "response_cache"),
+			Options: map[string]string{
+				optionFormats: strings.Join([]string{
+					middleware.FormatJSON,
+					middleware.FormatNDJSON,
+					middleware.FormatProtobuf,
+				}, ","),
+			},
+		},
+		{
+			Path:       "/api/stats/rates",
+			Handler:    "rates",
+			Middleware: with(public, "metrics", "timeout", "tenant", "compress"),
+		},
+		{
+			Path:       "/api/stats/heatmap",
+			Handler:    "heatmap",
+			Middleware: with(public, "metrics", "timeout", "tenant", "compress"),
+		},
+		{
+			// Anomalies are detected over the whole deployment, so they
+			// need admin credentials and are kept off the public listener
+			// when there is an admin one
+			Path:       "/api/stats/anomalies",
+			Handler:    "anomalies",
+			Middleware: with(public, "metrics", "admin_client_cert", "admin_auth", "tenant", "timeout", "compress"),
+			Options:    map[string]string{optionListener: listenerAdmin},
+		},
+		{
+			Path:       "/api/v1/ingest/webhook/{source}",
+			Handler:    "webhook_ingest",
+			Middleware: with(public, "metrics", "max_body", "timeout", "webhook_auth", "tenant", "signature"),
+		},
+		{
+			Path:       "/api/v1/experiments/assignments",
+			Handler:    "experiment_assignments",
+			Middleware: with(public, "metrics", "timeout", "tenant"),
+		},
+		{
+			Path:       "/api/v1/flows/next",
+			Handler:    "flow_next",
+			Middleware: with(public, "metrics", "timeout", "tenant", "compress"),
+		},
+		{
+			Path:       "/api/v1/flows/paths",
+			Handler:    "flow_paths",
+			Middleware: with(public, "metrics", "timeout", "tenant", "compress"),
+		},
+		{
+			// Recordings hold page content, so they need admin credentials
+			// and are kept off the public listener when there is an admin
+			// one. Its chunks are compressed already.
+			Path:       "/api/v1/sessions/{id}/replay",
+			Handler:    "session_replay",
+			Middleware: with(public, "metrics", "admin_client_cert", "admin_auth", "tenant", "timeout"),
+			Options:    map[string]string{optionListener: listenerAdmin, optionStreaming: "true"},
+		},
+		{
+			Path:       "/api/health",
+			Handler:    "health",
+			Middleware: []string{"logger"},
+		},
+		{Path: "/admin/event-types", Handler: "event_types", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/event-types/{name}", Handler: "event_type", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/ip-rules", Handler: "ip_rules", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/maintenance", Handler: "maintenance", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/loglevel", Handler: "log_level", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/config", Handler: "config", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/dashboard", Handler: "dashboard", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/grafana-dashboard.json", Handler: "grafana_dashboard", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/report", Handler: "report", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/sessions", Handler: "sessions", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/health", Handler: "admin_health", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/privacy/subjects/{id}", Handler: "subject_export", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/privacy/erasures", Handler: "erasures", Middleware: admin, Options: adminOptions},
+		{Path: "/admin/privacy/erasures/{id}", Handler: "erasure", Middleware: admin, Options: adminOptions},
+	}
+
+	// Segment's HTTP API, under its own paths and the one-letter ones
+	// analytics.js posts to
+	for _, call := range []string{"track", "page", "identify", "batch"} {
+		for _, path := range []string{"/v1/" + call, "/v1/" + call[:1]} {
+			route := router.Route{
+				Path:       path,
+				Handler:    "segment_" + call,
+				Middleware: with(public, "metrics", "max_body", "timeout", "segment_auth", "tenant", "signature"),
+			}
+			if call == "batch" {
+				// Segment's batch limit
+				route.Options = map[string]string{optionMaxBodySize: "500KB"}
+			}
+			routes = append(routes, route)
+		}
+	}
+
+	// GA4 Measurement Protocol, at Google's paths
+	routes = append(routes,
+		router.Route{
+			Path:       "/mp/collect",
+			Handler:    "ga4_collect",
+			Middleware: with(public, "metrics", "max_body", "timeout", "ga4_auth", "tenant", "signature"),
+		},
+		router.Route{
+			Path:       "/debug/mp/collect",
+			Handler:    "ga4_debug",
+			Middleware: with(public, "metrics", "max_body", "timeout", "ga4_auth", "tenant", "signature"),
+		},
+	)
+
+	if cfg.DebugEndpoints {
+		routes = append(routes, router.Route{
+			Path:       "/debug/",
+			Handler:    "debug",
+			Middleware: []string{"logger", "security", "admin_client_cert", "admin_auth", "audit"},
+			Options:    adminOptions,
+		})
+	}
+	if cfg.PrometheusEnabled() && cfg.MetricsPort == 0 {
+		// Scrapes are too frequent to audit; scrapers authenticate like
+		// any other admin client when admin auth is configured
+		routes = append(routes, router.Route{
+			Path:       "GET /metrics",
+			Handler:    "prometheus",
+			Middleware: []string{"security", "admin_client_cert", "admin_auth"},
+			Options:    adminOptions,
+		})
+	}
+	return routes
+}
+
+func with(base []string, names ...string) []string {
+	return append(append([]string(nil), base...), names...)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyLogLevelCycle is a no-op where SIGUSR1 does not exist; the admin
+// API still changes the log level
+func notifyLogLevelCycle(chan<- os.Signal) {}
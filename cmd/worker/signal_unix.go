@@ -0,0 +1,14 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyLogLevelCycle delivers SIGUSR1, which steps through log levels
+func notifyLogLevelCycle(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
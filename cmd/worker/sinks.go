@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/sink"
+)
+
+// buildSinks creates the sinks named in cfg.Sinks, in order
+func buildSinks(cfg *config.Config) ([]*sink.Batcher, error) {
+	batching := sink.Config{
+		QueueSize:     cfg.SinkQueueSize,
+		BatchSize:     cfg.SinkBatchSize,
+		FlushInterval: time.Duration(cfg.SinkFlushInterval),
+		WriteTimeout:  time.Duration(cfg.SinkWriteTimeout),
+	}
+
+	var sinks []*sink.Batcher
+	for _, name := range cfg.Sinks {
+		w, err := newSinkWriter(cfg, name)
+		if err != nil {
+			closeSinks(context.Background(), sinks)
+			return nil, fmt.Errorf("%s sink: %w", name, err)
+		}
+		sinks = append(sinks, sink.New(name, w, batching))
+	}
+	return sinks, nil
+}
+
+func newSinkWriter(cfg *config.Config, name string) (sink.Writer, error) {
+	switch name {
+	case "kafka":
+		return sink.NewKafka(sink.KafkaConfig{
+			Brokers:     cfg.KafkaBrokers,
+			Topic:       cfg.KafkaTopic,
+			Compression: cfg.KafkaCompression,
+			BatchSize:   cfg.SinkBatchSize,
+		})
+	case "nats":
+		return sink.NewNATS(sink.NATSConfig{
+			URL:             cfg.NATSURL,
+			Subject:         cfg.NATSSubject,
+			CredentialsFile: cfg.NATSCredentialsFile,
+		})
+	case "webhook":
+		return sink.NewWebhook(sink.WebhookConfig{
+			URLs:             cfg.WebhookURLs,
+			Secret:           []byte(cfg.WebhookSecret),
+			MaxAttempts:      cfg.WebhookMaxAttempts,
+			BreakerThreshold: cfg.WebhookBreakerThreshold,
+			BreakerCooldown:  time.Duration(cfg.WebhookBreakerCooldown),
+		})
+	case "kinesis":
+		return sink.NewKinesis(sink.KinesisConfig{
+			Stream:    cfg.KinesisStream,
+			Aggregate: cfg.KinesisAggregate,
+		})
+	case "firehose":
+		return sink.NewFirehose(sink.FirehoseConfig{
+			DeliveryStream: cfg.FirehoseDeliveryStream,
+			Aggregate:      cfg.FirehoseAggregate,
+		})
+	case "elasticsearch":
+		return sink.NewElasticsearch(sink.ElasticsearchConfig{
+			URLs:           cfg.ElasticsearchURLs,
+			Index:          cfg.ElasticsearchIndex,
+			IndexMode:      cfg.ElasticsearchIndexMode,
+			Username:       cfg.ElasticsearchUsername,
+			Password:       cfg.ElasticsearchPassword,
+			APIKey:         cfg.ElasticsearchAPIKey,
+			ManageTemplate: cfg.ElasticsearchManageTemplate,
+		})
+	case "redis":
+		return sink.NewRedis(sink.RedisConfig{
+			URL:      cfg.RedisURL,
+			Password: cfg.RedisPassword,
+			Channel:  cfg.RedisChannel,
+		})
+	case "amqp":
+		return sink.NewAMQP(sink.AMQPConfig{
+			URL:        cfg.AMQPURL,
+			Password:   cfg.AMQPPassword,
+			Exchange:   cfg.AMQPExchange,
+			RoutingKey: cfg.AMQPRoutingKey,
+		})
+	case "bigquery":
+		return sink.NewBigQuery(sink.BigQueryConfig{
+			Project:      cfg.BigQueryProject,
+			Dataset:      cfg.BigQueryDataset,
+			Table:        cfg.BigQueryTable,
+			ManageSchema: cfg.BigQueryManageSchema,
+		})
+	default:
+		return nil, fmt.Errorf("unknown sink")
+	}
+}
+
+// closeSinks writes the events sinks still queue and closes them
+func closeSinks(ctx context.Context, sinks []*sink.Batcher) {
+	for _, s := range sinks {
+		if err := s.Close(ctx); err != nil {
+			slog.Error("Failed to close sink", "error", err)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Alert states
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// Alert is a notification that a rule started or stopped breaching its
+// threshold
+type Alert struct {
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	Summary   string    `json:"summary"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	StartsAt  time.Time `json:"starts_at"`
+	Time      time.Time `json:"time"`
+}
+
+// Rule fires while Value returns more than Threshold
+type Rule struct {
+	Name      string
+	Summary   string
+	Threshold float64
+	Value     func() float64
+}
+
+// Notifier delivers alerts somewhere a person will see them
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Evaluator checks its rules periodically. A rule notifies once when it
+// starts firing, again every cool-down while it keeps firing, and once
+// when it resolves, so a flapping value does not page on every check. Each
+// notifier is tracked apart: one that failed or was rate limited is sent
+// the alert again on the next check, and is only sent a resolution for a
+// firing alert it delivered.
+type Evaluator struct {
+	rules     []Rule
+	notifiers []Notifier
+	cooldown  time.Duration
+
+	mu     sync.Mutex
+	states map[string]*ruleState
+}
+
+type ruleState struct {
+	firing bool
+	since  time.Time
+
+	// Per notifier, the state last delivered and when
+	sent   []string
+	sentAt []time.Time
+}
+
+// NewEvaluator creates an evaluator logging alerts and sending them to
+// notifiers
+func NewEvaluator(rules []Rule, notifiers []Notifier, cooldown time.Duration) *Evaluator {
+	return &Evaluator{
+		rules:     rules,
+		notifiers: append([]Notifier{logNotifier{}}, notifiers...),
+		cooldown:  cooldown,
+		states:    make(map[string]*ruleState),
+	}
+}
+
+// Run evaluates the rules every interval until ctx is done
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.Evaluate(ctx, now)
+		}
+	}
+}
+
+// Evaluate checks every rule once and sends the alerts that are due
+func (e *Evaluator) Evaluate(ctx context.Context, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, r := range e.rules {
+		value := r.Value()
+		state, ok := e.states[r.Name]
+		if !ok {
+			state = &ruleState{
+				sent:   make([]string, len(e.notifiers)),
+				sentAt: make([]time.Time, len(e.notifiers)),
+			}
+			e.states[r.Name] = state
+		}
+
+		breached := value > r.Threshold
+		if breached && !state.firing {
+			state.since = now
+		}
+		state.firing = breached
+
+		a := Alert{
+			Rule:      r.Name,
+			State:     StateResolved,
+			Summary:   r.Summary,
+			Value:     value,
+			Threshold: r.Threshold,
+			StartsAt:  state.since,
+			Time:      now,
+		}
+		if state.firing {
+			a.State = StateFiring
+		}
+
+		for i, n := range e.notifiers {
+			if !e.due(state, i, a.State, now) {
+				continue
+			}
+			if err := n.Notify(ctx, a); err != nil {
+				slog.ErrorContext(ctx, "Failed to send alert", "rule", a.Rule, "notifier", fmt.Sprintf("%T", unwrap(n)), "error", err)
+				continue
+			}
+			state.sent[i], state.sentAt[i] = a.State, now
+		}
+	}
+}
+
+// due reports whether notifier i is owed an alert in state
+func (e *Evaluator) due(state *ruleState, i int, alertState string, now time.Time) bool {
+	if alertState == StateFiring {
+		return state.sent[i] != StateFiring || now.Sub(state.sentAt[i]) >= e.cooldown
+	}
+	return state.sent[i] == StateFiring
+}
+
+// logNotifier logs alerts, which is where they go without notifiers
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, a Alert) error {
+	if a.State == StateFiring {
+		slog.WarnContext(ctx, "Alert firing", "rule", a.Rule, "value", a.Value, "threshold", a.Threshold)
+	} else {
+		slog.InfoContext(ctx, "Alert resolved", "rule", a.Rule, "value", a.Value, "threshold", a.Threshold)
+	}
+	return nil
+}
+
+// unwrap returns the notifier a wrapper such as RateLimit's sends through
+func unwrap(n Notifier) Notifier {
+	for {
+		w, ok := n.(interface{ Unwrap() Notifier })
+		if !ok {
+			return n
+		}
+		n = w.Unwrap()
+	}
+}
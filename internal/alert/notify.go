@@ -0,0 +1,177 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrRateLimited is returned for a firing alert a rate limited notifier
+// dropped
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimit sends at most perHour firing alerts an hour through n, in
+// bursts of up to a tenth of that. Resolutions always go through, so an
+// incident that was opened is closed. A perHour of 0 leaves n unlimited.
+func RateLimit(n Notifier, perHour int) Notifier {
+	if perHour <= 0 {
+		return n
+	}
+	return &rateLimited{
+		next:    n,
+		limiter: rate.NewLimiter(rate.Every(time.Hour/time.Duration(perHour)), max(1, perHour/10)),
+	}
+}
+
+type rateLimited struct {
+	next    Notifier
+	limiter *rate.Limiter
+}
+
+func (n *rateLimited) Notify(ctx context.Context, a Alert) error {
+	if a.State == StateFiring && !n.limiter.Allow() {
+		return ErrRateLimited
+	}
+	return n.next.Notify(ctx, a)
+}
+
+func (n *rateLimited) Unwrap() Notifier {
+	return n.next
+}
+
+// Webhook posts each alert as JSON to a URL
+type Webhook struct {
+	URL string
+}
+
+func (n Webhook) Notify(ctx context.Context, a Alert) error {
+	return postJSON(ctx, n.URL, a)
+}
+
+// Slack posts alerts to a Slack incoming webhook, as a line of text with
+// an attachment colored by state that lists the value, threshold and start
+type Slack struct {
+	WebhookURL string
+}
+
+// Attachment colors, Slack's own red and green
+const (
+	slackColorFiring   = "#e01e5a"
+	slackColorResolved = "#2eb67d"
+)
+
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments,omitempty"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Fields []slackField `json:"fields"`
+	TS     int64        `json:"ts"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (n Slack) Notify(ctx context.Context, a Alert) error {
+	value, threshold := formatFloat(a.Value), formatFloat(a.Threshold)
+	text := fmt.Sprintf(":rotating_light: *%s* firing: %s (%s > %s)", a.Rule, a.Summary, value, threshold)
+	color := slackColorFiring
+	if a.State == StateResolved {
+		text = fmt.Sprintf(":white_check_mark: *%s* resolved: %s (%s)", a.Rule, a.Summary, value)
+		color = slackColorResolved
+	}
+	return postJSON(ctx, n.WebhookURL, slackMessage{
+		Text: text,
+		Attachments: []slackAttachment{{
+			Color: color,
+			Fields: []slackField{
+				{Title: "Value", Value: value, Short: true},
+				{Title: "Threshold", Value: threshold, Short: true},
+				{Title: "Since", Value: a.StartsAt.UTC().Format(time.RFC3339), Short: true},
+			},
+			TS: a.Time.Unix(),
+		}},
+	})
+}
+
+// PagerDuty triggers and resolves incidents through the Events API v2.
+// Source and rule name form the dedup key, so a rule maps to one incident.
+type PagerDuty struct {
+	RoutingKey string
+	Source     string
+
+	// URL overrides PagerDutyEventsURL
+	URL string
+}
+
+func (n PagerDuty) Notify(ctx context.Context, a Alert) error {
+	event := map[string]interface{}{
+		"routing_key":  n.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    n.Source + "/" + a.Rule,
+	}
+	if a.State == StateResolved {
+		event["event_action"] = "resolve"
+	} else {
+		event["payload"] = map[string]interface{}{
+			"summary":        a.Rule + ": " + a.Summary,
+			"source":         n.Source,
+			"severity":       "error",
+			"timestamp":      a.StartsAt.Format(time.RFC3339),
+			"custom_details": a,
+		}
+	}
+
+	url := n.URL
+	if url == "" {
+		url = PagerDutyEventsURL
+	}
+	return postJSON(ctx, url, event)
+}
+
+// formatFloat avoids exponents, which read badly for byte counts
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is a single audit record. Entries are chained: Hash covers the
+// entry's content together with PrevHash, so editing or dropping a line
+// breaks every hash after it.
+type Entry struct {
+	Time      time.Time   `json:"time"`
+	Seq       int64       `json:"seq"`
+	Actor     string      `json:"actor"`
+	Action    string      `json:"action"`
+	Method    string      `json:"method"`
+	Path      string      `json:"path"`
+	Status    int         `json:"status"`
+	RequestID string      `json:"request_id,omitempty"`
+	ClientIP  string      `json:"client_ip,omitempty"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+	PrevHash  string      `json:"prev_hash"`
+	Hash      string      `json:"hash"`
+}
+
+// Logger appends hash-chained entries to a dedicated audit stream
+type Logger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	closer   io.Closer
+	seq      int64
+	prevHash string
+}
+
+// New writes audit entries to w, starting a fresh chain
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Open appends to the audit file at path, continuing the chain from its
+// last entry if the file already exists
+func Open(path string) (*Logger, error) {
+	l := &Logger{}
+
+	if f, err := os.Open(path); err == nil {
+		last, err := lastEntry(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read audit log: %w", err)
+		}
+		if last != nil {
+			l.seq = last.Seq
+			l.prevHash = last.Hash
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	l.w = f
+	l.closer = f
+	return l, nil
+}
+
+// Close closes the underlying file, if any
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Log completes the entry's sequence number and hashes and writes it
+func (l *Logger) Log(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Seq = l.seq + 1
+	e.PrevHash = l.prevHash
+
+	// Hash the values in the generic form Verify will decode them into
+	var err error
+	if e.Before, err = normalize(e.Before); err != nil {
+		return err
+	}
+	if e.After, err = normalize(e.After); err != nil {
+		return err
+	}
+
+	hash, err := entryHash(e)
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	l.seq = e.Seq
+	l.prevHash = e.Hash
+	return nil
+}
+
+// Verify reads an audit log and checks that every entry's hash is intact
+// and links to its predecessor
+func Verify(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	prev := ""
+	first := true
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if !first && e.PrevHash != prev {
+			return fmt.Errorf("line %d: chain broken, previous hash does not match", line)
+		}
+		want, err := entryHash(e)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if want != e.Hash {
+			return fmt.Errorf("line %d: entry hash mismatch", line)
+		}
+		prev = e.Hash
+		first = false
+	}
+	return scanner.Err()
+}
+
+func entryHash(e Entry) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func normalize(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+	return generic, err
+}
+
+func lastEntry(r io.Reader) (*Entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var last []byte
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	var e Entry
+	if err := json.Unmarshal(last, &e); err != nil {
+		return nil, errors.New("last audit entry is not valid JSON")
+	}
+	return &e, nil
+}
+
+type recordKey struct{}
+
+// Record collects details about an admin request while it is handled
+type Record struct {
+	mu     sync.Mutex
+	action string
+	before interface{}
+	after  interface{}
+}
+
+// WithRecord attaches an empty record to ctx
+func WithRecord(ctx context.Context) (context.Context, *Record) {
+	rec := &Record{}
+	return context.WithValue(ctx, recordKey{}, rec), rec
+}
+
+// SetChange notes a configuration change made while handling the request
+func SetChange(ctx context.Context, action string, before, after interface{}) {
+	rec, ok := ctx.Value(recordKey{}).(*Record)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.action = action
+	rec.before = before
+	rec.after = after
+}
+
+// Change returns the change noted via SetChange
+func (r *Record) Change() (action string, before, after interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.action, r.before, r.after
+}
@@ -0,0 +1,237 @@
+// Package certs serves the TLS certificate and client CAs from files,
+// reloading them when they change on disk so renewed certificates are
+// picked up without a restart, or obtains certificates from an ACME
+// certificate authority such as Let's Encrypt.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config names the PEM files to serve. Without ClientCAFile, client
+// certificates are not requested. With ACME, certificates are obtained for
+// its hosts rather than read from CertFile and KeyFile.
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ACME         *ACMEConfig
+}
+
+// ACMEConfig sets how certificates are obtained and renewed. The
+// authority's HTTP-01 challenges are answered by HTTPHandler, which must
+// be served on port 80 of every host.
+type ACMEConfig struct {
+	// Hosts certificates are obtained for; others are refused
+	Hosts []string
+
+	// Contact address given to the authority, optional
+	Email string
+
+	// Directory the account key and certificates are kept in between
+	// restarts, which the authority's rate limits make necessary
+	CacheDir string
+
+	// The authority's directory; Let's Encrypt's production one when empty
+	DirectoryURL string
+}
+
+// Store holds the loaded certificate and client CAs
+type Store struct {
+	cfg  Config
+	acme *autocert.Manager
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamps    map[string]stamp
+}
+
+// stamp identifies a version of a file
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Load reads the files named by cfg. ACME certificates are obtained later,
+// on the first handshake for each host.
+func Load(cfg Config) (*Store, error) {
+	s := &Store{cfg: cfg}
+	if cfg.ACME != nil {
+		s.acme = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACME.Hosts...),
+			Cache:      autocert.DirCache(cfg.ACME.CacheDir),
+			Email:      cfg.ACME.Email,
+			Client:     &acme.Client{DirectoryURL: cfg.ACME.DirectoryURL},
+		}
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the files again, keeping the loaded ones if any is invalid
+func (s *Store) Reload() error {
+	stamps, err := s.stat()
+	if err != nil {
+		return err
+	}
+	var cert *tls.Certificate
+	if s.acme == nil {
+		pair, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &pair
+	}
+	var pool *x509.CertPool
+	if s.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(s.cfg.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates in " + s.cfg.ClientCAFile)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert, s.clientCAs, s.stamps = cert, pool, stamps
+	return nil
+}
+
+func (s *Store) stat() (map[string]stamp, error) {
+	stamps := make(map[string]stamp, 3)
+	for _, path := range []string{s.cfg.CertFile, s.cfg.KeyFile, s.cfg.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps[path] = stamp{info.ModTime(), info.Size()}
+	}
+	return stamps, nil
+}
+
+// changed reports whether any file differs from the loaded version
+func (s *Store) changed() (bool, error) {
+	stamps, err := s.stat()
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for path, st := range stamps {
+		if loaded := s.stamps[path]; !st.modTime.Equal(loaded.modTime) || st.size != loaded.size {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Watch reloads the files every interval they changed until ctx is done.
+// A certificate and key replaced one after the other may be seen half
+// updated; the mismatched pair fails to load and is retried on the next
+// check.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := s.changed()
+			if err != nil {
+				slog.Warn("TLS files unavailable, keeping the loaded ones", "error", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				slog.Error("Failed to reload TLS files", "error", err)
+				continue
+			}
+			if s.acme != nil {
+				slog.Info("Reloaded TLS client CAs", "file", s.cfg.ClientCAFile)
+				continue
+			}
+			slog.Info("Reloaded TLS certificate", "file", s.cfg.CertFile, "expires", s.expiry())
+		}
+	}
+}
+
+func (s *Store) expiry() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.cert.Leaf == nil {
+		return time.Time{}
+	}
+	return s.cert.Leaf.NotAfter
+}
+
+// TLSConfig returns a server configuration presenting the current
+// certificate. With client CAs, client certificates are verified when
+// given but not required, leaving routes to decide whether they need one.
+// Fields set on the returned configuration, such as NextProtos, apply to
+// every handshake.
+func (s *Store) TLSConfig() *tls.Config {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	base.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if s.acme != nil {
+			// Obtained on the first handshake for a host and renewed
+			// before they expire
+			return s.acme.GetCertificate(hello)
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.cert, nil
+	}
+	// The client CAs may change, so each handshake gets the current ones
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		s.mu.RLock()
+		pool := s.clientCAs
+		s.mu.RUnlock()
+		if pool == nil {
+			return nil, nil
+		}
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		return cfg, nil
+	}
+	return base
+}
+
+// HTTPHandler answers the ACME authority's HTTP-01 challenges, passing
+// other requests to fallback. Without ACME it is fallback.
+func (s *Store) HTTPHandler(fallback http.Handler) http.Handler {
+	if s.acme == nil {
+		return fallback
+	}
+	return s.acme.HTTPHandler(fallback)
+}
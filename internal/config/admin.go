@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// validateAdmin checks the admin listener settings
+func (c *Config) validateAdmin() error {
+	switch c.AdminAuth {
+	case "token":
+	case "none":
+		// Only a listener nothing off the host reaches may go without
+		// credentials
+		if !c.privateAdminListener() {
+			return fmt.Errorf("admin_auth none needs admin_addr to be a unix:// socket or a loopback host:port, got %q", c.AdminAddr)
+		}
+	default:
+		return fmt.Errorf("admin_auth must be token or none, got %s", c.AdminAuth)
+	}
+
+	// Admin routes are never open by accident
+	if c.AdminAuth == "token" && !c.JWTEnabled() && c.AdminAPIKeys == "" && c.AdminAPIKeysFile == "" {
+		return fmt.Errorf("admin routes need admin_api_keys, admin_api_keys_file or JWT, or admin_auth none on a private admin_addr")
+	}
+
+	// Profiles and process internals never share the public listener
+	if c.DebugEndpoints && c.AdminAddr == "" {
+		return fmt.Errorf("debug_endpoints needs admin_addr, so /debug/ is not served on the public listener")
+	}
+
+	network, addr := c.AdminListener()
+	switch network {
+	case "unix":
+		if addr == "" {
+			return fmt.Errorf("admin_addr must name a socket path after unix://")
+		}
+		// Client certificates need TLS, which the socket is served without
+		if c.TLSAdminClientCert {
+			return fmt.Errorf("tls_admin_client_cert cannot be checked on the admin_addr Unix socket, which is served without TLS")
+		}
+		if httpNetwork, httpAddr := c.HTTPListener(); httpNetwork == network && httpAddr == addr {
+			return fmt.Errorf("admin_addr must differ from http_socket, got %q for both", c.AdminAddr)
+		}
+	case "tcp":
+		_, portValue, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("admin_addr must be host:port, unix:///path or systemd, got %q", c.AdminAddr)
+		}
+		port, err := strconv.Atoi(portValue)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("admin_addr port must be between 1 and 65535, got %q", portValue)
+		}
+		if port == c.Port || port == c.MetricsPort || port == c.GRPCPort || (c.ACMEEnabled() && port == c.ACMEHTTPPort) {
+			return fmt.Errorf("admin_addr port %d must differ from port, metrics_port, grpc_port and acme_http_port", port)
+		}
+	}
+	return nil
+}
+
+// privateAdminListener reports whether the admin listener is a Unix socket
+// or bound to loopback. A systemd socket may be bound anywhere, so it is not
+// taken as private.
+func (c *Config) privateAdminListener() bool {
+	network, addr := c.AdminListener()
+	switch network {
+	case "unix":
+		return true
+	case "tcp":
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return false
+		}
+		if host == "localhost" {
+			return true
+		}
+		ip, err := netip.ParseAddr(host)
+		return err == nil && ip.IsLoopback()
+	}
+	return false
+}
@@ -1,9 +1,22 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
@@ -11,15 +24,543 @@ type Config struct {
 	LogLevel     string `json:"log_level"`
 	OTELEndpoint string `json:"otel_endpoint"`
 	Environment  string `json:"environment"`
+
+	// Telemetry can be switched off entirely. When enabled, a pipeline
+	// that fails to start leaves the server running without it, and
+	// failed exports, which the OTLP exporters retry, are logged at most
+	// once per TelemetryErrorInterval.
+	TelemetryEnabled       bool     `json:"telemetry_enabled"`
+	TelemetryErrorInterval Duration `json:"telemetry_error_interval"`
+
+	// OTLP exporter settings, read from the standard OTEL_EXPORTER_OTLP_*
+	// variables. Headers often carry vendor API keys, so they are a secret.
+	OTELProtocol          string   `json:"otel_protocol"`
+	OTELInsecure          bool     `json:"otel_insecure"`
+	OTELHeaders           string   `json:"-"`
+	OTELCertificate       string   `json:"otel_certificate"`
+	OTELClientCertificate string   `json:"otel_client_certificate"`
+	OTELClientKey         string   `json:"otel_client_key"`
+	OTELCompression       string   `json:"otel_compression"`
+	OTELTimeout           Duration `json:"otel_timeout"`
+	OTELRetry             bool     `json:"otel_retry"`
+	OTELRetryMaxElapsed   Duration `json:"otel_retry_max_elapsed"`
+
+	// Metrics exporter: otlp, prometheus, both or none. Prometheus metrics
+	// are served at /metrics on MetricsPort, or with the admin routes when
+	// it is 0.
+	MetricsExporter string `json:"metrics_exporter"`
+	MetricsPort     int    `json:"metrics_port"`
+
+	// Metrics are also sent over StatsD to StatsDAddr, host:port or
+	// unix:///path, when set. StatsDFlavor is statsd or dogstatsd; only
+	// dogstatsd carries attributes and StatsDTags, as name:value tags.
+	StatsDAddr     string   `json:"statsd_addr"`
+	StatsDPrefix   string   `json:"statsd_prefix"`
+	StatsDFlavor   string   `json:"statsd_flavor"`
+	StatsDTags     []string `json:"statsd_tags"`
+	StatsDInterval Duration `json:"statsd_interval"`
+
+	// Aggregate stats, such as click rates, active sessions and sink queue
+	// lengths, are pushed in the InfluxDB line protocol to InfluxURL, a
+	// full write endpoint, every InfluxInterval when set. InfluxToken
+	// authenticates to InfluxDB 2.x.
+	InfluxURL      string   `json:"influx_url"`
+	InfluxToken    string   `json:"-"`
+	InfluxInterval Duration `json:"influx_interval"`
+
+	// MetricsMaxRoutes caps the distinct route labels on HTTP metrics
+	MetricsMaxRoutes int `json:"metrics_max_routes"`
+
+	// Cursor positions are recorded MetricsBatchSize at a time, or every
+	// MetricsBatchInterval; a size of 0 records each one as it arrives
+	MetricsBatchSize     int      `json:"metrics_batch_size"`
+	MetricsBatchInterval Duration `json:"metrics_batch_interval"`
+
+	// Histogram bucket boundaries, in seconds and in pixels
+	HTTPLatencyBuckets    []float64 `json:"http_latency_buckets"`
+	CursorPositionBuckets []float64 `json:"cursor_position_buckets"`
+
+	// Session histogram buckets: events, seconds and clicks per session
+	SessionEventBuckets    []float64 `json:"session_event_buckets"`
+	SessionDurationBuckets []float64 `json:"session_duration_buckets"`
+	SessionClickBuckets    []float64 `json:"session_click_buckets"`
+
+	// Sessions idle for SessionTimeout expire, which records them in the
+	// session histograms; expiry runs every SessionCleanupInterval
+	SessionTimeout         Duration `json:"session_timeout"`
+	SessionCleanupInterval Duration `json:"session_cleanup_interval"`
+
+	// Gaps between a session's events longer than this count as idle
+	// rather than active time
+	SessionIdleThreshold Duration `json:"session_idle_threshold"`
+
+	// MetricsExemplarFilter decides which measurements may be kept as
+	// exemplars linking metrics to traces: trace_based, always_on or
+	// always_off
+	MetricsExemplarFilter string `json:"metrics_exemplar_filter"`
+
+	// LogsExporter is otlp to also send logs to the collector, or none to
+	// only write them to stdout
+	LogsExporter string `json:"logs_exporter"`
+
+	// Logs are also appended to LogFile when set, which is rotated before
+	// it grows past LogFileMaxSize or once it is LogFileMaxAge old (0
+	// disables either limit), keeping LogFileMaxBackups rotated files (0
+	// keeps all). LogSyslog also sends them to syslog: "local" for the
+	// local socket, which journald serves too, or udp://host:port or
+	// tcp://host:port. Changes need a restart.
+	LogFile           string   `json:"log_file"`
+	LogFileMaxSize    ByteSize `json:"log_file_max_size"`
+	LogFileMaxAge     Duration `json:"log_file_max_age"`
+	LogFileMaxBackups int      `json:"log_file_max_backups"`
+	LogSyslog         string   `json:"log_syslog"`
+
+	// RuntimeMetrics reports goroutines, heap, GC, CPU and open files
+	RuntimeMetrics bool `json:"runtime_metrics"`
+
+	// Trace sampling. TraceSampler takes the OTEL_TRACES_SAMPLER names and
+	// TraceSampleRatio is its ratio. Route ratios replace it for new traces
+	// whose URL path matches, and spans that end in error are exported
+	// regardless of the sampling decision when TraceSampleErrors is set.
+	TraceSampler           string             `json:"trace_sampler"`
+	TraceSampleRatio       float64            `json:"trace_sample_ratio"`
+	TraceRouteSampleRatios map[string]float64 `json:"trace_route_sample_ratios"`
+	TraceSampleErrors      bool               `json:"trace_sample_errors"`
+
+	// Tail sampling holds unsampled spans in memory per trace and exports
+	// the traces that had an error or a span slower than TraceTailLatency
+	TraceTailSampling  bool     `json:"trace_tail_sampling"`
+	TraceTailLatency   Duration `json:"trace_tail_latency"`
+	TraceTailWait      Duration `json:"trace_tail_wait"`
+	TraceTailMaxTraces int      `json:"trace_tail_max_traces"`
+
+	// Per event type fractions of tracking events that produce spans and
+	// metric recordings, such as {"mousemove": 0.01}; unlisted types are
+	// always recorded
+	EventSpanSampleRates   map[string]float64 `json:"event_span_sample_rates"`
+	EventMetricSampleRates map[string]float64 `json:"event_metric_sample_rates"`
+
+	// Service level objectives over the requests HTTP metrics are recorded
+	// for: the share answered without a 5xx and the share answered within
+	// SLOLatencyThreshold. Ratios and error budget burn rates are computed
+	// over each of SLOWindows.
+	SLOAvailabilityTarget float64    `json:"slo_availability_target"`
+	SLOLatencyTarget      float64    `json:"slo_latency_target"`
+	SLOLatencyThreshold   Duration   `json:"slo_latency_threshold"`
+	SLOWindows            []Duration `json:"slo_windows"`
+
+	// HTTP server timeouts and limits
+	ReadTimeout         Duration `json:"read_timeout"`
+	ReadHeaderTimeout   Duration `json:"read_header_timeout"`
+	WriteTimeout        Duration `json:"write_timeout"`
+	IdleTimeout         Duration `json:"idle_timeout"`
+	MaxHeaderBytes      ByteSize `json:"max_header_bytes"`
+	ShutdownGracePeriod Duration `json:"shutdown_grace_period"`
+
+	// The main server listens on HTTPSocket instead of Port when it is
+	// set: unix:///path, or systemd to adopt the socket systemd passes by
+	// socket activation named http, or else the first one passed.
+	HTTPSocket string `json:"http_socket"`
+
+	// Routes listed for the admin listener (admin, debug and metrics by
+	// default) are served on AdminAddr instead of the main port when it is
+	// set: host:port, unix:///path for a socket only local processes
+	// allowed to open it can reach, or systemd for the activated socket
+	// named admin. The admin listener has its own timeouts, and with
+	// AdminAuth none it trusts its clients instead of asking for a JWT or
+	// API key.
+	AdminAddr         string   `json:"admin_addr"`
+	AdminReadTimeout  Duration `json:"admin_read_timeout"`
+	AdminWriteTimeout Duration `json:"admin_write_timeout"`
+	AdminIdleTimeout  Duration `json:"admin_idle_timeout"`
+	AdminAuth         string   `json:"admin_auth"`
+
+	// Custom event types
+	EventTypesFile      string `json:"event_types_file"`
+	EventTypeStrictness string `json:"event_type_strictness"`
+
+	// A JSON array of A/B experiments and their variants
+	ExperimentsFile string `json:"experiments_file"`
+
+	// A JSON array of goals, such as clicks on #signup, counted as
+	// conversions
+	GoalsFile string `json:"goals_file"`
+
+	// Session replay: recording chunks sent in replay events are kept, up
+	// to ReplayMaxSessionBytes per session and ReplayMaxBytes in all, until
+	// ReplayRetention after a session's last chunk
+	ReplayEnabled         bool     `json:"replay_enabled"`
+	ReplayMaxSessionBytes ByteSize `json:"replay_max_session_bytes"`
+	ReplayMaxBytes        ByteSize `json:"replay_max_bytes"`
+	ReplayRetention       Duration `json:"replay_retention"`
+
+	// Bot filtering: off, tag or drop events that look automated. A session
+	// sending more than BotMaxEventRate events a second counts as one; 0
+	// disables that check.
+	BotFilter       string `json:"bot_filter"`
+	BotMaxEventRate int    `json:"bot_max_event_rate"`
+
+	// GeoIP: a MaxMind City database to locate clients with, polled for
+	// updates every GeoIPWatchInterval (0 disables polling)
+	GeoIPDatabase      string   `json:"geoip_database"`
+	GeoIPWatchInterval Duration `json:"geoip_watch_interval"`
+
+	// Privacy: client addresses are kept as they are (none), truncated or
+	// hashed with PrivacyIPHashKey; page URLs can lose their query string;
+	// custom fields named in PrivacyRedactFields are redacted
+	PrivacyIPMode       string   `json:"privacy_ip_mode"`
+	PrivacyIPHashKey    string   `json:"-"`
+	PrivacyStripQuery   bool     `json:"privacy_strip_query"`
+	PrivacyRedactFields []string `json:"privacy_redact_fields"`
+
+	// Consent: ignore processes every event in full; opt_out processes
+	// events in aggregate-only mode when the browser sends DNT or Sec-GPC;
+	// opt_in does unless the event grants consent. An explicit consent flag
+	// in the event always wins.
+	ConsentPolicy string `json:"consent_policy"`
+
+	// Page URLs lose the PageTrackingParams query parameters (a trailing
+	// '*' matches any suffix) and have their host lowercased, so pages are
+	// counted under one URL. Paths matching one of PageTemplates, such as
+	// "/users/{id}", are replaced by it.
+	PageTrackingParams []string `json:"page_tracking_params"`
+	PageTemplates      []string `json:"page_templates"`
+
+	// Tenancy: none serves a single site; api_key makes each API key's ID a
+	// tenant and header takes the tenant from TenantHeader, set by one of
+	// TrustedProxies. Only Tenants are accepted when listed, as they must be
+	// with header, otherwise up to MaxTenants distinct ones.
+	TenantSource string   `json:"tenant_source"`
+	TenantHeader string   `json:"tenant_header"`
+	Tenants      []string `json:"tenants"`
+	MaxTenants   int      `json:"max_tenants"`
+
+	// Debug endpoints (pprof, expvar), off by default and only served on
+	// the admin listener, so they need AdminAddr
+	DebugEndpoints bool `json:"debug_endpoints"`
+
+	// TLS and HTTP/2
+	TLSCertFile               string `json:"tls_cert_file"`
+	TLSKeyFile                string `json:"tls_key_file"`
+	H2C                       bool   `json:"h2c"`
+	HTTP2MaxConcurrentStreams int    `json:"http2_max_concurrent_streams"`
+
+	// The certificate, key and client CAs are reloaded when they change,
+	// checked every TLSWatchInterval (0 disables reloading). Clients may
+	// present a certificate signed by one of TLSClientCAFile's CAs, which
+	// admin routes and the gRPC event feed require with
+	// TLSAdminClientCert.
+	TLSWatchInterval   Duration `json:"tls_watch_interval"`
+	TLSClientCAFile    string   `json:"tls_client_ca_file"`
+	TLSAdminClientCert bool     `json:"tls_admin_client_cert"`
+
+	// ACME certificates, replacing the certificate and key files: setting
+	// ACMEHosts obtains and renews certificates for them from
+	// ACMEDirectoryURL, Let's Encrypt when empty. The HTTP-01 challenge is
+	// answered on ACMEHTTPPort, which redirects other requests to HTTPS.
+	// The account key and certificates are kept in ACMECacheDir.
+	ACMEHosts        []string `json:"acme_hosts"`
+	ACMEEmail        string   `json:"acme_email"`
+	ACMECacheDir     string   `json:"acme_cache_dir"`
+	ACMEDirectoryURL string   `json:"acme_directory_url"`
+	ACMEHTTPPort     int      `json:"acme_http_port"`
+
+	// API key authentication. API keys send events and name tenants; only
+	// admin API keys are accepted by admin routes and the gRPC event feed,
+	// so a key handed to browsers or webhook senders grants no more.
+	APIKeys             string `json:"-"`
+	APIKeysFile         string `json:"api_keys_file"`
+	AdminAPIKeys        string `json:"-"`
+	AdminAPIKeysFile    string `json:"admin_api_keys_file"`
+	RequireTrackingAuth bool   `json:"require_tracking_auth"`
+
+	// JWT authentication for admin routes
+	JWTSecret        string   `json:"-"`
+	JWTPublicKeyFile string   `json:"jwt_public_key_file"`
+	JWTJWKSURL       string   `json:"jwt_jwks_url"`
+	JWTJWKSCacheTTL  Duration `json:"jwt_jwks_cache_ttl"`
+	JWTIssuer        string   `json:"jwt_issuer"`
+	JWTAudience      string   `json:"jwt_audience"`
+	JWTLeeway        Duration `json:"jwt_leeway"`
+	JWTTenantClaim   string   `json:"jwt_tenant_claim"`
+
+	// HMAC request signing for event ingestion
+	SigningSecret    string   `json:"-"`
+	SignatureMaxSkew Duration `json:"signature_max_skew"`
+
+	// Request body limits and decode hardening
+	MaxBodySize           ByteSize `json:"max_body_size"`
+	DisallowUnknownFields bool     `json:"disallow_unknown_fields"`
+	MaxCustomFields       int      `json:"max_custom_fields"`
+
+	// Handler timeouts, per route pattern with a default
+	DefaultRouteTimeout Duration            `json:"default_route_timeout"`
+	RouteTimeouts       map[string]Duration `json:"route_timeouts"`
+
+	// CORS policy. No origin is allowed until listed, "*" included.
+	CORSAllowedOrigins   []string `json:"cors_allowed_origins"`
+	CORSAllowedMethods   []string `json:"cors_allowed_methods"`
+	CORSAllowedHeaders   []string `json:"cors_allowed_headers"`
+	CORSExposedHeaders   []string `json:"cors_exposed_headers"`
+	CORSAllowCredentials bool     `json:"cors_allow_credentials"`
+	CORSMaxAge           Duration `json:"cors_max_age"`
+
+	// Proxies whose forwarding headers are trusted for client IP resolution
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// Static IP/CIDR access lists, updatable at runtime via the admin API
+	IPAllowList []string `json:"ip_allow_list"`
+	IPDenyList  []string `json:"ip_deny_list"`
+
+	// CSRF protection for browser-invoked state-changing routes
+	CSRFEnabled bool     `json:"csrf_enabled"`
+	CSRFRoutes  []string `json:"csrf_routes"`
+
+	// Security headers, with per-route header overrides
+	ContentSecurityPolicy     string                       `json:"content_security_policy"`
+	HSTSMaxAge                Duration                     `json:"hsts_max_age"`
+	HSTSIncludeSubdomains     bool                         `json:"hsts_include_subdomains"`
+	HSTSPreload               bool                         `json:"hsts_preload"`
+	FrameOptions              string                       `json:"frame_options"`
+	ReferrerPolicy            string                       `json:"referrer_policy"`
+	CrossOriginOpenerPolicy   string                       `json:"cross_origin_opener_policy"`
+	CrossOriginEmbedderPolicy string                       `json:"cross_origin_embedder_policy"`
+	CrossOriginResourcePolicy string                       `json:"cross_origin_resource_policy"`
+	PermissionsPolicy         string                       `json:"permissions_policy"`
+	SecurityRouteHeaders      map[string]map[string]string `json:"security_route_headers"`
+
+	// Cache-Control policy per route pattern
+	CacheControl map[string]string `json:"cache_control"`
+
+	// Server-side caching of computed responses such as stats
+	ResponseCacheTTL        Duration `json:"response_cache_ttl"`
+	ResponseCacheMaxEntries int      `json:"response_cache_max_entries"`
+
+	// API versions accepted in the API-Version header; the first is the default
+	APIVersions []string `json:"api_versions"`
+
+	// Maintenance mode, also switchable at runtime via the admin API
+	MaintenanceMode       bool     `json:"maintenance_mode"`
+	MaintenanceMessage    string   `json:"maintenance_message"`
+	MaintenanceRetryAfter Duration `json:"maintenance_retry_after"`
+
+	// Audit log for admin routes; stderr when no file is set
+	AuditLogFile string `json:"audit_log_file"`
+
+	// Built-in alerting, checked every AlertInterval. A threshold of 0
+	// disables its rule. Alerts are logged and sent to every configured
+	// notifier; a rule that keeps firing is re-sent every AlertCooldown.
+	// The error rate is only taken over at least AlertErrorRateMinRequests
+	// requests. AlertSinkQueueDepth is the events a sink may have queued.
+	// Notifier URLs and keys carry credentials, so they are secrets. Each
+	// notifier sends at most its rate limit of firing alerts an hour (0
+	// for no limit); resolutions are never held back.
+	AlertInterval             Duration `json:"alert_interval"`
+	AlertCooldown             Duration `json:"alert_cooldown"`
+	AlertErrorRate            float64  `json:"alert_error_rate"`
+	AlertErrorRateWindow      Duration `json:"alert_error_rate_window"`
+	AlertErrorRateMinRequests int      `json:"alert_error_rate_min_requests"`
+	AlertSinkQueueDepth       int      `json:"alert_sink_queue_depth"`
+	AlertHeapBytes            ByteSize `json:"alert_heap_bytes"`
+	AlertDenialsPerMinute     float64  `json:"alert_denials_per_minute"`
+	AlertWebhookURL           string   `json:"-"`
+	AlertSlackWebhookURL      string   `json:"-"`
+	AlertPagerDutyRoutingKey  string   `json:"-"`
+	AlertWebhookRateLimit     int      `json:"alert_webhook_rate_limit"`
+	AlertSlackRateLimit       int      `json:"alert_slack_rate_limit"`
+	AlertPagerDutyRateLimit   int      `json:"alert_pagerduty_rate_limit"`
+
+	// Anomaly detection flags a minute whose click, new session or error
+	// rate is more than AnomalyThreshold standard deviations from its
+	// exponentially weighted average (0 disables). AnomalyAlpha weighs each
+	// new minute; nothing is flagged during AnomalyWarmup. Anomalies also
+	// fire alerts.
+	AnomalyThreshold float64  `json:"anomaly_threshold"`
+	AnomalyAlpha     float64  `json:"anomaly_alpha"`
+	AnomalyWarmup    Duration `json:"anomaly_warmup"`
+
+	// Email digest of clicks, top elements, sessions and anomalies, sent
+	// "daily" at midnight UTC or "weekly" on Mondays at midnight UTC
+	// (empty sends none) from ReportFrom to ReportTo through the SMTP
+	// server at ReportSMTPAddr, host:port. The connection is upgraded with
+	// STARTTLS when the server offers it; the username and password, when
+	// set, authenticate with PLAIN.
+	ReportSchedule     string   `json:"report_schedule"`
+	ReportTopElements  int      `json:"report_top_elements"`
+	ReportSMTPAddr     string   `json:"report_smtp_addr"`
+	ReportSMTPUsername string   `json:"report_smtp_username"`
+	ReportSMTPPassword string   `json:"-"`
+	ReportFrom         string   `json:"report_from"`
+	ReportTo           []string `json:"report_to"`
+
+	// Sinks forward every processed event to the systems named here; see
+	// SinkNames. Each sink queues up to SinkQueueSize events, dropping more,
+	// and writes them SinkBatchSize at a time or every SinkFlushInterval.
+	Sinks             []string `json:"sinks"`
+	SinkQueueSize     int      `json:"sink_queue_size"`
+	SinkBatchSize     int      `json:"sink_batch_size"`
+	SinkFlushInterval Duration `json:"sink_flush_interval"`
+	SinkWriteTimeout  Duration `json:"sink_write_timeout"`
+
+	// Kafka sink; messages are keyed by session ID. Compression is none,
+	// gzip, snappy, lz4 or zstd.
+	KafkaBrokers     []string `json:"kafka_brokers"`
+	KafkaTopic       string   `json:"kafka_topic"`
+	KafkaCompression string   `json:"kafka_compression"`
+
+	// NATS JetStream sink. NATSSubject is a template: {tenant},
+	// {event_type}, {device} and {country} are replaced by the event's
+	// values. A stream must capture the subjects.
+	NATSURL             string `json:"nats_url"`
+	NATSSubject         string `json:"nats_subject"`
+	NATSCredentialsFile string `json:"nats_credentials_file"`
+
+	// Webhook sink, POSTing batches to every URL. WebhookSecret signs them
+	// as SigningSecret is checked. An endpoint failing
+	// WebhookBreakerThreshold batches in a row is skipped for
+	// WebhookBreakerCooldown.
+	WebhookURLs             []string `json:"webhook_urls"`
+	WebhookSecret           string   `json:"-"`
+	WebhookMaxAttempts      int      `json:"webhook_max_attempts"`
+	WebhookBreakerThreshold int      `json:"webhook_breaker_threshold"`
+	WebhookBreakerCooldown  Duration `json:"webhook_breaker_cooldown"`
+
+	// Kinesis Data Streams and Data Firehose sinks, with AWS credentials
+	// and region from the default chain. KinesisStream is a name or ARN.
+	// Aggregation packs several events into each record: KPL aggregated
+	// records per shard for Kinesis, JSON lines for Firehose.
+	KinesisStream          string `json:"kinesis_stream"`
+	KinesisAggregate       bool   `json:"kinesis_aggregate"`
+	FirehoseDeliveryStream string `json:"firehose_delivery_stream"`
+	FirehoseAggregate      bool   `json:"firehose_aggregate"`
+
+	// Elasticsearch or OpenSearch sink, bulk indexing into daily indices
+	// named ElasticsearchIndex-YYYY.MM.DD, or into the data stream
+	// ElasticsearchIndex; ElasticsearchIndexMode is daily or data_stream.
+	// An API key wins over a user name and password.
+	ElasticsearchURLs           []string `json:"elasticsearch_urls"`
+	ElasticsearchIndex          string   `json:"elasticsearch_index"`
+	ElasticsearchIndexMode      string   `json:"elasticsearch_index_mode"`
+	ElasticsearchUsername       string   `json:"elasticsearch_username"`
+	ElasticsearchPassword       string   `json:"-"`
+	ElasticsearchAPIKey         string   `json:"-"`
+	ElasticsearchManageTemplate bool     `json:"elasticsearch_manage_template"`
+
+	// Redis pub/sub sink. RedisChannel is a template like NATSSubject;
+	// RedisPassword overrides the one in RedisURL.
+	RedisURL      string `json:"redis_url"`
+	RedisPassword string `json:"-"`
+	RedisChannel  string `json:"redis_channel"`
+
+	// AMQP sink, such as RabbitMQ, publishing to AMQPExchange with
+	// publisher confirms. AMQPRoutingKey is a template like NATSSubject;
+	// AMQPPassword overrides the one in AMQPURL.
+	AMQPURL        string `json:"amqp_url"`
+	AMQPPassword   string `json:"-"`
+	AMQPExchange   string `json:"amqp_exchange"`
+	AMQPRoutingKey string `json:"amqp_routing_key"`
+
+	// BigQuery sink, streaming into BigQueryDataset.BigQueryTable with the
+	// Storage Write API and credentials from the application default
+	// chain. BigQueryManageSchema creates the table and adds the columns
+	// it lacks; the dataset must exist.
+	BigQueryProject      string `json:"bigquery_project"`
+	BigQueryDataset      string `json:"bigquery_dataset"`
+	BigQueryTable        string `json:"bigquery_table"`
+	BigQueryManageSchema bool   `json:"bigquery_manage_schema"`
+
+	// MQTT ingestion, enabled by MQTTBrokers: events are taken from the
+	// MQTTTopics filters as /api/track takes them, or in arrays. The
+	// client ID, by default rate-limited-worker-<hostname>, names the
+	// persistent session. MQTTTenantTopicLevel, when not 0, is the 1-based
+	// topic level naming the tenant.
+	MQTTBrokers          []string `json:"mqtt_brokers"`
+	MQTTClientID         string   `json:"mqtt_client_id"`
+	MQTTUsername         string   `json:"mqtt_username"`
+	MQTTPassword         string   `json:"-"`
+	MQTTTopics           []string `json:"mqtt_topics"`
+	MQTTQoS              int      `json:"mqtt_qos"`
+	MQTTTenantTopicLevel int      `json:"mqtt_tenant_topic_level"`
+
+	// gRPC event feed on GRPCPort, disabled when 0: subscribers receive
+	// processed events as sinks do, each through a buffer of
+	// StreamBufferSize events, and are evicted when it fills. At most
+	// StreamMaxSubscribers subscribe at once. Subscribers always present
+	// an admin API key or JWT; a JWT carrying JWTTenantClaim only
+	// receives that tenant's events.
+	GRPCPort             int `json:"grpc_port"`
+	StreamBufferSize     int `json:"stream_buffer_size"`
+	StreamMaxSubscribers int `json:"stream_max_subscribers"`
+
+	// JSON route table replacing the built-in routes when set
+	RoutesFile string `json:"routes_file"`
+
+	// How often the config file and remote document are checked for
+	// changes; 0 disables watching
+	ConfigWatchInterval Duration `json:"config_watch_interval"`
+
+	// Config document in Consul or etcd, layered between file and environment
+	RemoteConfigURL   string `json:"remote_config_url"`
+	RemoteConfigToken string `json:"-"`
+
+	// Where each value came from, by key; see Source
+	sources map[string]string
 }
 
+// Load builds the configuration from defaults, then the profile for
+// ENVIRONMENT, then the config file named by CONFIG_FILE if any, then
+// environment variables
 func Load() (*Config, error) {
-	cfg := &Config{
-		Port:         getEnvInt("PORT", 8080),
-		LogLevel:     getEnvString("LOG_LEVEL", "INFO"),
-		OTELEndpoint: getEnvString("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4317"),
-		Environment:  getEnvString("ENVIRONMENT", "development"),
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile is Load with an explicit config file path. YAML (.yaml, .yml),
+// TOML (.toml) and JSON files are accepted and use the same keys as the
+// JSON form of Config. Environment variables take precedence over the
+// file; secrets are only read from the environment, from a file named by
+// the matching _FILE variable, or from a secret store they reference.
+func LoadFile(path string) (*Config, error) {
+	return LoadWithFlags(path, nil)
+}
+
+// LoadWithFlags is LoadFile with command line flags applied last, so they
+// override both the file and the environment
+func LoadWithFlags(path string, flags *Flags) (*Config, error) {
+	// The environment picks the profile the other layers override, and the
+	// remote document is located through them, so resolve both first
+	probe := defaults()
+	if err := probe.applyLayers(path, nil, flags); err != nil {
+		return nil, err
 	}
+	profile, ok := profiles[probe.Environment]
+	if !ok {
+		return nil, fmt.Errorf("invalid configuration: environment must be one of %s, got %q", profileNames(), probe.Environment)
+	}
+	if err := probe.resolveSecrets(); err != nil {
+		return nil, fmt.Errorf("resolve secrets: %w", err)
+	}
+	remote, err := probe.fetchRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := defaults()
+	cfg.track(SourceProfile, func() error {
+		profile(cfg)
+		return nil
+	})
+	if err := cfg.applyLayers(path, remote, flags); err != nil {
+		return nil, err
+	}
+	// Secrets only come from the environment, which the probe has
+	// already read and resolved
+	cfg.track(SourceSecretStore, func() error {
+		probeSecrets := probe.secrets()
+		for i, s := range cfg.secrets() {
+			*s.value = *probeSecrets[i].value
+		}
+		return nil
+	})
 
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -28,10 +569,684 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// applyLayers applies the config file, the remote document, the
+// environment and the flags, in increasing order of precedence
+func (c *Config) applyLayers(path string, remote *remoteDocument, flags *Flags) error {
+	if path != "" {
+		if err := c.track(SourceFile, func() error { return c.readFile(path) }); err != nil {
+			return err
+		}
+	}
+	if remote != nil {
+		if err := c.track(SourceRemote, func() error { return remote.apply(c) }); err != nil {
+			return err
+		}
+	}
+	if err := c.track(SourceEnv, c.applyEnv); err != nil {
+		return fmt.Errorf("invalid environment: %w", err)
+	}
+	return c.track(SourceFlag, func() error { return flags.apply(c) })
+}
+
+func defaults() *Config {
+	return &Config{
+		Port:         8080,
+		LogLevel:     "INFO",
+		OTELEndpoint: "localhost:4317",
+		Environment:  "development",
+
+		TelemetryEnabled:       true,
+		TelemetryErrorInterval: Duration(30 * time.Second),
+
+		OTELProtocol:        "grpc",
+		OTELInsecure:        true,
+		OTELTimeout:         Duration(10 * time.Second),
+		OTELRetry:           true,
+		OTELRetryMaxElapsed: Duration(time.Minute),
+
+		MetricsExporter: "otlp",
+		StatsDFlavor:    "statsd",
+		StatsDInterval:  Duration(10 * time.Second),
+		InfluxInterval:  Duration(10 * time.Second),
+		RuntimeMetrics:  true,
+		LogsExporter:    "otlp",
+
+		LogFileMaxSize:    100 << 20,
+		LogFileMaxAge:     Duration(24 * time.Hour),
+		LogFileMaxBackups: 7,
+
+		MetricsExemplarFilter: "trace_based",
+
+		MetricsMaxRoutes: 100,
+
+		MetricsBatchSize:     256,
+		MetricsBatchInterval: Duration(time.Second),
+
+		// Most requests take milliseconds, far below the SDK's default
+		// buckets; positions follow common viewport widths
+		HTTPLatencyBuckets:    []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		CursorPositionBuckets: []float64{0, 100, 200, 320, 480, 640, 768, 1024, 1280, 1440, 1920, 2560, 3840},
+
+		SessionEventBuckets:    []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000},
+		SessionDurationBuckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+		SessionClickBuckets:    []float64{0, 1, 2, 3, 5, 10, 20, 50, 100, 200},
+
+		SessionTimeout:         Duration(30 * time.Minute),
+		SessionCleanupInterval: Duration(time.Minute),
+		SessionIdleThreshold:   Duration(30 * time.Second),
+
+		// Every track call is a span, so only a sample of them is traced
+		TraceSampler:           "parentbased_traceidratio",
+		TraceSampleRatio:       1,
+		TraceRouteSampleRatios: map[string]float64{"/api/track": 0.01},
+		TraceSampleErrors:      true,
+
+		TraceTailLatency:   Duration(2 * time.Second),
+		TraceTailWait:      Duration(10 * time.Second),
+		TraceTailMaxTraces: 10000,
+
+		EventSpanSampleRates:   map[string]float64{},
+		EventMetricSampleRates: map[string]float64{},
+
+		// The short and long windows of multiwindow burn rate alerts
+		SLOAvailabilityTarget: 0.999,
+		SLOLatencyTarget:      0.99,
+		SLOLatencyThreshold:   Duration(500 * time.Millisecond),
+		SLOWindows:            []Duration{Duration(5 * time.Minute), Duration(time.Hour), Duration(6 * time.Hour)},
+
+		ReadTimeout:         Duration(30 * time.Second),
+		ReadHeaderTimeout:   Duration(10 * time.Second),
+		WriteTimeout:        Duration(30 * time.Second),
+		IdleTimeout:         Duration(120 * time.Second),
+		MaxHeaderBytes:      1 << 20,
+		ShutdownGracePeriod: Duration(30 * time.Second),
+
+		// Long enough for a 30 second CPU profile
+		AdminReadTimeout:  Duration(30 * time.Second),
+		AdminWriteTimeout: Duration(2 * time.Minute),
+		AdminIdleTimeout:  Duration(120 * time.Second),
+		AdminAuth:         "token",
+
+		EventTypeStrictness: "warn",
+
+		ReplayMaxSessionBytes: 5 << 20,
+		ReplayMaxBytes:        256 << 20,
+		ReplayRetention:       Duration(24 * time.Hour),
+
+		BotFilter:       "tag",
+		BotMaxEventRate: 20,
+
+		GeoIPWatchInterval: Duration(time.Minute),
+
+		PrivacyIPMode: "none",
+		ConsentPolicy: "opt_out",
+
+		PageTrackingParams: []string{
+			"utm_*", "gclid", "gbraid", "wbraid", "dclid", "fbclid", "msclkid",
+			"mc_cid", "mc_eid", "_ga", "_gl", "yclid", "igshid",
+		},
+
+		TenantSource: "none",
+		TenantHeader: "X-Tenant-ID",
+		MaxTenants:   100,
+
+		HTTP2MaxConcurrentStreams: 250,
+		TLSWatchInterval:          Duration(time.Minute),
+		ACMECacheDir:              "acme-cache",
+		ACMEHTTPPort:              80,
+
+		JWTJWKSCacheTTL: Duration(time.Hour),
+		JWTLeeway:       Duration(time.Minute),
+		JWTTenantClaim:  "tenant",
+
+		SignatureMaxSkew: Duration(5 * time.Minute),
+
+		MaxBodySize:     64 << 10,
+		MaxCustomFields: 50,
+
+		DefaultRouteTimeout: Duration(10 * time.Second),
+		RouteTimeouts:       map[string]Duration{},
+
+		CORSAllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		CORSAllowedHeaders: []string{
+			"Content-Type", "Authorization", "X-Request-ID", "X-API-Key", "X-Signature", "X-Signature-Timestamp",
+		},
+		CORSExposedHeaders: []string{"X-Request-ID"},
+		CORSMaxAge:         Duration(10 * time.Minute),
+
+		CSRFRoutes: []string{"/api/track"},
+
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self'; img-src 'self' data:; " +
+			"connect-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'",
+		FrameOptions:            "DENY",
+		ReferrerPolicy:          "strict-origin-when-cross-origin",
+		CrossOriginOpenerPolicy: "same-origin",
+		SecurityRouteHeaders:    map[string]map[string]string{},
+
+		CacheControl: map[string]string{
+			"/static/":   "public, max-age=3600",
+			"/api/stats": "no-cache",
+		},
+		ResponseCacheTTL:        Duration(5 * time.Second),
+		ResponseCacheMaxEntries: 1024,
+
+		APIVersions: []string{"1"},
+
+		MaintenanceRetryAfter: Duration(5 * time.Minute),
+
+		AlertInterval:             Duration(30 * time.Second),
+		AlertCooldown:             Duration(15 * time.Minute),
+		AlertErrorRate:            0.05,
+		AlertErrorRateWindow:      Duration(5 * time.Minute),
+		AlertErrorRateMinRequests: 20,
+		AlertSlackRateLimit:       30,
+		AlertPagerDutyRateLimit:   30,
+
+		AnomalyThreshold: 3,
+		AnomalyAlpha:     0.1,
+		AnomalyWarmup:    Duration(15 * time.Minute),
+
+		ReportTopElements: 10,
+
+		SinkQueueSize:     10000,
+		SinkBatchSize:     100,
+		SinkFlushInterval: Duration(time.Second),
+		SinkWriteTimeout:  Duration(10 * time.Second),
+		KafkaTopic:        "worker-events",
+		KafkaCompression:  "snappy",
+		NATSURL:           "nats://127.0.0.1:4222",
+		NATSSubject:       "worker.events.{event_type}",
+
+		WebhookMaxAttempts:      5,
+		WebhookBreakerThreshold: 5,
+		WebhookBreakerCooldown:  Duration(30 * time.Second),
+
+		KinesisAggregate:  true,
+		FirehoseAggregate: true,
+
+		ElasticsearchIndex:          "worker-events",
+		ElasticsearchIndexMode:      "daily",
+		ElasticsearchManageTemplate: true,
+
+		RedisURL:     "redis://127.0.0.1:6379/0",
+		RedisChannel: "worker:events:{event_type}",
+
+		AMQPURL:        "amqp://guest@127.0.0.1:5672/",
+		AMQPExchange:   "amq.topic",
+		AMQPRoutingKey: "worker.events.{event_type}",
+
+		BigQueryTable:        "events",
+		BigQueryManageSchema: true,
+
+		MQTTTopics: []string{"worker/events/#"},
+		MQTTQoS:    1,
+
+		StreamBufferSize:     256,
+		StreamMaxSubscribers: 100,
+
+		ConfigWatchInterval: Duration(5 * time.Second),
+	}
+}
+
+// applyEnv overrides fields whose environment variable is set. Malformed
+// typed values are reported together, each naming its variable.
+func (c *Config) applyEnv() error {
+	var errs []error
+
+	c.Port = getEnvInt("PORT", c.Port)
+	c.LogLevel = getEnvString("LOG_LEVEL", c.LogLevel)
+	c.OTELEndpoint = getEnvString("OTEL_EXPORTER_OTLP_ENDPOINT", c.OTELEndpoint)
+	c.Environment = getEnvString("ENVIRONMENT", c.Environment)
+
+	c.TelemetryEnabled = getEnvBool("TELEMETRY_ENABLED", c.TelemetryEnabled)
+	c.TelemetryErrorInterval = getEnvDuration("TELEMETRY_ERROR_INTERVAL", c.TelemetryErrorInterval, &errs)
+
+	c.OTELProtocol = getEnvString("OTEL_EXPORTER_OTLP_PROTOCOL", c.OTELProtocol)
+	c.OTELInsecure = getEnvBool("OTEL_EXPORTER_OTLP_INSECURE", c.OTELInsecure)
+	c.OTELHeaders = getEnvSecret("OTEL_EXPORTER_OTLP_HEADERS", c.OTELHeaders, &errs)
+	c.OTELCertificate = getEnvString("OTEL_EXPORTER_OTLP_CERTIFICATE", c.OTELCertificate)
+	c.OTELClientCertificate = getEnvString("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE", c.OTELClientCertificate)
+	c.OTELClientKey = getEnvString("OTEL_EXPORTER_OTLP_CLIENT_KEY", c.OTELClientKey)
+	c.OTELCompression = getEnvString("OTEL_EXPORTER_OTLP_COMPRESSION", c.OTELCompression)
+	c.OTELTimeout = getEnvMillis("OTEL_EXPORTER_OTLP_TIMEOUT", c.OTELTimeout, &errs)
+	c.OTELRetry = getEnvBool("OTLP_RETRY_ENABLED", c.OTELRetry)
+	c.OTELRetryMaxElapsed = getEnvDuration("OTLP_RETRY_MAX_ELAPSED", c.OTELRetryMaxElapsed, &errs)
+
+	c.MetricsExporter = getEnvString("METRICS_EXPORTER", c.MetricsExporter)
+	c.StatsDAddr = getEnvString("STATSD_ADDR", c.StatsDAddr)
+	c.StatsDPrefix = getEnvString("STATSD_PREFIX", c.StatsDPrefix)
+	c.StatsDFlavor = getEnvString("STATSD_FLAVOR", c.StatsDFlavor)
+	c.StatsDTags = getEnvStringSlice("STATSD_TAGS", c.StatsDTags)
+	c.StatsDInterval = getEnvDuration("STATSD_INTERVAL", c.StatsDInterval, &errs)
+	c.InfluxURL = getEnvString("INFLUX_URL", c.InfluxURL)
+	c.InfluxToken = getEnvSecret("INFLUX_TOKEN", c.InfluxToken, &errs)
+	c.InfluxInterval = getEnvDuration("INFLUX_INTERVAL", c.InfluxInterval, &errs)
+	c.MetricsPort = getEnvInt("METRICS_PORT", c.MetricsPort)
+	c.MetricsMaxRoutes = getEnvInt("METRICS_MAX_ROUTES", c.MetricsMaxRoutes)
+	c.MetricsBatchSize = getEnvInt("METRICS_BATCH_SIZE", c.MetricsBatchSize)
+	c.MetricsBatchInterval = getEnvDuration("METRICS_BATCH_INTERVAL", c.MetricsBatchInterval, &errs)
+	c.HTTPLatencyBuckets = getEnvFloatSlice("HTTP_LATENCY_BUCKETS", c.HTTPLatencyBuckets, &errs)
+	c.CursorPositionBuckets = getEnvFloatSlice("CURSOR_POSITION_BUCKETS", c.CursorPositionBuckets, &errs)
+	c.SessionEventBuckets = getEnvFloatSlice("SESSION_EVENT_BUCKETS", c.SessionEventBuckets, &errs)
+	c.SessionDurationBuckets = getEnvFloatSlice("SESSION_DURATION_BUCKETS", c.SessionDurationBuckets, &errs)
+	c.SessionClickBuckets = getEnvFloatSlice("SESSION_CLICK_BUCKETS", c.SessionClickBuckets, &errs)
+	c.SessionTimeout = getEnvDuration("SESSION_TIMEOUT", c.SessionTimeout, &errs)
+	c.SessionCleanupInterval = getEnvDuration("SESSION_CLEANUP_INTERVAL", c.SessionCleanupInterval, &errs)
+	c.SessionIdleThreshold = getEnvDuration("SESSION_IDLE_THRESHOLD", c.SessionIdleThreshold, &errs)
+	c.MetricsExemplarFilter = getEnvString("OTEL_METRICS_EXEMPLAR_FILTER", c.MetricsExemplarFilter)
+	c.LogsExporter = getEnvString("OTEL_LOGS_EXPORTER", c.LogsExporter)
+	c.LogFile = getEnvString("LOG_FILE", c.LogFile)
+	c.LogFileMaxSize = getEnvByteSize("LOG_FILE_MAX_SIZE", c.LogFileMaxSize, &errs)
+	c.LogFileMaxAge = getEnvDuration("LOG_FILE_MAX_AGE", c.LogFileMaxAge, &errs)
+	c.LogFileMaxBackups = getEnvInt("LOG_FILE_MAX_BACKUPS", c.LogFileMaxBackups)
+	c.LogSyslog = getEnvString("LOG_SYSLOG", c.LogSyslog)
+	c.RuntimeMetrics = getEnvBool("RUNTIME_METRICS", c.RuntimeMetrics)
+
+	c.TraceSampler = getEnvString("OTEL_TRACES_SAMPLER", c.TraceSampler)
+	c.TraceSampleRatio = getEnvFloat("OTEL_TRACES_SAMPLER_ARG", c.TraceSampleRatio, &errs)
+	c.TraceRouteSampleRatios = getEnvFloatMap("TRACE_ROUTE_SAMPLE_RATIOS", c.TraceRouteSampleRatios, &errs)
+	c.TraceSampleErrors = getEnvBool("TRACE_SAMPLE_ERRORS", c.TraceSampleErrors)
+	c.TraceTailSampling = getEnvBool("TRACE_TAIL_SAMPLING", c.TraceTailSampling)
+	c.TraceTailLatency = getEnvDuration("TRACE_TAIL_LATENCY", c.TraceTailLatency, &errs)
+	c.TraceTailWait = getEnvDuration("TRACE_TAIL_WAIT", c.TraceTailWait, &errs)
+	c.TraceTailMaxTraces = getEnvInt("TRACE_TAIL_MAX_TRACES", c.TraceTailMaxTraces)
+
+	c.EventSpanSampleRates = getEnvFloatMap("EVENT_SPAN_SAMPLE_RATES", c.EventSpanSampleRates, &errs)
+	c.EventMetricSampleRates = getEnvFloatMap("EVENT_METRIC_SAMPLE_RATES", c.EventMetricSampleRates, &errs)
+
+	c.SLOAvailabilityTarget = getEnvFloat("SLO_AVAILABILITY_TARGET", c.SLOAvailabilityTarget, &errs)
+	c.SLOLatencyTarget = getEnvFloat("SLO_LATENCY_TARGET", c.SLOLatencyTarget, &errs)
+	c.SLOLatencyThreshold = getEnvDuration("SLO_LATENCY_THRESHOLD", c.SLOLatencyThreshold, &errs)
+	c.SLOWindows = getEnvDurationSlice("SLO_WINDOWS", c.SLOWindows, &errs)
+
+	c.ReadTimeout = getEnvDuration("READ_TIMEOUT", c.ReadTimeout, &errs)
+	c.ReadHeaderTimeout = getEnvDuration("READ_HEADER_TIMEOUT", c.ReadHeaderTimeout, &errs)
+	c.WriteTimeout = getEnvDuration("WRITE_TIMEOUT", c.WriteTimeout, &errs)
+	c.IdleTimeout = getEnvDuration("IDLE_TIMEOUT", c.IdleTimeout, &errs)
+	c.MaxHeaderBytes = getEnvByteSize("MAX_HEADER_BYTES", c.MaxHeaderBytes, &errs)
+	c.ShutdownGracePeriod = getEnvDuration("SHUTDOWN_GRACE_PERIOD", c.ShutdownGracePeriod, &errs)
+
+	c.HTTPSocket = getEnvString("HTTP_SOCKET", c.HTTPSocket)
+
+	c.AdminAddr = getEnvString("ADMIN_ADDR", c.AdminAddr)
+	c.AdminReadTimeout = getEnvDuration("ADMIN_READ_TIMEOUT", c.AdminReadTimeout, &errs)
+	c.AdminWriteTimeout = getEnvDuration("ADMIN_WRITE_TIMEOUT", c.AdminWriteTimeout, &errs)
+	c.AdminIdleTimeout = getEnvDuration("ADMIN_IDLE_TIMEOUT", c.AdminIdleTimeout, &errs)
+	c.AdminAuth = getEnvString("ADMIN_AUTH", c.AdminAuth)
+
+	c.EventTypesFile = getEnvString("EVENT_TYPES_FILE", c.EventTypesFile)
+	c.EventTypeStrictness = getEnvString("EVENT_TYPE_STRICTNESS", c.EventTypeStrictness)
+	c.ExperimentsFile = getEnvString("EXPERIMENTS_FILE", c.ExperimentsFile)
+	c.GoalsFile = getEnvString("GOALS_FILE", c.GoalsFile)
+	c.ReplayEnabled = getEnvBool("REPLAY_ENABLED", c.ReplayEnabled)
+	c.ReplayMaxSessionBytes = getEnvByteSize("REPLAY_MAX_SESSION_BYTES", c.ReplayMaxSessionBytes, &errs)
+	c.ReplayMaxBytes = getEnvByteSize("REPLAY_MAX_BYTES", c.ReplayMaxBytes, &errs)
+	c.ReplayRetention = getEnvDuration("REPLAY_RETENTION", c.ReplayRetention, &errs)
+	c.BotFilter = getEnvString("BOT_FILTER", c.BotFilter)
+	c.BotMaxEventRate = getEnvInt("BOT_MAX_EVENT_RATE", c.BotMaxEventRate)
+	c.GeoIPDatabase = getEnvString("GEOIP_DATABASE", c.GeoIPDatabase)
+	c.GeoIPWatchInterval = getEnvDuration("GEOIP_WATCH_INTERVAL", c.GeoIPWatchInterval, &errs)
+	c.PrivacyIPMode = getEnvString("PRIVACY_IP_MODE", c.PrivacyIPMode)
+	c.PrivacyIPHashKey = getEnvSecret("PRIVACY_IP_HASH_KEY", c.PrivacyIPHashKey, &errs)
+	c.PrivacyStripQuery = getEnvBool("PRIVACY_STRIP_QUERY", c.PrivacyStripQuery)
+	c.PrivacyRedactFields = getEnvStringSlice("PRIVACY_REDACT_FIELDS", c.PrivacyRedactFields)
+	c.ConsentPolicy = getEnvString("CONSENT_POLICY", c.ConsentPolicy)
+	c.PageTrackingParams = getEnvStringSlice("PAGE_TRACKING_PARAMS", c.PageTrackingParams)
+	c.PageTemplates = getEnvStringSlice("PAGE_TEMPLATES", c.PageTemplates)
+	c.TenantSource = getEnvString("TENANT_SOURCE", c.TenantSource)
+	c.TenantHeader = getEnvString("TENANT_HEADER", c.TenantHeader)
+	c.Tenants = getEnvStringSlice("TENANTS", c.Tenants)
+	c.MaxTenants = getEnvInt("MAX_TENANTS", c.MaxTenants)
+
+	c.DebugEndpoints = getEnvBool("DEBUG_ENDPOINTS_ENABLED", c.DebugEndpoints)
+
+	c.TLSCertFile = getEnvString("TLS_CERT_FILE", c.TLSCertFile)
+	c.TLSKeyFile = getEnvString("TLS_KEY_FILE", c.TLSKeyFile)
+	c.H2C = getEnvBool("H2C_ENABLED", c.H2C)
+	c.HTTP2MaxConcurrentStreams = getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", c.HTTP2MaxConcurrentStreams)
+	c.TLSWatchInterval = getEnvDuration("TLS_WATCH_INTERVAL", c.TLSWatchInterval, &errs)
+	c.TLSClientCAFile = getEnvString("TLS_CLIENT_CA_FILE", c.TLSClientCAFile)
+	c.TLSAdminClientCert = getEnvBool("TLS_ADMIN_CLIENT_CERT", c.TLSAdminClientCert)
+	c.ACMEHosts = getEnvStringSlice("ACME_HOSTS", c.ACMEHosts)
+	c.ACMEEmail = getEnvString("ACME_EMAIL", c.ACMEEmail)
+	c.ACMECacheDir = getEnvString("ACME_CACHE_DIR", c.ACMECacheDir)
+	c.ACMEDirectoryURL = getEnvString("ACME_DIRECTORY_URL", c.ACMEDirectoryURL)
+	c.ACMEHTTPPort = getEnvInt("ACME_HTTP_PORT", c.ACMEHTTPPort)
+
+	// API_KEYS_FILE predates the _FILE convention and already takes a
+	// mounted secret, so API_KEYS itself is read as a plain variable, as
+	// is ADMIN_API_KEYS beside ADMIN_API_KEYS_FILE
+	c.APIKeys = getEnvString("API_KEYS", c.APIKeys)
+	c.APIKeysFile = getEnvString("API_KEYS_FILE", c.APIKeysFile)
+	c.AdminAPIKeys = getEnvString("ADMIN_API_KEYS", c.AdminAPIKeys)
+	c.AdminAPIKeysFile = getEnvString("ADMIN_API_KEYS_FILE", c.AdminAPIKeysFile)
+	c.RequireTrackingAuth = getEnvBool("REQUIRE_TRACKING_AUTH", c.RequireTrackingAuth)
+
+	c.JWTSecret = getEnvSecret("JWT_HS256_SECRET", c.JWTSecret, &errs)
+	c.JWTPublicKeyFile = getEnvString("JWT_PUBLIC_KEY_FILE", c.JWTPublicKeyFile)
+	c.JWTJWKSURL = getEnvString("JWT_JWKS_URL", c.JWTJWKSURL)
+	c.JWTJWKSCacheTTL = getEnvDuration("JWT_JWKS_CACHE_TTL", c.JWTJWKSCacheTTL, &errs)
+	c.JWTIssuer = getEnvString("JWT_ISSUER", c.JWTIssuer)
+	c.JWTAudience = getEnvString("JWT_AUDIENCE", c.JWTAudience)
+	c.JWTLeeway = getEnvDuration("JWT_LEEWAY", c.JWTLeeway, &errs)
+	c.JWTTenantClaim = getEnvString("JWT_TENANT_CLAIM", c.JWTTenantClaim)
+
+	c.SigningSecret = getEnvSecret("SIGNING_SECRET", c.SigningSecret, &errs)
+	c.SignatureMaxSkew = getEnvDuration("SIGNATURE_MAX_SKEW", c.SignatureMaxSkew, &errs)
+
+	c.MaxBodySize = getEnvByteSize("MAX_BODY_SIZE", c.MaxBodySize, &errs)
+	c.DisallowUnknownFields = getEnvBool("DISALLOW_UNKNOWN_FIELDS", c.DisallowUnknownFields)
+	c.MaxCustomFields = getEnvInt("MAX_CUSTOM_FIELDS", c.MaxCustomFields)
+
+	c.DefaultRouteTimeout = getEnvDuration("DEFAULT_ROUTE_TIMEOUT", c.DefaultRouteTimeout, &errs)
+	c.RouteTimeouts = getEnvDurationMap("ROUTE_TIMEOUTS", c.RouteTimeouts, &errs)
+
+	c.CORSAllowedOrigins = getEnvStringSlice("CORS_ALLOWED_ORIGINS", c.CORSAllowedOrigins)
+	c.CORSAllowedMethods = getEnvStringSlice("CORS_ALLOWED_METHODS", c.CORSAllowedMethods)
+	c.CORSAllowedHeaders = getEnvStringSlice("CORS_ALLOWED_HEADERS", c.CORSAllowedHeaders)
+	c.CORSExposedHeaders = getEnvStringSlice("CORS_EXPOSED_HEADERS", c.CORSExposedHeaders)
+	c.CORSAllowCredentials = getEnvBool("CORS_ALLOW_CREDENTIALS", c.CORSAllowCredentials)
+	c.CORSMaxAge = getEnvDuration("CORS_MAX_AGE", c.CORSMaxAge, &errs)
+
+	c.TrustedProxies = getEnvStringSlice("TRUSTED_PROXIES", c.TrustedProxies)
+
+	c.IPAllowList = getEnvStringSlice("IP_ALLOW_LIST", c.IPAllowList)
+	c.IPDenyList = getEnvStringSlice("IP_DENY_LIST", c.IPDenyList)
+
+	c.CSRFEnabled = getEnvBool("CSRF_ENABLED", c.CSRFEnabled)
+	c.CSRFRoutes = getEnvStringSlice("CSRF_ROUTES", c.CSRFRoutes)
+
+	c.ContentSecurityPolicy = getEnvString("CONTENT_SECURITY_POLICY", c.ContentSecurityPolicy)
+	c.HSTSMaxAge = getEnvDuration("HSTS_MAX_AGE", c.HSTSMaxAge, &errs)
+	c.HSTSIncludeSubdomains = getEnvBool("HSTS_INCLUDE_SUBDOMAINS", c.HSTSIncludeSubdomains)
+	c.HSTSPreload = getEnvBool("HSTS_PRELOAD", c.HSTSPreload)
+	c.FrameOptions = getEnvString("FRAME_OPTIONS", c.FrameOptions)
+	c.ReferrerPolicy = getEnvString("REFERRER_POLICY", c.ReferrerPolicy)
+	c.CrossOriginOpenerPolicy = getEnvString("CROSS_ORIGIN_OPENER_POLICY", c.CrossOriginOpenerPolicy)
+	c.CrossOriginEmbedderPolicy = getEnvString("CROSS_ORIGIN_EMBEDDER_POLICY", c.CrossOriginEmbedderPolicy)
+	c.CrossOriginResourcePolicy = getEnvString("CROSS_ORIGIN_RESOURCE_POLICY", c.CrossOriginResourcePolicy)
+	c.PermissionsPolicy = getEnvString("PERMISSIONS_POLICY", c.PermissionsPolicy)
+	c.SecurityRouteHeaders = getEnvJSON("SECURITY_ROUTE_HEADERS", c.SecurityRouteHeaders, &errs)
+
+	c.CacheControl = getEnvJSON("CACHE_CONTROL", c.CacheControl, &errs)
+	c.ResponseCacheTTL = getEnvDuration("RESPONSE_CACHE_TTL", c.ResponseCacheTTL, &errs)
+	c.ResponseCacheMaxEntries = getEnvInt("RESPONSE_CACHE_MAX_ENTRIES", c.ResponseCacheMaxEntries)
+
+	c.APIVersions = getEnvStringSlice("API_VERSIONS", c.APIVersions)
+
+	c.MaintenanceMode = getEnvBool("MAINTENANCE_MODE", c.MaintenanceMode)
+	c.MaintenanceMessage = getEnvString("MAINTENANCE_MESSAGE", c.MaintenanceMessage)
+	c.MaintenanceRetryAfter = getEnvDuration("MAINTENANCE_RETRY_AFTER", c.MaintenanceRetryAfter, &errs)
+
+	c.AuditLogFile = getEnvString("AUDIT_LOG_FILE", c.AuditLogFile)
+
+	c.AlertInterval = getEnvDuration("ALERT_INTERVAL", c.AlertInterval, &errs)
+	c.AlertCooldown = getEnvDuration("ALERT_COOLDOWN", c.AlertCooldown, &errs)
+	c.AlertErrorRate = getEnvFloat("ALERT_ERROR_RATE", c.AlertErrorRate, &errs)
+	c.AlertErrorRateWindow = getEnvDuration("ALERT_ERROR_RATE_WINDOW", c.AlertErrorRateWindow, &errs)
+	c.AlertErrorRateMinRequests = getEnvInt("ALERT_ERROR_RATE_MIN_REQUESTS", c.AlertErrorRateMinRequests)
+	c.AlertSinkQueueDepth = getEnvInt("ALERT_SINK_QUEUE_DEPTH", c.AlertSinkQueueDepth)
+	c.AlertHeapBytes = getEnvByteSize("ALERT_HEAP_BYTES", c.AlertHeapBytes, &errs)
+	c.AlertDenialsPerMinute = getEnvFloat("ALERT_DENIALS_PER_MINUTE", c.AlertDenialsPerMinute, &errs)
+	c.AlertWebhookURL = getEnvSecret("ALERT_WEBHOOK_URL", c.AlertWebhookURL, &errs)
+	c.AlertSlackWebhookURL = getEnvSecret("ALERT_SLACK_WEBHOOK_URL", c.AlertSlackWebhookURL, &errs)
+	c.AlertPagerDutyRoutingKey = getEnvSecret("ALERT_PAGERDUTY_ROUTING_KEY", c.AlertPagerDutyRoutingKey, &errs)
+	c.AlertWebhookRateLimit = getEnvInt("ALERT_WEBHOOK_RATE_LIMIT", c.AlertWebhookRateLimit)
+	c.AlertSlackRateLimit = getEnvInt("ALERT_SLACK_RATE_LIMIT", c.AlertSlackRateLimit)
+	c.AlertPagerDutyRateLimit = getEnvInt("ALERT_PAGERDUTY_RATE_LIMIT", c.AlertPagerDutyRateLimit)
+	c.AnomalyThreshold = getEnvFloat("ANOMALY_THRESHOLD", c.AnomalyThreshold, &errs)
+	c.AnomalyAlpha = getEnvFloat("ANOMALY_ALPHA", c.AnomalyAlpha, &errs)
+	c.AnomalyWarmup = getEnvDuration("ANOMALY_WARMUP", c.AnomalyWarmup, &errs)
+	c.ReportSchedule = getEnvString("REPORT_SCHEDULE", c.ReportSchedule)
+	c.ReportTopElements = getEnvInt("REPORT_TOP_ELEMENTS", c.ReportTopElements)
+	c.ReportSMTPAddr = getEnvString("REPORT_SMTP_ADDR", c.ReportSMTPAddr)
+	c.ReportSMTPUsername = getEnvString("REPORT_SMTP_USERNAME", c.ReportSMTPUsername)
+	c.ReportSMTPPassword = getEnvSecret("REPORT_SMTP_PASSWORD", c.ReportSMTPPassword, &errs)
+	c.ReportFrom = getEnvString("REPORT_FROM", c.ReportFrom)
+	c.ReportTo = getEnvStringSlice("REPORT_TO", c.ReportTo)
+
+	c.Sinks = getEnvStringSlice("SINKS", c.Sinks)
+	c.SinkQueueSize = getEnvInt("SINK_QUEUE_SIZE", c.SinkQueueSize)
+	c.SinkBatchSize = getEnvInt("SINK_BATCH_SIZE", c.SinkBatchSize)
+	c.SinkFlushInterval = getEnvDuration("SINK_FLUSH_INTERVAL", c.SinkFlushInterval, &errs)
+	c.SinkWriteTimeout = getEnvDuration("SINK_WRITE_TIMEOUT", c.SinkWriteTimeout, &errs)
+	c.KafkaBrokers = getEnvStringSlice("KAFKA_BROKERS", c.KafkaBrokers)
+	c.KafkaTopic = getEnvString("KAFKA_TOPIC", c.KafkaTopic)
+	c.KafkaCompression = getEnvString("KAFKA_COMPRESSION", c.KafkaCompression)
+	c.NATSURL = getEnvString("NATS_URL", c.NATSURL)
+	c.NATSSubject = getEnvString("NATS_SUBJECT", c.NATSSubject)
+	c.NATSCredentialsFile = getEnvString("NATS_CREDENTIALS_FILE", c.NATSCredentialsFile)
+	c.WebhookURLs = getEnvStringSlice("WEBHOOK_URLS", c.WebhookURLs)
+	c.WebhookSecret = getEnvSecret("WEBHOOK_SECRET", c.WebhookSecret, &errs)
+	c.WebhookMaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", c.WebhookMaxAttempts)
+	c.WebhookBreakerThreshold = getEnvInt("WEBHOOK_BREAKER_THRESHOLD", c.WebhookBreakerThreshold)
+	c.WebhookBreakerCooldown = getEnvDuration("WEBHOOK_BREAKER_COOLDOWN", c.WebhookBreakerCooldown, &errs)
+	c.KinesisStream = getEnvString("KINESIS_STREAM", c.KinesisStream)
+	c.KinesisAggregate = getEnvBool("KINESIS_AGGREGATE", c.KinesisAggregate)
+	c.FirehoseDeliveryStream = getEnvString("FIREHOSE_DELIVERY_STREAM", c.FirehoseDeliveryStream)
+	c.FirehoseAggregate = getEnvBool("FIREHOSE_AGGREGATE", c.FirehoseAggregate)
+	c.ElasticsearchURLs = getEnvStringSlice("ELASTICSEARCH_URLS", c.ElasticsearchURLs)
+	c.ElasticsearchIndex = getEnvString("ELASTICSEARCH_INDEX", c.ElasticsearchIndex)
+	c.ElasticsearchIndexMode = getEnvString("ELASTICSEARCH_INDEX_MODE", c.ElasticsearchIndexMode)
+	c.ElasticsearchUsername = getEnvString("ELASTICSEARCH_USERNAME", c.ElasticsearchUsername)
+	c.ElasticsearchPassword = getEnvSecret("ELASTICSEARCH_PASSWORD", c.ElasticsearchPassword, &errs)
+	c.ElasticsearchAPIKey = getEnvSecret("ELASTICSEARCH_API_KEY", c.ElasticsearchAPIKey, &errs)
+	c.ElasticsearchManageTemplate = getEnvBool("ELASTICSEARCH_MANAGE_TEMPLATE", c.ElasticsearchManageTemplate)
+	c.RedisURL = getEnvString("REDIS_URL", c.RedisURL)
+	c.RedisPassword = getEnvSecret("REDIS_PASSWORD", c.RedisPassword, &errs)
+	c.RedisChannel = getEnvString("REDIS_CHANNEL", c.RedisChannel)
+	c.AMQPURL = getEnvString("AMQP_URL", c.AMQPURL)
+	c.AMQPPassword = getEnvSecret("AMQP_PASSWORD", c.AMQPPassword, &errs)
+	c.AMQPExchange = getEnvString("AMQP_EXCHANGE", c.AMQPExchange)
+	c.AMQPRoutingKey = getEnvString("AMQP_ROUTING_KEY", c.AMQPRoutingKey)
+	c.BigQueryProject = getEnvString("BIGQUERY_PROJECT", c.BigQueryProject)
+	c.BigQueryDataset = getEnvString("BIGQUERY_DATASET", c.BigQueryDataset)
+	c.BigQueryTable = getEnvString("BIGQUERY_TABLE", c.BigQueryTable)
+	c.BigQueryManageSchema = getEnvBool("BIGQUERY_MANAGE_SCHEMA", c.BigQueryManageSchema)
+	c.MQTTBrokers = getEnvStringSlice("MQTT_BROKERS", c.MQTTBrokers)
+	c.MQTTClientID = getEnvString("MQTT_CLIENT_ID", c.MQTTClientID)
+	c.MQTTUsername = getEnvString("MQTT_USERNAME", c.MQTTUsername)
+	c.MQTTPassword = getEnvSecret("MQTT_PASSWORD", c.MQTTPassword, &errs)
+	c.MQTTTopics = getEnvStringSlice("MQTT_TOPICS", c.MQTTTopics)
+	c.MQTTQoS = getEnvInt("MQTT_QOS", c.MQTTQoS)
+	c.MQTTTenantTopicLevel = getEnvInt("MQTT_TENANT_TOPIC_LEVEL", c.MQTTTenantTopicLevel)
+
+	c.GRPCPort = getEnvInt("GRPC_PORT", c.GRPCPort)
+	c.StreamBufferSize = getEnvInt("STREAM_BUFFER_SIZE", c.StreamBufferSize)
+	c.StreamMaxSubscribers = getEnvInt("STREAM_MAX_SUBSCRIBERS", c.StreamMaxSubscribers)
+
+	c.RoutesFile = getEnvString("ROUTES_FILE", c.RoutesFile)
+
+	c.ConfigWatchInterval = getEnvDuration("CONFIG_WATCH_INTERVAL", c.ConfigWatchInterval, &errs)
+
+	c.RemoteConfigURL = getEnvString("REMOTE_CONFIG_URL", c.RemoteConfigURL)
+	c.RemoteConfigToken = getEnvSecret("REMOTE_CONFIG_TOKEN", c.RemoteConfigToken, &errs)
+
+	return errors.Join(errs...)
+}
+
+// readFile decodes a YAML, TOML or JSON config file over c
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	raw, err := parseDocument(data, strings.ToLower(filepath.Ext(path)))
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return c.decode(raw, "config file "+path)
+}
+
+// parseDocument parses a config document in the format named by a file
+// extension
+func parseDocument(data []byte, ext string) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	var err error
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	return raw, err
+}
+
+// decode applies a parsed document over c. Values are re-encoded as JSON
+// so every format shares Config's JSON keys, and decoded key by key so
+// errors name the offending key.
+func (c *Config) decode(raw map[string]interface{}, origin string) error {
+	fields := fieldsByKey(c)
+	for _, key := range sortedKeys(raw) {
+		field, ok := fields[key]
+		if !ok {
+			if suggestion := suggestKey(key, sortedKeys(fields)); suggestion != "" {
+				return fmt.Errorf("%s: unknown key %q, did you mean %q?", origin, key, suggestion)
+			}
+			return fmt.Errorf("%s: unknown key %q", origin, key)
+		}
+		encoded, err := json.Marshal(raw[key])
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", origin, key, err)
+		}
+		if err := json.Unmarshal(encoded, field.Addr().Interface()); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				err = fmt.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value)
+			}
+			return fmt.Errorf("%s: %s: %w", origin, key, err)
+		}
+	}
+	return nil
+}
+
+// fieldsByKey maps JSON keys to the settable fields of c
+func fieldsByKey(c *Config) map[string]reflect.Value {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	fields := make(map[string]reflect.Value, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key := jsonKey(t.Field(i)); key != "" {
+			fields[key] = v.Field(i)
+		}
+	}
+	return fields
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TLSEnabled reports whether the server should terminate TLS itself
+func (c *Config) TLSEnabled() bool {
+	return (c.TLSCertFile != "" && c.TLSKeyFile != "") || c.ACMEEnabled()
+}
+
+// ACMEEnabled reports whether certificates are obtained over ACME
+func (c *Config) ACMEEnabled() bool {
+	return len(c.ACMEHosts) > 0
+}
+
+// PrometheusEnabled reports whether metrics are exposed for scraping
+func (c *Config) PrometheusEnabled() bool {
+	return c.TelemetryEnabled && (c.MetricsExporter == "prometheus" || c.MetricsExporter == "both")
+}
+
+// SyslogAddr returns the network and address LogSyslog names, both empty
+// for the local syslog socket
+func (c *Config) SyslogAddr() (network, addr string, err error) {
+	if c.LogSyslog == "" || c.LogSyslog == "local" {
+		return "", "", nil
+	}
+	u, err := url.Parse(c.LogSyslog)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Port() == "" {
+		return "", "", fmt.Errorf("log_syslog must be local, udp://host:port or tcp://host:port, got %q", c.LogSyslog)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// HTTPListener returns the network and address the main server listens
+// on. The systemd network's address is the activated socket's name.
+func (c *Config) HTTPListener() (network, addr string) {
+	if c.HTTPSocket == "" {
+		return "tcp", fmt.Sprintf(":%d", c.Port)
+	}
+	return parseListener(c.HTTPSocket, "http")
+}
+
+// AdminListener returns the network and address AdminAddr names, both
+// empty when admin routes share the main port
+func (c *Config) AdminListener() (network, addr string) {
+	if c.AdminAddr == "" {
+		return "", ""
+	}
+	return parseListener(c.AdminAddr, "admin")
+}
+
+// parseListener splits a listener setting, unix:///path, systemd or
+// host:port, into its network and address. A systemd listener's address is
+// name.
+func parseListener(value, name string) (network, addr string) {
+	if path, ok := strings.CutPrefix(value, "unix://"); ok {
+		return "unix", path
+	}
+	if value == "systemd" {
+		return "systemd", name
+	}
+	return "tcp", value
+}
+
+// OTLPLogsEnabled reports whether logs are exported over OTLP
+func (c *Config) OTLPLogsEnabled() bool {
+	return c.TelemetryEnabled && c.LogsExporter == "otlp"
+}
+
+// JWTEnabled reports whether admin routes are protected by JWT
+func (c *Config) JWTEnabled() bool {
+	return c.JWTSecret != "" || c.JWTPublicKeyFile != "" || c.JWTJWKSURL != ""
+}
+
+// CSRFProtected reports whether CSRF checks apply to a route pattern
+func (c *Config) CSRFProtected(route string) bool {
+	if !c.CSRFEnabled {
+		return false
+	}
+	for _, r := range c.CSRFRoutes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteTimeout returns the handler timeout for a route pattern
+func (c *Config) RouteTimeout(route string) time.Duration {
+	if d, ok := c.RouteTimeouts[route]; ok {
+		return time.Duration(d)
+	}
+	return time.Duration(c.DefaultRouteTimeout)
+}
+
 func (c *Config) validate() error {
 	if c.Port < 1 || c.Port > 65535 {
 		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Port)
 	}
+	switch network, addr := c.HTTPListener(); {
+	case c.HTTPSocket != "" && network == "tcp":
+		return fmt.Errorf("http_socket must be unix:///path or systemd, got %q; TCP is set with port", c.HTTPSocket)
+	case network == "unix" && addr == "":
+		return fmt.Errorf("http_socket must name a socket path after unix://")
+	}
 
 	validLogLevels := map[string]bool{
 		"DEBUG": true,
@@ -46,6 +1261,403 @@ func (c *Config) validate() error {
 	if c.OTELEndpoint == "" {
 		return fmt.Errorf("OTEL endpoint cannot be empty")
 	}
+	if c.TelemetryErrorInterval <= 0 {
+		return fmt.Errorf("telemetry_error_interval must be positive, got %s", c.TelemetryErrorInterval)
+	}
+	if c.OTELProtocol != "grpc" && c.OTELProtocol != "http/protobuf" {
+		return fmt.Errorf("otel_protocol must be grpc or http/protobuf, got %s", c.OTELProtocol)
+	}
+	if c.OTELCompression != "" && c.OTELCompression != "none" && c.OTELCompression != "gzip" {
+		return fmt.Errorf("otel_compression must be gzip or none, got %s", c.OTELCompression)
+	}
+	if (c.OTELClientCertificate == "") != (c.OTELClientKey == "") {
+		return fmt.Errorf("otel_client_certificate and otel_client_key must be set together")
+	}
+	if c.OTELTimeout <= 0 {
+		return fmt.Errorf("otel_timeout must be positive, got %s", c.OTELTimeout)
+	}
+	if c.OTELRetryMaxElapsed <= 0 {
+		return fmt.Errorf("otel_retry_max_elapsed must be positive, got %s", c.OTELRetryMaxElapsed)
+	}
+
+	switch c.MetricsExporter {
+	case "otlp", "prometheus", "both", "none":
+	default:
+		return fmt.Errorf("metrics_exporter must be otlp, prometheus, both or none, got %s", c.MetricsExporter)
+	}
+	if c.StatsDAddr != "" {
+		if path, ok := strings.CutPrefix(c.StatsDAddr, "unix://"); ok {
+			if path == "" {
+				return fmt.Errorf("statsd_addr must name a socket path after unix://")
+			}
+		} else if _, _, err := net.SplitHostPort(c.StatsDAddr); err != nil {
+			return fmt.Errorf("statsd_addr must be host:port or unix:///path, got %q", c.StatsDAddr)
+		}
+		if c.StatsDFlavor != "statsd" && c.StatsDFlavor != "dogstatsd" {
+			return fmt.Errorf("statsd_flavor must be statsd or dogstatsd, got %s", c.StatsDFlavor)
+		}
+		if c.StatsDInterval <= 0 {
+			return fmt.Errorf("statsd_interval must be positive, got %s", c.StatsDInterval)
+		}
+	}
+	if c.InfluxURL != "" {
+		if u, err := url.Parse(c.InfluxURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("influx_url must be an http or https URL, got %q", c.InfluxURL)
+		}
+		if c.InfluxInterval <= 0 {
+			return fmt.Errorf("influx_interval must be positive, got %s", c.InfluxInterval)
+		}
+	}
+	if c.MetricsPort < 0 || c.MetricsPort > 65535 {
+		return fmt.Errorf("metrics_port must be between 0 and 65535, got %d", c.MetricsPort)
+	}
+	if c.LogsExporter != "otlp" && c.LogsExporter != "none" {
+		return fmt.Errorf("logs_exporter must be otlp or none, got %s", c.LogsExporter)
+	}
+	if c.LogFileMaxAge < 0 || c.LogFileMaxBackups < 0 {
+		return fmt.Errorf("log_file_max_age and log_file_max_backups cannot be negative, got %s and %d", c.LogFileMaxAge, c.LogFileMaxBackups)
+	}
+	if _, _, err := c.SyslogAddr(); err != nil {
+		return err
+	}
+	switch c.MetricsExemplarFilter {
+	case "trace_based", "always_on", "always_off":
+	default:
+		return fmt.Errorf("metrics_exemplar_filter must be trace_based, always_on or always_off, got %s", c.MetricsExemplarFilter)
+	}
+	if c.MetricsMaxRoutes < 1 {
+		return fmt.Errorf("metrics_max_routes must be at least 1, got %d", c.MetricsMaxRoutes)
+	}
+	if c.MetricsBatchSize < 0 {
+		return fmt.Errorf("metrics_batch_size cannot be negative, got %d", c.MetricsBatchSize)
+	}
+	if c.MetricsBatchInterval <= 0 {
+		return fmt.Errorf("metrics_batch_interval must be positive, got %s", c.MetricsBatchInterval)
+	}
+	for key, buckets := range map[string][]float64{
+		"http_latency_buckets":     c.HTTPLatencyBuckets,
+		"cursor_position_buckets":  c.CursorPositionBuckets,
+		"session_event_buckets":    c.SessionEventBuckets,
+		"session_duration_buckets": c.SessionDurationBuckets,
+		"session_click_buckets":    c.SessionClickBuckets,
+	} {
+		if len(buckets) == 0 {
+			return fmt.Errorf("%s cannot be empty", key)
+		}
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] <= buckets[i-1] {
+				return fmt.Errorf("%s must be increasing, got %g after %g", key, buckets[i], buckets[i-1])
+			}
+		}
+	}
+	if c.SessionTimeout <= 0 {
+		return fmt.Errorf("session_timeout must be positive, got %s", c.SessionTimeout)
+	}
+	if c.SessionCleanupInterval <= 0 {
+		return fmt.Errorf("session_cleanup_interval must be positive, got %s", c.SessionCleanupInterval)
+	}
+	if c.SessionIdleThreshold <= 0 || c.SessionIdleThreshold > c.SessionTimeout {
+		return fmt.Errorf("session_idle_threshold must be positive and at most session_timeout, got %s", c.SessionIdleThreshold)
+	}
+	if c.MetricsPort == c.Port {
+		return fmt.Errorf("metrics_port must differ from port %d", c.Port)
+	}
+
+	switch c.TraceSampler {
+	case "always_on", "always_off", "traceidratio",
+		"parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio":
+	default:
+		return fmt.Errorf("trace_sampler must be one of always_on, always_off, traceidratio, parentbased_always_on, parentbased_always_off or parentbased_traceidratio, got %s", c.TraceSampler)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		return fmt.Errorf("trace_sample_ratio must be between 0 and 1, got %g", c.TraceSampleRatio)
+	}
+	for _, path := range sortedKeys(c.TraceRouteSampleRatios) {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("trace_route_sample_ratios: %q is not a URL path", path)
+		}
+		if r := c.TraceRouteSampleRatios[path]; r < 0 || r > 1 {
+			return fmt.Errorf("trace_route_sample_ratios[%s] must be between 0 and 1, got %g", path, r)
+		}
+	}
+
+	if c.TraceTailLatency <= 0 {
+		return fmt.Errorf("trace_tail_latency must be positive, got %s", c.TraceTailLatency)
+	}
+	if c.TraceTailWait <= 0 {
+		return fmt.Errorf("trace_tail_wait must be positive, got %s", c.TraceTailWait)
+	}
+	if c.TraceTailMaxTraces < 1 {
+		return fmt.Errorf("trace_tail_max_traces must be at least 1, got %d", c.TraceTailMaxTraces)
+	}
+
+	for key, rates := range map[string]map[string]float64{
+		"event_span_sample_rates":   c.EventSpanSampleRates,
+		"event_metric_sample_rates": c.EventMetricSampleRates,
+	} {
+		for _, eventType := range sortedKeys(rates) {
+			if r := rates[eventType]; r < 0 || r > 1 {
+				return fmt.Errorf("%s[%s] must be between 0 and 1, got %g", key, eventType, r)
+			}
+		}
+	}
+
+	for key, target := range map[string]float64{
+		"slo_availability_target": c.SLOAvailabilityTarget,
+		"slo_latency_target":      c.SLOLatencyTarget,
+	} {
+		if target <= 0 || target >= 1 {
+			return fmt.Errorf("%s must be between 0 and 1 exclusive, got %g", key, target)
+		}
+	}
+	if c.SLOLatencyThreshold <= 0 {
+		return fmt.Errorf("slo_latency_threshold must be positive, got %s", c.SLOLatencyThreshold)
+	}
+	if len(c.SLOWindows) == 0 {
+		return fmt.Errorf("slo_windows cannot be empty")
+	}
+	for _, w := range c.SLOWindows {
+		// Requests are counted in 10 second buckets kept for the longest window
+		if w < Duration(time.Minute) || w > Duration(7*24*time.Hour) {
+			return fmt.Errorf("slo_windows must be between 1m and 168h, got %s", w)
+		}
+	}
+
+	// Zero disables a server timeout, as in net/http
+	for key, d := range map[string]Duration{
+		"read_timeout":        c.ReadTimeout,
+		"read_header_timeout": c.ReadHeaderTimeout,
+		"write_timeout":       c.WriteTimeout,
+		"idle_timeout":        c.IdleTimeout,
+		"admin_read_timeout":  c.AdminReadTimeout,
+		"admin_write_timeout": c.AdminWriteTimeout,
+		"admin_idle_timeout":  c.AdminIdleTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s cannot be negative, got %s", key, d)
+		}
+	}
+	if c.ReadTimeout > 0 && c.ReadHeaderTimeout > c.ReadTimeout {
+		return fmt.Errorf("read_header_timeout (%s) cannot exceed read_timeout (%s)", c.ReadHeaderTimeout, c.ReadTimeout)
+	}
+	if c.MaxHeaderBytes < 1<<10 {
+		return fmt.Errorf("max_header_bytes must be at least 1KB, got %s", c.MaxHeaderBytes)
+	}
+	if c.ShutdownGracePeriod <= 0 {
+		return fmt.Errorf("shutdown_grace_period must be positive, got %s", c.ShutdownGracePeriod)
+	}
+	if err := c.validateAdmin(); err != nil {
+		return err
+	}
+
+	if c.EventTypeStrictness != "warn" && c.EventTypeStrictness != "strict" {
+		return fmt.Errorf("event type strictness must be warn or strict, got %s", c.EventTypeStrictness)
+	}
+	switch c.BotFilter {
+	case "off", "tag", "drop":
+	default:
+		return fmt.Errorf("bot_filter must be off, tag or drop, got %s", c.BotFilter)
+	}
+	if c.ReplayEnabled {
+		if c.ReplayMaxSessionBytes < 1 {
+			return fmt.Errorf("replay_max_session_bytes must be positive, got %s", c.ReplayMaxSessionBytes)
+		}
+		if c.ReplayMaxBytes < c.ReplayMaxSessionBytes {
+			return fmt.Errorf("replay_max_bytes must be at least replay_max_session_bytes %s, got %s", c.ReplayMaxSessionBytes, c.ReplayMaxBytes)
+		}
+		if c.ReplayRetention <= 0 {
+			return fmt.Errorf("replay_retention must be positive, got %s", c.ReplayRetention)
+		}
+	}
+	switch c.PrivacyIPMode {
+	case "none", "truncate", "hash":
+	default:
+		return fmt.Errorf("privacy_ip_mode must be none, truncate or hash, got %s", c.PrivacyIPMode)
+	}
+	// A random key would make the hashes change on every restart and differ
+	// between replicas
+	if c.PrivacyIPMode == "hash" && c.PrivacyIPHashKey == "" {
+		return fmt.Errorf("privacy_ip_mode hash needs privacy_ip_hash_key")
+	}
+	switch c.ConsentPolicy {
+	case "ignore", "opt_out", "opt_in":
+	default:
+		return fmt.Errorf("consent_policy must be ignore, opt_out or opt_in, got %s", c.ConsentPolicy)
+	}
+	for _, template := range c.PageTemplates {
+		if !strings.HasPrefix(template, "/") {
+			return fmt.Errorf("page_templates must be paths starting with /, got %s", template)
+		}
+	}
+	switch c.TenantSource {
+	case "none", "api_key":
+	case "header":
+		if c.TenantHeader == "" {
+			return fmt.Errorf("tenant_header is required when tenant_source is header")
+		}
+		// The header is only taken from a proxy, naming a known tenant
+		if len(c.TrustedProxies) == 0 {
+			return fmt.Errorf("tenant_source header requires trusted_proxies to set the header")
+		}
+		if len(c.Tenants) == 0 {
+			return fmt.Errorf("tenant_source header requires tenants to list the tenants it may name")
+		}
+	default:
+		return fmt.Errorf("tenant_source must be none, api_key or header, got %s", c.TenantSource)
+	}
+	if c.TenantSource == "api_key" && c.APIKeys == "" && c.APIKeysFile == "" {
+		return fmt.Errorf("tenant_source api_key requires api_keys or api_keys_file")
+	}
+	if c.MaxTenants < 0 {
+		return fmt.Errorf("max_tenants must not be negative, got %d", c.MaxTenants)
+	}
+	if c.BotMaxEventRate < 0 {
+		return fmt.Errorf("bot_max_event_rate must not be negative, got %d", c.BotMaxEventRate)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLS cert file and key file must be set together")
+	}
+	if c.TLSWatchInterval < 0 {
+		return fmt.Errorf("tls_watch_interval cannot be negative, got %s", c.TLSWatchInterval)
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		return fmt.Errorf("tls_client_ca_file needs TLS, which tls_cert_file and tls_key_file or acme_hosts enable")
+	}
+	if c.TLSAdminClientCert && c.TLSClientCAFile == "" {
+		return fmt.Errorf("tls_admin_client_cert needs tls_client_ca_file to verify client certificates")
+	}
+	if err := c.validateACME(); err != nil {
+		return err
+	}
+
+	if c.JWTJWKSCacheTTL <= 0 {
+		return fmt.Errorf("jwt_jwks_cache_ttl must be positive, got %s", c.JWTJWKSCacheTTL)
+	}
+
+	if c.JWTLeeway < 0 {
+		return fmt.Errorf("jwt_leeway cannot be negative, got %s", c.JWTLeeway)
+	}
+
+	if c.SignatureMaxSkew < Duration(time.Second) {
+		return fmt.Errorf("signature_max_skew must be at least 1s, got %s", c.SignatureMaxSkew)
+	}
+
+	if c.MaxBodySize < 1 {
+		return fmt.Errorf("max_body_size must be positive, got %s", c.MaxBodySize)
+	}
+
+	if c.MaxCustomFields < 0 {
+		return fmt.Errorf("max custom fields cannot be negative, got %d", c.MaxCustomFields)
+	}
+
+	if c.DefaultRouteTimeout < 0 {
+		return fmt.Errorf("default_route_timeout cannot be negative, got %s", c.DefaultRouteTimeout)
+	}
+	for route, d := range c.RouteTimeouts {
+		if d < 0 {
+			return fmt.Errorf("route_timeouts[%s] cannot be negative, got %s", route, d)
+		}
+	}
+	// A handler timeout past the write deadline could never be reported
+	if c.WriteTimeout > 0 && c.DefaultRouteTimeout >= c.WriteTimeout {
+		return fmt.Errorf("default_route_timeout (%s) must be shorter than write_timeout (%s)", c.DefaultRouteTimeout, c.WriteTimeout)
+	}
+
+	if c.CORSAllowCredentials {
+		for _, origin := range c.CORSAllowedOrigins {
+			if origin == "*" {
+				return fmt.Errorf("CORS credentials cannot be combined with a wildcard origin")
+			}
+		}
+	}
+
+	if c.CORSMaxAge < 0 {
+		return fmt.Errorf("cors_max_age cannot be negative, got %s", c.CORSMaxAge)
+	}
+
+	if c.HSTSMaxAge < 0 {
+		return fmt.Errorf("hsts_max_age cannot be negative, got %s", c.HSTSMaxAge)
+	}
+
+	if c.ResponseCacheTTL < 0 {
+		return fmt.Errorf("response_cache_ttl cannot be negative, got %s", c.ResponseCacheTTL)
+	}
+
+	if c.ResponseCacheMaxEntries < 1 {
+		return fmt.Errorf("response cache max entries must be positive, got %d", c.ResponseCacheMaxEntries)
+	}
+
+	if len(c.APIVersions) == 0 {
+		return fmt.Errorf("at least one API version must be configured")
+	}
+
+	if c.MaintenanceRetryAfter < 0 {
+		return fmt.Errorf("maintenance_retry_after cannot be negative, got %s", c.MaintenanceRetryAfter)
+	}
+
+	if c.AlertInterval <= 0 {
+		return fmt.Errorf("alert_interval must be positive, got %s", c.AlertInterval)
+	}
+	if c.AlertCooldown < 0 {
+		return fmt.Errorf("alert_cooldown cannot be negative, got %s", c.AlertCooldown)
+	}
+	if c.AlertErrorRate < 0 || c.AlertErrorRate > 1 {
+		return fmt.Errorf("alert_error_rate must be between 0 and 1, got %g", c.AlertErrorRate)
+	}
+	// The error rate comes from the requests counted for the SLOs
+	if longest := slices.Max(c.SLOWindows); c.AlertErrorRateWindow <= 0 || c.AlertErrorRateWindow > longest {
+		return fmt.Errorf("alert_error_rate_window must be positive and at most the longest SLO window %s, got %s", longest, c.AlertErrorRateWindow)
+	}
+	if c.AlertErrorRateMinRequests < 0 {
+		return fmt.Errorf("alert_error_rate_min_requests cannot be negative, got %d", c.AlertErrorRateMinRequests)
+	}
+	if c.AlertSinkQueueDepth < 0 {
+		return fmt.Errorf("alert_sink_queue_depth cannot be negative, got %d", c.AlertSinkQueueDepth)
+	}
+	if c.AlertDenialsPerMinute < 0 {
+		return fmt.Errorf("alert_denials_per_minute cannot be negative, got %g", c.AlertDenialsPerMinute)
+	}
+	if c.AlertWebhookRateLimit < 0 || c.AlertSlackRateLimit < 0 || c.AlertPagerDutyRateLimit < 0 {
+		return fmt.Errorf("alert notifier rate limits cannot be negative, got %d, %d and %d",
+			c.AlertWebhookRateLimit, c.AlertSlackRateLimit, c.AlertPagerDutyRateLimit)
+	}
+	if c.AnomalyThreshold < 0 {
+		return fmt.Errorf("anomaly_threshold cannot be negative, got %g", c.AnomalyThreshold)
+	}
+	if c.AnomalyAlpha <= 0 || c.AnomalyAlpha > 1 {
+		return fmt.Errorf("anomaly_alpha must be in (0, 1], got %g", c.AnomalyAlpha)
+	}
+	if c.AnomalyWarmup < 0 {
+		return fmt.Errorf("anomaly_warmup cannot be negative, got %s", c.AnomalyWarmup)
+	}
+
+	if err := c.validateReport(); err != nil {
+		return err
+	}
+
+	if err := c.validateMQTT(); err != nil {
+		return err
+	}
+	if err := c.validateStream(); err != nil {
+		return err
+	}
+	if err := c.validateSinks(); err != nil {
+		return err
+	}
+
+	if c.ConfigWatchInterval < 0 {
+		return fmt.Errorf("config_watch_interval cannot be negative, got %s", c.ConfigWatchInterval)
+	}
+	if c.RemoteConfigURL != "" {
+		if _, err := NewRemoteSource(c.RemoteConfigURL, ""); err != nil {
+			return fmt.Errorf("remote_config_url: %w", err)
+		}
+	}
+
+	if c.HTTP2MaxConcurrentStreams < 1 {
+		return fmt.Errorf("HTTP/2 max concurrent streams must be positive, got %d", c.HTTP2MaxConcurrentStreams)
+	}
 
 	return nil
 }
@@ -65,3 +1677,184 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvStringSlice parses a comma separated list, dropping empty entries
+func getEnvStringSlice(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvMillis is getEnvDuration for variables the OpenTelemetry spec
+// defines in milliseconds, so a bare integer is milliseconds here
+func getEnvMillis(key string, defaultValue Duration, errs *[]error) Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return Duration(time.Duration(n) * time.Millisecond)
+	}
+	return getEnvDuration(key, defaultValue, errs)
+}
+
+// getEnvDuration parses a duration such as "30s", recording an error that
+// names the variable when it is malformed
+func getEnvDuration(key string, defaultValue Duration, errs *[]error) Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return d
+}
+
+// getEnvByteSize parses a size such as "10MB", recording an error that
+// names the variable when it is malformed
+func getEnvByteSize(key string, defaultValue ByteSize, errs *[]error) ByteSize {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	size, err := ParseByteSize(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return size
+}
+
+// getEnvFloat parses a number, recording an error that names the
+// variable when it is malformed
+func getEnvFloat(key string, defaultValue float64, errs *[]error) float64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: invalid number %q", key, value))
+		return defaultValue
+	}
+	return f
+}
+
+// getEnvFloatSlice parses a comma separated list of numbers
+func getEnvFloatSlice(key string, defaultValue []float64, errs *[]error) []float64 {
+	items := getEnvStringSlice(key, nil)
+	if items == nil {
+		return defaultValue
+	}
+
+	result := make([]float64, 0, len(items))
+	for _, item := range items {
+		f, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: invalid number %q", key, item))
+			return defaultValue
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// getEnvFloatMap parses "key=number,key=number" pairs
+func getEnvFloatMap(key string, defaultValue map[string]float64, errs *[]error) map[string]float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	result := make(map[string]float64)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			*errs = append(*errs, fmt.Errorf("%s: invalid entry %q, expected key=number", key, pair))
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s[%s]: invalid number %q", key, strings.TrimSpace(k), v))
+			continue
+		}
+		result[strings.TrimSpace(k)] = f
+	}
+	return result
+}
+
+// getEnvDurationSlice parses a comma separated list of durations
+func getEnvDurationSlice(key string, defaultValue []Duration, errs *[]error) []Duration {
+	items := getEnvStringSlice(key, nil)
+	if items == nil {
+		return defaultValue
+	}
+
+	result := make([]Duration, 0, len(items))
+	for _, item := range items {
+		d, err := ParseDuration(item)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+			return defaultValue
+		}
+		result = append(result, d)
+	}
+	return result
+}
+
+// getEnvDurationMap parses "key=duration,key=duration" pairs
+func getEnvDurationMap(key string, defaultValue map[string]Duration, errs *[]error) map[string]Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	result := make(map[string]Duration)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			*errs = append(*errs, fmt.Errorf("%s: invalid entry %q, expected route=duration", key, pair))
+			continue
+		}
+		d, err := ParseDuration(v)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s[%s]: %w", key, strings.TrimSpace(k), err))
+			continue
+		}
+		result[strings.TrimSpace(k)] = d
+	}
+	return result
+}
+
+// getEnvJSON decodes a JSON encoded value, recording an error that names
+// the variable when it is malformed
+func getEnvJSON[T any](key string, defaultValue T, errs *[]error) T {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var parsed T
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", key, err))
+		return defaultValue
+	}
+	return parsed
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+)
+
+// Where a config value came from, from lowest to highest precedence
+const (
+	SourceDefault     = "default"
+	SourceProfile     = "profile"
+	SourceFile        = "file"
+	SourceRemote      = "remote"
+	SourceEnv         = "env"
+	SourceFlag        = "flag"
+	SourceSecretStore = "secret_store"
+)
+
+// Setting is one config value annotated with its source
+type Setting struct {
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// setting is a config field addressed by its key. Secrets are keyed by
+// their lower-cased environment variable since they have no JSON name.
+type setting struct {
+	key    string
+	value  reflect.Value
+	secret bool
+}
+
+func (c *Config) settings() []setting {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	settings := make([]setting, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key := c.settingKey(t.Field(i)); key != "" {
+			settings = append(settings, setting{key: key, value: v.Field(i), secret: jsonKey(t.Field(i)) == ""})
+		}
+	}
+	return settings
+}
+
+// displayValue is the value as shown in logs and the admin API, with
+// secrets reduced to whether they are set
+func (s setting) displayValue() any {
+	if s.secret {
+		if s.value.String() == "" {
+			return "unset"
+		}
+		return "redacted"
+	}
+	value := s.value.Interface()
+	if str, ok := value.(interface{ String() string }); ok {
+		return str.String()
+	}
+	return value
+}
+
+// track runs one load layer and attributes every value it changed to
+// source. Values are compared by their JSON encoding, so a layer setting
+// a key to the value it already had leaves the earlier source in place.
+func (c *Config) track(source string, layer func() error) error {
+	before := c.fingerprint()
+	if err := layer(); err != nil {
+		return err
+	}
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	for key, after := range c.fingerprint() {
+		if after != before[key] {
+			c.sources[key] = source
+		}
+	}
+	return nil
+}
+
+func (c *Config) fingerprint() map[string]string {
+	prints := make(map[string]string)
+	for _, s := range c.settings() {
+		b, _ := json.Marshal(s.value.Interface())
+		prints[s.key] = string(b)
+	}
+	return prints
+}
+
+// Source reports where the value for key came from
+func (c *Config) Source(key string) string {
+	if source, ok := c.sources[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// Dump returns every config value with its source, secrets redacted
+func (c *Config) Dump() map[string]Setting {
+	dump := make(map[string]Setting)
+	for _, s := range c.settings() {
+		dump[s.key] = Setting{Value: s.displayValue(), Source: c.Source(s.key)}
+	}
+	return dump
+}
+
+// LogValue reports every config key with secrets redacted, so the
+// effective configuration can be logged as is
+func (c *Config) LogValue() slog.Value {
+	settings := c.settings()
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		attrs = append(attrs, slog.Any(s.key, s.displayValue()))
+	}
+	return slog.GroupValue(attrs...)
+}
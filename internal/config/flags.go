@@ -0,0 +1,169 @@
+package config
+
+import (
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Flags holds command line overrides for config keys. Every key with a
+// JSON name gets a flag of the same name with dashes, e.g. log_level is
+// --log-level. Secrets have no JSON name and therefore no flag.
+type Flags struct {
+	set map[string]string
+}
+
+// RegisterFlags adds a flag per config key to fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{set: make(map[string]string)}
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := jsonKey(field)
+		if key == "" {
+			continue
+		}
+		fs.Var(&flagValue{flags: f, key: key, typ: field.Type},
+			strings.ReplaceAll(key, "_", "-"), flagUsage(key, field.Type))
+	}
+	return f
+}
+
+// apply writes every flag given on the command line into c
+func (f *Flags) apply(c *Config) error {
+	if f == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		raw, ok := f.set[jsonKey(t.Field(i))]
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			return fmt.Errorf("flag --%s: %w", strings.ReplaceAll(jsonKey(t.Field(i)), "_", "-"), err)
+		}
+	}
+	return nil
+}
+
+func jsonKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// flagUsage names the argument in backquotes so flag's help shows it
+func flagUsage(key string, t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return "overrides " + key + " (`duration` such as 30s)"
+	case reflect.TypeOf(ByteSize(0)):
+		return "overrides " + key + " (`size` such as 10MB)"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "overrides " + key
+	case reflect.Float64:
+		return "overrides " + key + " (`number`)"
+	case reflect.Slice:
+		return "overrides " + key + " (comma separated `list`)"
+	case reflect.Map:
+		return "overrides " + key + " (`json` object)"
+	default:
+		return "overrides " + key + " (`" + t.Kind().String() + "`)"
+	}
+}
+
+// setField parses raw according to the field's type. Lists are comma
+// separated and maps are JSON, matching the environment variables.
+func setField(field reflect.Value, raw string) error {
+	if u, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		items := reflect.Zero(field.Type())
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setField(elem, item); err != nil {
+				return err
+			}
+			items = reflect.Append(items, elem)
+		}
+		field.Set(items)
+	case reflect.Map:
+		// Map values decode from JSON, so typed values use their JSON form
+		parsed := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(raw), parsed.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		field.Set(parsed.Elem())
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// flagValue records the raw value so it can be applied after the file
+// and environment have been read
+type flagValue struct {
+	flags *Flags
+	key   string
+	typ   reflect.Type
+}
+
+func (v *flagValue) String() string {
+	if v == nil || v.flags == nil {
+		return ""
+	}
+	return v.flags.set[v.key]
+}
+
+func (v *flagValue) Set(raw string) error {
+	// Parse into a scratch value so malformed flags fail at parse time
+	if err := setField(reflect.New(v.typ).Elem(), raw); err != nil {
+		return err
+	}
+	v.flags.set[v.key] = raw
+	return nil
+}
+
+// IsBoolFlag lets boolean keys be given as a bare --flag
+func (v *flagValue) IsBoolFlag() bool {
+	return v.typ.Kind() == reflect.Bool
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+var mqttSchemes = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}
+
+// validateMQTT checks the MQTT ingestion settings, when it is enabled
+func (c *Config) validateMQTT() error {
+	if len(c.MQTTBrokers) == 0 {
+		return nil
+	}
+	for _, raw := range c.MQTTBrokers {
+		if u, err := url.Parse(raw); err != nil || !slices.Contains(mqttSchemes, u.Scheme) || u.Host == "" {
+			return fmt.Errorf("mqtt_brokers must be URLs with a scheme of %v, got %q", mqttSchemes, raw)
+		}
+	}
+	if len(c.MQTTTopics) == 0 {
+		return fmt.Errorf("mqtt_topics must be set when mqtt_brokers is")
+	}
+	if slices.Contains(c.MQTTTopics, "") {
+		return fmt.Errorf("mqtt_topics must not hold an empty topic filter")
+	}
+	if c.MQTTQoS < 0 || c.MQTTQoS > 2 {
+		return fmt.Errorf("mqtt_qos must be 0, 1 or 2, got %d", c.MQTTQoS)
+	}
+	if c.MQTTTenantTopicLevel < 0 {
+		return fmt.Errorf("mqtt_tenant_topic_level must not be negative, got %d", c.MQTTTenantTopicLevel)
+	}
+	if c.MQTTTenantTopicLevel > 0 && c.TenantSource == "none" {
+		return fmt.Errorf("mqtt_tenant_topic_level needs tenancy, which tenant_source none disables")
+	}
+	return nil
+}
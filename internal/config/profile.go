@@ -0,0 +1,52 @@
+package config
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// profiles adjust the built-in defaults for an ENVIRONMENT. The config
+// file, environment variables and flags are all applied on top, so a
+// profile only decides what an unset key means.
+var profiles = map[string]func(c *Config){
+	"development": func(c *Config) {},
+
+	// Staging behaves like production apart from HSTS, so a bad
+	// certificate setup does not pin browsers to HTTPS for a year
+	"staging": func(c *Config) {
+		c.DisallowUnknownFields = true
+		c.EventTypeStrictness = "strict"
+		c.PrivacyIPMode = "truncate"
+		c.PrivacyStripQuery = true
+		c.PrivacyRedactFields = []string{"email", "phone", "name", "address", "password", "token"}
+		c.TraceSampleRatio = 0.1
+		c.TraceSampleErrors = true
+	},
+
+	// Production samples a tenth of new traces, failed requests still
+	// being traced in full. CORS is left alone, as no origin is allowed
+	// until listed in any environment, and so is the per-session event
+	// rate, since a fair limit depends on the site rather than the stage.
+	"production": func(c *Config) {
+		c.DisallowUnknownFields = true
+		c.EventTypeStrictness = "strict"
+		c.HSTSMaxAge = Duration(365 * 24 * time.Hour)
+		c.HSTSIncludeSubdomains = true
+		c.PrivacyIPMode = "truncate"
+		c.PrivacyStripQuery = true
+		c.PrivacyRedactFields = []string{"email", "phone", "name", "address", "password", "token"}
+		c.TraceSampleRatio = 0.1
+		c.TraceSampleErrors = true
+	},
+}
+
+// profileNames lists the known environments for error messages
+func profileNames() string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reloadableFields can change without a restart. Changes to any other
+// field are reported and ignored until the process restarts.
+var reloadableFields = map[string]bool{
+	"LogLevel":             true,
+	"CORSAllowedOrigins":   true,
+	"CORSAllowedMethods":   true,
+	"CORSAllowedHeaders":   true,
+	"CORSExposedHeaders":   true,
+	"CORSAllowCredentials": true,
+	"CORSMaxAge":           true,
+	"IPAllowList":          true,
+	"IPDenyList":           true,
+	"APIKeys":              true,
+	"APIKeysFile":          true,
+	"AdminAPIKeys":         true,
+	"AdminAPIKeysFile":     true,
+
+	"MaxCustomFields":       true,
+	"DisallowUnknownFields": true,
+	"SessionTimeout":        true,
+	"ReplayRetention":       true,
+
+	"EventSpanSampleRates":   true,
+	"EventMetricSampleRates": true,
+}
+
+var current atomic.Pointer[Config]
+
+// Current returns the active configuration snapshot. Callers must treat it
+// as read-only; reloads swap in a new snapshot instead of mutating it.
+func Current() *Config {
+	return current.Load()
+}
+
+// SetCurrent makes cfg the active configuration snapshot
+func SetCurrent(cfg *Config) {
+	current.Store(cfg)
+}
+
+// merge returns a copy of c with the reloadable fields taken from next,
+// along with the names of changed fields that need a restart
+func (c *Config) merge(next *Config) (*Config, []string) {
+	merged := *c
+	merged.sources = make(map[string]string, len(c.sources))
+	for key, source := range c.sources {
+		merged.sources[key] = source
+	}
+	var ignored []string
+
+	mv := reflect.ValueOf(&merged).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	t := mv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		if reloadableFields[name] {
+			mv.Field(i).Set(nv.Field(i))
+			if key := c.settingKey(t.Field(i)); key != "" {
+				merged.sources[key] = next.Source(key)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(mv.Field(i).Interface(), nv.Field(i).Interface()) {
+			if key := jsonKey(t.Field(i)); key != "" {
+				name = key
+			}
+			ignored = append(ignored, name)
+		}
+	}
+	return &merged, ignored
+}
+
+// Reloader re-reads the configuration from the sources it was first loaded
+// from and publishes the reloadable part through Current
+type Reloader struct {
+	path  string
+	flags *Flags
+
+	mu        sync.Mutex
+	listeners []func(old, next *Config)
+}
+
+func NewReloader(path string, flags *Flags) *Reloader {
+	return &Reloader{path: path, flags: flags}
+}
+
+// OnReload registers fn to run after each successful reload
+func (r *Reloader) OnReload(fn func(old, next *Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Reload loads and validates the configuration again. On error the active
+// snapshot is left untouched.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	loaded, err := LoadWithFlags(r.path, r.flags)
+	if err != nil {
+		return err
+	}
+
+	old := Current()
+	next, ignored := old.merge(loaded)
+	if err := next.validate(); err != nil {
+		return err
+	}
+	// A fleet is retuned through the remote document, so a change there
+	// that would only apply after a restart is refused rather than left
+	// for each instance to pick up whenever it restarts
+	var remote []string
+	for _, key := range ignored {
+		if loaded.Source(key) == SourceRemote {
+			remote = append(remote, key)
+		}
+	}
+	if len(remote) > 0 {
+		return fmt.Errorf("remote config changes %s, which cannot be reloaded; restart the workers to apply them", strings.Join(remote, ", "))
+	}
+	if len(ignored) > 0 {
+		slog.Warn("Configuration changes need a restart to take effect", "fields", ignored)
+	}
+
+	SetCurrent(next)
+	for _, fn := range r.listeners {
+		fn(old, next)
+	}
+	return nil
+}
+
+// WatchFile polls the config file every interval and reloads when its
+// modification time or size changes. It returns when ctx is done.
+func (r *Reloader) WatchFile(ctx context.Context, interval time.Duration) {
+	if r.path == "" || interval <= 0 {
+		return
+	}
+
+	stat := func() (time.Time, int64) {
+		info, err := os.Stat(r.path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return info.ModTime(), info.Size()
+	}
+
+	modTime, size := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m, s := stat()
+			if m.Equal(modTime) && s == size {
+				continue
+			}
+			modTime, size = m, s
+			if s < 0 {
+				slog.Warn("Config file disappeared, keeping current configuration", "file", r.path)
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "file", r.path, "error", err)
+				continue
+			}
+			slog.Info("Reloaded configuration", "file", r.path, "trigger", "file_change")
+		}
+	}
+}
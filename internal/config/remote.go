@@ -0,0 +1,260 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// A remote config document lives under one key in Consul or etcd and uses
+// the same keys as the config file. It sits between the file and the
+// environment, so each instance can still override it locally:
+//
+//	consul://consul:8500/services/worker/config.yaml
+//	etcd+https://etcd:2379/worker/config.json
+//
+// The key's extension picks the format; without one it is read as YAML,
+// which also accepts JSON. Once running, changes to the reloadable
+// settings apply within the watch interval; a change to any other setting
+// fails the reload, keeping the current configuration.
+const remoteTimeout = 10 * time.Second
+
+// bootstrapKeys decide where configuration comes from, so a remote
+// document cannot set them
+var bootstrapKeys = []string{"environment", "remote_config_url"}
+
+// RemoteSource reads a config document from a key-value store
+type RemoteSource interface {
+	// Fetch returns the document and a version that changes with it
+	Fetch(ctx context.Context) (data []byte, version string, err error)
+}
+
+// NewRemoteSource returns the source for a consul:// or etcd:// URL. A
+// "+https" suffix on the scheme selects TLS. token is sent as the Consul
+// ACL token or the etcd auth token.
+func NewRemoteSource(rawURL, token string) (RemoteSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote config URL: %w", err)
+	}
+	backend, secure := strings.CutSuffix(u.Scheme, "+https")
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+
+	key := strings.Trim(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("invalid remote config URL %q, expected %s://host:port/key", rawURL, u.Scheme)
+	}
+
+	base := scheme + "://" + u.Host
+	client := &http.Client{Timeout: remoteTimeout}
+	switch backend {
+	case "consul":
+		return &consulSource{base: base, key: key, token: token, client: client}, nil
+	case "etcd":
+		return &etcdSource{base: base, key: key, token: token, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unsupported remote config scheme %q, expected consul or etcd", u.Scheme)
+	}
+}
+
+// fetchRemote reads the remote document named by c, if any
+func (c *Config) fetchRemote() (*remoteDocument, error) {
+	if c.RemoteConfigURL == "" {
+		return nil, nil
+	}
+	source, err := NewRemoteSource(c.RemoteConfigURL, c.RemoteConfigToken)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	data, _, err := source.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch remote config: %w", err)
+	}
+
+	format := path.Ext(c.RemoteConfigURL)
+	if format != ".json" && format != ".toml" {
+		format = ".yaml"
+	}
+	return &remoteDocument{data: data, format: format, origin: "remote config " + c.RemoteConfigURL}, nil
+}
+
+type remoteDocument struct {
+	data   []byte
+	format string
+	origin string
+}
+
+func (d *remoteDocument) apply(c *Config) error {
+	raw, err := parseDocument(d.data, d.format)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", d.origin, err)
+	}
+	for _, key := range bootstrapKeys {
+		if _, ok := raw[key]; ok {
+			return fmt.Errorf("%s: %s cannot be set remotely", d.origin, key)
+		}
+	}
+	return c.decode(raw, d.origin)
+}
+
+// consulSource reads a raw value from Consul's KV HTTP API. The version
+// is the key's modify index.
+type consulSource struct {
+	base   string
+	key    string
+	token  string
+	client *http.Client
+}
+
+func (s *consulSource) Fetch(ctx context.Context) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+"/v1/kv/"+s.key+"?raw", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("consul %s: %w", s.key, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", fmt.Errorf("consul %s: key not found", s.key)
+	default:
+		return nil, "", fmt.Errorf("consul %s: server returned %s", s.key, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("consul %s: %w", s.key, err)
+	}
+	return data, resp.Header.Get("X-Consul-Index"), nil
+}
+
+// etcdSource reads a key through etcd's v3 JSON gateway. The version is
+// the key's mod revision.
+type etcdSource struct {
+	base   string
+	key    string
+	token  string
+	client *http.Client
+}
+
+func (s *etcdSource) Fetch(ctx context.Context) ([]byte, string, error) {
+	body, err := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte("/" + s.key))})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.base+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("etcd %s: %w", s.key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("etcd %s: server returned %s", s.key, resp.Status)
+	}
+
+	var result struct {
+		KVs []struct {
+			Value       string `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("etcd %s: decode response: %w", s.key, err)
+	}
+	if len(result.KVs) == 0 {
+		return nil, "", fmt.Errorf("etcd %s: key not found", s.key)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(result.KVs[0].Value)
+	if err != nil {
+		return nil, "", fmt.Errorf("etcd %s: decode value: %w", s.key, err)
+	}
+	return data, result.KVs[0].ModRevision, nil
+}
+
+// WatchRemote polls the remote config document every interval and reloads
+// when its version changes. It returns when ctx is done.
+func (r *Reloader) WatchRemote(ctx context.Context, interval time.Duration) {
+	cfg := Current()
+	if cfg == nil || cfg.RemoteConfigURL == "" || interval <= 0 {
+		return
+	}
+
+	source, err := NewRemoteSource(cfg.RemoteConfigURL, cfg.RemoteConfigToken)
+	if err != nil {
+		slog.Error("Not watching remote config", "url", cfg.RemoteConfigURL, "error", err)
+		return
+	}
+
+	version := func() (string, error) {
+		fetchCtx, cancel := context.WithTimeout(ctx, remoteTimeout)
+		defer cancel()
+		_, v, err := source.Fetch(fetchCtx)
+		return v, err
+	}
+
+	last, err := version()
+	if err != nil {
+		slog.Warn("Failed to read remote config version", "url", cfg.RemoteConfigURL, "error", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v, err := version()
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					slog.Warn("Failed to read remote config version", "url", cfg.RemoteConfigURL, "error", err)
+				}
+				continue
+			}
+			if v == last {
+				continue
+			}
+			last = v
+			if err := r.Reload(); err != nil {
+				slog.Error("Failed to reload configuration", "url", cfg.RemoteConfigURL, "error", err)
+				continue
+			}
+			slog.Info("Reloaded configuration", "url", cfg.RemoteConfigURL, "trigger", "remote_change")
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+)
+
+// validateReport checks the digest settings. The SMTP server and
+// addresses are only needed once a schedule is set, though they are
+// checked whenever given, as on-demand reports use them too.
+func (c *Config) validateReport() error {
+	switch c.ReportSchedule {
+	case "", "daily", "weekly":
+	default:
+		return fmt.Errorf("report_schedule must be daily, weekly or empty, got %q", c.ReportSchedule)
+	}
+	if c.ReportTopElements <= 0 {
+		return fmt.Errorf("report_top_elements must be positive, got %d", c.ReportTopElements)
+	}
+	if c.ReportSchedule != "" {
+		if c.ReportSMTPAddr == "" || c.ReportFrom == "" || len(c.ReportTo) == 0 {
+			return errors.New("report_schedule needs report_smtp_addr, report_from and report_to")
+		}
+	}
+	if c.ReportSMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.ReportSMTPAddr); err != nil {
+			return fmt.Errorf("report_smtp_addr must be host:port, got %q", c.ReportSMTPAddr)
+		}
+	}
+	for _, addr := range append([]string{c.ReportFrom}, c.ReportTo...) {
+		if addr == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("report address %q: %w", addr, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// schemaEnums lists the accepted values of keys validate restricts to a set
+var schemaEnums = map[string]func() []string{
+	"log_level":                func() []string { return []string{"DEBUG", "INFO", "WARN", "ERROR"} },
+	"event_type_strictness":    func() []string { return []string{"warn", "strict"} },
+	"bot_filter":               func() []string { return []string{"off", "tag", "drop"} },
+	"privacy_ip_mode":          func() []string { return []string{"none", "truncate", "hash"} },
+	"consent_policy":           func() []string { return []string{"ignore", "opt_out", "opt_in"} },
+	"tenant_source":            func() []string { return []string{"none", "api_key", "header"} },
+	"logs_exporter":            func() []string { return []string{"otlp", "none"} },
+	"metrics_exporter":         func() []string { return []string{"otlp", "prometheus", "both", "none"} },
+	"statsd_flavor":            func() []string { return []string{"statsd", "dogstatsd"} },
+	"metrics_exemplar_filter":  func() []string { return []string{"trace_based", "always_on", "always_off"} },
+	"otel_protocol":            func() []string { return []string{"grpc", "http/protobuf"} },
+	"otel_compression":         func() []string { return []string{"gzip", "none"} },
+	"sinks":                    func() []string { return SinkNames },
+	"kafka_compression":        func() []string { return kafkaCompressions },
+	"elasticsearch_index_mode": func() []string { return elasticsearchIndexModes },
+	"trace_sampler": func() []string {
+		return []string{"always_on", "always_off", "traceidratio",
+			"parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio"}
+	},
+	"environment": func() []string {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	},
+}
+
+var (
+	durationSchema = map[string]any{
+		"type":        []string{"string", "integer"},
+		"pattern":     `^([0-9]+|([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`,
+		"description": "Duration such as 30s, 5m or 1h30m; a bare integer is seconds",
+	}
+	byteSizeSchema = map[string]any{
+		"type":        []string{"string", "integer"},
+		"pattern":     `(?i)^[0-9]+ *(b|k|m|g|kb|mb|gb|kib|mib|gib)?$`,
+		"minimum":     0,
+		"description": "Size in bytes such as 512, 64KB or 10MB; units are binary",
+	}
+)
+
+// Schema returns a JSON Schema describing config files. Every key is
+// optional and defaults to its development value; unknown keys are
+// rejected, as they are when a file is loaded.
+func Schema() ([]byte, error) {
+	base := defaults()
+	v := reflect.ValueOf(base).Elem()
+	t := v.Type()
+
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		key := jsonKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		prop := typeSchema(t.Field(i).Type)
+		if enum, ok := schemaEnums[key]; ok {
+			// Lists restrict their items
+			if items, ok := prop["items"].(map[string]any); ok {
+				items["enum"] = enum()
+			} else {
+				prop["enum"] = enum()
+			}
+		}
+		if f := v.Field(i); !f.IsZero() && !((f.Kind() == reflect.Map || f.Kind() == reflect.Slice) && f.Len() == 0) {
+			prop["default"] = f.Interface()
+		}
+		properties[key] = prop
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "Worker configuration",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}, "", "  ")
+}
+
+// typeSchema describes a config field type. It returns a fresh map so
+// callers can add keywords.
+func typeSchema(t reflect.Type) map[string]any {
+	copyOf := func(m map[string]any) map[string]any {
+		out := make(map[string]any, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		return out
+	}
+
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return copyOf(durationSchema)
+	case reflect.TypeOf(ByteSize(0)):
+		return copyOf(byteSizeSchema)
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	default:
+		return map[string]any{}
+	}
+}
+
+// suggestKey returns the known key closest to an unknown one, or "" when
+// none is close enough to be a likely typo
+func suggestKey(unknown string, known []string) string {
+	best, bestDistance := "", max(2, len(unknown)/3)+1
+	for _, key := range known {
+		if d := editDistance(unknown, key); d < bestDistance {
+			best, bestDistance = key, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
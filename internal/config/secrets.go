@@ -0,0 +1,254 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// Secrets may name an external store instead of holding the value:
+//
+//	vault:<path>#<field>       a Vault KV secret, v1 or v2 mount
+//	awssm:<secret-id>[#<field>] an AWS Secrets Manager secret, optionally
+//	                            a field of a JSON secret string
+//
+// References are resolved on every load, so a reload picks up rotated values.
+const secretTimeout = 10 * time.Second
+
+// SecretResolver fetches the value a secret reference points to
+type SecretResolver interface {
+	ResolveSecret(ctx context.Context, ref string) (string, error)
+}
+
+// secretStores builds a resolver per reference scheme. Resolvers are only
+// created when a reference with their scheme is present.
+var secretStores = map[string]func(ctx context.Context) (SecretResolver, error){
+	"vault": newVaultResolver,
+	"awssm": newAWSSecretsResolver,
+}
+
+// secret is a config field that is never written to files or logs
+type secret struct {
+	field string
+	env   string
+	value *string
+}
+
+func (c *Config) secrets() []secret {
+	return []secret{
+		{"APIKeys", "API_KEYS", &c.APIKeys},
+		{"AdminAPIKeys", "ADMIN_API_KEYS", &c.AdminAPIKeys},
+		{"JWTSecret", "JWT_HS256_SECRET", &c.JWTSecret},
+		{"SigningSecret", "SIGNING_SECRET", &c.SigningSecret},
+		{"RemoteConfigToken", "REMOTE_CONFIG_TOKEN", &c.RemoteConfigToken},
+		{"OTELHeaders", "OTEL_EXPORTER_OTLP_HEADERS", &c.OTELHeaders},
+		{"InfluxToken", "INFLUX_TOKEN", &c.InfluxToken},
+		{"AlertWebhookURL", "ALERT_WEBHOOK_URL", &c.AlertWebhookURL},
+		{"AlertSlackWebhookURL", "ALERT_SLACK_WEBHOOK_URL", &c.AlertSlackWebhookURL},
+		{"AlertPagerDutyRoutingKey", "ALERT_PAGERDUTY_ROUTING_KEY", &c.AlertPagerDutyRoutingKey},
+		{"ReportSMTPPassword", "REPORT_SMTP_PASSWORD", &c.ReportSMTPPassword},
+		{"PrivacyIPHashKey", "PRIVACY_IP_HASH_KEY", &c.PrivacyIPHashKey},
+		{"WebhookSecret", "WEBHOOK_SECRET", &c.WebhookSecret},
+		{"ElasticsearchPassword", "ELASTICSEARCH_PASSWORD", &c.ElasticsearchPassword},
+		{"ElasticsearchAPIKey", "ELASTICSEARCH_API_KEY", &c.ElasticsearchAPIKey},
+		{"RedisPassword", "REDIS_PASSWORD", &c.RedisPassword},
+		{"AMQPPassword", "AMQP_PASSWORD", &c.AMQPPassword},
+		{"MQTTPassword", "MQTT_PASSWORD", &c.MQTTPassword},
+	}
+}
+
+// settingKey names a field in logs, the admin API and source tracking
+func (c *Config) settingKey(field reflect.StructField) string {
+	if key := jsonKey(field); key != "" {
+		return key
+	}
+	for _, s := range c.secrets() {
+		if s.field == field.Name {
+			return strings.ToLower(s.env)
+		}
+	}
+	return ""
+}
+
+// resolveSecrets replaces secret references with the values they name
+func (c *Config) resolveSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+
+	resolvers := make(map[string]SecretResolver)
+	for _, s := range c.secrets() {
+		scheme, ref, ok := strings.Cut(*s.value, ":")
+		newResolver, known := secretStores[scheme]
+		if !ok || !known {
+			continue
+		}
+
+		resolver, ok := resolvers[scheme]
+		if !ok {
+			var err error
+			if resolver, err = newResolver(ctx); err != nil {
+				return fmt.Errorf("%s: %s secret store: %w", s.env, scheme, err)
+			}
+			resolvers[scheme] = resolver
+		}
+
+		value, err := resolver.ResolveSecret(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+		*s.value = value
+	}
+	return nil
+}
+
+// getEnvSecret reads a secret from key or, when only key_FILE is set, from
+// the file it names, as Docker and Kubernetes secret mounts provide
+func getEnvSecret(key, defaultValue string, errs *[]error) string {
+	value, path := os.Getenv(key), os.Getenv(key+"_FILE")
+	switch {
+	case value != "" && path != "":
+		*errs = append(*errs, fmt.Errorf("%s and %s_FILE are both set", key, key))
+		return defaultValue
+	case value != "":
+		return value
+	case path == "":
+		return defaultValue
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s_FILE: %w", key, err))
+		return defaultValue
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// splitSecretRef splits "path#field" into its parts
+func splitSecretRef(ref string) (string, string) {
+	path, field, _ := strings.Cut(ref, "#")
+	return path, field
+}
+
+// vaultResolver reads KV secrets over Vault's HTTP API using the address
+// and token from VAULT_ADDR and VAULT_TOKEN (or VAULT_TOKEN_FILE)
+type vaultResolver struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+}
+
+func newVaultResolver(context.Context) (SecretResolver, error) {
+	var errs []error
+	r := &vaultResolver{
+		addr:      strings.TrimRight(os.Getenv("VAULT_ADDR"), "/"),
+		token:     getEnvSecret("VAULT_TOKEN", "", &errs),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    &http.Client{Timeout: secretTimeout},
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if r.addr == "" || r.token == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	return r, nil
+}
+
+func (r *vaultResolver) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	path, field := splitSecretRef(ref)
+	if path == "" || field == "" {
+		return "", fmt.Errorf("invalid vault reference %q, expected vault:<path>#<field>", ref)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.token)
+	if r.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.namespace)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault %s: server returned %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("vault %s: decode response: %w", path, err)
+	}
+
+	// KV v2 nests the secret under data.data next to its metadata
+	data := body.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault %s: no string field %q", path, field)
+	}
+	return value, nil
+}
+
+// awsSecretsResolver reads Secrets Manager secrets with the default AWS
+// credential chain: environment, shared config, or the instance/task role
+type awsSecretsResolver struct {
+	client *secretsmanager.Client
+}
+
+func newAWSSecretsResolver(ctx context.Context) (SecretResolver, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &awsSecretsResolver{client: secretsmanager.NewFromConfig(cfg)}, nil
+}
+
+func (r *awsSecretsResolver) ResolveSecret(ctx context.Context, ref string) (string, error) {
+	id, field := splitSecretRef(ref)
+	if id == "" {
+		return "", fmt.Errorf("invalid awssm reference %q, expected awssm:<secret-id>[#<field>]", ref)
+	}
+
+	out, err := r.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &id})
+	if err != nil {
+		return "", fmt.Errorf("secrets manager %s: %w", id, err)
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secrets manager %s: secret has no string value", id)
+	}
+	if field == "" {
+		return *out.SecretString, nil
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secrets manager %s: secret is not a JSON object: %w", id, err)
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secrets manager %s: no string field %q", id, field)
+	}
+	return value, nil
+}
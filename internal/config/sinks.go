@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// SinkNames are the accepted Sinks entries
+var SinkNames = []string{"kafka", "nats", "webhook", "kinesis", "firehose", "elasticsearch", "redis", "amqp", "bigquery"}
+
+var kafkaCompressions = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+
+var elasticsearchIndexModes = []string{"daily", "data_stream"}
+
+// validateSinks checks the sink selection, and the settings of each
+// selected sink
+func (c *Config) validateSinks() error {
+	for i, name := range c.Sinks {
+		if !slices.Contains(SinkNames, name) {
+			return fmt.Errorf("unknown sink %q, must be one of %v", name, SinkNames)
+		}
+		if slices.Contains(c.Sinks[:i], name) {
+			return fmt.Errorf("sink %q is listed twice", name)
+		}
+	}
+	if len(c.Sinks) == 0 {
+		return nil
+	}
+
+	if c.SinkQueueSize < 1 {
+		return fmt.Errorf("sink_queue_size must be positive, got %d", c.SinkQueueSize)
+	}
+	if c.SinkBatchSize < 1 || c.SinkBatchSize > c.SinkQueueSize {
+		return fmt.Errorf("sink_batch_size must be from 1 to sink_queue_size %d, got %d", c.SinkQueueSize, c.SinkBatchSize)
+	}
+	if c.SinkFlushInterval <= 0 {
+		return fmt.Errorf("sink_flush_interval must be positive, got %s", c.SinkFlushInterval)
+	}
+	if c.SinkWriteTimeout <= 0 {
+		return fmt.Errorf("sink_write_timeout must be positive, got %s", c.SinkWriteTimeout)
+	}
+
+	if slices.Contains(c.Sinks, "kafka") {
+		if len(c.KafkaBrokers) == 0 {
+			return fmt.Errorf("kafka_brokers must be set for the kafka sink")
+		}
+		if c.KafkaTopic == "" {
+			return fmt.Errorf("kafka_topic must be set for the kafka sink")
+		}
+		if !slices.Contains(kafkaCompressions, c.KafkaCompression) {
+			return fmt.Errorf("kafka_compression must be one of %v, got %q", kafkaCompressions, c.KafkaCompression)
+		}
+	}
+	if slices.Contains(c.Sinks, "nats") {
+		if c.NATSURL == "" {
+			return fmt.Errorf("nats_url must be set for the nats sink")
+		}
+		if c.NATSSubject == "" {
+			return fmt.Errorf("nats_subject must be set for the nats sink")
+		}
+	}
+	if slices.Contains(c.Sinks, "webhook") {
+		if len(c.WebhookURLs) == 0 {
+			return fmt.Errorf("webhook_urls must be set for the webhook sink")
+		}
+		for _, raw := range c.WebhookURLs {
+			if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("webhook_urls must be http or https URLs, got %q", raw)
+			}
+		}
+		if c.WebhookMaxAttempts < 1 {
+			return fmt.Errorf("webhook_max_attempts must be positive, got %d", c.WebhookMaxAttempts)
+		}
+		if c.WebhookBreakerThreshold < 1 {
+			return fmt.Errorf("webhook_breaker_threshold must be positive, got %d", c.WebhookBreakerThreshold)
+		}
+		if c.WebhookBreakerCooldown <= 0 {
+			return fmt.Errorf("webhook_breaker_cooldown must be positive, got %s", c.WebhookBreakerCooldown)
+		}
+	}
+	if slices.Contains(c.Sinks, "kinesis") && c.KinesisStream == "" {
+		return fmt.Errorf("kinesis_stream must be set for the kinesis sink")
+	}
+	if slices.Contains(c.Sinks, "firehose") && c.FirehoseDeliveryStream == "" {
+		return fmt.Errorf("firehose_delivery_stream must be set for the firehose sink")
+	}
+	if slices.Contains(c.Sinks, "elasticsearch") {
+		if len(c.ElasticsearchURLs) == 0 {
+			return fmt.Errorf("elasticsearch_urls must be set for the elasticsearch sink")
+		}
+		for _, raw := range c.ElasticsearchURLs {
+			if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("elasticsearch_urls must be http or https URLs, got %q", raw)
+			}
+		}
+		// Index and data stream names must be lowercase, and are not paths
+		if c.ElasticsearchIndex == "" || c.ElasticsearchIndex != strings.ToLower(c.ElasticsearchIndex) ||
+			strings.ContainsAny(c.ElasticsearchIndex, `/\*?"<>| ,#:`) {
+			return fmt.Errorf("elasticsearch_index must be a lowercase index name, got %q", c.ElasticsearchIndex)
+		}
+		if !slices.Contains(elasticsearchIndexModes, c.ElasticsearchIndexMode) {
+			return fmt.Errorf("elasticsearch_index_mode must be one of %v, got %q", elasticsearchIndexModes, c.ElasticsearchIndexMode)
+		}
+	}
+	if slices.Contains(c.Sinks, "redis") {
+		if u, err := url.Parse(c.RedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("redis_url must be a redis or rediss URL, got %q", c.RedisURL)
+		}
+		if c.RedisChannel == "" {
+			return fmt.Errorf("redis_channel must be set for the redis sink")
+		}
+	}
+	if slices.Contains(c.Sinks, "amqp") {
+		if u, err := url.Parse(c.AMQPURL); err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "" {
+			return fmt.Errorf("amqp_url must be an amqp or amqps URL, got %q", c.AMQPURL)
+		}
+		if c.AMQPRoutingKey == "" {
+			return fmt.Errorf("amqp_routing_key must be set for the amqp sink")
+		}
+	}
+	if slices.Contains(c.Sinks, "bigquery") {
+		if c.BigQueryProject == "" {
+			return fmt.Errorf("bigquery_project must be set for the bigquery sink")
+		}
+		if c.BigQueryDataset == "" {
+			return fmt.Errorf("bigquery_dataset must be set for the bigquery sink")
+		}
+		if c.BigQueryTable == "" {
+			return fmt.Errorf("bigquery_table must be set for the bigquery sink")
+		}
+	}
+	return nil
+}
+
+// validateStream checks the gRPC event feed settings, when it is enabled
+func (c *Config) validateStream() error {
+	if c.GRPCPort == 0 {
+		return nil
+	}
+	if c.GRPCPort < 0 || c.GRPCPort > 65535 {
+		return fmt.Errorf("grpc_port must be between 0 and 65535, got %d", c.GRPCPort)
+	}
+	if c.GRPCPort == c.Port || c.GRPCPort == c.MetricsPort {
+		return fmt.Errorf("grpc_port must differ from port %d and metrics_port %d", c.Port, c.MetricsPort)
+	}
+	// The feed carries every tenant's events with client addresses
+	if !c.JWTEnabled() && c.AdminAPIKeys == "" && c.AdminAPIKeysFile == "" {
+		return fmt.Errorf("grpc_port needs admin_api_keys, admin_api_keys_file or JWT to authenticate subscribers")
+	}
+	if c.StreamBufferSize < 1 {
+		return fmt.Errorf("stream_buffer_size must be positive, got %d", c.StreamBufferSize)
+	}
+	if c.StreamMaxSubscribers < 1 {
+		return fmt.Errorf("stream_max_subscribers must be positive, got %d", c.StreamMaxSubscribers)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// validateACME checks the ACME settings, when certificates are obtained
+// over ACME
+func (c *Config) validateACME() error {
+	if !c.ACMEEnabled() {
+		return nil
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		return fmt.Errorf("acme_hosts replaces tls_cert_file and tls_key_file, which must not be set with it")
+	}
+	if slices.Contains(c.ACMEHosts, "") {
+		return fmt.Errorf("acme_hosts must not hold an empty host name")
+	}
+	if c.ACMECacheDir == "" {
+		return fmt.Errorf("acme_cache_dir must be set, as certificates cannot be obtained again on every start")
+	}
+	if c.ACMEDirectoryURL != "" {
+		if u, err := url.Parse(c.ACMEDirectoryURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("acme_directory_url must be an https URL, got %q", c.ACMEDirectoryURL)
+		}
+	}
+	if c.ACMEHTTPPort < 1 || c.ACMEHTTPPort > 65535 {
+		return fmt.Errorf("acme_http_port must be between 1 and 65535, got %d", c.ACMEHTTPPort)
+	}
+	if c.ACMEHTTPPort == c.Port || c.ACMEHTTPPort == c.MetricsPort || c.ACMEHTTPPort == c.GRPCPort {
+		return fmt.Errorf("acme_http_port %d must differ from port, metrics_port and grpc_port", c.ACMEHTTPPort)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as "30s", "5m" or "1h30m" in config
+// files, flags and environment variables. A bare integer is read as seconds.
+type Duration time.Duration
+
+// ParseDuration parses a Go duration string or a whole number of seconds
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Duration(time.Duration(n) * time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, expected a value like 30s, 5m or 1h", s)
+	}
+	return Duration(d), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*d = Duration(time.Duration(n) * time.Second)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string like \"30s\"", b)
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	parsed, err := ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// ByteSize is a number of bytes written as "512", "64KB" or "10MB" in
+// config files, flags and environment variables. Units are binary, so
+// 1KB is 1024 bytes; the KiB spelling is accepted as well.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses a whole number of bytes with an optional unit
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	upper := strings.ToUpper(s)
+
+	factor := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/factor {
+		return 0, fmt.Errorf("invalid size %q, expected a value like 512, 64KB or 10MB", s)
+	}
+	return ByteSize(n * factor), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		factor int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if b != 0 && int64(b)%u.factor == 0 {
+			return strconv.FormatInt(int64(b)/u.factor, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		if n < 0 {
+			return fmt.Errorf("invalid size %d, cannot be negative", n)
+		}
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid size %s, expected a string like \"10MB\"", data)
+	}
+	return b.UnmarshalText([]byte(s))
+}
+
+func (b *ByteSize) UnmarshalText(data []byte) error {
+	parsed, err := ParseByteSize(string(data))
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package geoip resolves client addresses to a location using a local
+// MaxMind DB file, such as GeoLite2-City or GeoIP2-City.
+package geoip
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is where an address is registered. Country and Region are ISO
+// 3166 codes; any part the database does not know is empty.
+type Location struct {
+	Country string
+	Region  string
+	City    string
+}
+
+// cityRecord is the part of a City database record Lookup reads
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+// DB is a database file that can be reloaded in place, so updates written
+// by geoipupdate apply without a restart
+type DB struct {
+	path string
+
+	// Held for reading during lookups, since the reader is memory mapped
+	// and must not be closed under one
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// Open loads the database at path
+func Open(path string) (*DB, error) {
+	db := &DB{path: path}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Lookup returns the location of ip, or the zero Location when the
+// database has no record of it
+func (db *DB) Lookup(ip netip.Addr) Location {
+	if !ip.IsValid() {
+		return Location{}
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var record cityRecord
+	if err := db.reader.Lookup(net.IP(ip.Unmap().AsSlice()), &record); err != nil {
+		return Location{}
+	}
+	loc := Location{
+		Country: record.Country.ISOCode,
+		City:    record.City.Names["en"],
+	}
+	if len(record.Subdivisions) > 0 {
+		loc.Region = record.Subdivisions[0].ISOCode
+	}
+	return loc
+}
+
+// Reload reopens the database file. On error the loaded database stays in
+// use.
+func (db *DB) Reload() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	old := db.reader
+	db.reader, db.modTime, db.size = reader, info.ModTime(), info.Size()
+	db.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Watch polls the database file every interval and reloads it when its
+// modification time or size changes. It returns when ctx is done.
+func (db *DB) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(db.path)
+			if err != nil {
+				slog.Warn("GeoIP database unavailable, keeping the loaded one", "file", db.path, "error", err)
+				continue
+			}
+			db.mu.RLock()
+			changed := !info.ModTime().Equal(db.modTime) || info.Size() != db.size
+			db.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := db.Reload(); err != nil {
+				slog.Error("Failed to reload GeoIP database", "file", db.path, "error", err)
+				continue
+			}
+			slog.Info("Reloaded GeoIP database", "file", db.path)
+		}
+	}
+}
+
+// Close releases the database
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.reader.Close()
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/audit"
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// EventTypes lists (GET) or registers (POST) custom event types
+func (h *Handler) EventTypes(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "event_types_handler")
+	defer span.End()
+
+	registry := h.service.EventTypes()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, registry.List())
+	case http.MethodPost:
+		var def service.EventTypeDefinition
+		if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid JSON")
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid JSON", Instance: r.URL.Path})
+			return
+		}
+		before, existed := registry.Lookup(def.Name)
+		if err := registry.Register(def); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid event type definition")
+			writeError(w, r, err)
+			return
+		}
+
+		if existed {
+			audit.SetChange(r.Context(), "event_type.update", before, def)
+		} else {
+			audit.SetChange(r.Context(), "event_type.register", nil, def)
+		}
+
+		span.SetAttributes(attribute.String("event_type.name", def.Name))
+		slog.InfoContext(r.Context(), "Registered custom event type", "name", def.Name)
+		writeJSON(w, http.StatusCreated, def)
+	default:
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	span.SetStatus(codes.Ok, "event types handled")
+}
+
+// EventType returns (GET) or removes (DELETE) a single custom event type
+func (h *Handler) EventType(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "event_type_handler")
+	defer span.End()
+
+	name := r.PathValue("name")
+	span.SetAttributes(attribute.String("event_type.name", name))
+	registry := h.service.EventTypes()
+
+	switch r.Method {
+	case http.MethodGet:
+		def, ok := registry.Lookup(name)
+		if !ok {
+			span.SetStatus(codes.Error, "event type not found")
+			writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "Unknown event type", Instance: r.URL.Path})
+			return
+		}
+		writeJSON(w, http.StatusOK, def)
+	case http.MethodDelete:
+		before, _ := registry.Lookup(name)
+		if !registry.Unregister(name) {
+			span.SetStatus(codes.Error, "event type not found")
+			writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "Unknown event type", Instance: r.URL.Path})
+			return
+		}
+		audit.SetChange(r.Context(), "event_type.unregister", before, nil)
+		slog.InfoContext(r.Context(), "Unregistered custom event type", "name", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	span.SetStatus(codes.Ok, "event type handled")
+}
+
+// IPRules is the JSON shape of the runtime IP allow and deny lists
+type IPRules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// IPRules returns (GET) or replaces (PUT) the IP allow and deny lists
+func (h *Handler) IPRules(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "ip_rules_handler")
+	defer span.End()
+
+	if h.ipFilter == nil {
+		span.SetStatus(codes.Error, "ip filter not configured")
+		writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "IP filtering is not enabled", Instance: r.URL.Path})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		allow, deny := h.ipFilter.Rules()
+		writeJSON(w, http.StatusOK, IPRules{Allow: prefixStrings(allow), Deny: prefixStrings(deny)})
+	case http.MethodPut:
+		var rules IPRules
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid JSON")
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid JSON", Instance: r.URL.Path})
+			return
+		}
+		allow, err := middleware.ParsePrefixes(rules.Allow)
+		if err != nil {
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: err.Error(), Instance: r.URL.Path})
+			return
+		}
+		deny, err := middleware.ParsePrefixes(rules.Deny)
+		if err != nil {
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: err.Error(), Instance: r.URL.Path})
+			return
+		}
+
+		prevAllow, prevDeny := h.ipFilter.Rules()
+		h.ipFilter.Set(allow, deny)
+		audit.SetChange(r.Context(), "ip_rules.update",
+			IPRules{Allow: prefixStrings(prevAllow), Deny: prefixStrings(prevDeny)},
+			IPRules{Allow: prefixStrings(allow), Deny: prefixStrings(deny)},
+		)
+		span.SetAttributes(
+			attribute.Int("ip_rules.allow", len(allow)),
+			attribute.Int("ip_rules.deny", len(deny)),
+		)
+		slog.InfoContext(r.Context(), "Updated IP rules", "allow", len(allow), "deny", len(deny))
+		writeJSON(w, http.StatusOK, IPRules{Allow: prefixStrings(allow), Deny: prefixStrings(deny)})
+	default:
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	span.SetStatus(codes.Ok, "ip rules handled")
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	result := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		result = append(result, p.String())
+	}
+	return result
+}
+
+// Maintenance returns (GET) or toggles (PUT) maintenance mode
+func (h *Handler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "maintenance_handler")
+	defer span.End()
+
+	if h.maintenance == nil {
+		span.SetStatus(codes.Error, "maintenance mode not configured")
+		writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "Maintenance mode is not available", Instance: r.URL.Path})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.maintenance.Status())
+	case http.MethodPut:
+		var req struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid JSON")
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid JSON", Instance: r.URL.Path})
+			return
+		}
+
+		before := h.maintenance.Status()
+		h.maintenance.Set(req.Enabled, req.Message)
+		audit.SetChange(r.Context(), "maintenance.update", before, h.maintenance.Status())
+		span.SetAttributes(attribute.Bool("maintenance.enabled", req.Enabled))
+		slog.WarnContext(r.Context(), "Maintenance mode changed", "enabled", req.Enabled)
+		writeJSON(w, http.StatusOK, h.maintenance.Status())
+	default:
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	span.SetStatus(codes.Ok, "maintenance handled")
+}
+
+// LogLevelStatus is the log level as reported by the admin API
+type LogLevelStatus struct {
+	Level     string     `json:"level"`
+	RevertsTo string     `json:"reverts_to,omitempty"`
+	RevertsAt *time.Time `json:"reverts_at,omitempty"`
+}
+
+// LogLevel returns (GET) or changes (PUT) the log level. A PUT may give a
+// duration after which the previous level is restored, so DEBUG logging
+// switched on during an incident does not stay on.
+func (h *Handler) LogLevel(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "log_level_handler")
+	defer span.End()
+
+	if h.logLevel == nil {
+		span.SetStatus(codes.Error, "log level not configured")
+		writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "Log level control is not available", Instance: r.URL.Path})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.logLevelMu.Lock()
+		status := h.logLevelStatus()
+		h.logLevelMu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+	case http.MethodPut:
+		var req struct {
+			Level    string `json:"level"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "invalid JSON")
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid JSON", Instance: r.URL.Path})
+			return
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+			writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "level must be DEBUG, INFO, WARN or ERROR", Instance: r.URL.Path})
+			return
+		}
+		var revertAfter time.Duration
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "duration must be a positive duration such as 15m", Instance: r.URL.Path})
+				return
+			}
+			revertAfter = d
+		}
+
+		h.logLevelMu.Lock()
+		before := h.logLevelStatus()
+		h.setLogLevel(level, revertAfter)
+		after := h.logLevelStatus()
+		h.logLevelMu.Unlock()
+
+		audit.SetChange(r.Context(), "log_level.update", before, after)
+		span.SetAttributes(attribute.String("log.level", level.String()))
+		slog.WarnContext(r.Context(), "Log level changed", "level", level.String(), "from", before.Level, "duration", revertAfter.String())
+		writeJSON(w, http.StatusOK, after)
+	default:
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	span.SetStatus(codes.Ok, "log level handled")
+}
+
+// setLogLevel switches the level and schedules a revert when revertAfter
+// is positive, replacing any pending revert. The caller holds logLevelMu.
+func (h *Handler) setLogLevel(level slog.Level, revertAfter time.Duration) {
+	previous := h.logLevel.Level()
+	if h.logLevelRevert != nil {
+		// Chained temporary changes restore the level from before the first
+		h.logLevelRevert.Stop()
+		h.logLevelRevert = nil
+		previous = h.logLevelPrevious
+	}
+	h.logLevel.Set(level)
+	if revertAfter <= 0 {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(revertAfter, func() {
+		h.logLevelMu.Lock()
+		defer h.logLevelMu.Unlock()
+		if h.logLevelRevert != timer {
+			return
+		}
+		h.logLevel.Set(previous)
+		h.logLevelRevert = nil
+		slog.Warn("Log level reverted", "level", previous.String())
+	})
+	h.logLevelRevert = timer
+	h.logLevelPrevious = previous
+	h.logLevelRevertAt = time.Now().Add(revertAfter)
+}
+
+// logLevelStatus reports the current level. The caller holds logLevelMu.
+func (h *Handler) logLevelStatus() LogLevelStatus {
+	status := LogLevelStatus{Level: h.logLevel.Level().String()}
+	if h.logLevelRevert != nil {
+		at := h.logLevelRevertAt
+		status.RevertsTo = h.logLevelPrevious.String()
+		status.RevertsAt = &at
+	}
+	return status
+}
+
+// CycleLogLevel steps the log level through DEBUG, INFO, WARN and ERROR
+// and back to DEBUG, cancelling any pending revert. It returns the new
+// level.
+func (h *Handler) CycleLogLevel() slog.Level {
+	h.logLevelMu.Lock()
+	defer h.logLevelMu.Unlock()
+
+	next := slog.LevelDebug
+	switch current := h.logLevel.Level(); {
+	case current < slog.LevelInfo:
+		next = slog.LevelInfo
+	case current < slog.LevelWarn:
+		next = slog.LevelWarn
+	case current < slog.LevelError:
+		next = slog.LevelError
+	}
+	h.setLogLevel(next, 0)
+	return next
+}
+
+// Config returns the effective configuration with the source of every
+// value and secrets redacted. Runtime changes made through other admin
+// endpoints, such as IP rules, are not reflected.
+func (h *Handler) Config(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "config_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	var cfg *config.Config
+	if h.config != nil {
+		cfg = h.config()
+	}
+	if cfg == nil {
+		span.SetStatus(codes.Error, "config not available")
+		writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "Configuration is not available", Instance: r.URL.Path})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cfg.Dump())
+	span.SetStatus(codes.Ok, "config dumped")
+}
+
+// Sessions lists the live sessions with their active and idle time, of
+// every tenant or of the one given as ?tenant=
+func (h *Handler) Sessions(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(adminTenant(r), "sessions_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	sessions := h.service.GetSessions(ctx)
+	span.SetAttributes(attribute.Int("sessions.count", len(sessions)))
+	writeJSON(w, http.StatusOK, sessions)
+	span.SetStatus(codes.Ok, "sessions listed")
+}
+
+// AdminHealth is the health check with each objective's ratio and burn
+// rate per window, which the public one leaves out
+func (h *Handler) AdminHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "admin_health_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	response := h.health(ctx)
+	response.SLOs = h.service.GetSLOs()
+	writeJSON(w, http.StatusOK, response)
+	span.SetStatus(codes.Ok, "health check completed")
+}
+
+// adminTenant returns the request context naming the tenant given as
+// ?tenant=. Admin clients act on any tenant, rather than the one their
+// credentials would resolve to.
+func adminTenant(r *http.Request) context.Context {
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		return service.ContextWithTenant(r.Context(), tenant)
+	}
+	return r.Context()
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/telemetry"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+var dashboardPage = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta http-equiv="refresh" content="10">
+    <title>Worker dashboard</title>
+</head>
+<body>
+    <h1>Worker dashboard</h1>
+    <p>Rendered {{.Now.Format "2006-01-02 15:04:05 MST"}}, refreshes every 10 seconds</p>
+    {{if .Tenants}}
+    <p>Tenant {{.Tenant}}; show {{range .Tenants}}<a href="?tenant={{.}}">{{.}}</a> {{end}}</p>
+    {{end}}
+
+    <h2>Process</h2>
+    <table>
+        <tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+        <tr><th>Goroutines</th><td>{{.Goroutines}}</td></tr>
+        <tr><th>Heap in use</th><td>{{.Heap}}</td></tr>
+        <tr><th>Log level</th><td>{{with .LogLevel}}{{.}}{{else}}n/a{{end}}</td></tr>
+        <tr><th>Maintenance</th><td>{{if .Maintenance.Enabled}}on since {{.Maintenance.Since.Format "15:04:05"}}: {{.Maintenance.Message}}{{else}}off{{end}}</td></tr>
+        <tr><th>Queue depth</th><td>n/a, events are processed inline</td></tr>
+    </table>
+
+    <h2>Sessions</h2>
+    <table>
+        <tr><th>Active</th><td>{{.Stats.ActiveSessions}}</td></tr>
+        <tr><th>Total</th><td>{{.Stats.TotalSessions}}</td></tr>
+        <tr><th>Unique sessions</th><td>~{{.Stats.SessionsHour}} this hour, ~{{.Stats.SessionsDay}} today</td></tr>
+        <tr><th>Devices</th><td>{{range $device, $n := .Stats.Devices}}{{$device}} {{$n}} {{else}}none{{end}}</td></tr>
+        <tr><th>Unique visitors</th><td>~{{.Stats.VisitorsHour}} this hour, ~{{.Stats.VisitorsDay}} today</td></tr>
+        <tr><th>Clicks</th><td>{{.Stats.TotalClicks}}</td></tr>
+        <tr><th>Page views</th><td>{{.Stats.PageViews}}</td></tr>
+    </table>
+
+    <h2>Rates per minute</h2>
+    <table>
+        <tr><th>Window</th><th>Clicks</th><th>Events</th><th>Sessions started</th></tr>
+        {{range .Rates}}
+        <tr><td>{{.Window}}</td><td>{{printf "%.2f" .ClicksPerMinute}}</td><td>{{printf "%.2f" .EventsPerMinute}}</td><td>{{printf "%.2f" .SessionsPerMinute}}</td></tr>
+        {{end}}
+    </table>
+
+    {{if .Anomalies}}
+    <h2>Anomalies</h2>
+    <table>
+        <tr><th>Minute</th><th>Signal</th><th>Value</th><th>Expected</th><th>z-score</th></tr>
+        {{range .Anomalies}}
+        <tr><td>{{.Start.Format "15:04"}}</td><td>{{.Signal}}</td><td>{{printf "%.2f" .Value}}</td><td>{{printf "%.2f" .Expected}}</td><td>{{printf "%.1f" .ZScore}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+
+    <h2>Requests</h2>
+    <table>
+        <tr><th>2xx</th><td>{{.Requests.Success}}</td></tr>
+        <tr><th>3xx</th><td>{{.Requests.Redirect}}</td></tr>
+        <tr><th>4xx</th><td>{{.Requests.ClientErrors}}</td></tr>
+        <tr><th>5xx</th><td>{{.Requests.ServerErrors}}</td></tr>
+        <tr><th>Denied (401, 403, 429)</th><td>{{.Requests.Denied}}</td></tr>
+        <tr><th>IP rules</th><td>{{.AllowRules}} allow, {{.DenyRules}} deny</td></tr>
+    </table>
+
+    {{if .SLOs}}
+    <h2>Objectives</h2>
+    <table>
+        <tr><th>SLO</th><th>Target</th><th>Window</th><th>Requests</th><th>Ratio</th><th>Burn rate</th></tr>
+        {{range .SLOs}}{{$slo := .}}{{range .Windows}}
+        <tr><td>{{$slo.Name}}</td><td>{{$slo.Target}}</td><td>{{.Window}}</td><td>{{.Requests}}</td><td>{{printf "%.4f" .Ratio}}</td><td>{{printf "%.2f" .BurnRate}}</td></tr>
+        {{end}}{{end}}
+    </table>
+    {{end}}
+
+    <h2>Recent errors</h2>
+    {{if .Errors}}
+    <table>
+        <tr><th>Time</th><th>Message</th><th>Attributes</th><th>Trace</th></tr>
+        {{range .Errors}}
+        <tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Message}}</td><td>{{.Attrs}}</td><td>{{.TraceID}}</td></tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p>None{{if not .ErrorsTracked}} recorded; error capture is not configured{{end}}</p>
+    {{end}}
+</body>
+</html>`))
+
+// dashboardRateWindows are the windows the dashboard shows rates over
+var dashboardRateWindows = []time.Duration{time.Minute, 5 * time.Minute, service.RateHistory}
+
+type dashboardData struct {
+	Now           time.Time
+	Tenant        string
+	Tenants       []string
+	Uptime        string
+	Goroutines    int
+	Heap          config.ByteSize
+	LogLevel      string
+	Maintenance   middleware.MaintenanceStatus
+	Stats         service.Stats
+	Rates         []service.Rates
+	Anomalies     []service.Anomaly
+	Requests      service.RequestCounts
+	SLOs          []service.SLOStatus
+	AllowRules    int
+	DenyRules     int
+	Errors        []telemetry.ErrorEntry
+	ErrorsTracked bool
+}
+
+// WithRecentErrors shows the errors kept by e on the dashboard
+func WithRecentErrors(e *telemetry.RecentErrors) Option {
+	return func(h *Handler) {
+		h.recentErrors = e
+	}
+}
+
+// Dashboard renders a self-monitoring page from the service state, for a
+// quick look at health without a metrics backend
+func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(adminTenant(r), "dashboard_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	uptime := h.service.Uptime()
+
+	data := dashboardData{
+		Now:        time.Now(),
+		Uptime:     uptime.Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Heap:       config.ByteSize(mem.HeapInuse),
+		Stats:      h.service.GetStats(ctx),
+		Requests:   h.service.GetRequestCounts(),
+		Anomalies:  h.service.GetAnomalies(),
+		SLOs:       h.service.GetSLOs(),
+		Tenants:    h.service.Tenants(),
+	}
+	if data.Tenants != nil {
+		data.Tenant = service.TenantFromContext(ctx)
+		if data.Tenant == "" {
+			data.Tenant = service.DefaultTenant
+		}
+	}
+	for _, window := range dashboardRateWindows {
+		data.Rates = append(data.Rates, h.service.GetRates(ctx, window))
+	}
+	if h.logLevel != nil {
+		data.LogLevel = h.logLevel.Level().String()
+	}
+	if h.maintenance != nil {
+		data.Maintenance = h.maintenance.Status()
+	}
+	if h.ipFilter != nil {
+		allow, deny := h.ipFilter.Rules()
+		data.AllowRules, data.DenyRules = len(allow), len(deny)
+	}
+	if h.recentErrors != nil {
+		data.Errors = h.recentErrors.Entries()
+		data.ErrorsTracked = true
+	}
+
+	// Render first so a template error still gets a proper status
+	var buf bytes.Buffer
+	if err := dashboardPage.Execute(&buf, data); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "template execution failed")
+		slog.ErrorContext(ctx, "Failed to render dashboard", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = buf.WriteTo(w)
+
+	span.SetStatus(codes.Ok, "dashboard rendered")
+}
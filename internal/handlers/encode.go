@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+)
+
+// protoMarshaler is implemented by responses that have a protobuf encoding
+type protoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+// writeNegotiated encodes v in the format chosen by middleware.Negotiate,
+// defaulting to JSON on routes that do not negotiate
+func writeNegotiated(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	switch middleware.NegotiatedFormat(r.Context()) {
+	case middleware.FormatNDJSON:
+		writeNDJSON(w, status, v)
+	case middleware.FormatProtobuf:
+		m, ok := v.(protoMarshaler)
+		if !ok {
+			writeProblem(w, r, Problem{
+				Status: http.StatusNotAcceptable,
+				Detail: "This resource has no protobuf representation",
+			})
+			return
+		}
+		b, err := m.MarshalProto()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
+			writeProblem(w, r, Problem{Status: http.StatusInternalServerError})
+			return
+		}
+		w.Header().Set("Content-Type", middleware.FormatProtobuf)
+		w.WriteHeader(status)
+		_, _ = w.Write(b)
+	default:
+		writeJSON(w, status, v)
+	}
+}
+
+// writeNDJSON writes one JSON document per line: one per element for
+// slices, or a single line otherwise
+func writeNDJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", middleware.FormatNDJSON)
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		if err := enc.Encode(v); err != nil {
+			slog.Error("Failed to encode response", "error", err)
+		}
+		return
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := enc.Encode(rv.Index(i).Interface()); err != nil {
+			slog.Error("Failed to encode response", "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// AssignmentsResponse lists a session's variant of each experiment
+type AssignmentsResponse struct {
+	SessionID   string            `json:"session_id"`
+	Assignments map[string]string `json:"assignments"`
+}
+
+// ExperimentAssignments returns the variants of the session given as
+// ?session_id=, counting them as exposures. The same session always gets
+// the same variants.
+func (h *Handler) ExperimentAssignments(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "experiment_assignments_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if sessionID == "" {
+		span.SetStatus(codes.Error, "missing session id")
+		writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "session_id is required", Instance: r.URL.Path})
+		return
+	}
+	if len(sessionID) > service.MaxSessionIDLen {
+		span.SetStatus(codes.Error, "session id too long")
+		writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "session_id is too long", Instance: r.URL.Path})
+		return
+	}
+
+	assignments, err := h.service.ExposeAssignments(ctx, sessionID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "assignment failed")
+		writeError(w, r, err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("experiments.count", len(assignments)))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, AssignmentsResponse{SessionID: sessionID, Assignments: assignments})
+	span.SetStatus(codes.Ok, "assignments returned")
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Result sizes of the flow API, set with ?limit=
+const (
+	defaultFlowLimit = 10
+	maxFlowLimit     = 100
+)
+
+// FlowNext returns what sessions of the request's tenant clicked right
+// after the element given as ?element=, most frequent first
+func (h *Handler) FlowNext(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "flow_next_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	element := r.URL.Query().Get("element")
+	if element == "" || len(element) > service.MaxElementIDLen {
+		span.SetStatus(codes.Error, "invalid element")
+		writeError(w, r, fmt.Errorf("%w: element must be an element ID of at most %d characters", service.ErrInvalidRequest, service.MaxElementIDLen))
+		return
+	}
+	limit, err := flowLimit(r)
+	if err != nil {
+		span.SetStatus(codes.Error, "invalid limit")
+		writeError(w, r, err)
+		return
+	}
+
+	next := h.service.NextElements(ctx, element, limit)
+	span.SetAttributes(attribute.Int64("flow.total", next.Total))
+	writeJSON(w, http.StatusOK, next)
+	span.SetStatus(codes.Ok, "flow returned")
+}
+
+// FlowPaths returns the most frequent sequences of
+// service.FlowPathLength clicks made by sessions of the request's tenant
+func (h *Handler) FlowPaths(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "flow_paths_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	limit, err := flowLimit(r)
+	if err != nil {
+		span.SetStatus(codes.Error, "invalid limit")
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.service.CommonPaths(ctx, limit))
+	span.SetStatus(codes.Ok, "paths returned")
+}
+
+// flowLimit parses ?limit=, defaulting to defaultFlowLimit
+func flowLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultFlowLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxFlowLimit {
+		return 0, fmt.Errorf("%w: limit must be a number from 1 to %d", service.ErrInvalidRequest, maxFlowLimit)
+	}
+	return limit, nil
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// maxGA4Events is the Measurement Protocol's limit of events per request
+const maxGA4Events = 25
+
+// ga4Request is a GA4 Measurement Protocol payload. Fields the worker has
+// no use for are ignored.
+type ga4Request struct {
+	ClientID        string    `json:"client_id"`
+	AppInstanceID   string    `json:"app_instance_id"`
+	UserID          string    `json:"user_id"`
+	TimestampMicros ga4Micros `json:"timestamp_micros"`
+	UserProperties  map[string]struct {
+		Value interface{} `json:"value"`
+	} `json:"user_properties"`
+	Events []struct {
+		Name            string                 `json:"name"`
+		Params          map[string]interface{} `json:"params"`
+		TimestampMicros ga4Micros              `json:"timestamp_micros"`
+	} `json:"events"`
+}
+
+// ga4Micros is a Unix time in microseconds, which senders give as a
+// number or a string
+type ga4Micros int64
+
+func (m *ga4Micros) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	v, err := strconv.ParseInt(string(bytes.Trim(data, `"`)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("timestamp_micros: %w", err)
+	}
+	*m = ga4Micros(v)
+	return nil
+}
+
+func (m ga4Micros) time() time.Time {
+	if m == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(int64(m))
+}
+
+// ga4ValidationMessage is how the Measurement Protocol's debug endpoint
+// describes a problem
+type ga4ValidationMessage struct {
+	FieldPath      string `json:"fieldPath"`
+	Description    string `json:"description"`
+	ValidationCode string `json:"validationCode"`
+}
+
+// GA4Collect accepts GA4 Measurement Protocol requests at /mp/collect.
+// Each event becomes a custom event whose "event" field is its name, and
+// whose other custom fields are its scalar params, the user ID and the
+// user properties prefixed "user.". page_location is the page URL. The
+// client ID, joined with the session_id param when there is one, is the
+// session ID. Unlike Google's endpoint, invalid requests are answered
+// with the problem rather than silently accepted. Every event is checked
+// before any is processed; should processing fail partway, the events
+// before the failure are kept.
+func (h *Handler) GA4Collect(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "ga4_collect_handler")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	var req ga4Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid body")
+		writeDecodeProblem(w, r, err)
+		return
+	}
+	events, verr, err := h.ga4Events(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		writeError(w, r, err)
+		return
+	}
+	if len(verr.Fields) > 0 {
+		span.SetStatus(codes.Error, "invalid event")
+		span.SetAttributes(attribute.Int("validation.invalid_fields", len(verr.Fields)))
+		slog.WarnContext(ctx, "Rejected invalid Measurement Protocol request", "error", verr)
+		writeValidationProblem(w, r, verr)
+		return
+	}
+
+	for i := range events {
+		eventCtx := h.addRequestMetadata(ctx, r, &events[i])
+		if err := h.service.ProcessTrackingEvent(eventCtx, events[i]); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "event processing failed")
+			slog.ErrorContext(ctx, "Failed to process Measurement Protocol event", "error", err)
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	span.SetAttributes(attribute.Int("ga4.events", len(events)))
+	w.WriteHeader(http.StatusNoContent)
+	span.SetStatus(codes.Ok, "events tracked")
+}
+
+// GA4Debug checks Measurement Protocol requests at /debug/mp/collect
+// without processing them, answering with validation messages as
+// Google's debug endpoint does
+func (h *Handler) GA4Debug(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "ga4_debug_handler")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	messages := []ga4ValidationMessage{}
+	var req ga4Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		messages = append(messages, ga4ValidationMessage{
+			Description:    "Unable to parse the request body: " + err.Error(),
+			ValidationCode: "VALUE_INVALID",
+		})
+	} else {
+		_, verr, err := h.ga4Events(req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "validation failed")
+			writeError(w, r, err)
+			return
+		}
+		for _, f := range verr.Fields {
+			messages = append(messages, ga4ValidationMessage{
+				FieldPath:      f.Field,
+				Description:    f.Reason,
+				ValidationCode: "VALUE_INVALID",
+			})
+		}
+	}
+
+	span.SetAttributes(attribute.Int("ga4.validation_messages", len(messages)))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"validationMessages": messages})
+	span.SetStatus(codes.Ok, "request checked")
+}
+
+// ga4Events maps a Measurement Protocol request onto tracking events and
+// validates them. Validation problems are collected in the returned
+// *service.ValidationError, which has no fields when there are none.
+func (h *Handler) ga4Events(req ga4Request) ([]service.TrackingEvent, *service.ValidationError, error) {
+	verr := &service.ValidationError{}
+	clientID := req.ClientID
+	if clientID == "" {
+		clientID = req.AppInstanceID
+	}
+	if clientID == "" {
+		verr.Fields = append(verr.Fields, service.FieldError{Field: "client_id", Reason: "client_id or app_instance_id is required"})
+	}
+	if len(req.Events) == 0 || len(req.Events) > maxGA4Events {
+		verr.Fields = append(verr.Fields, service.FieldError{
+			Field:  "events",
+			Reason: fmt.Sprintf("must hold from 1 to %d events", maxGA4Events),
+		})
+		return nil, verr, nil
+	}
+
+	user := make(map[string]interface{}, len(req.UserProperties))
+	for name, p := range req.UserProperties {
+		user[name] = p.Value
+	}
+
+	events := make([]service.TrackingEvent, 0, len(req.Events))
+	now := time.Now()
+	for i, e := range req.Events {
+		prefix := "events[" + strconv.Itoa(i) + "]."
+		if e.Name == "" {
+			verr.Fields = append(verr.Fields, service.FieldError{Field: prefix + "name", Reason: "is required"})
+		}
+
+		event := service.TrackingEvent{
+			EventType: "custom",
+			Timestamp: e.TimestampMicros.time(),
+			SessionID: clientID,
+			Custom:    make(map[string]interface{}),
+		}
+		if event.Timestamp.IsZero() {
+			event.Timestamp = req.TimestampMicros.time()
+		}
+		switch session := e.Params["session_id"].(type) {
+		case float64:
+			event.SessionID = clientID + "." + strconv.FormatFloat(session, 'f', -1, 64)
+		case string:
+			event.SessionID = clientID + "." + session
+		}
+		if clientID == "" {
+			event.SessionID = ""
+		}
+		if url, ok := e.Params["page_location"].(string); ok {
+			event.PageURL = url
+		}
+
+		flattenProperties(event.Custom, "", e.Params)
+		flattenProperties(event.Custom, "user.", user)
+		event.Custom["event"] = e.Name
+		if req.UserID != "" {
+			event.Custom["user_id"] = req.UserID
+		}
+
+		if err := h.checkEvent(event, prefix, now, verr); err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+	}
+	return events, verr, nil
+}
@@ -1,13 +1,23 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/niquet/rate-limited-worker/internal/config"
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/report"
 	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/telemetry"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -18,6 +28,62 @@ import (
 type Handler struct {
 	service *service.Service
 	tracer  *trace.Tracer
+
+	// Decode hardening
+	disallowUnknownFields bool
+	maxCustomFields       int
+
+	// Runtime-updatable IP allow/deny lists
+	ipFilter *middleware.IPFilter
+
+	// Runtime maintenance switch
+	maintenance *middleware.MaintenanceMode
+
+	// Runtime log level, with an optional pending revert
+	logLevel         *slog.LevelVar
+	logLevelMu       sync.Mutex
+	logLevelRevert   *time.Timer
+	logLevelPrevious slog.Level
+	logLevelRevertAt time.Time
+
+	// Active configuration snapshot, for the config dump and the settings
+	// reloaded at runtime
+	config func() *config.Config
+
+	// Errors shown on the dashboard
+	recentErrors *telemetry.RecentErrors
+
+	// Digest served and sent on demand
+	reporter *report.Reporter
+}
+
+// Option configures optional Handler behaviour
+type Option func(*Handler)
+
+// WithDecodeLimits rejects unknown JSON fields when disallowUnknown is set
+// and caps the number of keys in an event's custom map. With WithConfig,
+// the active configuration's limits apply instead.
+func WithDecodeLimits(disallowUnknown bool, maxCustomFields int) Option {
+	return func(h *Handler) {
+		h.disallowUnknownFields = disallowUnknown
+		h.maxCustomFields = maxCustomFields
+	}
+}
+
+// decodeLimits returns whether unknown fields are rejected and the cap on
+// custom fields, read per request so reloads apply
+func (h *Handler) decodeLimits() (disallowUnknown bool, maxCustomFields int) {
+	if h.config != nil {
+		if cfg := h.config(); cfg != nil {
+			return cfg.DisallowUnknownFields, cfg.MaxCustomFields
+		}
+	}
+	return h.disallowUnknownFields, h.maxCustomFields
+}
+
+type homePageData struct {
+	CSRFToken string
+	CSPNonce  string
 }
 
 type HealthResponse struct {
@@ -25,14 +91,54 @@ type HealthResponse struct {
 	Timestamp time.Time `json:"timestamp"`
 	Version   string    `json:"version"`
 	Uptime    string    `json:"uptime"`
+
+	// SLOs reports each objective's ratio and burn rate per window. Only
+	// the admin health check sets it, burn rates being internal.
+	SLOs []service.SLOStatus `json:"slos,omitempty"`
+}
+
+// WithIPFilter exposes the IP filter through the admin API
+func WithIPFilter(f *middleware.IPFilter) Option {
+	return func(h *Handler) {
+		h.ipFilter = f
+	}
+}
+
+// WithMaintenance exposes the maintenance switch through the admin API
+func WithMaintenance(m *middleware.MaintenanceMode) Option {
+	return func(h *Handler) {
+		h.maintenance = m
+	}
 }
 
-func New(svc *service.Service) *Handler {
+// WithLogLevel exposes the shared log level through the admin API
+func WithLogLevel(level *slog.LevelVar) Option {
+	return func(h *Handler) {
+		h.logLevel = level
+	}
+}
+
+// WithConfig exposes the configuration returned by current, with secrets
+// redacted, through the admin API
+func WithConfig(current func() *config.Config) Option {
+	return func(h *Handler) {
+		h.config = current
+	}
+}
+
+func New(svc *service.Service, opts ...Option) *Handler {
 	tracer := otel.Tracer("worker-handlers")
-	return &Handler{
-		service: svc,
-		tracer:  &tracer,
+	h := &Handler{
+		service:         svc,
+		tracer:          &tracer,
+		maxCustomFields: 50,
 	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h
 }
 
 func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
@@ -61,6 +167,7 @@ func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
 <head>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    {{if .CSRFToken}}<meta name="csrf-token" content="{{.CSRFToken}}">{{end}}
     <title>Worker - Interactive Tracking Demo</title>
     <link rel="stylesheet" href="/static/css/style.css">
 </head>
@@ -122,7 +229,7 @@ func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
         </footer>
     </div>
 
-    <script src="/static/js/tracking.js"></script>
+    <script src="/static/js/tracking.js"{{if .CSPNonce}} nonce="{{.CSPNonce}}"{{end}}></script>
 </body>
 </html>`
 
@@ -135,16 +242,20 @@ func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "template parsing failed")
-		slog.Error("Failed to parse template", "error", err)
+		slog.ErrorContext(r.Context(), "Failed to parse template", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	// Execute template
-	if err := t.Execute(w, nil); err != nil {
+	data := homePageData{
+		CSRFToken: middleware.CSRFToken(ctx),
+		CSPNonce:  middleware.CSPNonce(ctx),
+	}
+	if err := t.Execute(w, data); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "template execution failed")
-		slog.Error("Failed to execute template", "error", err)
+		slog.ErrorContext(r.Context(), "Failed to execute template", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -163,49 +274,75 @@ func (h *Handler) TrackEvent(w http.ResponseWriter, r *http.Request) {
 	// Only accept POST requests
 	if r.Method != http.MethodPost {
 		span.SetStatus(codes.Error, "method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, service.ErrMethodNotAllowed)
 		return
 	}
 
-	// Parse JSON request
+	// Parse the request, JSON unless it says protobuf
 	var event service.TrackingEvent
-	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+	var err error
+	format := "JSON"
+	if isProtobuf(r.Header.Get("Content-Type")) {
+		format = "protobuf"
+		event, err = h.decodeProtoEvent(r.Body)
+	} else {
+		event, err = h.decodeJSONEvent(r.Body)
+	}
+	var verr *service.ValidationError
+	if errors.As(err, &verr) {
+		span.SetStatus(codes.Error, "unsupported schema")
+		slog.WarnContext(r.Context(), "Rejected tracking event schema", "error", err)
+		writeValidationProblem(w, r, verr)
+		return
+	}
+	if err != nil {
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "invalid JSON")
-		slog.Error("Failed to decode tracking event", "error", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			span.SetStatus(codes.Error, "payload too large")
+			slog.WarnContext(r.Context(), "Rejected oversized tracking event", "limit_bytes", maxErr.Limit)
+			writeProblem(w, r, Problem{
+				Status:   http.StatusRequestEntityTooLarge,
+				Detail:   fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit),
+				Instance: r.URL.Path,
+			})
+			return
+		}
+		span.SetStatus(codes.Error, "invalid "+format)
+		slog.ErrorContext(r.Context(), "Failed to decode tracking event", "format", format, "error", err)
+		writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid " + format, Instance: r.URL.Path})
 		return
 	}
 
 	// Validate event
-	if event.EventType == "" {
-		span.SetStatus(codes.Error, "missing event type")
-		http.Error(w, "Event type is required", http.StatusBadRequest)
+	verr = &service.ValidationError{}
+	if err := h.checkEvent(event, "", time.Now(), verr); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "validation failed")
+		writeError(w, r, err)
 		return
 	}
-
-	// Set timestamp if not provided
-	if event.Timestamp.IsZero() {
-		event.Timestamp = time.Now()
+	if len(verr.Fields) > 0 {
+		span.SetStatus(codes.Error, "invalid event")
+		span.SetAttributes(attribute.Int("validation.invalid_fields", len(verr.Fields)))
+		slog.WarnContext(r.Context(), "Rejected invalid tracking event", "error", verr)
+		writeValidationProblem(w, r, verr)
+		return
 	}
 
-	// Add request metadata
-	event.UserAgent = r.UserAgent()
-	if event.PageURL == "" {
-		event.PageURL = r.Referer()
-	}
+	ctx = h.addRequestMetadata(ctx, r, &event)
 
 	// Process event through service layer
 	if err := h.service.ProcessTrackingEvent(ctx, event); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "event processing failed")
-		slog.Error("Failed to process tracking event", "error", err, "event_type", event.EventType)
-		http.Error(w, "Failed to process event", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Failed to process tracking event", "error", err, "event_type", event.EventType)
+		writeError(w, r, err)
 		return
 	}
 
 	// Log event details
-	slog.Info("Tracking event processed",
+	slog.InfoContext(r.Context(), "Tracking event processed",
 		"event_type", event.EventType,
 		"cursor_x", event.CursorX,
 		"cursor_y", event.CursorY,
@@ -233,40 +370,101 @@ func (h *Handler) TrackEvent(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		span.RecordError(err)
-		slog.Error("Failed to encode response", "error", err)
+		slog.ErrorContext(r.Context(), "Failed to encode response", "error", err)
 	}
 
 	span.SetStatus(codes.Ok, "event tracked successfully")
 }
 
+// decodeJSONEvent reads a JSON event, upgrading older schema versions
+func (h *Handler) decodeJSONEvent(body io.Reader) (service.TrackingEvent, error) {
+	var event service.TrackingEvent
+	var payload json.RawMessage
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return event, err
+	}
+	payload, err := service.UpgradeEvent(payload)
+	if err != nil {
+		return event, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	if disallowUnknown, _ := h.decodeLimits(); disallowUnknown {
+		decoder.DisallowUnknownFields()
+	}
+	err = decoder.Decode(&event)
+	return event, err
+}
+
+// addRequestMetadata fills in the parts of a validated event the server
+// sets from the request. The returned context carries the session, so
+// spans below it do, unless the event is processed without one.
+func (h *Handler) addRequestMetadata(ctx context.Context, r *http.Request, event *service.TrackingEvent) context.Context {
+	// Set timestamp if not provided
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	event.Tenant = service.TenantFromContext(ctx)
+	event.UserAgent = r.UserAgent()
+	event.ClientIP = ""
+	if ip := middleware.ClientIP(r); ip.IsValid() {
+		event.ClientIP = ip.String()
+	}
+	if event.PageURL == "" {
+		event.PageURL = r.Referer()
+	}
+	event.DoNotTrack = r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+	// Only the bot filter tags events, so a client cannot pre-tag its own
+	event.Bot, event.BotReason = false, ""
+
+	if h.service.Consented(*event) {
+		return telemetry.WithSessionID(ctx, event.SessionID)
+	}
+	event.SessionID = ""
+	return ctx
+}
+
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	ctx, span := (*h.tracer).Start(r.Context(), "health_check_handler")
 	defer span.End()
 
 	if r.Method != http.MethodGet {
 		span.SetStatus(codes.Error, "method not allowed")
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, service.ErrMethodNotAllowed)
 		return
 	}
 
-	// Get health metrics from service
-	healthData := h.service.GetHealthMetrics(ctx)
-
-	response := HealthResponse{
-		Status:    "healthy",
-		Timestamp: time.Now(),
-		Version:   "v1.0.0",
-		Uptime:    healthData.Uptime,
-	}
+	response := h.health(ctx)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "encoding failed")
-		slog.Error("Failed to encode health response", "error", err)
+		slog.ErrorContext(r.Context(), "Failed to encode health response", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	span.SetStatus(codes.Ok, "health check completed")
 }
+
+// health builds the health check response from the service's metrics
+func (h *Handler) health(ctx context.Context) HealthResponse {
+	healthData := h.service.GetHealthMetrics(ctx)
+
+	return HealthResponse{
+		Status:    "healthy",
+		Timestamp: time.Now(),
+		Version:   "v1.0.0",
+		Uptime:    healthData.Uptime,
+	}
+}
+
+// writeJSON encodes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to encode response", "error", err)
+	}
+}
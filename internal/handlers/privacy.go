@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/niquet/rate-limited-worker/internal/audit"
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// ErasureRequest is the body of an erasure request
+type ErasureRequest struct {
+	Subject string `json:"subject"`
+}
+
+// SubjectExport returns (GET) everything kept about a subject of the tenant
+// given as ?tenant=, named by its session ID or user_id, for data subject
+// access requests
+func (h *Handler) SubjectExport(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(adminTenant(r), "subject_export_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	export, err := h.service.ExportSubject(ctx, r.PathValue("id"))
+	if err != nil {
+		span.SetStatus(codes.Error, "subject not found")
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="subject-export.json"`)
+	writeJSON(w, http.StatusOK, export)
+	span.SetStatus(codes.Ok, "subject exported")
+}
+
+// Erasures schedules (POST) the erasure of a subject of the tenant given as
+// ?tenant= from every store. The response links to the request's status.
+func (h *Handler) Erasures(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(adminTenant(r), "erasures_handler")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	var req ErasureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid JSON")
+		writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid JSON", Instance: r.URL.Path})
+		return
+	}
+	if req.Subject == "" {
+		span.SetStatus(codes.Error, "missing subject")
+		writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "subject is required", Instance: r.URL.Path})
+		return
+	}
+
+	erasure := h.service.RequestErasure(ctx, req.Subject)
+	audit.SetChange(r.Context(), "privacy.erasure", nil, erasure)
+
+	span.SetAttributes(attribute.String("erasure.id", erasure.ID))
+	slog.InfoContext(r.Context(), "Scheduled erasure", "erasure_id", erasure.ID)
+	w.Header().Set("Location", "/admin/privacy/erasures/"+erasure.ID)
+	writeJSON(w, http.StatusAccepted, erasure)
+	span.SetStatus(codes.Ok, "erasure scheduled")
+}
+
+// Erasure returns (GET) the status of an erasure request
+func (h *Handler) Erasure(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "erasure_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	erasure, err := h.service.GetErasure(r.PathValue("id"))
+	if err != nil {
+		span.SetStatus(codes.Error, "erasure not found")
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, erasure)
+	span.SetStatus(codes.Ok, "erasure status returned")
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// Problem is an RFC 7807 problem details response body
+type Problem = middleware.Problem
+
+// writeProblem writes p as an application/problem+json response
+func writeProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	middleware.WriteProblem(w, r, p)
+}
+
+// writeError answers with the status and code of the sentinel err wraps.
+// Internal errors are not described to the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	middleware.WriteError(w, r, err)
+}
+
+// writeValidationProblem reports every invalid field of a rejected event
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, verr *service.ValidationError) {
+	writeProblem(w, r, Problem{
+		Type:          "/problems/validation-error",
+		Title:         "Invalid tracking event",
+		Status:        http.StatusBadRequest,
+		Detail:        "One or more fields failed validation",
+		Instance:      r.URL.Path,
+		InvalidParams: verr.Fields,
+		Code:          service.ErrorCode(verr),
+	})
+}
+
+// writeDecodeProblem answers a request whose JSON body could not be
+// decoded, telling an oversized body from a malformed one
+func writeDecodeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeProblem(w, r, Problem{
+			Status:   http.StatusRequestEntityTooLarge,
+			Detail:   fmt.Sprintf("Request body exceeds %d bytes", maxErr.Limit),
+			Instance: r.URL.Path,
+		})
+		return
+	}
+	writeProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid JSON", Instance: r.URL.Path})
+}
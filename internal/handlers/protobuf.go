@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+	trackingv1 "github.com/niquet/rate-limited-worker/proto/tracking/v1"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// isProtobuf reports whether a Content-Type header names a protobuf body
+func isProtobuf(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/x-protobuf" || mediaType == middleware.FormatProtobuf)
+}
+
+// decodeProtoEvent reads a trackingv1.TrackingEvent, returning a
+// *service.ValidationError for a schema version or timestamp the server
+// cannot take
+func (h *Handler) decodeProtoEvent(body io.Reader) (service.TrackingEvent, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return service.TrackingEvent{}, err
+	}
+	var msg trackingv1.TrackingEvent
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		return service.TrackingEvent{}, err
+	}
+	if disallowUnknown, _ := h.decodeLimits(); disallowUnknown && len(msg.ProtoReflect().GetUnknown()) > 0 {
+		return service.TrackingEvent{}, fmt.Errorf("%w: unknown fields", service.ErrInvalidRequest)
+	}
+
+	if msg.SchemaVersion < 0 {
+		return service.TrackingEvent{}, &service.ValidationError{Fields: []service.FieldError{{
+			Field:  "schema_version",
+			Reason: "must be a positive integer",
+		}}}
+	}
+	if msg.SchemaVersion > service.CurrentSchemaVersion {
+		return service.TrackingEvent{}, &service.ValidationError{Fields: []service.FieldError{{
+			Field:  "schema_version",
+			Reason: "version " + strconv.Itoa(int(msg.SchemaVersion)) + " is newer than the newest supported, " + strconv.Itoa(service.CurrentSchemaVersion),
+		}}}
+	}
+
+	event := service.TrackingEvent{
+		SchemaVersion: service.CurrentSchemaVersion,
+		EventType:     msg.EventType,
+		CursorX:       int(msg.CursorX),
+		CursorY:       int(msg.CursorY),
+		ElementID:     msg.ElementId,
+		ElementType:   msg.ElementType,
+		PageURL:       msg.PageUrl,
+		UserAgent:     msg.UserAgent,
+		SessionID:     msg.SessionId,
+		ViewportX:     int(msg.ViewportX),
+		ViewportY:     int(msg.ViewportY),
+		ScrollX:       int(msg.ScrollX),
+		ScrollY:       int(msg.ScrollY),
+		PageHeight:    int(msg.PageHeight),
+		ElementText:   msg.ElementText,
+		Consent:       msg.Consent,
+	}
+	if msg.Timestamp != nil {
+		if err := msg.Timestamp.CheckValid(); err != nil {
+			return service.TrackingEvent{}, &service.ValidationError{Fields: []service.FieldError{{
+				Field:  "timestamp",
+				Reason: "must be a valid timestamp",
+			}}}
+		}
+		event.Timestamp = msg.Timestamp.AsTime()
+	}
+	if len(msg.Custom.GetFields()) > 0 {
+		// Values decode as JSON's do, numbers as float64
+		event.Custom = msg.Custom.AsMap()
+	}
+	if msg.Replay != nil {
+		event.Replay = &service.ReplayChunk{
+			Sequence: int(msg.Replay.Sequence),
+			Encoding: msg.Replay.Encoding,
+			Data:     msg.Replay.Data,
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// SessionReplay streams the recording of a session of the request's tenant
+// for a replay player, one chunk per line in sequence order. Chunk data is
+// base64, gzip-compressed when the chunk's encoding says so.
+func (h *Handler) SessionReplay(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "session_replay_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	chunks, err := h.service.Replay(ctx, r.PathValue("id"))
+	if err != nil {
+		span.SetStatus(codes.Error, "recording not found")
+		writeError(w, r, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("replay.chunks", len(chunks)))
+
+	w.Header().Set("Content-Type", middleware.FormatNDJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	// Flushing per chunk lets the player start before the last one is sent
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for _, chunk := range chunks {
+		if err := enc.Encode(chunk); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "stream interrupted")
+			slog.WarnContext(ctx, "Replay stream interrupted", "error", err)
+			return
+		}
+		_ = rc.Flush()
+	}
+	span.SetStatus(codes.Ok, "recording streamed")
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/report"
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// WithReporter serves and sends the digest r builds through the admin API
+func WithReporter(r *report.Reporter) Option {
+	return func(h *Handler) {
+		h.reporter = r
+	}
+}
+
+// Report renders the digest of the period since the last scheduled one on
+// GET, and mails it to the configured recipients on POST. Neither starts
+// a new period.
+func (h *Handler) Report(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "report_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+	if h.reporter == nil {
+		span.SetStatus(codes.Error, "reports not available")
+		writeProblem(w, r, Problem{Status: http.StatusNotFound, Detail: "Reports are not available", Instance: r.URL.Path})
+		return
+	}
+
+	rep := h.reporter.Build(ctx, time.Now())
+
+	if r.Method == http.MethodPost {
+		err := h.reporter.Send(ctx, rep)
+		switch {
+		case errors.Is(err, report.ErrNotConfigured):
+			span.SetStatus(codes.Error, "reports not configured")
+			writeProblem(w, r, Problem{Status: http.StatusConflict, Detail: "No SMTP server, sender or recipients are configured", Instance: r.URL.Path})
+			return
+		case err != nil:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "send failed")
+			slog.ErrorContext(ctx, "Failed to send report", "error", err)
+			writeProblem(w, r, Problem{Status: http.StatusBadGateway, Detail: "The report could not be sent", Instance: r.URL.Path})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		span.SetStatus(codes.Ok, "report sent")
+		return
+	}
+
+	// Render first so a template error still gets a proper status
+	var buf bytes.Buffer
+	if err := report.Render(&buf, rep); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "template execution failed")
+		slog.ErrorContext(ctx, "Failed to render report", "error", err)
+		writeProblem(w, r, Problem{Status: http.StatusInternalServerError, Detail: "The report could not be rendered", Instance: r.URL.Path})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = buf.WriteTo(w)
+	span.SetStatus(codes.Ok, "report rendered")
+}
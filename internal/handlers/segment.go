@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// maxSegmentBatch bounds the messages of a /v1/batch request
+const maxSegmentBatch = 100
+
+// segmentMessage is a call in Segment's HTTP tracking API format. Fields
+// the worker has no use for are ignored.
+type segmentMessage struct {
+	Type        string                 `json:"type"`
+	AnonymousID string                 `json:"anonymousId"`
+	UserID      string                 `json:"userId"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Event       string                 `json:"event"`
+	Name        string                 `json:"name"`
+	Category    string                 `json:"category"`
+	Properties  map[string]interface{} `json:"properties"`
+	Traits      map[string]interface{} `json:"traits"`
+	Context     struct {
+		UserAgent string `json:"userAgent"`
+		Page      struct {
+			URL string `json:"url"`
+		} `json:"page"`
+	} `json:"context"`
+}
+
+// SegmentTrack accepts a Segment track call at /v1/track
+func (h *Handler) SegmentTrack(w http.ResponseWriter, r *http.Request) {
+	h.segment(w, r, "track")
+}
+
+// SegmentPage accepts a Segment page call at /v1/page
+func (h *Handler) SegmentPage(w http.ResponseWriter, r *http.Request) {
+	h.segment(w, r, "page")
+}
+
+// SegmentIdentify accepts a Segment identify call at /v1/identify
+func (h *Handler) SegmentIdentify(w http.ResponseWriter, r *http.Request) {
+	h.segment(w, r, "identify")
+}
+
+// SegmentBatch accepts up to maxSegmentBatch Segment calls at /v1/batch.
+// The batch is checked whole first, so an invalid call rejects it before
+// any is processed; should processing fail partway, the calls before the
+// failure are kept. Call types the worker does not map, such as screen or
+// group, are skipped.
+func (h *Handler) SegmentBatch(w http.ResponseWriter, r *http.Request) {
+	h.segment(w, r, "batch")
+}
+
+// segment maps Segment calls onto tracking events and processes them:
+//
+//   - track becomes a custom event whose "event" field is the event name
+//   - page becomes a custom event named "page" on the page's URL, so it
+//     counts as a page view
+//   - identify becomes a custom event named "identify"
+//
+// anonymousId, or userId without one, is the session ID. Properties and
+// traits become custom fields, nested objects flattened into dotted keys;
+// lists and nulls are dropped. context.userAgent replaces the request's
+// user agent, for calls relayed by a server; context.ip is not trusted.
+func (h *Handler) segment(w http.ResponseWriter, r *http.Request, kind string) {
+	ctx, span := (*h.tracer).Start(r.Context(), "segment_"+kind+"_handler")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	var msgs []segmentMessage
+	var err error
+	if kind == "batch" {
+		var payload struct {
+			Batch []segmentMessage `json:"batch"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&payload)
+		msgs = payload.Batch
+	} else {
+		var msg segmentMessage
+		err = json.NewDecoder(r.Body).Decode(&msg)
+		msg.Type = kind
+		msgs = []segmentMessage{msg}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid body")
+		writeDecodeProblem(w, r, err)
+		return
+	}
+	if len(msgs) > maxSegmentBatch {
+		span.SetStatus(codes.Error, "batch too large")
+		writeError(w, r, fmt.Errorf("%w: a batch holds at most %d calls", service.ErrInvalidRequest, maxSegmentBatch))
+		return
+	}
+
+	events := make([]service.TrackingEvent, 0, len(msgs))
+	verr := &service.ValidationError{}
+	now := time.Now()
+	for i, msg := range msgs {
+		event, ok := segmentEvent(msg)
+		if !ok {
+			continue
+		}
+		prefix := ""
+		if kind == "batch" {
+			prefix = "batch[" + strconv.Itoa(i) + "]."
+		}
+		if event.SessionID == "" {
+			verr.Fields = append(verr.Fields, service.FieldError{Field: prefix + "anonymousId", Reason: "anonymousId or userId is required"})
+		}
+		if err := h.checkEvent(event, prefix, now, verr); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "validation failed")
+			writeError(w, r, err)
+			return
+		}
+		events = append(events, event)
+	}
+	if len(verr.Fields) > 0 {
+		span.SetStatus(codes.Error, "invalid event")
+		span.SetAttributes(attribute.Int("validation.invalid_fields", len(verr.Fields)))
+		slog.WarnContext(ctx, "Rejected invalid Segment call", "type", kind, "error", verr)
+		writeValidationProblem(w, r, verr)
+		return
+	}
+
+	for i := range events {
+		userAgent := events[i].UserAgent
+		eventCtx := h.addRequestMetadata(ctx, r, &events[i])
+		if userAgent != "" {
+			events[i].UserAgent = userAgent
+		}
+		if err := h.service.ProcessTrackingEvent(eventCtx, events[i]); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "event processing failed")
+			slog.ErrorContext(ctx, "Failed to process Segment call", "error", err, "type", kind)
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	span.SetAttributes(
+		attribute.Int("segment.calls", len(msgs)),
+		attribute.Int("segment.events", len(events)),
+	)
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+	span.SetStatus(codes.Ok, "segment calls tracked")
+}
+
+// segmentEvent maps a Segment call onto a tracking event, or returns false
+// for call types that have no mapping
+func segmentEvent(msg segmentMessage) (service.TrackingEvent, bool) {
+	event := service.TrackingEvent{
+		EventType: "custom",
+		Timestamp: msg.Timestamp,
+		SessionID: msg.AnonymousID,
+		PageURL:   msg.Context.Page.URL,
+		UserAgent: msg.Context.UserAgent,
+		Custom:    make(map[string]interface{}),
+	}
+	if event.SessionID == "" {
+		event.SessionID = msg.UserID
+	}
+
+	switch msg.Type {
+	case "track":
+		flattenProperties(event.Custom, "", msg.Properties)
+		event.Custom["event"] = msg.Event
+	case "page":
+		flattenProperties(event.Custom, "", msg.Properties)
+		if url, ok := msg.Properties["url"].(string); ok && url != "" {
+			event.PageURL = url
+		}
+		event.Custom["event"] = "page"
+		if msg.Name != "" {
+			event.Custom["name"] = msg.Name
+		}
+		if msg.Category != "" {
+			event.Custom["category"] = msg.Category
+		}
+	case "identify":
+		flattenProperties(event.Custom, "", msg.Traits)
+		event.Custom["event"] = "identify"
+	default:
+		return service.TrackingEvent{}, false
+	}
+	if msg.UserID != "" {
+		event.Custom["user_id"] = msg.UserID
+	}
+	return event, true
+}
+
+// checkEvent validates an event mapped from another format with the
+// active limits, as service.CheckEvent does
+func (h *Handler) checkEvent(event service.TrackingEvent, prefix string, now time.Time, verr *service.ValidationError) error {
+	_, maxCustomFields := h.decodeLimits()
+	return h.service.CheckEvent(event, maxCustomFields, prefix, now, verr)
+}
+
+// flattenProperties copies the scalar values of src into dst, keys of
+// nested objects joined with dots
+func flattenProperties(dst map[string]interface{}, prefix string, src map[string]interface{}) {
+	for key, value := range src {
+		switch v := value.(type) {
+		case string, float64, bool:
+			dst[prefix+key] = v
+		case map[string]interface{}:
+			flattenProperties(dst, prefix+key+".", v)
+		}
+	}
+}
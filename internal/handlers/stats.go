@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Stats returns the current tracking aggregates of the request's tenant
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "stats_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	writeNegotiated(w, r, http.StatusOK, h.service.GetStats(ctx))
+	span.SetStatus(codes.Ok, "stats returned")
+}
+
+// defaultRateWindow is the window Rates reports over without ?window=
+const defaultRateWindow = 5 * time.Minute
+
+// Rates returns the request tenant's clicks, events and sessions started
+// per minute over the window given as ?window=, a duration of at most
+// service.RateHistory
+func (h *Handler) Rates(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "rates_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	window := defaultRateWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > service.RateHistory {
+			span.SetStatus(codes.Error, "invalid window")
+			writeError(w, r, fmt.Errorf("%w: window must be a duration up to %s", service.ErrInvalidRequest, service.RateHistory))
+			return
+		}
+		window = d
+	}
+
+	writeJSON(w, http.StatusOK, h.service.GetRates(ctx, window))
+	span.SetStatus(codes.Ok, "rates returned")
+}
+
+// Anomalies lists the minutes of the last service.RateHistory whose click,
+// new session or error rate was anomalous, most recent first
+func (h *Handler) Anomalies(w http.ResponseWriter, r *http.Request) {
+	_, span := (*h.tracer).Start(r.Context(), "anomalies_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	anomalies := h.service.GetAnomalies()
+	if anomalies == nil {
+		anomalies = []service.Anomaly{}
+	}
+	writeJSON(w, http.StatusOK, anomalies)
+	span.SetStatus(codes.Ok, "anomalies returned")
+}
+
+// Heatmap returns the click heatmap of the page given as ?page_url= for the
+// request's tenant, positions being percentages of the viewport
+func (h *Handler) Heatmap(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "heatmap_handler")
+	defer span.End()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	pageURL := r.URL.Query().Get("page_url")
+	if pageURL == "" || len(pageURL) > service.MaxPageURLLen {
+		span.SetStatus(codes.Error, "invalid page url")
+		writeError(w, r, fmt.Errorf("%w: page_url must be a URL of at most %d characters", service.ErrInvalidRequest, service.MaxPageURLLen))
+		return
+	}
+
+	heatmap, err := h.service.GetHeatmap(ctx, pageURL)
+	if err != nil {
+		span.SetStatus(codes.Error, "page not found")
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, heatmap)
+	span.SetStatus(codes.Ok, "heatmap returned")
+}
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// webhookParser maps a third-party webhook's body onto tracking events. An
+// error describes why the payload is unusable and is answered with a 400.
+type webhookParser func(r *http.Request, body []byte) ([]service.TrackingEvent, error)
+
+// webhookParsers are the sources accepted at /api/v1/ingest/webhook/{source}
+var webhookParsers = map[string]webhookParser{
+	"form":       parseFormWebhook,
+	"typeform":   parseTypeformWebhook,
+	"contentful": parseContentfulWebhook,
+	"strapi":     parseStrapiWebhook,
+}
+
+// WebhookSources returns the sources webhooks are accepted from, sorted
+func WebhookSources() []string {
+	sources := make([]string, 0, len(webhookParsers))
+	for source := range webhookParsers {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// IngestWebhook accepts a third-party service's webhook at
+// /api/v1/ingest/webhook/{source}, mapping its payload onto custom events
+// whose "event" field names what happened and whose "source" field is the
+// source. Every event of a payload is checked before any is processed, so
+// an invalid one rejects the payload; should processing fail partway, the
+// events before the failure are kept.
+//
+// Webhooks are sent by the source's servers, so their events have no
+// browser session unless the payload carries one, and the bot filter tags
+// them unless the payload carries the user's user agent.
+func (h *Handler) IngestWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx, span := (*h.tracer).Start(r.Context(), "webhook_ingest_handler")
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		span.SetStatus(codes.Error, "method not allowed")
+		writeError(w, r, service.ErrMethodNotAllowed)
+		return
+	}
+
+	source := r.PathValue("source")
+	span.SetAttributes(attribute.String("webhook.source", source))
+	parse, ok := webhookParsers[source]
+	if !ok {
+		span.SetStatus(codes.Error, "unknown source")
+		writeProblem(w, r, Problem{
+			Status: http.StatusNotFound,
+			Detail: fmt.Sprintf("Unknown webhook source %q, expected one of %s", source, strings.Join(WebhookSources(), ", ")),
+		})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid body")
+		writeDecodeProblem(w, r, err)
+		return
+	}
+	events, err := parse(r, body)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid payload")
+		slog.WarnContext(ctx, "Rejected invalid webhook", "source", source, "error", err)
+		writeError(w, r, fmt.Errorf("%w: %s payload: %v", service.ErrInvalidRequest, source, err))
+		return
+	}
+
+	verr := &service.ValidationError{}
+	now := time.Now()
+	for i := range events {
+		events[i].EventType = "custom"
+		events[i].Custom["source"] = source
+		prefix := ""
+		if len(events) > 1 {
+			prefix = "events[" + strconv.Itoa(i) + "]."
+		}
+		if err := h.checkEvent(events[i], prefix, now, verr); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "validation failed")
+			writeError(w, r, err)
+			return
+		}
+	}
+	if len(verr.Fields) > 0 {
+		span.SetStatus(codes.Error, "invalid event")
+		span.SetAttributes(attribute.Int("validation.invalid_fields", len(verr.Fields)))
+		slog.WarnContext(ctx, "Rejected invalid webhook", "source", source, "error", verr)
+		writeValidationProblem(w, r, verr)
+		return
+	}
+
+	for i := range events {
+		userAgent, pageURL := events[i].UserAgent, events[i].PageURL
+		eventCtx := h.addRequestMetadata(ctx, r, &events[i])
+		if userAgent != "" {
+			events[i].UserAgent = userAgent
+		}
+		if pageURL == "" {
+			// The sender's Referer is not a page the user was on
+			events[i].PageURL = ""
+		}
+		if err := h.service.ProcessTrackingEvent(eventCtx, events[i]); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "event processing failed")
+			slog.ErrorContext(ctx, "Failed to process webhook event", "error", err, "source", source)
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	span.SetAttributes(attribute.Int("webhook.events", len(events)))
+	w.WriteHeader(http.StatusNoContent)
+	span.SetStatus(codes.Ok, "webhook ingested")
+}
+
+// webhookEvent returns a custom event named name
+func webhookEvent(name string) service.TrackingEvent {
+	return service.TrackingEvent{Custom: map[string]interface{}{"event": name}}
+}
+
+// parseFormWebhook maps a form submission, posted URL-encoded or as a JSON
+// object by services such as Formspree or Netlify Forms, onto a
+// "form_submission" event. The form is named by the form query parameter
+// or the form_name field. The session_id field is the session ID; other
+// fields become custom fields prefixed "field.", nested objects flattened
+// into dotted keys and lists and nulls dropped. Fields starting with an
+// underscore, which form services use for settings such as honeypots, are
+// left out.
+func parseFormWebhook(r *http.Request, body []byte) ([]service.TrackingEvent, error) {
+	fields := map[string]interface{}{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		for key := range values {
+			fields[key] = values.Get(key)
+		}
+	case "application/json", "":
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return nil, err
+		}
+		// Netlify wraps the submitted fields in data
+		if data, ok := fields["data"].(map[string]interface{}); ok {
+			name := fields["form_name"]
+			fields = data
+			if name != nil {
+				fields["form_name"] = name
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %s", mediaType)
+	}
+
+	event := webhookEvent("form_submission")
+	form := r.URL.Query().Get("form")
+	if name, ok := fields["form_name"].(string); ok && form == "" {
+		form = name
+	}
+	if form != "" {
+		event.Custom["form"] = form
+	}
+	if id, ok := fields["session_id"].(string); ok {
+		event.SessionID = id
+	}
+	for key := range fields {
+		if strings.HasPrefix(key, "_") || key == "form_name" || key == "session_id" {
+			delete(fields, key)
+		}
+	}
+	flattenProperties(event.Custom, "field.", fields)
+	return []service.TrackingEvent{event}, nil
+}
+
+// typeformPayload is a Typeform form_response webhook. Fields the worker
+// has no use for are ignored.
+type typeformPayload struct {
+	EventType    string `json:"event_type"`
+	FormResponse struct {
+		FormID      string            `json:"form_id"`
+		Token       string            `json:"token"`
+		SubmittedAt time.Time         `json:"submitted_at"`
+		Hidden      map[string]string `json:"hidden"`
+		Definition  struct {
+			Title string `json:"title"`
+		} `json:"definition"`
+		Metadata struct {
+			UserAgent string `json:"user_agent"`
+			Referer   string `json:"referer"`
+		} `json:"metadata"`
+		Answers []struct {
+			Type  string `json:"type"`
+			Field struct {
+				ID  string `json:"id"`
+				Ref string `json:"ref"`
+			} `json:"field"`
+			Text    string  `json:"text"`
+			Number  float64 `json:"number"`
+			Boolean bool    `json:"boolean"`
+			Date    string  `json:"date"`
+			URL     string  `json:"url"`
+			Choice  struct {
+				Label string `json:"label"`
+			} `json:"choice"`
+			Choices struct {
+				Labels []string `json:"labels"`
+			} `json:"choices"`
+		} `json:"answers"`
+	} `json:"form_response"`
+}
+
+// parseTypeformWebhook maps a Typeform response onto a "form_submission"
+// event with the form's ID and title and the response's token. Answers
+// become custom fields named "answer." and the question's ref; multiple
+// choices are joined with commas, and contact details and uploads are left
+// out. The session_id hidden field is the session ID, and the respondent's
+// user agent and the page the form was embedded in are the event's.
+func parseTypeformWebhook(_ *http.Request, body []byte) ([]service.TrackingEvent, error) {
+	var payload typeformPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.EventType != "form_response" {
+		return nil, fmt.Errorf("unsupported event_type %q", payload.EventType)
+	}
+
+	resp := payload.FormResponse
+	event := webhookEvent("form_submission")
+	event.Timestamp = resp.SubmittedAt
+	event.SessionID = resp.Hidden["session_id"]
+	event.UserAgent = resp.Metadata.UserAgent
+	event.PageURL = resp.Metadata.Referer
+	event.Custom["form"] = resp.FormID
+	event.Custom["response_id"] = resp.Token
+	if resp.Definition.Title != "" {
+		event.Custom["form_title"] = resp.Definition.Title
+	}
+	for _, a := range resp.Answers {
+		key := a.Field.Ref
+		if key == "" {
+			key = a.Field.ID
+		}
+		key = "answer." + key
+		switch a.Type {
+		case "text":
+			event.Custom[key] = a.Text
+		case "number":
+			event.Custom[key] = a.Number
+		case "boolean":
+			event.Custom[key] = a.Boolean
+		case "date":
+			event.Custom[key] = a.Date
+		case "url":
+			event.Custom[key] = a.URL
+		case "choice":
+			event.Custom[key] = a.Choice.Label
+		case "choices":
+			event.Custom[key] = strings.Join(a.Choices.Labels, ",")
+		}
+	}
+	return []service.TrackingEvent{event}, nil
+}
+
+// contentfulSys is the sys object Contentful describes entities with
+type contentfulSys struct {
+	Type     string  `json:"type"`
+	ID       string  `json:"id"`
+	Revision float64 `json:"revision"`
+	Space    struct {
+		Sys struct {
+			ID string `json:"id"`
+		} `json:"sys"`
+	} `json:"space"`
+	Environment struct {
+		Sys struct {
+			ID string `json:"id"`
+		} `json:"sys"`
+	} `json:"environment"`
+	ContentType struct {
+		Sys struct {
+			ID string `json:"id"`
+		} `json:"sys"`
+	} `json:"contentType"`
+}
+
+// parseContentfulWebhook maps a Contentful webhook onto an event named by
+// its X-Contentful-Topic header, as in "entry.publish" for the topic
+// ContentManagement.Entry.publish. The entity's ID, type, content type,
+// space, environment and revision become custom fields; its content does
+// not.
+func parseContentfulWebhook(r *http.Request, body []byte) ([]service.TrackingEvent, error) {
+	topic := r.Header.Get("X-Contentful-Topic")
+	_, name, ok := strings.Cut(topic, ".")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid X-Contentful-Topic header %q", topic)
+	}
+
+	var payload struct {
+		Sys contentfulSys `json:"sys"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	sys := payload.Sys
+	event := webhookEvent(strings.ToLower(name))
+	for key, value := range map[string]string{
+		"entity_id":    sys.ID,
+		"entity_type":  sys.Type,
+		"content_type": sys.ContentType.Sys.ID,
+		"space":        sys.Space.Sys.ID,
+		"environment":  sys.Environment.Sys.ID,
+	} {
+		if value != "" {
+			event.Custom[key] = value
+		}
+	}
+	if sys.Revision > 0 {
+		event.Custom["revision"] = sys.Revision
+	}
+	return []service.TrackingEvent{event}, nil
+}
+
+// parseStrapiWebhook maps a Strapi webhook onto an event named by its event
+// field, as in "entry.publish". The model and the entry's or media's ID
+// become custom fields; its content does not.
+func parseStrapiWebhook(_ *http.Request, body []byte) ([]service.TrackingEvent, error) {
+	var payload struct {
+		Event     string    `json:"event"`
+		CreatedAt time.Time `json:"createdAt"`
+		Model     string    `json:"model"`
+		Entry     struct {
+			ID interface{} `json:"id"`
+		} `json:"entry"`
+		Media struct {
+			ID interface{} `json:"id"`
+		} `json:"media"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Event == "" {
+		return nil, errors.New("event is required")
+	}
+
+	event := webhookEvent(payload.Event)
+	event.Timestamp = payload.CreatedAt
+	if payload.Model != "" {
+		event.Custom["model"] = payload.Model
+	}
+	// IDs are numbers, or strings with a custom ID type
+	for key, id := range map[string]interface{}{"entry_id": payload.Entry.ID, "media_id": payload.Media.ID} {
+		switch v := id.(type) {
+		case float64:
+			event.Custom[key] = strconv.FormatFloat(v, 'f', -1, 64)
+		case string:
+			event.Custom[key] = v
+		}
+	}
+	return []service.TrackingEvent{event}, nil
+}
@@ -0,0 +1,161 @@
+// Package influx writes points in the InfluxDB line protocol over HTTP,
+// which InfluxDB 1.x and 2.x and Telegraf's http_listener_v2 take.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Point is one line: a measurement's fields at a time. Field values are
+// int64, float64, bool or string.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// precisions maps the precision query parameters of both API versions to
+// their units
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// Writer posts points to a write endpoint
+type Writer struct {
+	url       string
+	token     string
+	precision time.Duration
+}
+
+// NewWriter writes to url, a full write endpoint such as
+// http://host:8086/api/v2/write?org=o&bucket=b or
+// http://host:8086/write?db=d. Timestamps are given in the URL's
+// precision parameter, nanoseconds without one. A token, when set, is
+// sent as InfluxDB 2.x expects it.
+func NewWriter(rawURL, token string) (*Writer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	precision, ok := precisions[u.Query().Get("precision")]
+	if !ok {
+		return nil, fmt.Errorf("unknown precision %q", u.Query().Get("precision"))
+	}
+	return &Writer{url: rawURL, token: token, precision: precision}, nil
+}
+
+// Write posts points in one request
+func (w *Writer) Write(ctx context.Context, points []Point) error {
+	var body bytes.Buffer
+	for _, p := range points {
+		body.Write(AppendLine(nil, p, w.precision))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// AppendLine appends the line for p, with its timestamp in units of
+// precision. Tags and fields are sorted by key, as InfluxDB prefers them.
+func AppendLine(b []byte, p Point, precision time.Duration) []byte {
+	b = append(b, escape(p.Measurement, ", ")...)
+	for _, k := range sortedKeys(p.Tags) {
+		if p.Tags[k] == "" {
+			// Empty tag values are not allowed
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, escape(k, ",= ")...)
+		b = append(b, '=')
+		b = append(b, escape(p.Tags[k], ",= ")...)
+	}
+
+	sep := byte(' ')
+	for _, k := range sortedKeys(p.Fields) {
+		b = append(b, sep)
+		sep = ','
+		b = append(b, escape(k, ",= ")...)
+		b = append(b, '=')
+		switch v := p.Fields[k].(type) {
+		case int64:
+			b = strconv.AppendInt(b, v, 10)
+			b = append(b, 'i')
+		case float64:
+			b = strconv.AppendFloat(b, v, 'f', -1, 64)
+		case bool:
+			b = strconv.AppendBool(b, v)
+		default:
+			b = append(b, '"')
+			b = append(b, escape(fmt.Sprint(v), `"\`)...)
+			b = append(b, '"')
+		}
+	}
+
+	if !p.Time.IsZero() {
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, p.Time.UnixNano()/int64(precision), 10)
+	}
+	return append(b, '\n')
+}
+
+// escape backslash-escapes the characters special where s goes. Newlines
+// would end the line, so they become spaces, escaped in turn.
+func escape(s, special string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,113 @@
+// Package ingest feeds events that arrive other than through the HTTP
+// tracking API into the service, checked as that API checks them.
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+	"github.com/niquet/rate-limited-worker/internal/telemetry"
+)
+
+// maxMessageEvents bounds the events of one message
+const maxMessageEvents = 100
+
+// Pipeline decodes, validates and processes the events of a message
+type Pipeline struct {
+	service *service.Service
+	limits  func() (disallowUnknownFields bool, maxCustomFields int)
+}
+
+// NewPipeline creates a pipeline feeding svc, checking events as the
+// tracking API does with the limits returned by limits for each message
+func NewPipeline(svc *service.Service, limits func() (disallowUnknownFields bool, maxCustomFields int)) *Pipeline {
+	return &Pipeline{service: svc, limits: limits}
+}
+
+// Ingest processes payload, a JSON event as /api/track takes it or an
+// array of up to maxMessageEvents of them, for tenant, returning how many
+// were processed. The message is checked whole first, so an invalid event
+// rejects it before any is processed; a *service.ValidationError names
+// the problems. There is no request to
+// take metadata from: the client IP is unknown, and the user agent and
+// Do Not Track preference are the event's own.
+func (p *Pipeline) Ingest(ctx context.Context, payload []byte, tenant string) (int, error) {
+	disallowUnknownFields, maxCustomFields := p.limits()
+	events, err := p.decode(payload, disallowUnknownFields)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	verr := &service.ValidationError{}
+	for i, event := range events {
+		prefix := ""
+		if len(events) > 1 {
+			prefix = "[" + strconv.Itoa(i) + "]."
+		}
+		if err := p.service.CheckEvent(event, maxCustomFields, prefix, now, verr); err != nil {
+			return 0, err
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return 0, verr
+	}
+
+	for i := range events {
+		event := &events[i]
+		if event.Timestamp.IsZero() {
+			event.Timestamp = now
+		}
+		event.Tenant = tenant
+		event.ClientIP = ""
+		event.Bot, event.BotReason = false, ""
+
+		eventCtx := ctx
+		if p.service.Consented(*event) {
+			eventCtx = telemetry.WithSessionID(ctx, event.SessionID)
+		} else {
+			event.SessionID = ""
+		}
+		if err := p.service.ProcessTrackingEvent(eventCtx, *event); err != nil {
+			return i, err
+		}
+	}
+	return len(events), nil
+}
+
+// decode parses a payload, upgrading older schema versions
+func (p *Pipeline) decode(payload []byte, disallowUnknownFields bool) ([]service.TrackingEvent, error) {
+	var raws []json.RawMessage
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		if err := json.Unmarshal(payload, &raws); err != nil {
+			return nil, fmt.Errorf("%w: %w", service.ErrInvalidRequest, err)
+		}
+		if len(raws) == 0 || len(raws) > maxMessageEvents {
+			return nil, fmt.Errorf("%w: a message holds from 1 to %d events", service.ErrInvalidRequest, maxMessageEvents)
+		}
+	} else {
+		raws = []json.RawMessage{payload}
+	}
+
+	events := make([]service.TrackingEvent, len(raws))
+	for i, raw := range raws {
+		upgraded, err := service.UpgradeEvent(raw)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(upgraded))
+		if disallowUnknownFields {
+			decoder.DisallowUnknownFields()
+		}
+		if err := decoder.Decode(&events[i]); err != nil {
+			return nil, fmt.Errorf("%w: %w", service.ErrInvalidRequest, err)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,174 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MQTT message results counted by worker_ingest_messages_total
+const (
+	ResultAccepted = "accepted"
+	ResultInvalid  = "invalid"
+	ResultFailed   = "failed"
+	ResultSkipped  = "skipped"
+)
+
+// MQTTConfig configures the MQTT subscriber
+type MQTTConfig struct {
+	// Broker URLs, such as tcp://host:1883 or ssl://host:8883, tried in
+	// turn
+	Brokers []string
+
+	// Identifies the persistent session, which keeps QoS 1 and 2 messages
+	// for the subscriber while it is away
+	ClientID string
+
+	Username string
+	Password string
+
+	// Topic filters subscribed to, wildcards allowed
+	Topics []string
+	QoS    byte
+
+	// 1-based level of the topic naming the tenant, as 2 in
+	// events/<tenant>/kiosk-1; 0 leaves events to the default tenant
+	TenantLevel int
+}
+
+// MQTT subscribes to topics carrying JSON events, each message holding
+// what Pipeline.Ingest takes, and processes them as they arrive. Messages
+// are acknowledged once processed, invalid ones included, as sending them
+// again would not make them valid. Retained messages are skipped: the
+// broker hands them to every new subscription, which would count their
+// events again.
+type MQTT struct {
+	cfg      MQTTConfig
+	pipeline *Pipeline
+	client   mqtt.Client
+	tracer   trace.Tracer
+
+	messages metric.Int64Counter
+	results  map[string]metric.AddOption
+}
+
+// NewMQTT creates the subscriber, which connects once started and hands
+// messages to pipeline
+func NewMQTT(cfg MQTTConfig, pipeline *Pipeline) *MQTT {
+	m := &MQTT{
+		cfg:      cfg,
+		pipeline: pipeline,
+		tracer:   otel.Tracer("worker-ingest"),
+		results:  make(map[string]metric.AddOption),
+	}
+	for _, result := range []string{ResultAccepted, ResultInvalid, ResultFailed, ResultSkipped} {
+		m.results[result] = metric.WithAttributeSet(attribute.NewSet(
+			attribute.String("source", "mqtt"),
+			attribute.String("result", result),
+		))
+	}
+	m.messages, _ = otel.Meter("worker-ingest").Int64Counter("worker_ingest_messages_total",
+		metric.WithDescription("Messages received by ingestion listeners, by source and result"),
+	)
+
+	opts := mqtt.NewClientOptions().
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(m.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("Lost MQTT connection, reconnecting", "error", err)
+		})
+	for _, broker := range cfg.Brokers {
+		opts.AddBroker(broker)
+	}
+	m.client = mqtt.NewClient(opts)
+	return m
+}
+
+// Start connects in the background, retrying until the broker is reached
+func (m *MQTT) Start() {
+	m.client.Connect()
+}
+
+// subscribe subscribes to the topics on every connection, as a broker
+// that lost the session no longer has the subscriptions
+func (m *MQTT) subscribe(client mqtt.Client) {
+	filters := make(map[string]byte, len(m.cfg.Topics))
+	for _, topic := range m.cfg.Topics {
+		filters[topic] = m.cfg.QoS
+	}
+	token := client.SubscribeMultiple(filters, m.handle)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		slog.Error("Failed to subscribe to MQTT topics", "topics", m.cfg.Topics, "error", err)
+		return
+	}
+	slog.Info("Subscribed to MQTT topics", "topics", m.cfg.Topics)
+}
+
+func (m *MQTT) handle(_ mqtt.Client, msg mqtt.Message) {
+	ctx, span := m.tracer.Start(context.Background(), "mqtt_message", trace.WithAttributes(
+		attribute.String("messaging.system", "mqtt"),
+		attribute.String("messaging.destination.name", msg.Topic()),
+	))
+	defer span.End()
+
+	if msg.Retained() {
+		m.messages.Add(ctx, 1, m.results[ResultSkipped])
+		span.SetStatus(codes.Ok, "retained message skipped")
+		slog.DebugContext(ctx, "Skipped retained MQTT message", "topic", msg.Topic())
+		return
+	}
+
+	n, err := m.pipeline.Ingest(ctx, msg.Payload(), m.tenant(msg.Topic()))
+	switch {
+	case err == nil:
+		m.messages.Add(ctx, 1, m.results[ResultAccepted])
+		span.SetAttributes(attribute.Int("ingest.events", n))
+		span.SetStatus(codes.Ok, "events tracked")
+	case errors.Is(err, service.ErrInvalidRequest) || errors.Is(err, service.ErrForbidden) ||
+		errors.As(err, new(*service.ValidationError)):
+		m.messages.Add(ctx, 1, m.results[ResultInvalid])
+		span.SetStatus(codes.Error, "invalid message")
+		slog.WarnContext(ctx, "Rejected invalid MQTT message", "topic", msg.Topic(), "error", err)
+	default:
+		m.messages.Add(ctx, 1, m.results[ResultFailed])
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "event processing failed")
+		slog.ErrorContext(ctx, "Failed to process MQTT message", "topic", msg.Topic(), "events_processed", n, "error", err)
+	}
+}
+
+// tenant reads the tenant from its topic level, if configured
+func (m *MQTT) tenant(topic string) string {
+	if m.cfg.TenantLevel == 0 {
+		return ""
+	}
+	levels := strings.Split(topic, "/")
+	if m.cfg.TenantLevel > len(levels) {
+		return ""
+	}
+	return levels[m.cfg.TenantLevel-1]
+}
+
+// Close stops taking messages, letting the one in progress finish
+func (m *MQTT) Close() {
+	m.client.Disconnect(250)
+}
@@ -0,0 +1,114 @@
+// Package listen opens the listeners the servers accept connections on
+// when they are not plain TCP ports: Unix sockets, and sockets passed by
+// systemd socket activation.
+package listen
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes
+const listenFDsStart = 3
+
+// Unix listens on a socket at path. A socket left behind by an earlier run
+// is replaced, and the new one is only opened by its owner and group.
+func Unix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+// Sockets are the listeners passed by systemd that have not been taken
+type Sockets struct {
+	names     []string
+	listeners []net.Listener
+}
+
+// Systemd adopts the listeners systemd passed to this process, named by
+// the FileDescriptorName of their socket units. It returns no sockets when
+// the process was not socket activated. The activation variables are
+// cleared so child processes do not adopt the sockets too.
+func Systemd() (*Sockets, error) {
+	s := &Sockets{}
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return s, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("LISTEN_FDS must be a positive count, got %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(key)
+	}
+
+	for i := range count {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		// FileListener works on a duplicate, so the passed descriptor is
+		// closed either way
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		lis, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("socket %d (%s): %w", listenFDsStart+i, name, err)
+		}
+		s.names = append(s.names, name)
+		s.listeners = append(s.listeners, lis)
+	}
+	return s, nil
+}
+
+// Take returns the listener named name, which is no longer among s
+func (s *Sockets) Take(name string) (net.Listener, bool) {
+	i := slices.Index(s.names, name)
+	if i < 0 {
+		return nil, false
+	}
+	return s.take(i), true
+}
+
+// TakeFirst returns the first listener left
+func (s *Sockets) TakeFirst() (net.Listener, bool) {
+	if len(s.listeners) == 0 {
+		return nil, false
+	}
+	return s.take(0), true
+}
+
+func (s *Sockets) take(i int) net.Listener {
+	lis := s.listeners[i]
+	s.names = slices.Delete(s.names, i, i+1)
+	s.listeners = slices.Delete(s.listeners, i, i+1)
+	return lis
+}
+
+// Close closes the listeners left, returning their names
+func (s *Sockets) Close() []string {
+	names := s.names
+	for _, lis := range s.listeners {
+		lis.Close()
+	}
+	s.names, s.listeners = nil, nil
+	return names
+}
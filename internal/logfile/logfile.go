@@ -0,0 +1,166 @@
+// Package logfile writes logs to a file that is rotated once it grows too
+// large or too old, keeping a bounded number of rotated files beside it.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files; it sorts chronologically
+const backupTimeFormat = "20060102T150405.000"
+
+// Config sets where logs go and when the file is rotated. A zero MaxSize
+// or MaxAge disables that limit; a zero MaxBackups keeps every rotated file.
+type Config struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// Writer appends to the log file, rotating it before a write that would
+// take it past MaxSize or once it is older than MaxAge. A rotated file is
+// renamed with its rotation time before the extension, as in
+// worker-20240102T150405.000.log.
+type Writer struct {
+	cfg Config
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// Open appends to the file at cfg.Path, creating it and its directory if
+// needed
+func Open(cfg Config) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{cfg: cfg}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the file for appending. An existing file's age counts from
+// its modification time, as its creation time is not portable.
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size, w.opened = f, info.Size(), time.Now()
+	if info.Size() > 0 {
+		w.opened = info.ModTime()
+	}
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	if w.due(int64(len(p)), time.Now()) {
+		if err := w.rotate(); err != nil {
+			// Keep logging to the current file rather than lose lines
+			fmt.Fprintf(os.Stderr, "log file rotation failed: %v\n", err)
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// due reports whether the file must be rotated before writing n bytes. An
+// empty file is never rotated, so a line longer than MaxSize is written.
+func (w *Writer) due(n int64, now time.Time) bool {
+	if w.size == 0 {
+		return false
+	}
+	return (w.cfg.MaxSize > 0 && w.size+n > w.cfg.MaxSize) ||
+		(w.cfg.MaxAge > 0 && now.Sub(w.opened) >= w.cfg.MaxAge)
+}
+
+// rotate renames the current file, opens a new one and removes the oldest
+// rotated files beyond MaxBackups
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+
+	ext := filepath.Ext(w.cfg.Path)
+	backup := strings.TrimSuffix(w.cfg.Path, ext) + "-" + time.Now().UTC().Format(backupTimeFormat) + ext
+	renameErr := os.Rename(w.cfg.Path, backup)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	return w.prune()
+}
+
+// prune removes the oldest rotated files beyond MaxBackups
+func (w *Writer) prune() error {
+	if w.cfg.MaxBackups <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(w.cfg.Path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(w.cfg.Path)
+	prefix := strings.TrimSuffix(filepath.Base(w.cfg.Path), ext) + "-"
+	var backups []string
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ext)); err == nil {
+			backups = append(backups, e.Name())
+		}
+	}
+	if len(backups) <= w.cfg.MaxBackups {
+		return nil
+	}
+	slices.Sort(backups)
+	for _, name := range backups[:len(backups)-w.cfg.MaxBackups] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file; later writes fail
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
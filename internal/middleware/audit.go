@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/niquet/rate-limited-worker/internal/audit"
+)
+
+// Audit writes an audit entry for every request it wraps. It must run after
+// authentication so the caller's identity is known; handlers describe
+// configuration changes through audit.SetChange.
+func Audit(logger *audit.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, rec := audit.WithRecord(r.Context())
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			next.ServeHTTP(wrapped, r.WithContext(ctx))
+
+			action, before, after := rec.Change()
+			if action == "" {
+				action = r.Method + " " + r.URL.Path
+			}
+
+			err := logger.Log(audit.Entry{
+				Actor:     Actor(r),
+				Action:    action,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    wrapped.statusCode,
+				RequestID: RequestIDFromContext(r.Context()),
+				ClientIP:  ClientIP(r).String(),
+				Before:    before,
+				After:     after,
+			})
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Failed to write audit entry", "error", err, "path", r.URL.Path)
+			}
+		})
+	}
+}
+
+// Actor names the authenticated caller of a request
+func Actor(r *http.Request) string {
+	if claims, ok := JWTClaimsFromContext(r.Context()); ok {
+		return "jwt:" + claims.Subject()
+	}
+	if id, ok := APIKeyIdentity(r.Context()); ok {
+		return "api_key:" + id
+	}
+	return "anonymous"
+}
@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	apiKeyIdentityKey contextKey = iota
+	jwtClaimsKey
+	requestIDKey
+	clientIPKey
+	csrfTokenKey
+	cspNonceKey
+	formatKey
+	apiVersionKey
+)
+
+// APIKey pairs a secret key with the identity it authenticates
+type APIKey struct {
+	ID  string
+	Key string
+}
+
+// KeyStore resolves a presented API key to its identity
+type KeyStore interface {
+	Lookup(key string) (id string, ok bool)
+}
+
+// StaticKeyStore is an in-memory KeyStore that can be replaced at runtime.
+// Keys are kept as SHA-256 digests and compared in constant time.
+type StaticKeyStore struct {
+	mu   sync.RWMutex
+	keys []hashedKey
+}
+
+type hashedKey struct {
+	id     string
+	digest [sha256.Size]byte
+}
+
+func NewStaticKeyStore(keys []APIKey) *StaticKeyStore {
+	s := &StaticKeyStore{}
+	s.Set(keys)
+	return s
+}
+
+// Set replaces the full key set
+func (s *StaticKeyStore) Set(keys []APIKey) {
+	hashed := make([]hashedKey, 0, len(keys))
+	for _, k := range keys {
+		hashed = append(hashed, hashedKey{id: k.ID, digest: sha256.Sum256([]byte(k.Key))})
+	}
+
+	s.mu.Lock()
+	s.keys = hashed
+	s.mu.Unlock()
+}
+
+// Len returns the number of configured keys
+func (s *StaticKeyStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// Lookup compares the key against every entry so timing does not reveal
+// which, or whether any, key matched.
+func (s *StaticKeyStore) Lookup(key string) (string, bool) {
+	digest := sha256.Sum256([]byte(key))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var id string
+	found := 0
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare(digest[:], k.digest[:]) == 1 {
+			id = k.id
+			found = 1
+		}
+	}
+	return id, found == 1
+}
+
+// ParseAPIKeys parses a comma or newline separated list of id:key pairs
+func ParseAPIKeys(value string) ([]APIKey, error) {
+	var keys []APIKey
+	for i, entry := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, key, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %d, expected id:key", i+1)
+		}
+		keys = append(keys, APIKey{ID: id, Key: key})
+	}
+	return keys, nil
+}
+
+// LoadAPIKeysFile reads id:key pairs, one per line, from path
+func LoadAPIKeysFile(path string) ([]APIKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open API keys file: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read API keys file: %w", err)
+	}
+	return ParseAPIKeys(strings.Join(lines, "\n"))
+}
+
+// APIKeyAuth rejects requests without a valid key in either the
+// Authorization: Bearer or X-API-Key header
+func APIKeyAuth(store KeyStore) Middleware {
+	return keyAuth(store, presentedAPIKey)
+}
+
+// WriteKeyAuth is APIKeyAuth also accepting the key as a Basic
+// Authorization header's user name, as Segment's HTTP API sends write
+// keys, or as the writeKey field of a JSON body, where Segment's
+// analytics.js sends it. The body is read whole, so routes limit its size
+// first.
+func WriteKeyAuth(store KeyStore) Middleware {
+	return keyAuth(store, func(r *http.Request) string {
+		if key := presentedAPIKey(r); key != "" {
+			return key
+		}
+		if user, _, ok := r.BasicAuth(); ok {
+			return user
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+		var payload struct {
+			WriteKey string `json:"writeKey"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		return payload.WriteKey
+	})
+}
+
+// QueryKeyAuth is APIKeyAuth also accepting the key as the query parameter
+// param, where the GA4 Measurement Protocol sends its API secret. Request
+// logs and spans record the path only, so the key is not kept.
+func QueryKeyAuth(store KeyStore, param string) Middleware {
+	return keyAuth(store, func(r *http.Request) string {
+		if key := presentedAPIKey(r); key != "" {
+			return key
+		}
+		return r.URL.Query().Get(param)
+	})
+}
+
+func keyAuth(store KeyStore, presented func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := presented(r)
+			if key == "" {
+				recordDenial(r, "api_key", "missing")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="worker"`)
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Missing API key"})
+				return
+			}
+
+			id, ok := store.Lookup(key)
+			if !ok {
+				recordDenial(r, "api_key", "invalid")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="worker", error="invalid_token"`)
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Invalid API key"})
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("auth.key_id", id))
+
+			ctx := context.WithValue(r.Context(), apiKeyIdentityKey, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// APIKeyIdentity returns the identity of the API key that authenticated the request
+func APIKeyIdentity(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(apiKeyIdentityKey).(string)
+	return id, ok
+}
+
+func presentedAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CacheStatusHeader reports whether a response was served from the cache
+const CacheStatusHeader = "X-Cache"
+
+// ResponseCacheConfig configures ResponseCache
+type ResponseCacheConfig struct {
+	TTL        time.Duration
+	MaxEntries int
+}
+
+// ResponseCache keeps successful GET and HEAD responses for TTL, keyed by
+// method, path and normalized query. Concurrent misses for the same key
+// share a single handler call so a burst of dashboard polls computes the
+// response once.
+func ResponseCache(cfg ResponseCacheConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		if cfg.TTL <= 0 {
+			return next
+		}
+		if cfg.MaxEntries <= 0 {
+			cfg.MaxEntries = 1024
+		}
+
+		c := &responseCache{
+			ttl:     cfg.TTL,
+			max:     cfg.MaxEntries,
+			entries: make(map[string]*cachedResponse),
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			span := trace.SpanFromContext(r.Context())
+			key := cacheKey(r)
+
+			if cached, ok := c.get(key, time.Now()); ok {
+				span.SetAttributes(attribute.String("cache.result", "hit"))
+				w.Header().Set(CacheStatusHeader, "HIT")
+				cached.writeTo(w)
+				return
+			}
+
+			resp, shared := c.flight.do(key, next, r, func(resp *cachedResponse) {
+				if resp.statusCode == http.StatusOK {
+					resp.expires = time.Now().Add(c.ttl)
+					c.set(key, resp)
+				}
+			})
+
+			result := "miss"
+			if shared {
+				result = "shared"
+			}
+			span.SetAttributes(attribute.String("cache.result", result))
+			w.Header().Set(CacheStatusHeader, "MISS")
+			resp.writeTo(w)
+		})
+	}
+}
+
+// cacheKey normalizes the query so parameter order does not split entries,
+// and includes the negotiated format and version so each representation
+// is cached separately, and the tenant so tenants never share one
+func cacheKey(r *http.Request) string {
+	ctx := r.Context()
+	return r.Method + " " + r.URL.Path + "?" + r.URL.Query().Encode() +
+		" " + NegotiatedFormat(ctx) + " " + APIVersion(ctx) +
+		" " + service.TenantFromContext(ctx)
+}
+
+type responseCache struct {
+	ttl    time.Duration
+	max    int
+	flight flightGroup
+
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+func (c *responseCache) get(key string, now time.Time) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(resp.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return resp, true
+}
+
+// set stores resp, dropping expired entries first when the cache is full.
+// If every entry is still fresh the response is simply not cached.
+func (c *responseCache) set(key string, resp *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.max {
+		now := time.Now()
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return
+		}
+	}
+	c.entries[key] = resp
+}
+
+// cachedResponse is an immutable snapshot of a handler's response
+type cachedResponse struct {
+	header     http.Header
+	body       []byte
+	statusCode int
+	expires    time.Time
+}
+
+func (c *cachedResponse) writeTo(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, v := range c.header {
+		dst[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(c.statusCode)
+	_, _ = w.Write(c.body)
+}
+
+// recordingWriter captures a response without writing it anywhere
+type recordingWriter struct {
+	header      http.Header
+	buf         bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+}
+
+func (rw *recordingWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.statusCode = code
+	rw.wroteHeader = true
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.buf.Write(b)
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientCertAuth answers 403 Forbidden for requests whose TLS connection
+// did not present a client certificate verified against the server's
+// client CAs
+func ClientCertAuth() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				recordDenial(r, "client_cert", "missing")
+				WriteProblem(w, r, Problem{Status: http.StatusForbidden, Detail: "Client certificate required"})
+				return
+			}
+
+			leaf := r.TLS.VerifiedChains[0][0]
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("auth.client_cert", leaf.Subject.CommonName))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
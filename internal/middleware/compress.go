@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(io.Discard) },
+}
+
+// Compress gzips responses for clients that accept it. Responses that are
+// already encoded, bodiless or answering HEAD are passed through untouched.
+func Compress() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if r.Method == http.MethodHead || acceptQuality(encodingRanges(r.Header.Get("Accept-Encoding")), "gzip/") <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// encodingRanges rewrites Accept-Encoding tokens as media ranges so
+// acceptQuality can weigh them, e.g. "gzip;q=0.5, *" → "gzip/;q=0.5, */*"
+func encodingRanges(header string) string {
+	parts := strings.Split(header, ",")
+	for i, part := range parts {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding == "*" {
+			coding = "*/*"
+		} else {
+			coding = strings.ToLower(coding) + "/"
+		}
+		if params != "" {
+			coding += ";" + params
+		}
+		parts[i] = coding
+	}
+	return strings.Join(parts, ",")
+}
+
+// compressWriter decides on the first write whether to gzip the body
+type compressWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		// The encoded body differs byte for byte, so a strong validator no longer holds
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		cw.gz = gzipWriters.Get().(*gzip.Writer)
+		cw.gz.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// FlushError sends what has been compressed so far to the client, so
+// streamed responses still arrive chunk by chunk
+func (cw *compressWriter) FlushError() error {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		if err := cw.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Flush is FlushError for handlers that check for http.Flusher
+func (cw *compressWriter) Flush() {
+	_ = cw.FlushError()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// deadlines and hijacking; flushing goes through FlushError
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if cw.gz == nil {
+		return
+	}
+	_ = cw.gz.Close()
+	gzipWriters.Put(cw.gz)
+	cw.gz = nil
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CORSConfig describes which cross-origin requests are allowed. Origins are
+// matched exactly, as "*" for any origin, or with a single wildcard label
+// such as "https://*.example.com" for subdomains.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+type originPattern struct {
+	prefix string
+	suffix string
+}
+
+// CORSPolicy holds a CORS configuration that can be replaced at runtime
+type CORSPolicy struct {
+	compiled atomic.Pointer[compiledCORS]
+}
+
+func NewCORSPolicy(cfg CORSConfig) *CORSPolicy {
+	p := &CORSPolicy{}
+	p.Set(cfg)
+	return p
+}
+
+// Set replaces the configuration for subsequent requests
+func (p *CORSPolicy) Set(cfg CORSConfig) {
+	p.compiled.Store(compileCORS(cfg))
+}
+
+// compiledCORS is a CORSConfig with origins parsed and headers joined
+type compiledCORS struct {
+	cfg       CORSConfig
+	anyOrigin bool
+	exact     map[string]bool
+	patterns  []originPattern
+	methods   string
+	headers   string
+	exposed   string
+	maxAge    string
+}
+
+func compileCORS(cfg CORSConfig) *compiledCORS {
+	c := &compiledCORS{
+		cfg:     cfg,
+		exact:   make(map[string]bool),
+		methods: strings.Join(cfg.AllowedMethods, ", "),
+		headers: strings.Join(cfg.AllowedHeaders, ", "),
+		exposed: strings.Join(cfg.ExposedHeaders, ", "),
+		maxAge:  strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			c.patterns = append(c.patterns, originPattern{prefix: prefix, suffix: suffix})
+		case origin != "":
+			c.exact[origin] = true
+		}
+	}
+	return c
+}
+
+func (c *compiledCORS) allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if c.anyOrigin || c.exact[origin] {
+		return true
+	}
+	for _, p := range c.patterns {
+		if len(origin) > len(p.prefix)+len(p.suffix) &&
+			strings.HasPrefix(origin, p.prefix) &&
+			strings.HasSuffix(origin, p.suffix) &&
+			!strings.ContainsAny(origin[len(p.prefix):len(origin)-len(p.suffix)], "/:") {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS handles Cross-Origin Resource Sharing with a fixed configuration
+func CORS(cfg CORSConfig) Middleware {
+	return DynamicCORS(NewCORSPolicy(cfg))
+}
+
+// DynamicCORS handles Cross-Origin Resource Sharing with the policy's
+// current configuration
+func DynamicCORS(p *CORSPolicy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c := p.compiled.Load()
+			h := w.Header()
+			h.Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions
+
+			if origin != "" && c.allowed(origin) {
+				if c.anyOrigin && !c.cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+				if c.cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+				if c.exposed != "" && !preflight {
+					h.Set("Access-Control-Expose-Headers", c.exposed)
+				}
+				if preflight {
+					h.Add("Vary", "Access-Control-Request-Method")
+					h.Add("Vary", "Access-Control-Request-Headers")
+					h.Set("Access-Control-Allow-Methods", c.methods)
+					h.Set("Access-Control-Allow-Headers", c.headers)
+					if c.cfg.MaxAge > 0 {
+						h.Set("Access-Control-Max-Age", c.maxAge)
+					}
+				}
+			}
+
+			// Disallowed origins get no CORS headers, which makes the
+			// browser block the response
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
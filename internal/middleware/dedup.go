@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// Deduplicate collapses concurrent identical GET and HEAD requests (same
+// path, query and negotiated representation) into a single handler call
+// whose response is written to every waiter. Nothing is kept once the call
+// returns. The key ignores credentials, though not the tenant, so only use
+// it on routes whose response does not otherwise depend on the caller.
+// ResponseCache already collapses concurrent misses, so it needs no
+// Deduplicate in front of it.
+func Deduplicate() Middleware {
+	return func(next http.Handler) http.Handler {
+		var flight flightGroup
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			resp, shared := flight.do(cacheKey(r), next, r, nil)
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("dedup.shared", shared))
+			resp.writeTo(w)
+		})
+	}
+}
+
+// flightGroup runs one handler call per key for concurrent callers
+type flightGroup struct {
+	group singleflight.Group
+}
+
+// do records next's response once for every concurrent caller with key.
+// The call is detached from the leader's cancellation so one client going
+// away does not fail the others. done, if set, runs before waiters are
+// released.
+func (f *flightGroup) do(key string, next http.Handler, r *http.Request, done func(*cachedResponse)) (*cachedResponse, bool) {
+	v, _, shared := f.group.Do(key, func() (interface{}, error) {
+		rec := &recordingWriter{header: make(http.Header), statusCode: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithoutCancel(r.Context())))
+
+		resp := &cachedResponse{
+			header:     rec.header,
+			body:       rec.buf.Bytes(),
+			statusCode: rec.statusCode,
+		}
+		if done != nil {
+			done(resp)
+		}
+		return resp, nil
+	})
+	return v.(*cachedResponse), shared
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// ETag buffers successful GET responses, tags them with a content
+// hash and answers 304 Not Modified when the client's If-None-Match matches
+func ETag() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bw := &bufferedWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(bw, r)
+
+			if bw.statusCode != http.StatusOK {
+				bw.flush()
+				return
+			}
+
+			etag := w.Header().Get("ETag")
+			if etag == "" {
+				sum := sha256.Sum256(bw.buf.Bytes())
+				etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+				w.Header().Set("ETag", etag)
+			}
+
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				h := w.Header()
+				h.Del("Content-Type")
+				h.Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			bw.flush()
+		})
+	}
+}
+
+// CacheControl sets the Cache-Control header unless the handler overrides it
+func CacheControl(value string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if value != "" {
+				w.Header().Set("Cache-Control", value)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// etagMatches implements the weak comparison If-None-Match requires
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds the status and body until flush
+type bufferedWriter struct {
+	http.ResponseWriter
+	buf        bytes.Buffer
+	statusCode int
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+	bw.statusCode = code
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	return bw.buf.Write(b)
+}
+
+// FlushError does nothing, as the body is only sent once it is complete
+// and its ETag known
+func (bw *bufferedWriter) FlushError() error {
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer for
+// deadlines; flushing goes through FlushError
+func (bw *bufferedWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+func (bw *bufferedWriter) flush() {
+	bw.ResponseWriter.WriteHeader(bw.statusCode)
+	_, _ = bw.ResponseWriter.Write(bw.buf.Bytes())
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// IPFilter holds allow and deny lists that can be replaced at runtime.
+// Deny entries always win; a non-empty allow list admits only matching
+// clients.
+type IPFilter struct {
+	mu    sync.RWMutex
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func NewIPFilter(allow, deny []netip.Prefix) *IPFilter {
+	return &IPFilter{allow: allow, deny: deny}
+}
+
+// Set replaces both lists
+func (f *IPFilter) Set(allow, deny []netip.Prefix) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.allow = allow
+	f.deny = deny
+}
+
+// Rules returns copies of the current allow and deny lists
+func (f *IPFilter) Rules() (allow, deny []netip.Prefix) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return append([]netip.Prefix(nil), f.allow...), append([]netip.Prefix(nil), f.deny...)
+}
+
+// Allowed reports whether addr passes the filter
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, p := range f.deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAccess answers 403 Forbidden for clients rejected by the filter. It
+// relies on RealIP having resolved the client address; a client without a
+// valid one, as behind a Unix socket proxy sending no forwarding headers,
+// only passes when there is no allow list.
+func IPAccess(f *IPFilter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+			if !f.Allowed(ip) {
+				trace.SpanFromContext(r.Context()).SetAttributes(attribute.Bool("client.denied", true))
+				recordDenial(r, "ip_filter", "client address not allowed")
+				slog.DebugContext(r.Context(), "Denied client by IP filter", "client_ip", ip.String(), "path", r.URL.Path)
+				WriteProblem(w, r, Problem{Status: http.StatusForbidden, Detail: "Client address not allowed"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// jwksMinRefresh bounds how often the JWKS is fetched, whether for an
+// unknown kid, an expired key set or a retry after a failed fetch
+const jwksMinRefresh = 30 * time.Second
+
+// JWTConfig configures token verification. At least one of HMACSecret,
+// RSAPublicKey or JWKSURL must be set.
+type JWTConfig struct {
+	HMACSecret   []byte
+	RSAPublicKey *rsa.PublicKey
+	JWKSURL      string
+	JWKSCacheTTL time.Duration
+	Issuer       string
+	Audience     string
+	Leeway       time.Duration
+	HTTPClient   *http.Client
+}
+
+// JWTClaims holds the decoded payload of a verified token
+type JWTClaims map[string]interface{}
+
+// Subject returns the "sub" claim
+func (c JWTClaims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+// JWTVerifier validates HS256 and RS256 signed tokens
+type JWTVerifier struct {
+	cfg  JWTConfig
+	jwks *jwksCache
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if len(cfg.HMACSecret) == 0 && cfg.RSAPublicKey == nil && cfg.JWKSURL == "" {
+		return nil, errors.New("JWT verification needs an HMAC secret, RSA public key or JWKS URL")
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.JWKSCacheTTL <= 0 {
+		cfg.JWKSCacheTTL = time.Hour
+	}
+
+	v := &JWTVerifier{cfg: cfg}
+	if cfg.JWKSURL != "" {
+		v.jwks = &jwksCache{url: cfg.JWKSURL, ttl: cfg.JWKSCacheTTL, client: cfg.HTTPClient}
+	}
+	return v, nil
+}
+
+// Verify checks the token signature and registered claims
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if len(v.cfg.HMACSecret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, v.cfg.HMACSecret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errors.New("signature mismatch")
+		}
+	case "RS256":
+		key, err := v.rsaKey(ctx, header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("signature mismatch")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	var claims JWTClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	if err := v.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) rsaKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if v.jwks != nil && (kid != "" || v.cfg.RSAPublicKey == nil) {
+		return v.jwks.key(ctx, kid)
+	}
+	if v.cfg.RSAPublicKey != nil {
+		return v.cfg.RSAPublicKey, nil
+	}
+	return nil, errors.New("RS256 tokens are not accepted")
+}
+
+func (v *JWTVerifier) validateClaims(claims JWTClaims, now time.Time) error {
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(v.cfg.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.cfg.Leeway).Before(nbf) {
+		return errors.New("token not yet valid")
+	}
+
+	if v.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return errors.New("token not issued for this audience")
+	}
+	return nil
+}
+
+// JWTAuth rejects requests without a valid Authorization: Bearer token
+func JWTAuth(v *JWTVerifier) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+				recordDenial(r, "jwt", "missing")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="worker"`)
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Missing bearer token"})
+				return
+			}
+
+			claims, err := v.Verify(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				slog.WarnContext(r.Context(), "Rejected bearer token", "error", err, "path", r.URL.Path)
+				recordDenial(r, "jwt", "invalid")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="worker", error="invalid_token"`)
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Invalid bearer token"})
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("auth.subject", claims.Subject()))
+
+			ctx := context.WithValue(r.Context(), jwtClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// JWTClaimsFromContext returns the claims of the token that authenticated the request
+func JWTClaimsFromContext(ctx context.Context) (JWTClaims, bool) {
+	claims, ok := ctx.Value(jwtClaimsKey).(JWTClaims)
+	return claims, ok
+}
+
+// LoadRSAPublicKey reads a PEM encoded RSA public key or certificate
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key file contains no PEM block")
+	}
+
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate: %w", err)
+		}
+		pub = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return key, nil
+}
+
+// jwksCache fetches and caches RSA signing keys from a JWKS endpoint.
+// Fetches run outside the lock, one at a time, and at most every
+// jwksMinRefresh. A stale key set is refreshed in the background, so
+// verifying with a cached key never waits on the identity provider; only
+// a key missing from the cache waits for a fetch.
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	fetches singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetched   time.Time
+	attempted time.Time
+}
+
+func (c *jwksCache) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	keys := c.keys
+	stale := time.Since(c.fetched) > c.ttl
+	// Failed fetches back off too, so an outage does not cost every
+	// request a fetch
+	due := time.Since(c.attempted) > jwksMinRefresh
+	c.mu.Unlock()
+
+	key, known := lookupKey(keys, kid)
+	switch {
+	case !due:
+	case known && stale:
+		go func() {
+			if _, err := c.refresh(ctx); err != nil {
+				slog.Warn("Failed to refresh JWKS", "url", c.url, "error", err)
+			}
+		}()
+	case !known:
+		refreshed, err := c.refresh(ctx)
+		if err != nil {
+			slog.Warn("Failed to refresh JWKS", "url", c.url, "error", err)
+			if keys == nil {
+				return nil, err
+			}
+			break
+		}
+		keys = refreshed
+		key, known = lookupKey(keys, kid)
+	}
+
+	if !known {
+		if keys == nil {
+			return nil, errors.New("JWKS not fetched yet")
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey finds the key named kid, or the only key when kid is empty
+func lookupKey(keys map[string]*rsa.PublicKey, kid string) (*rsa.PublicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// refresh fetches the key set, sharing one fetch between concurrent
+// callers, and caches it
+func (c *jwksCache) refresh(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	// The fetch outlives a caller that gives up, as others may share it
+	ctx = context.WithoutCancel(ctx)
+	keys, err, _ := c.fetches.Do("", func() (interface{}, error) {
+		c.mu.Lock()
+		c.attempted = time.Now()
+		c.mu.Unlock()
+
+		keys, err := c.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.keys, c.fetched = keys, time.Now()
+		c.mu.Unlock()
+		return keys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys.(map[string]*rsa.PublicKey), nil
+}
+
+func (c *jwksCache) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func numericClaim(claims JWTClaims, name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+func hasAudience(aud interface{}, want string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == want
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceMode is a runtime switch that takes public routes offline
+type MaintenanceMode struct {
+	mu         sync.RWMutex
+	enabled    bool
+	message    string
+	retryAfter time.Duration
+	since      time.Time
+}
+
+// MaintenanceStatus is the externally visible maintenance state
+type MaintenanceStatus struct {
+	Enabled    bool       `json:"enabled"`
+	Message    string     `json:"message"`
+	RetryAfter int        `json:"retry_after_seconds"`
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Down for maintenance</title>
+</head>
+<body>
+    <h1>Down for maintenance</h1>
+    <p>{{.Message}}</p>
+</body>
+</html>`))
+
+func NewMaintenanceMode(enabled bool, message string, retryAfter time.Duration) *MaintenanceMode {
+	m := &MaintenanceMode{retryAfter: retryAfter}
+	m.Set(enabled, message)
+	return m
+}
+
+// Set switches maintenance mode on or off; an empty message keeps the current one
+func (m *MaintenanceMode) Set(enabled bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	m.enabled = enabled
+	if message != "" {
+		m.message = message
+	}
+	if m.message == "" {
+		m.message = "The service is undergoing maintenance. Please try again shortly."
+	}
+}
+
+// Status returns the current maintenance state
+func (m *MaintenanceMode) Status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := MaintenanceStatus{
+		Enabled:    m.enabled,
+		Message:    m.message,
+		RetryAfter: int(m.retryAfter.Seconds()),
+	}
+	if m.enabled {
+		since := m.since
+		status.Since = &since
+	}
+	return status
+}
+
+// Maintenance answers 503 Service Unavailable while maintenance mode is on.
+// API clients get a JSON notice, browsers an HTML page.
+func Maintenance(m *MaintenanceMode) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := m.Status()
+			if !status.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recordDenial(r, "maintenance", "maintenance mode on")
+
+			if status.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(status.RetryAfter))
+			}
+			w.Header().Set("Cache-Control", "no-store")
+
+			if strings.HasPrefix(r.URL.Path, "/api/") || strings.Contains(r.Header.Get("Accept"), "application/json") {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"status":  "maintenance",
+					"message": status.Message,
+				})
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = maintenancePage.Execute(w, status)
+		})
+	}
+}
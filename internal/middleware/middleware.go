@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"bufio"
+	"context"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Middleware func(http.Handler) http.Handler
@@ -37,37 +41,23 @@ func RequestLogger() Middleware {
 			duration := time.Since(start)
 
 			// Log request details
-			slog.Info("HTTP request",
+			slog.InfoContext(r.Context(), "HTTP request",
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", wrapped.statusCode,
 				"duration_ms", duration.Milliseconds(),
 				"user_agent", r.UserAgent(),
 				"remote_addr", r.RemoteAddr,
+				"client_ip", ClientIP(r).String(),
+				"request_id", RequestIDFromContext(r.Context()),
 			)
 		})
 	}
 }
 
-// CORS handles Cross-Origin Resource Sharing
-func CORS() Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-// MetricsCollector collects custom metrics
+// MetricsCollector collects custom metrics. Requests are labelled with the
+// mux pattern that matched them, so arbitrary paths share a series; the
+// raw path is only used outside a mux and stays on the span.
 func MetricsCollector(svc *service.Service) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -80,35 +70,74 @@ func MetricsCollector(svc *service.Service) Middleware {
 			// Wrap ResponseWriter to capture status code
 			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
+			// Handlers report the code of the error they answered with
+			var errorCode string
+			ctx = context.WithValue(ctx, errorCodeKey{}, &errorCode)
+
 			// Process request
 			next.ServeHTTP(wrapped, r.WithContext(ctx))
 
 			// Calculate metrics
 			duration := time.Since(start)
 
+			route := r.Pattern
+			if route == "" {
+				route = r.URL.Path
+			}
+
+			if errorCode == "" {
+				errorCode = service.StatusErrorCode(wrapped.statusCode)
+			}
+
 			// Record metrics through service
-			svc.RecordHTTPMetrics(ctx, r.Method, r.URL.Path, wrapped.statusCode, duration)
+			svc.RecordHTTPMetrics(ctx, r.Method, route, wrapped.statusCode, errorCode, duration)
 
 			// Add span attributes
 			span.SetAttributes(
 				attribute.String("http.method", r.Method),
 				attribute.String("http.path", r.URL.Path),
+				attribute.String("http.route", route),
 				attribute.Int("http.status_code", wrapped.statusCode),
 				attribute.Int64("http.duration_ms", duration.Milliseconds()),
 			)
+			if errorCode != "" {
+				span.SetAttributes(attribute.String("error_code", errorCode))
+			}
 		})
 	}
 }
 
-// Security adds basic security headers
-func Security() Middleware {
+// recordDenial adds an event to the request span saying which check turned
+// the request away and why, so admission decisions appear in its trace
+func recordDenial(r *http.Request, check, reason string) {
+	trace.SpanFromContext(r.Context()).AddEvent("request.denied", trace.WithAttributes(
+		attribute.String("denial.check", check),
+		attribute.String("denial.reason", reason),
+	))
+}
+
+type errorCodeKey struct{}
+
+// SetErrorCode records the service error code a request was answered with,
+// for the error_code metric attribute. Responses without one get a code
+// derived from their status.
+func SetErrorCode(ctx context.Context, code string) {
+	if p, ok := ctx.Value(errorCodeKey{}).(*string); ok {
+		*p = code
+	}
+}
+
+// MaxBodySize limits request bodies to limit bytes; reads beyond it fail
+// with *http.MaxBytesError so handlers can answer 413
+func MaxBodySize(limit int64) Middleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("X-Frame-Options", "DENY")
-			w.Header().Set("X-XSS-Protection", "1; mode=block")
-			w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-
+			if r.ContentLength > limit {
+				recordDenial(r, "body_size", "content length over limit")
+				WriteProblem(w, r, Problem{Status: http.StatusRequestEntityTooLarge, Detail: "Request body too large"})
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -117,10 +146,36 @@ func Security() Middleware {
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode  int
+	wroteHeader bool
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
+	rw.wroteHeader = true
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streamed responses can be flushed
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Flush sends buffered data to the client, for handlers that check for
+// http.Flusher rather than using http.ResponseController
+func (rw *responseWriter) Flush() {
+	rw.wroteHeader = true
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection to the handler, for handlers that check for
+// http.Hijacker rather than using http.ResponseController
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(rw.ResponseWriter).Hijack()
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Response formats a route can offer through Negotiate
+const (
+	FormatJSON     = "application/json"
+	FormatNDJSON   = "application/x-ndjson"
+	FormatProtobuf = "application/protobuf"
+)
+
+// APIVersionHeader selects, and reports, the API version of a response
+const APIVersionHeader = "API-Version"
+
+// NegotiationConfig lists what a route can serve. The first format and
+// version are used when the client expresses no preference.
+type NegotiationConfig struct {
+	Formats  []string
+	Versions []string
+}
+
+// Negotiate picks the response format from the Accept header and the API
+// version from the API-Version header, answering 406 or 400 when neither
+// can be satisfied. Handlers read the outcome with NegotiatedFormat and
+// APIVersion.
+func Negotiate(cfg NegotiationConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept")
+			w.Header().Add("Vary", APIVersionHeader)
+
+			format, ok := selectFormat(r.Header.Get("Accept"), cfg.Formats)
+			if !ok {
+				WriteProblem(w, r, Problem{Status: http.StatusNotAcceptable, Detail: "Supported formats: " + strings.Join(cfg.Formats, ", ")})
+				return
+			}
+
+			version := ""
+			if len(cfg.Versions) > 0 {
+				version = cfg.Versions[0]
+			}
+			if requested := strings.TrimSpace(r.Header.Get(APIVersionHeader)); requested != "" {
+				if !slices.Contains(cfg.Versions, requested) {
+					WriteProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Unsupported API version " + strconv.Quote(requested)})
+					return
+				}
+				version = requested
+			}
+			if version != "" {
+				w.Header().Set(APIVersionHeader, version)
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(
+				attribute.String("http.response.format", format),
+				attribute.String("api.version", version),
+			)
+
+			ctx := context.WithValue(r.Context(), formatKey, format)
+			ctx = context.WithValue(ctx, apiVersionKey, version)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// NegotiatedFormat returns the media type chosen for the response, or JSON
+// when the route does not negotiate
+func NegotiatedFormat(ctx context.Context) string {
+	if format, ok := ctx.Value(formatKey).(string); ok {
+		return format
+	}
+	return FormatJSON
+}
+
+// APIVersion returns the API version chosen for the response
+func APIVersion(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionKey).(string)
+	return version
+}
+
+// selectFormat returns the supported format with the highest quality in
+// accept. Ties go to the earlier entry in supported.
+func selectFormat(accept string, supported []string) (string, bool) {
+	if len(supported) == 0 {
+		return FormatJSON, true
+	}
+	if strings.TrimSpace(accept) == "" {
+		return supported[0], true
+	}
+
+	best, bestQ := "", 0.0
+	for _, format := range supported {
+		if q := acceptQuality(accept, format); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// acceptQuality returns the q value the most specific matching media range
+// in accept assigns to mediaType
+func acceptQuality(accept, mediaType string) float64 {
+	typ, sub, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rangeType, rangeSub, _ := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+
+		var s int
+		switch {
+		case rangeType == typ && rangeSub == sub:
+			s = 2
+		case rangeType == typ && rangeSub == "*":
+			s = 1
+		case rangeType == "*" && rangeSub == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		value := 1.0
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					value = f
+				}
+			}
+		}
+		q, specificity = value, s
+	}
+	return q
+}
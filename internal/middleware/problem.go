@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// Problem is an RFC 7807 problem details response body
+type Problem struct {
+	Type          string               `json:"type"`
+	Title         string               `json:"title"`
+	Status        int                  `json:"status"`
+	Detail        string               `json:"detail,omitempty"`
+	Instance      string               `json:"instance,omitempty"`
+	Code          string               `json:"code,omitempty"`
+	InvalidParams []service.FieldError `json:"invalid-params,omitempty"`
+}
+
+// WriteProblem writes p as an application/problem+json response. Without
+// a code it gets the one for its status; either way the code is recorded
+// for the request's metrics.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.Code == "" {
+		p.Code = service.StatusErrorCode(p.Status)
+	}
+	SetErrorCode(r.Context(), p.Code)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("Failed to encode problem response", "error", err)
+	}
+}
+
+// WriteError answers with the status and code of the sentinel err wraps.
+// Server errors keep their message out of the response.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	p := Problem{Status: service.HTTPStatus(err), Code: service.ErrorCode(err)}
+	if p.Status < http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+	WriteProblem(w, r, p)
+}
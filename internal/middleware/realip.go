@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ParsePrefixes parses a list of CIDRs or bare IP addresses
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", v, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %w", v, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// RealIP resolves the originating client address. Forwarded, X-Forwarded-For
+// and X-Real-IP are only honored when the direct peer is a trusted proxy;
+// the forwarding chain is walked from the right and the first untrusted hop
+// is taken as the client. Peers connecting over a Unix socket are local
+// processes, a reverse proxy in front of the worker, so they are trusted
+// too; without forwarding headers their clients have no valid address.
+func RealIP(trusted []netip.Prefix) Middleware {
+	isTrusted := func(addr netip.Addr) bool {
+		return containsAddr(trusted, addr)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP := resolveClientIP(r, isTrusted)
+
+			if clientIP.IsValid() {
+				trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("client.address", clientIP.String()))
+				ctx := context.WithValue(r.Context(), clientIPKey, clientIP)
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP returns the address resolved by RealIP, falling back to the
+// direct peer address when the middleware did not run
+func ClientIP(r *http.Request) netip.Addr {
+	if addr, ok := r.Context().Value(clientIPKey).(netip.Addr); ok {
+		return addr
+	}
+	addr, _ := parseHostAddr(r.RemoteAddr)
+	return addr
+}
+
+func resolveClientIP(r *http.Request, isTrusted func(netip.Addr) bool) netip.Addr {
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	if !unixPeer(r) && (!ok || !isTrusted(peer)) {
+		return peer
+	}
+
+	chain := forwardedFor(r.Header.Values("Forwarded"))
+	if len(chain) == 0 {
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			chain = append(chain, strings.Split(v, ",")...)
+		}
+	}
+
+	if len(chain) == 0 {
+		if addr, ok := parseHostAddr(r.Header.Get("X-Real-IP")); ok {
+			return addr
+		}
+		return peer
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseHostAddr(chain[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// trustedPeer reports whether the request's direct peer is one of trusted
+// or connected over a Unix socket
+func trustedPeer(r *http.Request, trusted []netip.Prefix) bool {
+	if unixPeer(r) {
+		return true
+	}
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	return ok && containsAddr(trusted, peer)
+}
+
+// unixPeer reports whether the request arrived on a Unix socket listener
+func unixPeer(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers
+func forwardedFor(values []string) []string {
+	var result []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					result = append(result, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return result
+}
+
+// parseHostAddr accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port"
+func parseHostAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
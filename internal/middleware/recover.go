@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recover turns a panicking handler into a 500 response instead of letting
+// it take down the process. The stack is recorded on the active span and in
+// the log, and worker_panics_total is incremented.
+func Recover() Middleware {
+	panics, _ := otel.Meter("worker-middleware").Int64Counter("worker_panics_total",
+		metric.WithDescription("Total number of recovered handler panics"))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				// Deliberate aborts must keep propagating to net/http
+				if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(rec)
+				}
+
+				stack := string(debug.Stack())
+				err := fmt.Errorf("panic: %v", rec)
+
+				span := trace.SpanFromContext(r.Context())
+				span.RecordError(err, trace.WithAttributes(attribute.String("exception.stacktrace", stack)))
+				span.SetStatus(codes.Error, "handler panicked")
+
+				panics.Add(r.Context(), 1, metric.WithAttributes(
+					attribute.String("method", r.Method),
+				))
+
+				slog.ErrorContext(r.Context(), "Recovered from handler panic",
+					"error", err,
+					"method", r.Method,
+					"path", r.URL.Path,
+					"request_id", RequestIDFromContext(r.Context()),
+					"stack", stack,
+				)
+
+				if !wrapped.wroteHeader {
+					WriteProblem(wrapped, r, Problem{Status: http.StatusInternalServerError})
+				}
+			}()
+
+			next.ServeHTTP(wrapped, r)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSPNoncePlaceholder is replaced with a fresh per-request nonce in the
+// Content-Security-Policy header
+const CSPNoncePlaceholder = "{nonce}"
+
+// SecurityPolicy describes the security headers sent with a response. Empty
+// values omit the header. Overrides set or, with an empty value, remove
+// arbitrary headers and are applied last.
+type SecurityPolicy struct {
+	ContentSecurityPolicy     string
+	HSTSMaxAge                time.Duration
+	HSTSIncludeSubdomains     bool
+	HSTSPreload               bool
+	FrameOptions              string
+	ReferrerPolicy            string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+	PermissionsPolicy         string
+	Overrides                 map[string]string
+}
+
+// Security adds the configured security headers. When the CSP contains
+// CSPNoncePlaceholder a random nonce is generated per request and made
+// available to handlers via CSPNonce.
+func Security(policy SecurityPolicy) Middleware {
+	static := http.Header{}
+	static.Set("X-Content-Type-Options", "nosniff")
+	static.Set("X-XSS-Protection", "0")
+	setIfNotEmpty(static, "X-Frame-Options", policy.FrameOptions)
+	setIfNotEmpty(static, "Referrer-Policy", policy.ReferrerPolicy)
+	setIfNotEmpty(static, "Cross-Origin-Opener-Policy", policy.CrossOriginOpenerPolicy)
+	setIfNotEmpty(static, "Cross-Origin-Embedder-Policy", policy.CrossOriginEmbedderPolicy)
+	setIfNotEmpty(static, "Cross-Origin-Resource-Policy", policy.CrossOriginResourcePolicy)
+	setIfNotEmpty(static, "Permissions-Policy", policy.PermissionsPolicy)
+
+	if policy.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.Itoa(int(policy.HSTSMaxAge.Seconds()))
+		if policy.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if policy.HSTSPreload {
+			hsts += "; preload"
+		}
+		static.Set("Strict-Transport-Security", hsts)
+	}
+
+	csp := policy.ContentSecurityPolicy
+	if v, ok := policy.Overrides["Content-Security-Policy"]; ok {
+		csp = v
+	}
+	for name, value := range policy.Overrides {
+		if strings.EqualFold(name, "Content-Security-Policy") {
+			continue
+		}
+		if value == "" {
+			static.Del(name)
+		} else {
+			static.Set(name, value)
+		}
+	}
+	useNonce := strings.Contains(csp, CSPNoncePlaceholder)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for name, values := range static {
+				h[name] = values
+			}
+
+			if useNonce {
+				nonce := newCSPNonce()
+				h.Set("Content-Security-Policy", strings.ReplaceAll(csp, CSPNoncePlaceholder, nonce))
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+			} else if csp != "" {
+				h.Set("Content-Security-Policy", csp)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSPNonce returns the nonce allowed by this response's Content-Security-Policy
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey).(string)
+	return nonce
+}
+
+func newCSPNonce() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+func setIfNotEmpty(h http.Header, name, value string) {
+	if value != "" {
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers carrying the request signature
+const (
+	SignatureHeader          = "X-Signature"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+// SignPayload returns the signature header value for body sent at timestamp.
+// The MAC covers "<unix timestamp>.<body>" so a captured request cannot be
+// replayed outside the allowed skew window.
+func SignPayload(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACSignature rejects requests whose body is not signed with secret or
+// whose signature timestamp differs from the server clock by more than maxSkew
+func HMACSignature(secret []byte, maxSkew time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			span := trace.SpanFromContext(r.Context())
+
+			signature := r.Header.Get(SignatureHeader)
+			tsHeader := r.Header.Get(SignatureTimestampHeader)
+			if signature == "" || tsHeader == "" {
+				span.SetAttributes(attribute.String("signature.result", "missing"))
+				recordDenial(r, "signature", "missing")
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Missing request signature"})
+				return
+			}
+
+			ts, err := strconv.ParseInt(tsHeader, 10, 64)
+			if err != nil {
+				span.SetAttributes(attribute.String("signature.result", "invalid_timestamp"))
+				recordDenial(r, "signature", "invalid_timestamp")
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Invalid signature timestamp"})
+				return
+			}
+			if skew := time.Since(time.Unix(ts, 0)); skew > maxSkew || skew < -maxSkew {
+				span.SetAttributes(attribute.String("signature.result", "expired"))
+				recordDenial(r, "signature", "expired")
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Signature timestamp outside allowed window"})
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					WriteProblem(w, r, Problem{Status: http.StatusRequestEntityTooLarge, Detail: "Request body too large"})
+					return
+				}
+				WriteProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Failed to read request body"})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			expected := SignPayload(secret, ts, body)
+			if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+				span.SetAttributes(attribute.String("signature.result", "mismatch"))
+				recordDenial(r, "signature", "mismatch")
+				slog.WarnContext(r.Context(), "Rejected request with invalid signature", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+				WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Invalid request signature"})
+				return
+			}
+
+			span.SetAttributes(attribute.String("signature.result", "valid"))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
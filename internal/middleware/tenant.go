@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Where Tenant finds a request's tenant
+const (
+	// TenantFromAPIKey uses the identity of the API key presented, so each
+	// site is issued its own key
+	TenantFromAPIKey = "api_key"
+
+	// TenantFromHeader trusts a request header set by a trusted proxy, for
+	// deployments behind one that routes tenants
+	TenantFromHeader = "header"
+)
+
+// TenantConfig sets how Tenant resolves a request's tenant
+type TenantConfig struct {
+	// Source is TenantFromAPIKey or TenantFromHeader
+	Source string
+
+	// Header names the tenant with TenantFromHeader. It is only taken from
+	// TrustedProxies, and must name one of Tenants.
+	Header         string
+	TrustedProxies []netip.Prefix
+	Tenants        []string
+
+	// Keys resolves API keys with TenantFromAPIKey
+	Keys KeyStore
+}
+
+// Tenant names the tenant each request belongs to in its context, for the
+// service to keep tenants' data apart. Requests naming no tenant belong to
+// service.DefaultTenant, except that with TenantFromAPIKey a key must be
+// presented. Invalid tenant IDs are rejected.
+func Tenant(cfg TenantConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tenant string
+			switch cfg.Source {
+			case TenantFromAPIKey:
+				// Reuses the identity when auth has already run
+				id, ok := APIKeyIdentity(r.Context())
+				if !ok {
+					key := presentedAPIKey(r)
+					if key == "" {
+						recordDenial(r, "tenant", "missing API key")
+						w.Header().Set("WWW-Authenticate", `Bearer realm="worker"`)
+						WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Missing API key"})
+						return
+					}
+					if id, ok = cfg.Keys.Lookup(key); !ok {
+						recordDenial(r, "tenant", "invalid API key")
+						w.Header().Set("WWW-Authenticate", `Bearer realm="worker", error="invalid_token"`)
+						WriteProblem(w, r, Problem{Status: http.StatusUnauthorized, Detail: "Invalid API key"})
+						return
+					}
+				}
+				tenant = id
+			case TenantFromHeader:
+				tenant = r.Header.Get(cfg.Header)
+				if tenant == "" {
+					break
+				}
+				// Anyone else could name any tenant, or use up the tenant
+				// limit with made-up ones
+				if !trustedPeer(r, cfg.TrustedProxies) {
+					recordDenial(r, "tenant", "untrusted peer")
+					WriteProblem(w, r, Problem{Status: http.StatusForbidden, Detail: "Tenant header not accepted"})
+					return
+				}
+				if !slices.Contains(cfg.Tenants, tenant) {
+					recordDenial(r, "tenant", "unknown tenant")
+					WriteProblem(w, r, Problem{Status: http.StatusForbidden, Detail: "Unknown tenant"})
+					return
+				}
+			}
+
+			if tenant == "" {
+				tenant = service.DefaultTenant
+			}
+			if !service.ValidTenantID(tenant) {
+				recordDenial(r, "tenant", "invalid tenant")
+				WriteProblem(w, r, Problem{Status: http.StatusBadRequest, Detail: "Invalid tenant"})
+				return
+			}
+
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("tenant.id", tenant))
+			next.ServeHTTP(w, r.WithContext(service.ContextWithTenant(r.Context(), tenant)))
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StatusClientClosedRequest is the status recorded for requests whose
+// client went away before they were answered
+const StatusClientClosedRequest = 499
+
+// Timeout bounds the handler with a context deadline of d and answers
+// 504 Gateway Timeout if it has not finished by then. The response is
+// buffered until the handler returns so a late handler cannot write into
+// a timed out response, which rules it out for streamed responses. A
+// request whose client went away is recorded as 499 and not answered.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{header: make(http.Header), statusCode: http.StatusOK}
+			done := make(chan struct{})
+			panicChan := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicChan:
+				// Re-raise on the serving goroutine so Recover can handle it
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+
+				dst := w.Header()
+				for k, v := range tw.header {
+					dst[k] = v
+				}
+				w.WriteHeader(tw.statusCode)
+				_, _ = w.Write(tw.buf.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+
+				if errors.Is(ctx.Err(), context.Canceled) {
+					slog.DebugContext(r.Context(), "Client closed request",
+						"method", r.Method,
+						"path", r.URL.Path,
+						"request_id", RequestIDFromContext(r.Context()),
+					)
+					w.WriteHeader(StatusClientClosedRequest)
+					return
+				}
+				// A timeout is the handler running late, not an admission decision
+				span := trace.SpanFromContext(r.Context())
+				span.SetAttributes(attribute.Bool("http.timeout", true))
+				span.AddEvent("request.timed_out", trace.WithAttributes(
+					attribute.String("timeout", d.String()),
+				))
+				slog.WarnContext(r.Context(), "Request timed out",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"timeout", d.String(),
+					"request_id", RequestIDFromContext(r.Context()),
+				)
+				WriteProblem(w, r, Problem{Status: http.StatusGatewayTimeout, Detail: "Request timed out"})
+			}
+		})
+	}
+}
+
+// NoWriteDeadline lifts the server's WriteTimeout for the request, for
+// streamed responses that legitimately outlast it. A client that stops
+// reading is still caught by the connection's own failure.
+func NoWriteDeadline() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				slog.WarnContext(r.Context(), "Could not lift write deadline for streamed response", "error", err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeoutWriter buffers a handler's response until it completes
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.statusCode = code
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	return tw.buf.Write(b)
+}
@@ -0,0 +1,139 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// ErrNotConfigured is returned by Send without an SMTP server, sender or
+// recipients
+var ErrNotConfigured = errors.New("no SMTP server, sender or recipients configured")
+
+// SMTPConfig is where reports are mailed from and to. The connection is
+// upgraded with STARTTLS when the server offers it, and authenticates
+// with PLAIN when Username is set, which net/smtp only allows over TLS or
+// to localhost.
+type SMTPConfig struct {
+	// Addr is the server's host:port
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// Send renders the report and mails it to every recipient
+func (r *Reporter) Send(ctx context.Context, rep Report) error {
+	cfg := r.cfg.SMTP
+	if cfg.Addr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return ErrNotConfigured
+	}
+
+	var html bytes.Buffer
+	if err := Render(&html, rep); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("%s report, %s to %s", rep.Name, rep.From.UTC().Format("2 Jan 15:04"), rep.To.UTC().Format("2 Jan 15:04 MST"))
+	msg, err := message(cfg.From, cfg.To, subject, html.Bytes(), rep.To)
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, cfg, msg)
+}
+
+// message builds a MIME message with an HTML body
+func message(from string, to []string, subject string, html []byte, date time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	if _, err := qp.Write(html); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// sendMail delivers msg over one SMTP session, abandoned when ctx is done
+func sendMail(ctx context.Context, cfg SMTPConfig, msg []byte) error {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	// Addresses may carry display names, which the envelope leaves out
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return err
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		rcpt, err := mail.ParseAddress(to)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,279 @@
+// Package report builds periodic summaries of the service's stats and
+// mails them as HTML: clicks, page views and sessions over the period, the
+// most clicked elements and the anomalies detected.
+package report
+
+import (
+	"context"
+	"html/template"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// Schedules selectable with Config.Schedule
+const (
+	// ScheduleDaily sends a report at midnight UTC
+	ScheduleDaily = "daily"
+
+	// ScheduleWeekly sends a report on Mondays at midnight UTC
+	ScheduleWeekly = "weekly"
+)
+
+// Config sets when reports are sent, what they hold and where they go
+type Config struct {
+	// Name titles the reports, usually the service name
+	Name string
+
+	// Schedule is ScheduleDaily, ScheduleWeekly or empty for on-demand
+	// reports only
+	Schedule string
+
+	// TopElements is how many of the most clicked elements are listed
+	TopElements int
+
+	SMTP SMTPConfig
+}
+
+// Report is a summary of the period From to To
+type Report struct {
+	Name      string
+	From      time.Time
+	To        time.Time
+	Tenants   []TenantReport
+	Anomalies []service.Anomaly
+}
+
+// TenantReport holds the counts of one tenant, the only one without
+// tenancy. Clicks, page views and sessions are those of the period; active
+// sessions are counted at its end.
+type TenantReport struct {
+	Tenant         string
+	Clicks         int64
+	PageViews      int64
+	Sessions       int64
+	ActiveSessions int64
+	TopElements    []service.ElementClicks
+}
+
+// totals are a tenant's counters when the period started
+type totals struct {
+	clicks    int64
+	pageViews int64
+	sessions  int64
+	elements  map[string]int64
+}
+
+// Reporter builds reports over the period since the last scheduled one
+// was sent, the service start before that
+type Reporter struct {
+	svc *service.Service
+	cfg Config
+
+	mu        sync.Mutex
+	since     time.Time
+	baseline  map[string]totals
+	anomalies map[anomalyKey]service.Anomaly
+}
+
+type anomalyKey struct {
+	signal string
+	start  time.Time
+}
+
+// New creates a Reporter whose first report covers svc's uptime
+func New(svc *service.Service, cfg Config) *Reporter {
+	return &Reporter{
+		svc:       svc,
+		cfg:       cfg,
+		since:     time.Now().Add(-svc.Uptime()),
+		baseline:  map[string]totals{},
+		anomalies: map[anomalyKey]service.Anomaly{},
+	}
+}
+
+// Build summarizes the period from the last scheduled report up to now
+func (r *Reporter) Build(ctx context.Context, now time.Time) Report {
+	r.collectAnomalies()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep := Report{Name: r.cfg.Name, From: r.since, To: now}
+	for tenant, current := range r.current(ctx) {
+		base := r.baseline[tenant]
+		tr := TenantReport{
+			Tenant:         tenant,
+			Clicks:         max(0, current.clicks-base.clicks),
+			PageViews:      max(0, current.pageViews-base.pageViews),
+			Sessions:       max(0, current.sessions-base.sessions),
+			ActiveSessions: r.svc.GetStats(tenantContext(ctx, tenant)).ActiveSessions,
+		}
+		for id, clicks := range current.elements {
+			if clicks -= base.elements[id]; clicks > 0 {
+				tr.TopElements = append(tr.TopElements, service.ElementClicks{ElementID: id, Clicks: clicks})
+			}
+		}
+		service.SortElementClicks(tr.TopElements)
+		if len(tr.TopElements) > r.cfg.TopElements {
+			tr.TopElements = tr.TopElements[:r.cfg.TopElements]
+		}
+		rep.Tenants = append(rep.Tenants, tr)
+	}
+	slices.SortFunc(rep.Tenants, func(a, b TenantReport) int { return strings.Compare(a.Tenant, b.Tenant) })
+
+	for _, a := range r.anomalies {
+		rep.Anomalies = append(rep.Anomalies, a)
+	}
+	slices.SortFunc(rep.Anomalies, func(a, b service.Anomaly) int { return a.Start.Compare(b.Start) })
+	return rep
+}
+
+// current reads every tenant's counters. The caller holds mu.
+func (r *Reporter) current(ctx context.Context) map[string]totals {
+	// Without tenancy there is one set of stats, under no tenant
+	tenants := r.svc.Tenants()
+	if len(tenants) == 0 {
+		tenants = []string{""}
+	}
+
+	all := make(map[string]totals, len(tenants))
+	for _, tenant := range tenants {
+		tenantCtx := tenantContext(ctx, tenant)
+		stats := r.svc.GetStats(tenantCtx)
+		t := totals{
+			clicks:    stats.TotalClicks,
+			pageViews: stats.PageViews,
+			sessions:  stats.TotalSessions,
+			elements:  map[string]int64{},
+		}
+		for _, e := range r.svc.ElementClicks(tenantCtx) {
+			t.elements[e.ElementID] = e.Clicks
+		}
+		all[tenant] = t
+	}
+	return all
+}
+
+func tenantContext(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return service.ContextWithTenant(ctx, tenant)
+}
+
+// collectAnomalies keeps the anomalies of the period, which outlasts the
+// detector's history
+func (r *Reporter) collectAnomalies() {
+	anomalies := r.svc.GetAnomalies()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, a := range anomalies {
+		if !a.Start.Before(r.since) {
+			r.anomalies[anomalyKey{a.Signal, a.Start}] = a
+		}
+	}
+}
+
+// reset starts a new period at now
+func (r *Reporter) reset(ctx context.Context, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.since = now
+	r.baseline = r.current(ctx)
+	r.anomalies = map[anomalyKey]service.Anomaly{}
+}
+
+// Run sends a report on the schedule until ctx is done. A report that
+// fails to send is not retried; the next one covers its period too.
+func (r *Reporter) Run(ctx context.Context) {
+	// Anomalies are collected more often than the detector forgets them
+	collect := time.NewTicker(service.RateHistory / 2)
+	defer collect.Stop()
+
+	for {
+		next := nextRun(time.Now(), r.cfg.Schedule)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-collect.C:
+			timer.Stop()
+			r.collectAnomalies()
+		case now := <-timer.C:
+			rep := r.Build(ctx, now)
+			if err := r.Send(ctx, rep); err != nil {
+				slog.ErrorContext(ctx, "Failed to send report", "error", err)
+				continue
+			}
+			slog.InfoContext(ctx, "Sent report", "from", rep.From, "to", rep.To)
+			r.reset(ctx, now)
+		}
+	}
+}
+
+// nextRun returns the first midnight UTC after now, a Monday's for weekly
+// reports
+func nextRun(now time.Time, schedule string) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	if schedule == ScheduleWeekly {
+		next = next.AddDate(0, 0, (int(time.Monday)-int(next.Weekday())+7)%7)
+	}
+	return next
+}
+
+// Render writes the report as an HTML document, styled inline as mail
+// clients require
+func Render(w io.Writer, rep Report) error {
+	return reportTemplate.Execute(w, rep)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date":   func(t time.Time) string { return t.UTC().Format("Mon 2 Jan 2006 15:04 MST") },
+	"minute": func(t time.Time) string { return t.UTC().Format("Mon 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}} report</title></head>
+<body style="font-family: -apple-system, 'Segoe UI', Helvetica, Arial, sans-serif; color: #1d1c1d; max-width: 640px;">
+  <h1 style="font-size: 20px;">{{.Name}} report</h1>
+  <p style="color: #616061;">{{date .From}} to {{date .To}}</p>
+  {{range .Tenants}}
+  {{if .Tenant}}<h2 style="font-size: 16px;">Tenant {{.Tenant}}</h2>{{end}}
+  <table style="border-collapse: collapse; margin-bottom: 16px;">
+    <tr><td style="padding: 4px 16px 4px 0;">Clicks</td><td style="text-align: right;"><b>{{.Clicks}}</b></td></tr>
+    <tr><td style="padding: 4px 16px 4px 0;">Page views</td><td style="text-align: right;"><b>{{.PageViews}}</b></td></tr>
+    <tr><td style="padding: 4px 16px 4px 0;">Sessions started</td><td style="text-align: right;"><b>{{.Sessions}}</b></td></tr>
+    <tr><td style="padding: 4px 16px 4px 0;">Active sessions now</td><td style="text-align: right;"><b>{{.ActiveSessions}}</b></td></tr>
+  </table>
+  <h3 style="font-size: 14px;">Top elements</h3>
+  {{if .TopElements}}
+  <table style="border-collapse: collapse; margin-bottom: 16px;">
+    {{range .TopElements}}<tr><td style="padding: 2px 16px 2px 0;"><code>{{.ElementID}}</code></td><td style="text-align: right;">{{.Clicks}}</td></tr>
+    {{end}}
+  </table>
+  {{else}}
+  <p>No clicks</p>
+  {{end}}
+  {{end}}
+  <h2 style="font-size: 16px;">Anomalies</h2>
+  {{if .Anomalies}}
+  <table style="border-collapse: collapse;">
+    <tr><th style="text-align: left; padding: 2px 16px 2px 0;">Minute</th><th style="text-align: left; padding: 2px 16px 2px 0;">Signal</th><th style="text-align: right; padding: 2px 16px 2px 0;">Value</th><th style="text-align: right;">Expected</th></tr>
+    {{range .Anomalies}}<tr><td style="padding: 2px 16px 2px 0;">{{minute .Start}}</td><td style="padding: 2px 16px 2px 0;">{{.Signal}}</td><td style="text-align: right; padding: 2px 16px 2px 0;">{{printf "%.2f" .Value}}</td><td style="text-align: right;">{{printf "%.2f" .Expected}}</td></tr>
+    {{end}}
+  </table>
+  {{else}}
+  <p>None detected</p>
+  {{end}}
+</body>
+</html>
+`))
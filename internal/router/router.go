@@ -0,0 +1,123 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+)
+
+// Route declares one mux pattern, the named handler serving it and the
+// named middleware wrapping it, outermost first
+type Route struct {
+	Path       string            `json:"path"`
+	Handler    string            `json:"handler"`
+	Middleware []string          `json:"middleware,omitempty"`
+	Options    map[string]string `json:"options,omitempty"`
+}
+
+// Option returns the per-route option key
+func (r Route) Option(key string) (string, bool) {
+	v, ok := r.Options[key]
+	return v, ok
+}
+
+// MiddlewareFactory builds a named middleware for a route. A nil
+// middleware means it is disabled by configuration and is skipped.
+type MiddlewareFactory func(route Route) (middleware.Middleware, error)
+
+// Registry maps the names used in a route table to handlers and middleware
+type Registry struct {
+	handlers   map[string]http.Handler
+	middleware map[string]MiddlewareFactory
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers:   make(map[string]http.Handler),
+		middleware: make(map[string]MiddlewareFactory),
+	}
+}
+
+// Handle registers a handler under name
+func (reg *Registry) Handle(name string, h http.Handler) {
+	reg.handlers[name] = h
+}
+
+// HandleFunc registers a handler function under name
+func (reg *Registry) HandleFunc(name string, h http.HandlerFunc) {
+	reg.handlers[name] = h
+}
+
+// Middleware registers a middleware factory under name
+func (reg *Registry) Middleware(name string, f MiddlewareFactory) {
+	reg.middleware[name] = f
+}
+
+// Build resolves every route against the registry and mounts it on mux.
+// Nothing is mounted unless the whole table is valid.
+func (reg *Registry) Build(mux *http.ServeMux, routes []Route) error {
+	resolved := make([]http.Handler, 0, len(routes))
+	seen := make(map[string]bool)
+
+	for _, route := range routes {
+		if route.Path == "" {
+			return fmt.Errorf("route with handler %q has no path", route.Handler)
+		}
+		if seen[route.Path] {
+			return fmt.Errorf("route %s is declared twice", route.Path)
+		}
+		seen[route.Path] = true
+
+		h, err := reg.Resolve(route)
+		if err != nil {
+			return err
+		}
+		resolved = append(resolved, h)
+	}
+
+	for i, route := range routes {
+		mux.Handle(route.Path, resolved[i])
+	}
+	return nil
+}
+
+// Resolve builds the handler chain for a single route
+func (reg *Registry) Resolve(route Route) (http.Handler, error) {
+	h, ok := reg.handlers[route.Handler]
+	if !ok {
+		return nil, fmt.Errorf("route %s: unknown handler %q", route.Path, route.Handler)
+	}
+
+	mws := make([]middleware.Middleware, 0, len(route.Middleware))
+	for _, name := range route.Middleware {
+		factory, ok := reg.middleware[name]
+		if !ok {
+			return nil, fmt.Errorf("route %s: unknown middleware %q", route.Path, name)
+		}
+		mw, err := factory(route)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: middleware %s: %w", route.Path, name, err)
+		}
+		if mw != nil {
+			mws = append(mws, mw)
+		}
+	}
+	return middleware.Chain(h, mws...), nil
+}
+
+// LoadRoutes reads a JSON route table from path
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read route table: %w", err)
+	}
+
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parse route table: %w", err)
+	}
+	return routes, nil
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Signals checked for anomalies, one value per minute
+const (
+	// SignalClickRate is clicks per minute, bots excluded
+	SignalClickRate = "click_rate"
+
+	// SignalSessionRate is sessions started per minute
+	SignalSessionRate = "new_session_rate"
+
+	// SignalErrorRate is the share of requests answered 5xx. Minutes
+	// without requests are skipped.
+	SignalErrorRate = "error_rate"
+)
+
+// AnomalySignals lists every signal, in reporting order
+var AnomalySignals = []string{SignalClickRate, SignalSessionRate, SignalErrorRate}
+
+// anomalyRingSize is how many minutes are counted before DetectAnomalies
+// must have looked at them
+const anomalyRingSize = 5
+
+// AnomalyConfig sets when a minute counts as anomalous. Each signal keeps
+// an exponentially weighted mean and variance; a minute is anomalous when
+// its value is more than Threshold standard deviations from the mean.
+type AnomalyConfig struct {
+	Threshold float64
+
+	// Alpha is the weight of each new minute in the mean and variance
+	Alpha float64
+
+	// Warmup is how long the averages settle before anything is flagged
+	Warmup time.Duration
+}
+
+// WithAnomalyDetection checks the signals for anomalies. Detection covers
+// the whole deployment, across tenants.
+func WithAnomalyDetection(cfg AnomalyConfig) Option {
+	return func(s *Service) {
+		s.anomalies = newAnomalyDetector(cfg, time.Now())
+	}
+}
+
+// Anomaly is a minute in which a signal strayed from its average
+type Anomaly struct {
+	Signal   string    `json:"signal"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Value    float64   `json:"value"`
+	Expected float64   `json:"expected"`
+	ZScore   float64   `json:"z_score"`
+}
+
+// GetAnomalies returns the anomalous minutes of the last RateHistory, most
+// recent first, or nil without detection
+func (s *Service) GetAnomalies() []Anomaly {
+	if s.anomalies == nil {
+		return nil
+	}
+	return s.anomalies.recent(time.Now())
+}
+
+// AnomalyScore returns the absolute z-score of signal's latest minute, or
+// 0 while warming up or without detection
+func (s *Service) AnomalyScore(signal string) float64 {
+	if s.anomalies == nil {
+		return 0
+	}
+	return s.anomalies.score(signal)
+}
+
+// DetectAnomalies scores the minutes that finished before now. Call it at
+// least every few minutes; minutes it falls behind on are skipped.
+func (s *Service) DetectAnomalies(ctx context.Context, now time.Time) {
+	if s.anomalies == nil {
+		return
+	}
+	for _, a := range s.anomalies.close(now) {
+		s.anomalyCount.Add(ctx, 1, metric.WithAttributes(attribute.String("signal", a.Signal)))
+		slog.WarnContext(ctx, "Anomaly detected",
+			"signal", a.Signal,
+			"value", a.Value,
+			"expected", a.Expected,
+			"z_score", a.ZScore,
+			"window_start", a.Start,
+		)
+	}
+}
+
+type anomalyBucket struct {
+	index    int64
+	clicks   int64
+	sessions int64
+	requests int64
+	errors   int64
+}
+
+// signalState is a signal's running mean and variance
+type signalState struct {
+	mean     float64
+	variance float64
+	seen     bool
+	score    float64
+}
+
+// observe scores x against the running averages, then folds it in. floor
+// bounds the standard deviation from below, so a signal that has been flat
+// does not flag every small change.
+func (st *signalState) observe(x, alpha, floor float64) float64 {
+	if !st.seen {
+		st.mean, st.seen = x, true
+		return 0
+	}
+	z := (x - st.mean) / max(math.Sqrt(st.variance), floor)
+
+	diff := x - st.mean
+	incr := alpha * diff
+	st.mean += incr
+	st.variance = (1 - alpha) * (st.variance + diff*incr)
+	return z
+}
+
+type anomalyDetector struct {
+	cfg AnomalyConfig
+
+	mu      sync.Mutex
+	buckets [anomalyRingSize]anomalyBucket
+
+	// Minutes are scored from first, the first full minute; closed is the
+	// last one scored
+	first  int64
+	closed int64
+
+	signals   map[string]*signalState
+	anomalies []Anomaly
+}
+
+func newAnomalyDetector(cfg AnomalyConfig, now time.Time) *anomalyDetector {
+	first := minuteIndex(now) + 1
+	signals := make(map[string]*signalState, len(AnomalySignals))
+	for _, signal := range AnomalySignals {
+		signals[signal] = &signalState{}
+	}
+	return &anomalyDetector{cfg: cfg, first: first, closed: first - 1, signals: signals}
+}
+
+func minuteIndex(t time.Time) int64 {
+	return t.UnixNano() / int64(rateResolution)
+}
+
+// bucket returns the counts for index. The caller holds mu.
+func (d *anomalyDetector) bucket(index int64) *anomalyBucket {
+	b := &d.buckets[index%anomalyRingSize]
+	if b.index != index {
+		*b = anomalyBucket{index: index}
+	}
+	return b
+}
+
+func (d *anomalyDetector) recordEvent(now time.Time, click, sessionStarted bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b := d.bucket(minuteIndex(now))
+	if click {
+		b.clicks++
+	}
+	if sessionStarted {
+		b.sessions++
+	}
+}
+
+func (d *anomalyDetector) recordRequest(now time.Time, serverError bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b := d.bucket(minuteIndex(now))
+	b.requests++
+	if serverError {
+		b.errors++
+	}
+}
+
+// close scores every finished minute not yet scored and returns the
+// anomalies among them
+func (d *anomalyDetector) close(now time.Time) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := minuteIndex(now)
+	if oldest := current - anomalyRingSize + 1; d.closed < oldest-1 {
+		// Fell behind; those minutes' counts are gone
+		d.closed = oldest - 1
+	}
+	warmupMinutes := int64(d.cfg.Warmup / rateResolution)
+
+	var found []Anomaly
+	for index := d.closed + 1; index < current; index++ {
+		b := *d.bucket(index)
+		values := map[string]float64{
+			SignalClickRate:   float64(b.clicks),
+			SignalSessionRate: float64(b.sessions),
+		}
+		if b.requests > 0 {
+			values[SignalErrorRate] = float64(b.errors) / float64(b.requests)
+		}
+
+		start := time.Unix(0, index*int64(rateResolution)).UTC()
+		warm := index-d.first >= warmupMinutes
+		for _, signal := range AnomalySignals {
+			x, ok := values[signal]
+			if !ok {
+				continue
+			}
+			st := d.signals[signal]
+			expected := st.mean
+			// Counts vary about as much as their square root; rates are
+			// given a floor of one percentage point
+			floor := max(1, math.Sqrt(expected))
+			if signal == SignalErrorRate {
+				floor = 0.01
+			}
+			z := st.observe(x, d.cfg.Alpha, floor)
+
+			st.score = 0
+			if !warm {
+				continue
+			}
+			st.score = math.Abs(z)
+			if st.score > d.cfg.Threshold {
+				found = append(found, Anomaly{
+					Signal:   signal,
+					Start:    start,
+					End:      start.Add(rateResolution),
+					Value:    x,
+					Expected: expected,
+					ZScore:   z,
+				})
+			}
+		}
+		d.closed = index
+	}
+
+	d.anomalies = append(d.anomalies, found...)
+	d.trim(now)
+	return found
+}
+
+// trim drops anomalies older than RateHistory. The caller holds mu.
+func (d *anomalyDetector) trim(now time.Time) {
+	cutoff := now.Add(-RateHistory)
+	i := 0
+	for i < len(d.anomalies) && d.anomalies[i].End.Before(cutoff) {
+		i++
+	}
+	d.anomalies = d.anomalies[i:]
+}
+
+func (d *anomalyDetector) recent(now time.Time) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.trim(now)
+	anomalies := make([]Anomaly, len(d.anomalies))
+	for i, a := range d.anomalies {
+		anomalies[len(anomalies)-1-i] = a
+	}
+	return anomalies
+}
+
+func (d *anomalyDetector) score(signal string) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if st, ok := d.signals[signal]; ok {
+		return st.score
+	}
+	return 0
+}
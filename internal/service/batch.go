@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// positionSeries identifies one attribute set of the cursor position
+// histogram
+type positionSeries int
+
+const (
+	clickX positionSeries = iota
+	clickY
+	mousemoveX
+	mousemoveY
+	scrollX
+	scrollY
+	numPositionSeries
+)
+
+// positionAttrs are built once, since building a set per recording
+// allocates on every mousemove
+var positionAttrs = [numPositionSeries]metric.RecordOption{
+	clickX:     positionOption("x", "click"),
+	clickY:     positionOption("y", "click"),
+	mousemoveX: positionOption("x", "mousemove"),
+	mousemoveY: positionOption("y", "mousemove"),
+	scrollX:    positionOption("scroll_x", "scroll"),
+	scrollY:    positionOption("scroll_y", "scroll"),
+}
+
+func positionOption(coordinate, eventType string) metric.RecordOption {
+	return metric.WithAttributeSet(attribute.NewSet(
+		attribute.String("coordinate", coordinate),
+		attribute.String("event_type", eventType),
+	))
+}
+
+// WithMetricBatching buffers cursor positions and records them size at a
+// time, or every interval, instead of once per event, so request
+// goroutines do not contend on the histogram. Batched positions are
+// recorded without their request context and so carry no exemplars.
+func WithMetricBatching(size int, interval time.Duration) Option {
+	return func(s *Service) {
+		if size > 0 {
+			s.positions = newPositionBatch(size, interval, s.recordPositions)
+		}
+	}
+}
+
+// Close records any buffered metrics and stops the batch flusher
+func (s *Service) Close() {
+	if s.positions != nil {
+		s.positions.close()
+	}
+}
+
+// recordPosition records a pair of coordinates, through the batch when
+// there is one
+func (s *Service) recordPosition(ctx context.Context, xs, ys positionSeries, x, y int) {
+	if s.positions != nil {
+		pending := s.positions.add(xs, ys, int64(x), int64(y))
+		// Checked first so unsampled events do not allocate the event
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			span.AddEvent("metrics.enqueued", trace.WithAttributes(
+				attribute.Int("metrics.batch.pending", pending),
+			))
+		}
+		return
+	}
+	s.cursorPositions.Record(ctx, int64(x), positionAttrs[xs])
+	s.cursorPositions.Record(ctx, int64(y), positionAttrs[ys])
+}
+
+func (s *Service) recordPositions(samples *[numPositionSeries][]int64) {
+	ctx := context.Background()
+	for series, values := range samples {
+		for _, v := range values {
+			s.cursorPositions.Record(ctx, v, positionAttrs[series])
+		}
+	}
+}
+
+// positionBatch collects positions per series. Two buffers alternate so a
+// full one is recorded outside the lock while the other fills.
+type positionBatch struct {
+	size   int
+	record func(*[numPositionSeries][]int64)
+
+	mu      sync.Mutex
+	current *[numPositionSeries][]int64
+	spare   *[numPositionSeries][]int64
+	count   int
+
+	// Serializes recording so buffers are not swapped back mid-record
+	recordMu sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newPositionBatch(size int, interval time.Duration, record func(*[numPositionSeries][]int64)) *positionBatch {
+	b := &positionBatch{
+		size:    size,
+		record:  record,
+		current: new([numPositionSeries][]int64),
+		spare:   new([numPositionSeries][]int64),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if interval > 0 {
+		go b.flushLoop(interval)
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// add buffers a pair of positions, returning how many are pending
+// including them; a full batch is recorded before add returns
+func (b *positionBatch) add(xs, ys positionSeries, x, y int64) int {
+	b.mu.Lock()
+	b.current[xs] = append(b.current[xs], x)
+	b.current[ys] = append(b.current[ys], y)
+	b.count += 2
+	pending := b.count
+	b.mu.Unlock()
+
+	if pending >= b.size {
+		b.flush()
+	}
+	return pending
+}
+
+func (b *positionBatch) flush() {
+	b.recordMu.Lock()
+	defer b.recordMu.Unlock()
+
+	b.mu.Lock()
+	if b.count == 0 {
+		b.mu.Unlock()
+		return
+	}
+	full := b.current
+	b.current, b.spare = b.spare, b.current
+	b.count = 0
+	b.mu.Unlock()
+
+	b.record(full)
+	for i := range full {
+		full[i] = full[i][:0]
+	}
+}
+
+func (b *positionBatch) flushLoop(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.flush()
+		}
+	}
+}
+
+func (b *positionBatch) close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	<-b.done
+	b.flush()
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/useragent"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Bot filter modes
+const (
+	BotFilterOff  = "off"
+	BotFilterTag  = "tag"
+	BotFilterDrop = "drop"
+)
+
+// Reasons an event is classed as automated, in the order they are checked
+const (
+	BotReasonHeadless   = "headless"
+	BotReasonUserAgent  = "user_agent"
+	BotReasonEventRate  = "event_rate"
+	BotReasonNoViewport = "no_viewport"
+)
+
+// headlessTokens mark automation frameworks driving a real browser engine
+var headlessTokens = []string{"headless", "phantomjs", "selenium", "webdriver", "puppeteer", "playwright"}
+
+// WithBotFilter classifies events from automated clients. In BotFilterTag
+// mode they are kept and marked but left out of the click counters; in
+// BotFilterDrop mode they are discarded, those over the event rate failing
+// with ErrRateLimited. A session sending more than
+// maxEventRate events in a second is treated as automated; 0 disables the
+// check.
+func WithBotFilter(mode string, maxEventRate int) Option {
+	return func(s *Service) {
+		s.botMode = mode
+		s.botMaxEventRate = maxEventRate
+	}
+}
+
+// detectBot returns why event looks automated, or "" when it does not
+func (s *Service) detectBot(event TrackingEvent, now time.Time) string {
+	// Counted first so the burst is tracked whatever else matches
+	burst := s.sessionBurst(sessionKey{tenant: event.Tenant, id: event.SessionID}, now)
+
+	ua := strings.ToLower(event.UserAgent)
+	switch {
+	case containsAny(ua, headlessTokens) || event.Custom["webdriver"] == true:
+		return BotReasonHeadless
+	case useragent.IsBot(event.UserAgent):
+		return BotReasonUserAgent
+	case s.botMaxEventRate > 0 && burst > s.botMaxEventRate:
+		return BotReasonEventRate
+	case isInteraction(event.EventType) && event.ViewportX == 0 && event.ViewportY == 0:
+		// Browsers always know their viewport
+		return BotReasonNoViewport
+	}
+	return ""
+}
+
+// sessionBurst counts the session's events in the current second, or 0 for
+// a session that has not started
+func (s *Service) sessionBurst(key sessionKey, now time.Time) int {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+
+	session, ok := s.sessions[key]
+	if !ok {
+		return 0
+	}
+	if now.Sub(session.burstStart) >= time.Second {
+		session.burstStart, session.burstCount = now, 0
+	}
+	session.burstCount++
+	return session.burstCount
+}
+
+// recordBotDecision counts a filtered event; decision is human, tagged or
+// dropped, and human decisions have no reason
+func (s *Service) recordBotDecision(ctx context.Context, tenant, decision, reason string) {
+	attrs := append(s.tenantAttrs(tenant), attribute.String("decision", decision))
+	if reason != "" {
+		attrs = append(attrs, attribute.String("reason", reason))
+	}
+	s.botDecisions.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
+
+func isInteraction(eventType string) bool {
+	switch eventType {
+	case "click", "mousemove", "scroll":
+		return true
+	}
+	return false
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, t := range tokens {
+		if strings.Contains(s, t) {
+			return true
+		}
+	}
+	return false
+}
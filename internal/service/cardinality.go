@@ -0,0 +1,53 @@
+package service
+
+import "sync"
+
+// OtherLabel replaces label values beyond a cardinality limit
+const OtherLabel = "other"
+
+// cardinalityLimiter passes through the first max distinct values of a
+// metric label and maps any later ones to OtherLabel, so unbounded input
+// such as scanned URLs cannot create a series per value
+type cardinalityLimiter struct {
+	mu   sync.RWMutex
+	seen map[string]struct{}
+	max  int
+}
+
+func newCardinalityLimiter(max int) *cardinalityLimiter {
+	return &cardinalityLimiter{seen: make(map[string]struct{}), max: max}
+}
+
+func (l *cardinalityLimiter) value(v string) string {
+	l.mu.RLock()
+	_, ok := l.seen[v]
+	l.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return OtherLabel
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
+
+// httpMethods are the method label values kept as they are; anything else
+// is recorded as _OTHER, as the HTTP semantic conventions do
+var httpMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+func methodLabel(method string) string {
+	if httpMethods[method] {
+		return method
+	}
+	return "_OTHER"
+}
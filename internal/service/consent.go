@@ -0,0 +1,49 @@
+package service
+
+// Consent policies
+const (
+	// ConsentIgnore processes every event in full
+	ConsentIgnore = "ignore"
+
+	// ConsentOptOut processes events in full unless the browser sends DNT
+	// or Sec-GPC, or the event declines consent
+	ConsentOptOut = "opt_out"
+
+	// ConsentOptIn processes events in full only when the event grants
+	// consent
+	ConsentOptIn = "opt_in"
+)
+
+// WithConsentPolicy sets when events are processed in full. The others are
+// processed in aggregate-only mode: they count towards counters and
+// metrics but are not kept in a session, carry no custom fields and are
+// not tied to a session or client in spans and logs.
+func WithConsentPolicy(policy string) Option {
+	return func(s *Service) {
+		s.consentPolicy = policy
+	}
+}
+
+// Consented reports whether event may be processed in full. An explicit
+// consent flag in the event outweighs the browser's DNT and Sec-GPC
+// signals.
+func (s *Service) Consented(event TrackingEvent) bool {
+	switch s.consentPolicy {
+	case ConsentOptIn:
+		return event.Consent != nil && *event.Consent
+	case ConsentOptOut:
+		if event.Consent != nil {
+			return *event.Consent
+		}
+		return !event.DoNotTrack
+	}
+	return true
+}
+
+// aggregateOnly strips what ties an event to a person
+func aggregateOnly(event *TrackingEvent) {
+	event.SessionID = ""
+	event.ClientIP = ""
+	event.Custom = nil
+	event.ElementText = ""
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DefaultIdleThreshold is the gap between events beyond which a session
+// counts as idle
+const DefaultIdleThreshold = 30 * time.Second
+
+// Session states reported by GetSessions
+const (
+	SessionActive = "active"
+	SessionIdle   = "idle"
+)
+
+// WithIdleThreshold sets the gap between a session's events beyond which
+// the time counts as idle rather than active
+func WithIdleThreshold(d time.Duration) Option {
+	return func(s *Service) {
+		if d > 0 {
+			s.idleThreshold = d
+		}
+	}
+}
+
+// PageDwell is the average active time sessions spent on a page
+type PageDwell struct {
+	PageURL        string  `json:"page_url"`
+	Sessions       int64   `json:"sessions"`
+	AverageSeconds float64 `json:"average_seconds"`
+}
+
+// SessionSummary describes a live session for the sessions API
+type SessionSummary struct {
+	ID            string    `json:"id"`
+	Tenant        string    `json:"tenant,omitempty"`
+	State         string    `json:"state"`
+	StartTime     time.Time `json:"start_time"`
+	LastActive    time.Time `json:"last_active"`
+	Events        int       `json:"events"`
+	Clicks        int64     `json:"clicks"`
+	ActiveSeconds float64   `json:"active_seconds"`
+	IdleSeconds   float64   `json:"idle_seconds"`
+}
+
+// GetSessions returns the live sessions of the tenant ctx names, or of
+// every tenant when it names none, most recently active first. A session
+// is idle once its last event is older than the idle threshold, and the
+// time since then counts as idle.
+func (s *Service) GetSessions(ctx context.Context) []SessionSummary {
+	now := time.Now()
+	tenant := TenantFromContext(ctx)
+
+	s.sessionMutex.RLock()
+	sessions := make([]SessionSummary, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		if tenant != "" && session.Tenant != s.tenantID(tenant) {
+			continue
+		}
+		summary := SessionSummary{
+			ID:            session.ID,
+			Tenant:        session.Tenant,
+			State:         SessionActive,
+			StartTime:     session.StartTime,
+			LastActive:    session.LastActive,
+			Events:        len(session.Events),
+			Clicks:        session.ClickCount,
+			ActiveSeconds: session.ActiveTime.Seconds(),
+			IdleSeconds:   session.IdleTime.Seconds(),
+		}
+		if gap := now.Sub(session.LastActive); gap > s.idleThreshold {
+			summary.State = SessionIdle
+			summary.IdleSeconds += gap.Seconds()
+		}
+		sessions = append(sessions, summary)
+	}
+	s.sessionMutex.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastActive.After(sessions[j].LastActive) })
+	return sessions
+}
+
+// updateDwell splits the gap since the session's previous event into active
+// or idle time, crediting active time to the page that event was on, and
+// counts the visit to the event's page. Called with sessionMutex held,
+// before LastActive moves.
+func (s *Service) updateDwell(session *SessionData, event TrackingEvent, now time.Time, started bool) {
+	if !started {
+		gap := now.Sub(session.LastActive)
+		if gap > s.idleThreshold {
+			session.IdleTime += gap
+		} else {
+			session.ActiveTime += gap
+			previous := session.Events[len(session.Events)-1].PageURL
+			session.PageDwell[previous] += gap
+			session.stats.dwell.add(previous, gap)
+		}
+	}
+
+	if session.PageDwell == nil {
+		session.PageDwell = make(map[string]time.Duration)
+	}
+	if _, seen := session.PageDwell[event.PageURL]; !seen {
+		session.PageDwell[event.PageURL] = 0
+		session.stats.dwell.visit(event.PageURL)
+	}
+}
+
+type pageDwell struct {
+	sessions int64
+	total    time.Duration
+}
+
+// dwellTracker totals active time and visiting sessions per page
+type dwellTracker struct {
+	mu    sync.Mutex
+	pages map[string]*pageDwell
+}
+
+// page returns the totals for url, or for OtherLabel beyond
+// maxTrackedPages. The caller holds mu.
+func (t *dwellTracker) page(url string) *pageDwell {
+	if t.pages == nil {
+		t.pages = make(map[string]*pageDwell)
+	}
+	if p, ok := t.pages[url]; ok {
+		return p
+	}
+	if len(t.pages) >= maxTrackedPages {
+		url = OtherLabel
+		if p, ok := t.pages[url]; ok {
+			return p
+		}
+	}
+	p := &pageDwell{}
+	t.pages[url] = p
+	return p
+}
+
+func (t *dwellTracker) visit(url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.page(url).sessions++
+}
+
+func (t *dwellTracker) add(url string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.page(url).total += d
+}
+
+// dwellTimes returns the pages by URL
+func (t *dwellTracker) dwellTimes() []PageDwell {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pages := make([]PageDwell, 0, len(t.pages))
+	for url, p := range t.pages {
+		pages = append(pages, PageDwell{
+			PageURL:        url,
+			Sessions:       p.sessions,
+			AverageSeconds: p.total.Seconds() / float64(p.sessions),
+		})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PageURL < pages[j].PageURL })
+	return pages
+}
+
+// marshalProto encodes the PageDwell message documented on
+// Stats.MarshalProto
+func (d PageDwell) marshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, d.PageURL)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(d.Sessions))
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(d.AverageSeconds))
+	return b
+}
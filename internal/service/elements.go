@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// maxTrackedElements bounds the elements clicks are counted for; later
+// elements are counted under OtherLabel
+const maxTrackedElements = 1000
+
+// ElementClicks is how often an element was clicked, bots excluded
+type ElementClicks struct {
+	ElementID string `json:"element_id"`
+	Clicks    int64  `json:"clicks"`
+}
+
+// elementTracker counts clicks per element ID
+type elementTracker struct {
+	mu     sync.Mutex
+	clicks map[string]int64
+}
+
+func (t *elementTracker) click(element string) {
+	if element == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.clicks == nil {
+		t.clicks = make(map[string]int64)
+	}
+	if _, ok := t.clicks[element]; !ok && len(t.clicks) >= maxTrackedElements {
+		element = OtherLabel
+	}
+	t.clicks[element]++
+}
+
+// counts returns the clicks of every element, most clicked first
+func (t *elementTracker) counts() []ElementClicks {
+	t.mu.Lock()
+	elements := make([]ElementClicks, 0, len(t.clicks))
+	for id, clicks := range t.clicks {
+		elements = append(elements, ElementClicks{ElementID: id, Clicks: clicks})
+	}
+	t.mu.Unlock()
+
+	SortElementClicks(elements)
+	return elements
+}
+
+// SortElementClicks orders elements by clicks, most first, then by ID
+func SortElementClicks(elements []ElementClicks) {
+	sort.Slice(elements, func(i, j int) bool {
+		if elements[i].Clicks != elements[j].Clicks {
+			return elements[i].Clicks > elements[j].Clicks
+		}
+		return elements[i].ElementID < elements[j].ElementID
+	})
+}
+
+// ElementClicks returns the clicks on every element by sessions of the
+// tenant ctx names, most clicked first
+func (s *Service) ElementClicks(ctx context.Context) []ElementClicks {
+	if ts := s.lookupStats(s.tenantID(TenantFromContext(ctx))); ts != nil {
+		return ts.elements.counts()
+	}
+	return []ElementClicks{}
+}
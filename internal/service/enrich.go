@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"github.com/niquet/rate-limited-worker/internal/geoip"
+	"github.com/niquet/rate-limited-worker/internal/useragent"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Enricher adds to an event before it is filtered, scrubbed, kept and
+// counted, such as a customer ID looked up from the session. Enrich may
+// change any field except Tenant; it is called concurrently and should
+// return quickly, as events wait on it. An error is logged and the event
+// is processed with whatever the enricher set.
+type Enricher interface {
+	Enrich(ctx context.Context, event *TrackingEvent) error
+}
+
+// EnricherFunc adapts a function to Enricher
+type EnricherFunc func(ctx context.Context, event *TrackingEvent) error
+
+// Enrich calls f
+func (f EnricherFunc) Enrich(ctx context.Context, event *TrackingEvent) error {
+	return f(ctx, event)
+}
+
+// WithEnricher adds an enricher, run after the built-in enrichment and
+// after the enrichers added before it. name identifies it in logs and
+// spans.
+func WithEnricher(name string, e Enricher) Option {
+	return func(s *Service) {
+		s.enrichers = append(s.enrichers, namedEnricher{name: name, Enricher: e})
+	}
+}
+
+type namedEnricher struct {
+	name string
+	Enricher
+}
+
+// runEnrichers applies the added enrichers to event in order
+func (s *Service) runEnrichers(ctx context.Context, event *TrackingEvent) {
+	tenant := event.Tenant
+	for _, e := range s.enrichers {
+		ctx, span := s.startEventSpan(ctx, "enrich")
+		span.SetAttributes(attribute.String("enricher", e.name))
+		if err := e.Enrich(ctx, event); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "enrichment failed")
+			slog.WarnContext(ctx, "Enrichment failed", "enricher", e.name, "error", err)
+		}
+		span.End()
+	}
+	// The tenant decides whose data the event is, so it cannot be changed
+	event.Tenant = tenant
+}
+
+// WithGeoIP resolves client addresses to a location with db
+func WithGeoIP(db *geoip.DB) Option {
+	return func(s *Service) {
+		s.geo = db
+	}
+}
+
+// enrich derives the server-side fields of an event from the ones the
+// client and handler supplied
+func (s *Service) enrich(event *TrackingEvent) {
+	info := useragent.Parse(event.UserAgent)
+	event.Browser = info.Browser
+	event.OS = info.OS
+	event.Device = info.Device
+
+	event.CursorXPercent, event.CursorYPercent = nil, nil
+	if x, y, ok := viewportPercent(*event); ok {
+		event.CursorXPercent, event.CursorYPercent = &x, &y
+	}
+
+	event.Country, event.Region, event.City = "", "", ""
+	if s.geo != nil {
+		if ip, err := netip.ParseAddr(event.ClientIP); err == nil {
+			loc := s.geo.Lookup(ip)
+			event.Country, event.Region, event.City = loc.Country, loc.Region, loc.City
+		}
+	}
+}
+
+// Breakdown counts sessions by the browser, operating system and device
+// class of the agent that started them
+type Breakdown struct {
+	Browsers         map[string]int64 `json:"browsers,omitempty"`
+	OperatingSystems map[string]int64 `json:"operating_systems,omitempty"`
+	Devices          map[string]int64 `json:"devices,omitempty"`
+}
+
+// agentTracker keeps the Breakdown. The keys come from useragent's fixed
+// sets, so the maps stay small.
+type agentTracker struct {
+	mu        sync.Mutex
+	breakdown Breakdown
+}
+
+func (t *agentTracker) add(event TrackingEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.breakdown.Browsers == nil {
+		t.breakdown = Breakdown{
+			Browsers:         make(map[string]int64),
+			OperatingSystems: make(map[string]int64),
+			Devices:          make(map[string]int64),
+		}
+	}
+	t.breakdown.Browsers[event.Browser]++
+	t.breakdown.OperatingSystems[event.OS]++
+	t.breakdown.Devices[event.Device]++
+}
+
+func (t *agentTracker) snapshot() Breakdown {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Breakdown{
+		Browsers:         maps.Clone(t.breakdown.Browsers),
+		OperatingSystems: maps.Clone(t.breakdown.OperatingSystems),
+		Devices:          maps.Clone(t.breakdown.Devices),
+	}
+}
+
+// appendCountsProto appends m as the map<string, int64> field num, in key
+// order so the encoding is stable
+func appendCountsProto(b []byte, num protowire.Number, m map[string]int64) []byte {
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, uint64(m[k]))
+
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
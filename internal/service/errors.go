@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors classify failures so every layer reports them the same
+// way. Match them with errors.Is; errors returned by the service wrap one.
+var (
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrValidation       = errors.New("validation failed")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrNotFound         = errors.New("not found")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+	ErrPayloadTooLarge  = errors.New("payload too large")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrUnavailable      = errors.New("unavailable")
+	ErrStoreUnavailable = errors.New("store unavailable")
+	ErrTimeout          = errors.New("timeout")
+)
+
+// ErrorCodeInternal is the code of errors matching no sentinel
+const ErrorCodeInternal = "internal"
+
+// errorKinds gives each sentinel its stable code, used in responses and as
+// the error_code metric attribute, and the HTTP status it maps to
+var errorKinds = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{ErrInvalidRequest, "invalid_request", http.StatusBadRequest},
+	{ErrValidation, "validation_failed", http.StatusBadRequest},
+	{ErrUnauthorized, "unauthorized", http.StatusUnauthorized},
+	{ErrForbidden, "forbidden", http.StatusForbidden},
+	{ErrNotFound, "not_found", http.StatusNotFound},
+	{ErrMethodNotAllowed, "method_not_allowed", http.StatusMethodNotAllowed},
+	{ErrPayloadTooLarge, "payload_too_large", http.StatusRequestEntityTooLarge},
+	{ErrRateLimited, "rate_limited", http.StatusTooManyRequests},
+	{ErrUnavailable, "unavailable", http.StatusServiceUnavailable},
+	{ErrStoreUnavailable, "store_unavailable", http.StatusServiceUnavailable},
+	{ErrTimeout, "timeout", http.StatusGatewayTimeout},
+}
+
+// ErrorCode returns the code of the sentinel err wraps, or
+// ErrorCodeInternal
+func ErrorCode(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.code
+		}
+	}
+	return ErrorCodeInternal
+}
+
+// HTTPStatus returns the status code err is reported with
+func HTTPStatus(err error) int {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// statusCodeReplacer turns a status text into a code
+var statusCodeReplacer = strings.NewReplacer(" ", "_", "-", "_", "'", "")
+
+// StatusErrorCode returns the code for an error response that carries no
+// typed error, such as one written by middleware, or "" below 400
+func StatusErrorCode(status int) string {
+	if status < 400 {
+		return ""
+	}
+	for _, k := range errorKinds {
+		if k.status == status {
+			return k.code
+		}
+	}
+	// Other client errors are named after their status, as not_acceptable
+	if status < 500 {
+		if text := http.StatusText(status); text != "" {
+			return statusCodeReplacer.Replace(strings.ToLower(text))
+		}
+		return "client_error"
+	}
+	return ErrorCodeInternal
+}
+
+// kindError keeps its own message while matching a sentinel
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// newError returns an error with a formatted message that matches kind
+func newError(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Variant is one arm of an experiment. Sessions are split between variants
+// in proportion to their weights.
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Experiment splits sessions between two or more variants
+type Experiment struct {
+	Name     string    `json:"name"`
+	Variants []Variant `json:"variants"`
+}
+
+// LoadExperiments reads and checks a JSON array of experiments from path.
+// Variants without a weight get 1.
+func LoadExperiments(path string) ([]Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read experiments file: %w", err)
+	}
+
+	var experiments []Experiment
+	if err := json.Unmarshal(data, &experiments); err != nil {
+		return nil, fmt.Errorf("parse experiments file: %w", err)
+	}
+
+	seen := make(map[string]bool, len(experiments))
+	for i := range experiments {
+		e := &experiments[i]
+		if err := e.check(); err != nil {
+			return nil, err
+		}
+		if seen[e.Name] {
+			return nil, newError(ErrValidation, "experiment %q is defined twice", e.Name)
+		}
+		seen[e.Name] = true
+	}
+	return experiments, nil
+}
+
+// check validates the experiment, defaulting variant weights to 1
+func (e *Experiment) check() error {
+	if e.Name == "" {
+		return newError(ErrValidation, "experiment name is required")
+	}
+	if len(e.Variants) < 2 {
+		return newError(ErrValidation, "experiment %q needs at least two variants", e.Name)
+	}
+	names := make(map[string]bool, len(e.Variants))
+	for i := range e.Variants {
+		v := &e.Variants[i]
+		if v.Name == "" {
+			return newError(ErrValidation, "experiment %q has a variant without a name", e.Name)
+		}
+		if names[v.Name] {
+			return newError(ErrValidation, "experiment %q has variant %q twice", e.Name, v.Name)
+		}
+		names[v.Name] = true
+		if v.Weight < 0 {
+			return newError(ErrValidation, "experiment %q variant %q has a negative weight", e.Name, v.Name)
+		}
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+	}
+	return nil
+}
+
+// assign picks the session's variant. The pick depends only on the
+// experiment name, its variants and the session, so it is the same on
+// every instance and across restarts.
+func (e Experiment) assign(sessionID string) string {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(e.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(sessionID))
+	bucket := int(h.Sum64() % uint64(total))
+
+	for _, v := range e.Variants {
+		if bucket < v.Weight {
+			return v.Name
+		}
+		bucket -= v.Weight
+	}
+	return e.Variants[len(e.Variants)-1].Name
+}
+
+// WithExperiments runs experiments, checked as LoadExperiments does
+func WithExperiments(experiments []Experiment) Option {
+	return func(s *Service) {
+		s.experiments = experiments
+	}
+}
+
+// Assignments returns the session's variant of every experiment, by
+// experiment name
+func (s *Service) Assignments(sessionID string) map[string]string {
+	assignments := make(map[string]string, len(s.experiments))
+	for _, e := range s.experiments {
+		assignments[e.Name] = e.assign(sessionID)
+	}
+	return assignments
+}
+
+// ExposeAssignments returns the session's assignments, as Assignments, and
+// counts them as exposures for the tenant ctx names
+func (s *Service) ExposeAssignments(ctx context.Context, sessionID string) (map[string]string, error) {
+	ts, err := s.statsFor(s.tenantID(TenantFromContext(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	assignments := s.Assignments(sessionID)
+	ts.experiments.record(assignments, func(c *variantCounts) { c.exposures++ })
+	return assignments, nil
+}
+
+// ExperimentStats are the counts per variant of an experiment
+type ExperimentStats struct {
+	Name     string         `json:"name"`
+	Variants []VariantStats `json:"variants"`
+}
+
+// VariantStats count what sessions assigned to a variant did. Exposures
+// are assignments served; sessions, events and clicks exclude bots.
+type VariantStats struct {
+	Name      string `json:"name"`
+	Exposures int64  `json:"exposures"`
+	Sessions  int64  `json:"sessions"`
+	Events    int64  `json:"events"`
+	Clicks    int64  `json:"clicks"`
+}
+
+type variantCounts struct {
+	exposures int64
+	sessions  int64
+	events    int64
+	clicks    int64
+}
+
+// experimentTracker counts per experiment and variant. Only configured
+// names are recorded, so it stays as small as the configuration.
+type experimentTracker struct {
+	mu     sync.Mutex
+	counts map[string]map[string]*variantCounts
+}
+
+func (t *experimentTracker) record(assignments map[string]string, update func(*variantCounts)) {
+	if len(assignments) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.counts == nil {
+		t.counts = make(map[string]map[string]*variantCounts)
+	}
+	for experiment, variant := range assignments {
+		variants := t.counts[experiment]
+		if variants == nil {
+			variants = make(map[string]*variantCounts)
+			t.counts[experiment] = variants
+		}
+		c := variants[variant]
+		if c == nil {
+			c = &variantCounts{}
+			variants[variant] = c
+		}
+		update(c)
+	}
+}
+
+// stats returns the counts of experiments, in their order, with every
+// variant listed
+func (t *experimentTracker) stats(experiments []Experiment) []ExperimentStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stats []ExperimentStats
+	for _, e := range experiments {
+		es := ExperimentStats{Name: e.Name, Variants: make([]VariantStats, 0, len(e.Variants))}
+		for _, v := range e.Variants {
+			vs := VariantStats{Name: v.Name}
+			if c := t.counts[e.Name][v.Name]; c != nil {
+				vs.Exposures, vs.Sessions, vs.Events, vs.Clicks = c.exposures, c.sessions, c.events, c.clicks
+			}
+			es.Variants = append(es.Variants, vs)
+		}
+		stats = append(stats, es)
+	}
+	return stats
+}
+
+// recordExperiments counts event against the variants it carries
+func recordExperiments(ts *tenantStats, event TrackingEvent, started bool) {
+	if event.Bot {
+		return
+	}
+	ts.experiments.record(event.Experiments, func(c *variantCounts) {
+		c.events++
+		if started {
+			c.sessions++
+		}
+		if event.EventType == "click" {
+			c.clicks++
+		}
+	})
+}
+
+// marshalProto encodes the ExperimentStats message documented on
+// Stats.MarshalProto
+func (e ExperimentStats) marshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, e.Name)
+	for _, v := range e.Variants {
+		var vb []byte
+		vb = protowire.AppendTag(vb, 1, protowire.BytesType)
+		vb = protowire.AppendString(vb, v.Name)
+		for i, n := range []int64{v.Exposures, v.Sessions, v.Events, v.Clicks} {
+			if n == 0 {
+				continue
+			}
+			vb = protowire.AppendTag(vb, protowire.Number(i+2), protowire.VarintType)
+			vb = protowire.AppendVarint(vb, uint64(n))
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, vb)
+	}
+	return b
+}
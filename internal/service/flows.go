@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// FlowPathLength is the number of clicks in the paths CommonPaths counts
+const FlowPathLength = 3
+
+// Bounds on what flows are kept for; elements beyond them are counted
+// under OtherLabel and paths beyond them are not counted
+const (
+	maxFlowElements = 1000
+	maxNextElements = 100
+	maxFlowPaths    = 10000
+)
+
+// FlowStep is an element clicked after another, and how often
+type FlowStep struct {
+	ElementID string  `json:"element_id"`
+	Count     int64   `json:"count"`
+	Share     float64 `json:"share"`
+}
+
+// FlowNext is what sessions clicked right after an element
+type FlowNext struct {
+	ElementID string     `json:"element_id"`
+	Total     int64      `json:"total"`
+	Next      []FlowStep `json:"next"`
+}
+
+// FlowPath is a sequence of clicks sessions made in order, and how often
+type FlowPath struct {
+	Elements []string `json:"elements"`
+	Count    int64    `json:"count"`
+}
+
+// NextElements returns the elements clicked right after element by
+// sessions of the tenant ctx names, most frequent first, at most limit
+func (s *Service) NextElements(ctx context.Context, element string, limit int) FlowNext {
+	next := FlowNext{ElementID: element, Next: []FlowStep{}}
+	if ts := s.lookupStats(s.tenantID(TenantFromContext(ctx))); ts != nil {
+		next.Total, next.Next = ts.flows.next(element, limit)
+	}
+	return next
+}
+
+// CommonPaths returns the most frequent sequences of FlowPathLength clicks
+// made by sessions of the tenant ctx names, at most limit
+func (s *Service) CommonPaths(ctx context.Context, limit int) []FlowPath {
+	if ts := s.lookupStats(s.tenantID(TenantFromContext(ctx))); ts != nil {
+		return ts.flows.paths(limit)
+	}
+	return []FlowPath{}
+}
+
+// flowTracker counts transitions between clicked elements and the paths
+// they form. It is updated as clicks arrive, from each session's last few
+// clicks, so reading it never scans events.
+type flowTracker struct {
+	mu          sync.Mutex
+	transitions map[string]map[string]int64
+	pathCounts  map[[FlowPathLength]string]int64
+}
+
+// record counts a click on element, given the session's preceding clicks
+// oldest first
+func (t *flowTracker) record(previous []string, element string) {
+	if len(previous) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.transitions == nil {
+		t.transitions = make(map[string]map[string]int64)
+		t.pathCounts = make(map[[FlowPathLength]string]int64)
+	}
+
+	from := previous[len(previous)-1]
+	next, ok := t.transitions[from]
+	if !ok {
+		if len(t.transitions) >= maxFlowElements {
+			from = OtherLabel
+			next = t.transitions[from]
+		}
+		if next == nil {
+			next = make(map[string]int64)
+			t.transitions[from] = next
+		}
+	}
+	to := element
+	if _, ok := next[to]; !ok && len(next) >= maxNextElements {
+		to = OtherLabel
+	}
+	next[to]++
+
+	if len(previous) < FlowPathLength-1 {
+		return
+	}
+	var path [FlowPathLength]string
+	copy(path[:], previous[len(previous)-FlowPathLength+1:])
+	path[FlowPathLength-1] = element
+	if _, ok := t.pathCounts[path]; ok || len(t.pathCounts) < maxFlowPaths {
+		t.pathCounts[path]++
+	}
+}
+
+func (t *flowTracker) next(element string, limit int) (int64, []FlowStep) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var total int64
+	steps := make([]FlowStep, 0, len(t.transitions[element]))
+	for to, count := range t.transitions[element] {
+		total += count
+		steps = append(steps, FlowStep{ElementID: to, Count: count})
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		if steps[i].Count != steps[j].Count {
+			return steps[i].Count > steps[j].Count
+		}
+		return steps[i].ElementID < steps[j].ElementID
+	})
+	if len(steps) > limit {
+		steps = steps[:limit]
+	}
+	for i := range steps {
+		steps[i].Share = float64(steps[i].Count) / float64(total)
+	}
+	return total, steps
+}
+
+func (t *flowTracker) paths(limit int) []FlowPath {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	paths := make([]FlowPath, 0, len(t.pathCounts))
+	for path, count := range t.pathCounts {
+		paths = append(paths, FlowPath{Elements: append([]string(nil), path[:]...), Count: count})
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Count != paths[j].Count {
+			return paths[i].Count > paths[j].Count
+		}
+		return slices.Compare(paths[i].Elements, paths[j].Elements) < 0
+	})
+	if len(paths) > limit {
+		paths = paths[:limit]
+	}
+	return paths
+}
+
+// updateClickPath counts a click in the flows and appends it to the
+// session's recent clicks. Repeated clicks on one element count once.
+// Called with sessionMutex held.
+func (s *Service) updateClickPath(session *SessionData, event TrackingEvent) {
+	if event.EventType != "click" || event.Bot || event.ElementID == "" {
+		return
+	}
+	recent := session.recentClicks
+	if n := len(recent); n > 0 && recent[n-1] == event.ElementID {
+		return
+	}
+
+	session.stats.flows.record(recent, event.ElementID)
+	if len(recent) == FlowPathLength-1 {
+		recent = append(recent[:0], recent[1:]...)
+	}
+	session.recentClicks = append(recent, event.ElementID)
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Goal is an interaction operators count as a conversion, such as clicking
+// #signup. An event completes it when it has the goal's event type and,
+// where the goal sets them, its element ID and page URL.
+type Goal struct {
+	Name      string `json:"name"`
+	EventType string `json:"event_type,omitempty"`
+	ElementID string `json:"element_id,omitempty"`
+
+	// Compared after normalization, as events' page URLs are
+	PageURL string `json:"page_url,omitempty"`
+}
+
+// LoadGoals reads and checks a JSON array of goals from path. Goals without
+// an event type are clicks.
+func LoadGoals(path string) ([]Goal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read goals file: %w", err)
+	}
+
+	var goals []Goal
+	if err := json.Unmarshal(data, &goals); err != nil {
+		return nil, fmt.Errorf("parse goals file: %w", err)
+	}
+
+	seen := make(map[string]bool, len(goals))
+	for i := range goals {
+		g := &goals[i]
+		if g.Name == "" {
+			return nil, newError(ErrValidation, "goal name is required")
+		}
+		if seen[g.Name] {
+			return nil, newError(ErrValidation, "goal %q is defined twice", g.Name)
+		}
+		seen[g.Name] = true
+		if g.EventType == "" {
+			g.EventType = "click"
+		}
+		if g.EventType == "click" && g.ElementID == "" {
+			return nil, newError(ErrValidation, "click goal %q needs an element_id", g.Name)
+		}
+	}
+	return goals, nil
+}
+
+// WithGoals counts completions of goals, checked as LoadGoals does
+func WithGoals(goals []Goal) Option {
+	return func(s *Service) {
+		s.goals = goals
+	}
+}
+
+func (g Goal) matches(event TrackingEvent) bool {
+	return event.EventType == g.EventType &&
+		(g.ElementID == "" || event.ElementID == g.ElementID) &&
+		(g.PageURL == "" || event.PageURL == g.PageURL)
+}
+
+// GoalStats are the completions of a goal. A session completes a goal at
+// most once, on the page it first did; bots are excluded. Conversion rates
+// are completions per page view.
+type GoalStats struct {
+	Name           string     `json:"name"`
+	Completions    int64      `json:"completions"`
+	ConversionRate float64    `json:"conversion_rate"`
+	Pages          []GoalPage `json:"pages,omitempty"`
+}
+
+// GoalPage are the completions of a goal on one page
+type GoalPage struct {
+	PageURL        string  `json:"page_url"`
+	Completions    int64   `json:"completions"`
+	Views          int64   `json:"views"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// goalTracker counts completions per goal and page
+type goalTracker struct {
+	mu    sync.Mutex
+	goals map[string]map[string]int64
+}
+
+func (t *goalTracker) complete(goal, page string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.goals == nil {
+		t.goals = make(map[string]map[string]int64)
+	}
+	pages := t.goals[goal]
+	if pages == nil {
+		pages = make(map[string]int64)
+		t.goals[goal] = pages
+	}
+	if _, ok := pages[page]; !ok && len(pages) >= maxTrackedPages {
+		page = OtherLabel
+	}
+	pages[page]++
+}
+
+// stats returns the completions of goals, in their order, with rates
+// against the page views in views
+func (t *goalTracker) stats(goals []Goal, views map[string]int64) []GoalStats {
+	var totalViews int64
+	for _, v := range views {
+		totalViews += v
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stats []GoalStats
+	for _, g := range goals {
+		gs := GoalStats{Name: g.Name}
+		for page, n := range t.goals[g.Name] {
+			gs.Completions += n
+			gs.Pages = append(gs.Pages, GoalPage{
+				PageURL:        page,
+				Completions:    n,
+				Views:          views[page],
+				ConversionRate: rate(n, views[page]),
+			})
+		}
+		gs.ConversionRate = rate(gs.Completions, totalViews)
+		sort.Slice(gs.Pages, func(i, j int) bool { return gs.Pages[i].PageURL < gs.Pages[j].PageURL })
+		stats = append(stats, gs)
+	}
+	return stats
+}
+
+// rate is n per total, or 0 without any
+func rate(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// recordGoals counts the goals event completes for its session for the
+// first time
+func (s *Service) recordGoals(ctx context.Context, event TrackingEvent, ts *tenantStats) {
+	if len(s.goals) == 0 || event.Bot || event.SessionID == "" {
+		return
+	}
+
+	var completed []string
+	s.sessionMutex.Lock()
+	if session, ok := s.sessions[sessionKey{tenant: event.Tenant, id: event.SessionID}]; ok {
+		for _, g := range s.goals {
+			if session.goals[g.Name] || !g.matches(event) {
+				continue
+			}
+			if session.goals == nil {
+				session.goals = make(map[string]bool)
+			}
+			session.goals[g.Name] = true
+			completed = append(completed, g.Name)
+		}
+	}
+	s.sessionMutex.Unlock()
+
+	for _, name := range completed {
+		ts.goals.complete(name, event.PageURL)
+		s.goalCompletions.Add(ctx, 1, metric.WithAttributes(append(s.tenantAttrs(event.Tenant),
+			attribute.String("goal", name),
+		)...))
+	}
+}
+
+// marshalProto encodes the GoalStats message documented on
+// Stats.MarshalProto
+func (g GoalStats) marshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, g.Name)
+	if g.Completions != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(g.Completions))
+	}
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(g.ConversionRate))
+	for _, p := range g.Pages {
+		var pb []byte
+		pb = protowire.AppendTag(pb, 1, protowire.BytesType)
+		pb = protowire.AppendString(pb, p.PageURL)
+		for i, v := range []int64{p.Completions, p.Views} {
+			if v == 0 {
+				continue
+			}
+			pb = protowire.AppendTag(pb, protowire.Number(i+2), protowire.VarintType)
+			pb = protowire.AppendVarint(pb, uint64(v))
+		}
+		pb = protowire.AppendTag(pb, 4, protowire.Fixed64Type)
+		pb = protowire.AppendFixed64(pb, math.Float64bits(p.ConversionRate))
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, pb)
+	}
+	return b
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"sync"
+)
+
+// HeatmapGridSize is the number of rows and columns heatmaps split the
+// viewport into
+const HeatmapGridSize = 20
+
+// MetricCursorPositionPercent is the histogram of click positions as a
+// percentage of the viewport
+const MetricCursorPositionPercent = "worker_cursor_position_percent"
+
+// percentBuckets are the bucket boundaries of MetricCursorPositionPercent
+var percentBuckets = []float64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+
+// viewportPercent returns the cursor position as a percentage of the
+// viewport, or false when the event does not say how large it is
+func viewportPercent(event TrackingEvent) (x, y float64, ok bool) {
+	if event.ViewportX <= 0 || event.ViewportY <= 0 {
+		return 0, 0, false
+	}
+	x = min(float64(event.CursorX)/float64(event.ViewportX)*100, 100)
+	y = min(float64(event.CursorY)/float64(event.ViewportY)*100, 100)
+	return x, y, true
+}
+
+// Heatmap counts the clicks on a page by where they landed in the
+// viewport, so screens of any size line up. Cells[row][column] covers
+// 100/GridSize percent of the height and width each, from the top left.
+type Heatmap struct {
+	PageURL  string    `json:"page_url"`
+	Clicks   int64     `json:"clicks"`
+	GridSize int       `json:"grid_size"`
+	Cells    [][]int64 `json:"cells"`
+}
+
+// GetHeatmap returns the click heatmap of a page for the tenant ctx names,
+// or an error matching ErrNotFound when no click on it carried a viewport
+// size. pageURL is normalized as event page URLs are.
+func (s *Service) GetHeatmap(ctx context.Context, pageURL string) (Heatmap, error) {
+	if s.pageURLs != nil {
+		pageURL = s.pageURLs.normalize(pageURL)
+	}
+	if ts := s.lookupStats(s.tenantID(TenantFromContext(ctx))); ts != nil {
+		if heatmap, ok := ts.heatmaps.heatmap(pageURL); ok {
+			return heatmap, nil
+		}
+	}
+	return Heatmap{}, newError(ErrNotFound, "no clicks recorded for page %q", pageURL)
+}
+
+type heatmapGrid struct {
+	clicks int64
+	cells  [HeatmapGridSize][HeatmapGridSize]int64
+}
+
+// heatmapTracker keeps a click grid per page
+type heatmapTracker struct {
+	mu    sync.Mutex
+	pages map[string]*heatmapGrid
+}
+
+// add counts a click at x and y percent of the viewport on page
+func (t *heatmapTracker) add(page string, x, y float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pages == nil {
+		t.pages = make(map[string]*heatmapGrid)
+	}
+	g, ok := t.pages[page]
+	if !ok {
+		if len(t.pages) >= maxTrackedPages {
+			page = OtherLabel
+			g = t.pages[page]
+		}
+		if g == nil {
+			g = &heatmapGrid{}
+			t.pages[page] = g
+		}
+	}
+
+	g.clicks++
+	g.cells[heatmapCell(y)][heatmapCell(x)]++
+}
+
+// heatmapCell returns the grid index of a percentage; 100 falls in the
+// last cell
+func heatmapCell(percent float64) int {
+	return min(int(percent*HeatmapGridSize/100), HeatmapGridSize-1)
+}
+
+func (t *heatmapTracker) heatmap(page string) (Heatmap, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g, ok := t.pages[page]
+	if !ok {
+		return Heatmap{}, false
+	}
+	cells := make([][]int64, HeatmapGridSize)
+	for row := range cells {
+		cells[row] = append([]int64(nil), g.cells[row][:]...)
+	}
+	return Heatmap{PageURL: page, Clicks: g.clicks, GridSize: HeatmapGridSize, Cells: cells}, true
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// hllPrecision gives sketches 2^12 one-byte registers, 4 KiB each, for a
+// standard error of about 1.6%
+const hllPrecision = 12
+
+const hllRegisters = 1 << hllPrecision
+
+// hllSeed keys the hash; sketches are never persisted, so a per-process
+// seed is enough
+var hllSeed = maphash.MakeSeed()
+
+// hyperLogLog estimates the number of distinct values added to it
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func (h *hyperLogLog) add(value string) {
+	hash := maphash.String(hllSeed, value)
+	index := hash >> (64 - hllPrecision)
+	// The guard bit caps the rank when the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() int64 {
+	const m = float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	// Linear counting is more accurate while many registers are empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// uniqueWindow counts distinct sessions and visitors since start
+type uniqueWindow struct {
+	start    time.Time
+	sessions hyperLogLog
+	visitors hyperLogLog
+}
+
+// roll starts the window over when now falls in a later period
+func (w *uniqueWindow) roll(now time.Time, period time.Duration) {
+	start := now.Truncate(period)
+	if !start.Equal(w.start) {
+		*w = uniqueWindow{start: start}
+	}
+}
+
+func (w *uniqueWindow) add(session, visitor string) {
+	w.sessions.add(session)
+	w.visitors.add(visitor)
+}
+
+// uniqueCounter estimates distinct sessions and visitors in the current UTC
+// hour and day, in a fixed 16 KiB however many there are
+type uniqueCounter struct {
+	mu   sync.Mutex
+	hour uniqueWindow
+	day  uniqueWindow
+}
+
+func (u *uniqueCounter) add(now time.Time, session, visitor string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.hour.roll(now, time.Hour)
+	u.day.roll(now, 24*time.Hour)
+	u.hour.add(session, visitor)
+	u.day.add(session, visitor)
+}
+
+// Uniques are approximate distinct counts for the current UTC hour and day
+type Uniques struct {
+	SessionsHour int64 `json:"unique_sessions_hour"`
+	SessionsDay  int64 `json:"unique_sessions_day"`
+	VisitorsHour int64 `json:"unique_visitors_hour"`
+	VisitorsDay  int64 `json:"unique_visitors_day"`
+}
+
+func (u *uniqueCounter) uniques(now time.Time) Uniques {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.hour.roll(now, time.Hour)
+	u.day.roll(now, 24*time.Hour)
+	return Uniques{
+		SessionsHour: u.hour.sessions.estimate(),
+		SessionsDay:  u.day.sessions.estimate(),
+		VisitorsHour: u.hour.visitors.estimate(),
+		VisitorsDay:  u.day.visitors.estimate(),
+	}
+}
+
+// visitorKey identifies a visitor without cookies, by a keyed hash of the
+// client address and user agent. It is taken before the address is
+// truncated, which would merge the visitors of a network, and so the
+// address itself is not held.
+func (s *Service) visitorKey(event TrackingEvent) string {
+	mac := hmac.New(sha256.New, s.visitorSecret)
+	mac.Write([]byte(event.ClientIP))
+	mac.Write([]byte{0})
+	mac.Write([]byte(event.UserAgent))
+	return string(mac.Sum(nil))
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// PageConfig sets how page URLs are normalized, so the same page is
+// counted under one URL
+type PageConfig struct {
+	// TrackingParams are query parameters removed from page URLs, matched
+	// case-insensitively. A trailing '*' matches any suffix.
+	TrackingParams []string
+
+	// Templates replace matching paths, such as "/users/{id}" for
+	// "/users/42". Segments in braces match any one segment; the first
+	// matching template wins.
+	Templates []string
+}
+
+// WithPageNormalization normalizes the page URL of every event before it is
+// kept or counted: tracking parameters are removed, the remaining ones
+// sorted, the scheme and host lowercased, default ports dropped and paths
+// matched against the templates
+func WithPageNormalization(cfg PageConfig) Option {
+	return func(s *Service) {
+		n := &pageNormalizer{params: make(map[string]bool)}
+		for _, p := range cfg.TrackingParams {
+			p = strings.ToLower(p)
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				n.prefixes = append(n.prefixes, prefix)
+			} else {
+				n.params[p] = true
+			}
+		}
+		for _, t := range cfg.Templates {
+			n.templates = append(n.templates, pathSegments(t))
+		}
+		s.pageURLs = n
+	}
+}
+
+type pageNormalizer struct {
+	params    map[string]bool
+	prefixes  []string
+	templates [][]string
+}
+
+// normalize returns pageURL normalized, or unchanged when it does not
+// parse
+func (n *pageNormalizer) normalize(pageURL string) string {
+	if pageURL == "" {
+		return pageURL
+	}
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return pageURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	switch {
+	case u.Scheme == "http":
+		host = strings.TrimSuffix(host, ":80")
+	case u.Scheme == "https":
+		host = strings.TrimSuffix(host, ":443")
+	}
+	u.Host = host
+	if u.Host != "" && u.Path == "" {
+		u.Path = "/"
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			if n.tracking(key) {
+				delete(query, key)
+			}
+		}
+		// Encode sorts by key, so parameter order does not matter either
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+
+	template, ok := n.template(u.Path)
+	if !ok {
+		return u.String()
+	}
+	// Braces would be escaped, so the template is put back as written
+	u.Path, u.RawPath = template, ""
+	return strings.Replace(u.String(), u.EscapedPath(), template, 1)
+}
+
+func (n *pageNormalizer) tracking(param string) bool {
+	param = strings.ToLower(param)
+	if n.params[param] {
+		return true
+	}
+	for _, prefix := range n.prefixes {
+		if strings.HasPrefix(param, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// template returns the first template path matches, with its segments as
+// the template names them
+func (n *pageNormalizer) template(path string) (string, bool) {
+	segments := pathSegments(path)
+next:
+	for _, template := range n.templates {
+		if len(template) != len(segments) {
+			continue
+		}
+		for i, t := range template {
+			if !isPlaceholder(t) && t != segments[i] {
+				continue next
+			}
+		}
+		return "/" + strings.Join(template, "/"), true
+	}
+	return "", false
+}
+
+// pathSegments splits path at '/', ignoring leading and trailing slashes
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func isPlaceholder(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+// PageStats are the aggregates of one page. A view is a session arriving
+// on the page; views and clicks are both counted within sessions, so they
+// exclude bots and aggregate-only events alike.
+type PageStats struct {
+	PageURL       string  `json:"page_url"`
+	Views         int64   `json:"views"`
+	Clicks        int64   `json:"clicks"`
+	AverageScroll float64 `json:"average_scroll_percent"`
+}
+
+type pageCounts struct {
+	views  int64
+	clicks int64
+}
+
+// pageTracker counts views and clicks per page
+type pageTracker struct {
+	mu    sync.Mutex
+	pages map[string]*pageCounts
+}
+
+// page returns the counts for url, or for OtherLabel beyond
+// maxTrackedPages. The caller holds mu.
+func (t *pageTracker) page(url string) *pageCounts {
+	if t.pages == nil {
+		t.pages = make(map[string]*pageCounts)
+	}
+	if p, ok := t.pages[url]; ok {
+		return p
+	}
+	if len(t.pages) >= maxTrackedPages {
+		url = OtherLabel
+		if p, ok := t.pages[url]; ok {
+			return p
+		}
+	}
+	p := &pageCounts{}
+	t.pages[url] = p
+	return p
+}
+
+func (t *pageTracker) view(url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.page(url).views++
+}
+
+func (t *pageTracker) click(url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.page(url).clicks++
+}
+
+// views returns the views by page URL
+func (t *pageTracker) views() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	views := make(map[string]int64, len(t.pages))
+	for url, p := range t.pages {
+		views[url] = p.views
+	}
+	return views
+}
+
+// stats returns the pages by URL, with the average of depths for each
+func (t *pageTracker) stats(depths []ScrollDepth) []PageStats {
+	scroll := make(map[string]float64, len(depths))
+	for _, d := range depths {
+		scroll[d.PageURL] = d.Average
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pages := make([]PageStats, 0, len(t.pages))
+	for url, p := range t.pages {
+		pages = append(pages, PageStats{
+			PageURL:       url,
+			Views:         p.views,
+			Clicks:        p.clicks,
+			AverageScroll: scroll[url],
+		})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PageURL < pages[j].PageURL })
+	return pages
+}
+
+// marshalProto encodes the PageStats message documented on
+// Stats.MarshalProto
+func (p PageStats) marshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, p.PageURL)
+	for i, v := range []int64{p.Views, p.Clicks} {
+		if v == 0 {
+			continue
+		}
+		b = protowire.AppendTag(b, protowire.Number(i+2), protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	}
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(p.AverageScroll))
+	return b
+}
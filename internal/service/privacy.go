@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Client address anonymization modes
+const (
+	IPModeNone     = "none"
+	IPModeTruncate = "truncate"
+	IPModeHash     = "hash"
+)
+
+// RedactedValue replaces redacted custom field values
+const RedactedValue = "redacted"
+
+// PrivacyConfig sets how events are scrubbed before they are kept in a
+// session, logged or exported
+type PrivacyConfig struct {
+	// IPMode is IPModeNone, IPModeTruncate to zero the host part (IPv4 to
+	// /24, IPv6 to /48) or IPModeHash to replace the address with a keyed
+	// hash, which still tells visitors apart but cannot be reversed
+	IPMode string
+
+	// IPHashKey keys the hash, and the visitor hash of unique visitor
+	// counts. Hashes only match across restarts and replicas sharing the
+	// key; when empty a random key is made, so they match within one
+	// process.
+	IPHashKey []byte
+
+	// StripQuery drops the query string and fragment from page URLs
+	StripQuery bool
+
+	// RedactFields are custom field keys, matched case-insensitively, whose
+	// values are replaced with RedactedValue
+	RedactFields []string
+}
+
+// WithPrivacy scrubs events as cfg says. Enrichment that needs the raw
+// address, such as GeoIP, runs first.
+func WithPrivacy(cfg PrivacyConfig) Option {
+	return func(s *Service) {
+		if len(cfg.IPHashKey) > 0 {
+			s.visitorSecret = cfg.IPHashKey
+		} else if cfg.IPMode == IPModeHash {
+			cfg.IPHashKey = make([]byte, 32)
+			_, _ = rand.Read(cfg.IPHashKey)
+		}
+		redact := make(map[string]bool, len(cfg.RedactFields))
+		for _, key := range cfg.RedactFields {
+			redact[strings.ToLower(key)] = true
+		}
+		s.privacy = &privacy{cfg: cfg, redact: redact}
+	}
+}
+
+type privacy struct {
+	cfg    PrivacyConfig
+	redact map[string]bool
+}
+
+func (p *privacy) scrub(event *TrackingEvent) {
+	event.ClientIP = p.anonymizeIP(event.ClientIP)
+	if p.cfg.StripQuery {
+		event.PageURL = stripQuery(event.PageURL)
+	}
+
+	if len(p.redact) == 0 || len(event.Custom) == 0 {
+		return
+	}
+	// Copied so the caller's map is left alone
+	custom := make(map[string]interface{}, len(event.Custom))
+	for key, value := range event.Custom {
+		if p.redact[strings.ToLower(key)] {
+			value = RedactedValue
+		}
+		custom[key] = value
+	}
+	event.Custom = custom
+}
+
+func (p *privacy) anonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+
+	switch p.cfg.IPMode {
+	case IPModeTruncate:
+		bits := 24
+		if addr.Is6() {
+			bits = 48
+		}
+		prefix, _ := addr.Prefix(bits)
+		return prefix.Addr().String()
+	case IPModeHash:
+		mac := hmac.New(sha256.New, p.cfg.IPHashKey)
+		mac.Write(addr.AsSlice())
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return ip
+}
+
+// stripQuery removes the query string and fragment, which often carry
+// tokens, email addresses or search terms
+func stripQuery(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		if i := strings.IndexAny(pageURL, "?#"); i >= 0 {
+			return pageURL[:i]
+		}
+		return pageURL
+	}
+	u.RawQuery, u.ForceQuery = "", false
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateHistory is how far back GetRates can look
+const RateHistory = time.Hour
+
+// rateResolution is the width of the buckets interactions are counted in
+const rateResolution = time.Minute
+
+// Rates are interaction rates per minute over a recent window
+type Rates struct {
+	Window          string  `json:"window"`
+	ClicksPerMinute float64 `json:"clicks_per_minute"`
+	EventsPerMinute float64 `json:"events_per_minute"`
+
+	// SessionsPerMinute counts sessions started
+	SessionsPerMinute float64 `json:"sessions_per_minute"`
+}
+
+// GetRates returns the interaction rates of the tenant ctx names over the
+// window before now. The window is clamped to RateHistory, and shortened to
+// the uptime so a fresh process does not report rates diluted by time it
+// was not running.
+func (s *Service) GetRates(ctx context.Context, window time.Duration) Rates {
+	window = min(max(window, rateResolution), RateHistory)
+	ts := s.lookupStats(s.tenantID(TenantFromContext(ctx)))
+	if ts == nil {
+		ts = &tenantStats{}
+	}
+	return ts.rates.rates(time.Now(), s.startTime, window)
+}
+
+type rateBucket struct {
+	index    int64
+	clicks   int64
+	events   int64
+	sessions int64
+}
+
+// rateTracker counts interactions in a ring of per-minute buckets covering
+// RateHistory
+type rateTracker struct {
+	mu      sync.Mutex
+	buckets [RateHistory/rateResolution + 1]rateBucket
+}
+
+func (t *rateTracker) record(now time.Time, click, sessionStarted bool) {
+	index := now.UnixNano() / int64(rateResolution)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[index%int64(len(t.buckets))]
+	if b.index != index {
+		*b = rateBucket{index: index}
+	}
+	b.events++
+	if click {
+		b.clicks++
+	}
+	if sessionStarted {
+		b.sessions++
+	}
+}
+
+func (t *rateTracker) rates(now, start time.Time, window time.Duration) Rates {
+	current := now.UnixNano() / int64(rateResolution)
+	n := int64((window + rateResolution - 1) / rateResolution)
+	oldest := current - n + 1
+
+	var total rateBucket
+	t.mu.Lock()
+	for index := oldest; index <= current; index++ {
+		b := t.buckets[index%int64(len(t.buckets))]
+		if b.index != index {
+			continue
+		}
+		total.clicks += b.clicks
+		total.events += b.events
+		total.sessions += b.sessions
+	}
+	t.mu.Unlock()
+
+	// The buckets span from the start of the oldest one, which is up to a
+	// minute more than the window, to now
+	from := time.Unix(0, oldest*int64(rateResolution))
+	if from.Before(start) {
+		from = start
+	}
+	minutes := now.Sub(from).Minutes()
+
+	r := Rates{Window: windowLabel(window)}
+	if minutes > 0 {
+		r.ClicksPerMinute = float64(total.clicks) / minutes
+		r.EventsPerMinute = float64(total.events) / minutes
+		r.SessionsPerMinute = float64(total.sessions) / minutes
+	}
+	return r
+}
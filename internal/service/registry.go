@@ -0,0 +1,229 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Custom field value types accepted in event type definitions
+const (
+	FieldTypeString  = "string"
+	FieldTypeNumber  = "number"
+	FieldTypeBoolean = "boolean"
+)
+
+// builtinEventTypes are always accepted and never need registering
+var builtinEventTypes = map[string]bool{
+	"click":     true,
+	"mousemove": true,
+	"scroll":    true,
+	"custom":    true,
+	"replay":    true,
+}
+
+// FieldConstraint restricts the value of a single custom field
+type FieldConstraint struct {
+	Type      string   `json:"type"`
+	MaxLength int      `json:"max_length,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+}
+
+// EventTypeDefinition describes an operator-registered custom event type
+type EventTypeDefinition struct {
+	Name           string                     `json:"name"`
+	RequiredFields []string                   `json:"required_fields,omitempty"`
+	Fields         map[string]FieldConstraint `json:"fields,omitempty"`
+}
+
+// EventTypeRegistry holds the custom event types known to the service.
+// In strict mode events with an unknown type are rejected, otherwise they
+// are accepted and logged as a warning.
+type EventTypeRegistry struct {
+	mu     sync.RWMutex
+	types  map[string]EventTypeDefinition
+	strict bool
+}
+
+func NewEventTypeRegistry(strict bool) *EventTypeRegistry {
+	return &EventTypeRegistry{
+		types:  make(map[string]EventTypeDefinition),
+		strict: strict,
+	}
+}
+
+// LoadEventTypeDefinitions reads a JSON array of event type definitions from path
+func LoadEventTypeDefinitions(path string) ([]EventTypeDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read event types file: %w", err)
+	}
+
+	var defs []EventTypeDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, fmt.Errorf("parse event types file: %w", err)
+	}
+	return defs, nil
+}
+
+// Register adds or replaces an event type definition
+func (r *EventTypeRegistry) Register(def EventTypeDefinition) error {
+	if def.Name == "" {
+		return newError(ErrValidation, "event type name is required")
+	}
+	if len(def.Name) > MaxEventTypeLen {
+		return newError(ErrValidation, "event type name must be at most %d characters", MaxEventTypeLen)
+	}
+	if builtinEventTypes[def.Name] {
+		return newError(ErrValidation, "event type %q is built in and cannot be redefined", def.Name)
+	}
+	for name, c := range def.Fields {
+		switch c.Type {
+		case FieldTypeString, FieldTypeNumber, FieldTypeBoolean:
+		default:
+			return newError(ErrValidation, "field %q has unsupported type %q", name, c.Type)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[def.Name] = def
+	return nil
+}
+
+// Unregister removes an event type, reporting whether it existed
+func (r *EventTypeRegistry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.types[name]
+	delete(r.types, name)
+	return ok
+}
+
+// Lookup returns the definition registered under name
+func (r *EventTypeRegistry) Lookup(name string) (EventTypeDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	def, ok := r.types[name]
+	return def, ok
+}
+
+// List returns all registered definitions sorted by name
+func (r *EventTypeRegistry) List() []EventTypeDefinition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	defs := make([]EventTypeDefinition, 0, len(r.types))
+	for _, def := range r.types {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// Known reports whether eventType is built in or registered
+func (r *EventTypeRegistry) Known(eventType string) bool {
+	if builtinEventTypes[eventType] {
+		return true
+	}
+	_, ok := r.Lookup(eventType)
+	return ok
+}
+
+// SetStrict switches between rejecting and warning about unknown event types
+func (r *EventTypeRegistry) SetStrict(strict bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.strict = strict
+}
+
+func (r *EventTypeRegistry) isStrict() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.strict
+}
+
+func (r *EventTypeRegistry) validate(event TrackingEvent, verr *ValidationError) {
+	if event.EventType == "" || builtinEventTypes[event.EventType] {
+		return
+	}
+
+	def, ok := r.Lookup(event.EventType)
+	if !ok {
+		if r.isStrict() {
+			verr.add("event_type", "unknown event type %q", event.EventType)
+		}
+		return
+	}
+
+	for _, name := range def.RequiredFields {
+		if _, ok := event.Custom[name]; !ok {
+			verr.add("custom."+name, "is required for event type %q", def.Name)
+		}
+	}
+
+	for _, name := range sortedKeys(def.Fields) {
+		value, ok := event.Custom[name]
+		if !ok {
+			continue
+		}
+		def.Fields[name].check("custom."+name, value, verr)
+	}
+}
+
+func (c FieldConstraint) check(field string, value interface{}, verr *ValidationError) {
+	switch c.Type {
+	case FieldTypeString:
+		str, ok := value.(string)
+		if !ok {
+			verr.add(field, "must be a string")
+			return
+		}
+		if c.MaxLength > 0 && len(str) > c.MaxLength {
+			verr.add(field, "must be at most %d characters", c.MaxLength)
+		}
+		if len(c.Enum) > 0 && !contains(c.Enum, str) {
+			verr.add(field, "must be one of %v", c.Enum)
+		}
+	case FieldTypeNumber:
+		num, ok := value.(float64)
+		if !ok {
+			verr.add(field, "must be a number")
+			return
+		}
+		if c.Min != nil && num < *c.Min {
+			verr.add(field, "must be at least %g", *c.Min)
+		}
+		if c.Max != nil && num > *c.Max {
+			verr.add(field, "must be at most %g", *c.Max)
+		}
+	case FieldTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			verr.add(field, "must be a boolean")
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
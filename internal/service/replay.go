@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventTypeReplay events carry a chunk of a session recording
+const EventTypeReplay = "replay"
+
+// Replay chunk encodings
+const (
+	// ReplayEncodingNone chunks hold the recorder's JSON as it is
+	ReplayEncodingNone = ""
+
+	// ReplayEncodingGzip chunks hold it gzip-compressed
+	ReplayEncodingGzip = "gzip"
+)
+
+// MaxReplaySequence bounds chunk sequence numbers
+const MaxReplaySequence = 1 << 30
+
+// ReplayChunk is one piece of a session recording: DOM snapshots and
+// mutations as the recorder serialized them. Chunks are numbered from 0 and
+// played back in sequence order, whatever order they arrive in.
+type ReplayChunk struct {
+	Sequence int    `json:"sequence"`
+	Encoding string `json:"encoding,omitempty"`
+	Data     []byte `json:"data"`
+
+	// Set by the server to the event's timestamp
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReplayConfig bounds what is kept of session recordings. A session's
+// chunks beyond MaxSessionBytes, and any beyond MaxBytes in total, are
+// dropped; recordings are deleted Retention after their last chunk.
+type ReplayConfig struct {
+	MaxSessionBytes int64
+	MaxBytes        int64
+	Retention       time.Duration
+}
+
+// WithReplay keeps the chunks of replay events so sessions can be played
+// back. Without it replay events are processed like any other, and their
+// chunks are discarded.
+func WithReplay(cfg ReplayConfig) Option {
+	return func(s *Service) {
+		s.replays = &replayStore{cfg: cfg, recordings: make(map[sessionKey]*recording)}
+		s.erasers["replays"] = s.replays.erase
+	}
+}
+
+// Replay returns the recording of a session of the tenant ctx names, in
+// sequence order, or an error matching ErrNotFound
+func (s *Service) Replay(ctx context.Context, sessionID string) ([]ReplayChunk, error) {
+	if s.replays != nil {
+		key := sessionKey{tenant: s.tenantID(TenantFromContext(ctx)), id: sessionID}
+		if chunks := s.replays.chunks(key); chunks != nil {
+			return chunks, nil
+		}
+	}
+	return nil, newError(ErrNotFound, "no recording is kept for session %q", sessionID)
+}
+
+// CleanupOldReplays deletes recordings past their retention
+func (s *Service) CleanupOldReplays() {
+	if s.replays == nil {
+		return
+	}
+	if n := s.replays.expire(time.Now()); n > 0 {
+		slog.Debug("Expired session recordings", "count", n)
+	}
+}
+
+// SetReplayRetention changes how long recordings are kept after their
+// last chunk
+func (s *Service) SetReplayRetention(d time.Duration) {
+	if s.replays == nil {
+		return
+	}
+	s.replays.mu.Lock()
+	defer s.replays.mu.Unlock()
+	s.replays.cfg.Retention = d
+}
+
+// recording is a session's chunks, sorted by sequence
+type recording struct {
+	chunks  []ReplayChunk
+	bytes   int64
+	updated time.Time
+}
+
+type replayStore struct {
+	cfg ReplayConfig
+
+	mu         sync.Mutex
+	recordings map[sessionKey]*recording
+	bytes      int64
+}
+
+// replayStoreLimit is why a chunk is dropped when the store is full
+const replayStoreLimit = "store limit"
+
+// add keeps chunk, reporting why it was dropped if it was not. A chunk
+// whose sequence is already kept is a retry and is ignored.
+func (st *replayStore) add(key sessionKey, chunk ReplayChunk, now time.Time) (dropped string) {
+	size := int64(len(chunk.Data))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	rec := st.recordings[key]
+	if rec == nil {
+		rec = &recording{}
+	}
+	i := sort.Search(len(rec.chunks), func(i int) bool { return rec.chunks[i].Sequence >= chunk.Sequence })
+	if i < len(rec.chunks) && rec.chunks[i].Sequence == chunk.Sequence {
+		return ""
+	}
+	switch {
+	case rec.bytes+size > st.cfg.MaxSessionBytes:
+		return "session limit"
+	case st.bytes+size > st.cfg.MaxBytes:
+		return replayStoreLimit
+	}
+
+	rec.chunks = append(rec.chunks, ReplayChunk{})
+	copy(rec.chunks[i+1:], rec.chunks[i:])
+	rec.chunks[i] = chunk
+	rec.bytes += size
+	rec.updated = now
+	st.recordings[key] = rec
+	st.bytes += size
+	return ""
+}
+
+// chunks returns a copy of a session's chunks, or nil if none are kept.
+// Chunk data is never modified once stored, so it is shared.
+func (st *replayStore) chunks(key sessionKey) []ReplayChunk {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	rec, ok := st.recordings[key]
+	if !ok {
+		return nil
+	}
+	return append([]ReplayChunk(nil), rec.chunks...)
+}
+
+func (st *replayStore) expire(now time.Time) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	n := 0
+	for key, rec := range st.recordings {
+		if now.Sub(rec.updated) > st.cfg.Retention {
+			delete(st.recordings, key)
+			st.bytes -= rec.bytes
+			n++
+		}
+	}
+	return n
+}
+
+// erase is the Eraser for recordings
+func (st *replayStore) erase(_ context.Context, subject Subject) (int, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	erased := 0
+	for _, id := range subject.Sessions {
+		key := sessionKey{tenant: subject.Tenant, id: id}
+		rec, ok := st.recordings[key]
+		if !ok {
+			continue
+		}
+		delete(st.recordings, key)
+		st.bytes -= rec.bytes
+		erased += len(rec.chunks)
+	}
+	return erased, nil
+}
+
+// recordReplay keeps the chunk event carries. Chunks of bots are not kept.
+// A chunk over the session's limit is dropped, while one the full store
+// refuses fails with ErrStoreUnavailable, to be sent again once older
+// recordings expire.
+func (s *Service) recordReplay(ctx context.Context, event TrackingEvent) error {
+	if s.replays == nil || event.Replay == nil || event.Bot {
+		return nil
+	}
+
+	chunk := *event.Replay
+	chunk.Timestamp = event.Timestamp
+	key := sessionKey{tenant: event.Tenant, id: event.SessionID}
+	reason := s.replays.add(key, chunk, time.Now())
+	if reason == "" {
+		return nil
+	}
+	slog.WarnContext(ctx, "Dropped replay chunk",
+		"reason", reason,
+		"session_id", event.SessionID,
+		"sequence", chunk.Sequence,
+	)
+	if reason == replayStoreLimit {
+		return newError(ErrStoreUnavailable, "replay store is full")
+	}
+	return nil
+}
+
+// validateReplay checks the chunk of a replay event, and that other events
+// carry none
+func validateReplay(event TrackingEvent, verr *ValidationError) {
+	if event.EventType != EventTypeReplay {
+		if event.Replay != nil {
+			verr.add("replay", "is only allowed on %s events", EventTypeReplay)
+		}
+		return
+	}
+
+	if event.SessionID == "" {
+		verr.add("session_id", "is required for %s events", EventTypeReplay)
+	}
+	chunk := event.Replay
+	if chunk == nil {
+		verr.add("replay", "is required for %s events", EventTypeReplay)
+		return
+	}
+	if chunk.Sequence < 0 || chunk.Sequence > MaxReplaySequence {
+		verr.add("replay.sequence", "must be between 0 and %d", MaxReplaySequence)
+	}
+	switch chunk.Encoding {
+	case ReplayEncodingNone, ReplayEncodingGzip:
+	default:
+		verr.add("replay.encoding", "must be empty or %q", ReplayEncodingGzip)
+	}
+	if len(chunk.Data) == 0 {
+		verr.add("replay.data", "is required")
+	}
+}
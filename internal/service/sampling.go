@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// WithEventSampling sets, per event type, the fraction of events that
+// produce spans and the fraction that produce metric recordings. Types
+// not listed are always recorded. Session and click aggregates count every
+// event regardless. Sampled counters are scaled by the inverse rate so
+// they estimate every event, while histograms keep the sampled fraction,
+// which leaves their distribution unchanged.
+func WithEventSampling(spans, metrics map[string]float64) Option {
+	return func(s *Service) {
+		s.SetEventSampling(spans, metrics)
+	}
+}
+
+// sampleRates are per event type fractions of events that are traced and
+// measured
+type sampleRates struct {
+	spans   map[string]float64
+	metrics map[string]float64
+}
+
+// SetEventSampling replaces the sample rates WithEventSampling set, for
+// the events processed from then on
+func (s *Service) SetEventSampling(spans, metrics map[string]float64) {
+	s.sampleRates.Store(&sampleRates{spans: spans, metrics: metrics})
+}
+
+// sampleEvent decides whether an event of the given type is kept at the
+// rate rates lists for it
+func sampleEvent(rates map[string]float64, eventType string) bool {
+	rate, ok := rates[eventType]
+	if !ok || rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate
+}
+
+// sampleWeight decides, like sampleEvent, whether an event of the given
+// type is measured and returns the count it stands for: 0 when it is not,
+// and on average 1/rate when it is. The inverse rate is rounded up or down
+// at random so sums of weights stay unbiased.
+func sampleWeight(rates map[string]float64, eventType string) int64 {
+	rate, ok := rates[eventType]
+	if !ok || rate >= 1 {
+		return 1
+	}
+	if rand.Float64() >= rate {
+		return 0
+	}
+	inverse := 1 / rate
+	weight := math.Floor(inverse)
+	if rand.Float64() < inverse-weight {
+		weight++
+	}
+	return int64(weight)
+}
+
+// untracedKey marks the context of an event not sampled for spans
+type untracedKey struct{}
+
+// startEventSpan starts a span for event processing, or returns a no-op
+// span when the event was not sampled for spans
+func (s *Service) startEventSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if untraced, _ := ctx.Value(untracedKey{}).(bool); untraced {
+		return ctx, noop.Span{}
+	}
+	return s.tracer.Start(ctx, name)
+}
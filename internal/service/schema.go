@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Tracking event schema versions. Payloads without schema_version are
+// version 1.
+const (
+	// SchemaVersion1 timestamps are RFC 3339 strings or Unix milliseconds
+	SchemaVersion1 = 1
+
+	// SchemaVersion2 timestamps are RFC 3339 strings only
+	SchemaVersion2 = 2
+
+	// CurrentSchemaVersion is the version events are processed in
+	CurrentSchemaVersion = SchemaVersion2
+)
+
+// schemaUpgrades[v-1] rewrites a version v payload as version v+1
+var schemaUpgrades = []func(payload map[string]json.RawMessage) error{
+	upgradeSchemaV1,
+}
+
+// UpgradeEvent rewrites a tracking event payload of any supported schema
+// version as CurrentSchemaVersion, one version at a time. It returns a
+// *ValidationError when schema_version is invalid or newer than the server
+// supports, and an error matching ErrInvalidRequest when data is not a
+// JSON object.
+func UpgradeEvent(data []byte) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(data, &payload); err != nil || payload == nil {
+		return nil, newError(ErrInvalidRequest, "tracking event must be a JSON object")
+	}
+
+	version := SchemaVersion1
+	if raw, ok := payload["schema_version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil || version < SchemaVersion1 {
+			return nil, &ValidationError{Fields: []FieldError{{
+				Field:  "schema_version",
+				Reason: "must be a positive integer",
+			}}}
+		}
+	}
+	if version > CurrentSchemaVersion {
+		return nil, &ValidationError{Fields: []FieldError{{
+			Field:  "schema_version",
+			Reason: "version " + strconv.Itoa(version) + " is newer than the newest supported, " + strconv.Itoa(CurrentSchemaVersion),
+		}}}
+	}
+	if version == CurrentSchemaVersion {
+		return data, nil
+	}
+
+	for v := version; v < CurrentSchemaVersion; v++ {
+		if err := schemaUpgrades[v-1](payload); err != nil {
+			return nil, err
+		}
+	}
+	payload["schema_version"] = json.RawMessage(strconv.Itoa(CurrentSchemaVersion))
+	return json.Marshal(payload)
+}
+
+// upgradeSchemaV1 turns a Unix millisecond timestamp into RFC 3339
+func upgradeSchemaV1(payload map[string]json.RawMessage) error {
+	raw, ok := payload["timestamp"]
+	if !ok || len(raw) == 0 || raw[0] == '"' || bytes.Equal(raw, []byte("null")) {
+		return nil
+	}
+	var millis int64
+	if err := json.Unmarshal(raw, &millis); err != nil {
+		return &ValidationError{Fields: []FieldError{{
+			Field:  "timestamp",
+			Reason: "must be an RFC 3339 string or Unix milliseconds",
+		}}}
+	}
+	ts, _ := json.Marshal(time.UnixMilli(millis).UTC())
+	payload["timestamp"] = ts
+	return nil
+}
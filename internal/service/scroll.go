@@ -0,0 +1,147 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxTrackedPages bounds the pages per page analytics are kept for; later
+// pages are aggregated under OtherLabel
+const maxTrackedPages = 100
+
+// scrollMilestones are the depths, in percent, ScrollDepth counts sessions
+// reaching
+var scrollMilestones = [...]float64{25, 50, 75, 100}
+
+// ScrollDepth is the distribution over sessions of the furthest a page was
+// read, as a percentage of its height
+type ScrollDepth struct {
+	PageURL  string  `json:"page_url"`
+	Sessions int64   `json:"sessions"`
+	Average  float64 `json:"average_percent"`
+
+	// Sessions reaching each of scrollMilestones
+	Reached25  int64 `json:"reached_25"`
+	Reached50  int64 `json:"reached_50"`
+	Reached75  int64 `json:"reached_75"`
+	Reached100 int64 `json:"reached_100"`
+}
+
+// scrollDepthPercent is how far down the page the bottom of the viewport
+// is, or false when the event does not say how tall the page is
+func scrollDepthPercent(event TrackingEvent) (float64, bool) {
+	if event.PageHeight <= 0 || event.ViewportY <= 0 {
+		return 0, false
+	}
+	depth := float64(event.ScrollY+event.ViewportY) / float64(event.PageHeight) * 100
+	return min(depth, 100), true
+}
+
+type pageScroll struct {
+	sessions int64
+	sum      float64
+	reached  [len(scrollMilestones)]int64
+}
+
+// scrollTracker aggregates each session's maximum depth per page. Sessions
+// report only increases, so the distribution stays exact without keeping
+// every depth.
+type scrollTracker struct {
+	mu    sync.Mutex
+	pages map[string]*pageScroll
+}
+
+// raise moves a session's maximum depth on page from previous to depth;
+// first is set when the session had no depth for the page yet
+func (t *scrollTracker) raise(page string, previous, depth float64, first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pages == nil {
+		t.pages = make(map[string]*pageScroll)
+	}
+	p, ok := t.pages[page]
+	if !ok {
+		if len(t.pages) >= maxTrackedPages {
+			page = OtherLabel
+			p = t.pages[page]
+		}
+		if p == nil {
+			p = &pageScroll{}
+			t.pages[page] = p
+		}
+	}
+
+	if first {
+		p.sessions++
+		previous = -1
+	}
+	p.sum += depth - max(previous, 0)
+	for i, m := range scrollMilestones {
+		if previous < m && depth >= m {
+			p.reached[i]++
+		}
+	}
+}
+
+// depths returns the pages by URL
+func (t *scrollTracker) depths() []ScrollDepth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	depths := make([]ScrollDepth, 0, len(t.pages))
+	for url, p := range t.pages {
+		depths = append(depths, ScrollDepth{
+			PageURL:    url,
+			Sessions:   p.sessions,
+			Average:    p.sum / float64(p.sessions),
+			Reached25:  p.reached[0],
+			Reached50:  p.reached[1],
+			Reached75:  p.reached[2],
+			Reached100: p.reached[3],
+		})
+	}
+	sort.Slice(depths, func(i, j int) bool { return depths[i].PageURL < depths[j].PageURL })
+	return depths
+}
+
+// updateScrollDepth raises session's maximum depth for the event's page.
+// Called with sessionMutex held.
+func (s *Service) updateScrollDepth(session *SessionData, event TrackingEvent) {
+	depth, ok := scrollDepthPercent(event)
+	if !ok {
+		return
+	}
+	previous, seen := session.ScrollDepth[event.PageURL]
+	if seen && depth <= previous {
+		return
+	}
+	if session.ScrollDepth == nil {
+		session.ScrollDepth = make(map[string]float64)
+	}
+	session.ScrollDepth[event.PageURL] = depth
+	session.stats.scrollDepths.raise(event.PageURL, previous, depth, !seen)
+}
+
+// marshalProto encodes the ScrollDepth message documented on
+// Stats.MarshalProto
+func (d ScrollDepth) marshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, d.PageURL)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(d.Sessions))
+	b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(d.Average))
+	for i, v := range []int64{d.Reached25, d.Reached50, d.Reached75, d.Reached100} {
+		if v == 0 {
+			continue
+		}
+		b = protowire.AppendTag(b, protowire.Number(i+4), protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	}
+	return b
+}
@@ -2,49 +2,184 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"log/slog"
 
+	"github.com/niquet/rate-limited-worker/internal/geoip"
+
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Histogram names, for configuring their buckets
+const (
+	MetricHTTPRequestDuration = "worker_http_request_duration_seconds"
+	MetricCursorPositions     = "worker_cursor_positions"
+	MetricSessionEvents       = "worker_session_events"
+	MetricSessionDuration     = "worker_session_duration_seconds"
+	MetricSessionClicks       = "worker_session_clicks"
 )
 
 type Service struct {
-	startTime      time.Time
-	clickCounter   int64
-	pageViews      int64
-	sessionCounter int64
+	startTime time.Time
+
+	// Aggregates by tenant, "" without tenancy
+	tenants     map[string]*tenantStats
+	tenantMutex sync.RWMutex
+	tenancy     *tenancy
 
 	// Metrics
 	clickRate       metric.Int64Counter
+	events          metric.Int64Counter
+	botDecisions    metric.Int64Counter
+	goalCompletions metric.Int64Counter
+	anomalyCount    metric.Int64Counter
 	cursorPositions metric.Int64Histogram
+	cursorPercent   metric.Float64Histogram
 	requestDuration metric.Float64Histogram
 	activeUsers     metric.Int64UpDownCounter
 	httpRequests    metric.Int64Counter
 
+	// Recorded when a session expires
+	sessionEvents   metric.Int64Histogram
+	sessionDuration metric.Float64Histogram
+	sessionClicks   metric.Int64Histogram
+
+	// Bounds the distinct route labels on the HTTP metrics
+	routeLabels *cardinalityLimiter
+
+	// Responses by status class (index status/100) and denials, for the
+	// dashboard
+	responses [6]int64
+	denied    int64
+
+	// The gap between events that separates active from idle time
+	idleThreshold time.Duration
+
+	// Client address locations, when configured
+	geo *geoip.DB
+
+	// Custom enrichment, in the order added
+	enrichers []namedEnricher
+
+	// Where processed events are forwarded, in the order added
+	sinks []Sink
+
+	// Normalizes page URLs, when configured
+	pageURLs *pageNormalizer
+
+	// Scrubs events before they are kept, when configured
+	privacy *privacy
+
+	// Keys the visitor hash of unique visitor counts
+	visitorSecret []byte
+
+	// When events are processed in full rather than aggregate-only
+	consentPolicy string
+
+	// Stores sessions are erased from, and erasure requests by ID
+	erasers      map[string]Eraser
+	erasures     map[string]*Erasure
+	erasureMutex sync.Mutex
+
+	// Session recordings, when kept
+	replays *replayStore
+
+	// Experiments sessions are bucketed into
+	experiments []Experiment
+
+	// Goals counted as conversions
+	goals []Goal
+
+	// Bot filter mode and per-session event rate limit
+	botMode         string
+	botMaxEventRate int
+
+	// Service level objectives, when tracked
+	slo *sloTracker
+
+	// Per-minute signals checked for anomalies, when enabled
+	anomalies *anomalyDetector
+
+	// Buffered cursor positions, when batching
+	positions *positionBatch
+
+	// Per event type fractions of events that are traced and measured
+	sampleRates atomic.Pointer[sampleRates]
+
 	// Thread-safe collections
-	sessions     map[string]*SessionData
+	sessions     map[sessionKey]*SessionData
 	sessionMutex sync.RWMutex
 
+	// Custom event types
+	eventTypes *EventTypeRegistry
+
 	// OpenTelemetry
 	tracer trace.Tracer
 	meter  metric.Meter
 }
 
+// Option configures optional Service behaviour
+type Option func(*Service)
+
+// WithMaxMetricRoutes caps the distinct route labels on the HTTP metrics;
+// routes beyond it are recorded as OtherLabel. The default is 100.
+func WithMaxMetricRoutes(n int) Option {
+	return func(s *Service) {
+		s.routeLabels = newCardinalityLimiter(n)
+	}
+}
+
+// WithEventTypes sets the registry used to validate custom event types
+func WithEventTypes(registry *EventTypeRegistry) Option {
+	return func(s *Service) {
+		s.eventTypes = registry
+	}
+}
+
 type SessionData struct {
 	ID         string
+	Tenant     string
 	StartTime  time.Time
 	LastActive time.Time
 	ClickCount int64
 	Events     []TrackingEvent
+
+	// Furthest scrolled, in percent, per page URL
+	ScrollDepth map[string]float64
+
+	// Time between events, split at the idle threshold, and the active
+	// time per page URL
+	ActiveTime time.Duration
+	IdleTime   time.Duration
+	PageDwell  map[string]time.Duration
+
+	// The session's last clicked elements, oldest first, for the flows
+	recentClicks []string
+
+	// Goals the session completed
+	goals map[string]bool
+
+	// Events in the second from burstStart, for the bot filter
+	burstStart time.Time
+	burstCount int
+
+	// The aggregates of the session's tenant
+	stats *tenantStats
 }
 
 type TrackingEvent struct {
+	// The payload's schema version; UpgradeEvent brings older payloads to
+	// CurrentSchemaVersion
+	SchemaVersion int `json:"schema_version,omitempty"`
+
 	EventType   string                 `json:"event_type"`
 	Timestamp   time.Time              `json:"timestamp"`
 	CursorX     int                    `json:"cursor_x"`
@@ -58,8 +193,48 @@ type TrackingEvent struct {
 	ViewportY   int                    `json:"viewport_y"`
 	ScrollX     int                    `json:"scroll_x"`
 	ScrollY     int                    `json:"scroll_y"`
+	PageHeight  int                    `json:"page_height,omitempty"`
 	ElementText string                 `json:"element_text"`
 	Custom      map[string]interface{} `json:"custom,omitempty"`
+
+	// Set by the server to the tenant the request belongs to, when
+	// tenancy is enabled
+	Tenant string `json:"tenant,omitempty"`
+
+	// Set by the server from the resolved client address, which the
+	// privacy stage may truncate or replace with a hash
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// Set by the server from the user agent
+	Browser string `json:"browser,omitempty"`
+	OS      string `json:"os,omitempty"`
+	Device  string `json:"device,omitempty"`
+
+	// Set by the server to the cursor position as a percentage of the
+	// viewport, when the event gives the viewport size
+	CursorXPercent *float64 `json:"cursor_x_percent,omitempty"`
+	CursorYPercent *float64 `json:"cursor_y_percent,omitempty"`
+
+	// Set by the server from the client address when GeoIP is configured
+	Country string `json:"country,omitempty"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+
+	// Consent grants (true) or declines (false) full processing, outweighing
+	// DoNotTrack, which the server sets from the DNT and Sec-GPC headers
+	Consent    *bool `json:"consent,omitempty"`
+	DoNotTrack bool  `json:"do_not_track,omitempty"`
+
+	// Set by the bot filter when the event looks automated
+	Bot       bool   `json:"bot,omitempty"`
+	BotReason string `json:"bot_reason,omitempty"`
+
+	// Set by the server to the session's variant of each experiment
+	Experiments map[string]string `json:"experiments,omitempty"`
+
+	// The recording chunk of a replay event. It is stored apart from the
+	// session's events, which keep the event without it.
+	Replay *ReplayChunk `json:"replay,omitempty"`
 }
 
 type HealthMetrics struct {
@@ -70,7 +245,135 @@ type HealthMetrics struct {
 	TotalSessions int64  `json:"total_sessions"`
 }
 
-func New() *Service {
+// Stats is a point-in-time snapshot of the tracking aggregates. It holds no
+// wall-clock values so unchanged state yields an identical snapshot.
+type Stats struct {
+	TotalClicks    int64 `json:"total_clicks"`
+	PageViews      int64 `json:"page_views"`
+	ActiveSessions int64 `json:"active_sessions"`
+	TotalSessions  int64 `json:"total_sessions"`
+
+	// Approximate, from HyperLogLog sketches
+	Uniques
+
+	Breakdown
+
+	ScrollDepth []ScrollDepth     `json:"scroll_depth,omitempty"`
+	DwellTime   []PageDwell       `json:"dwell_time,omitempty"`
+	Experiments []ExperimentStats `json:"experiments,omitempty"`
+	Pages       []PageStats       `json:"pages,omitempty"`
+	Goals       []GoalStats       `json:"goals,omitempty"`
+}
+
+// MarshalProto encodes the stats as the protobuf message
+//
+//	message Stats {
+//	  int64 total_clicks = 1;
+//	  int64 page_views = 2;
+//	  int64 active_sessions = 3;
+//	  int64 total_sessions = 4;
+//	  int64 unique_sessions_hour = 5;
+//	  int64 unique_sessions_day = 6;
+//	  int64 unique_visitors_hour = 7;
+//	  int64 unique_visitors_day = 8;
+//	  repeated ScrollDepth scroll_depth = 9;
+//	  repeated PageDwell dwell_time = 10;
+//	  map<string, int64> browsers = 11;
+//	  map<string, int64> operating_systems = 12;
+//	  map<string, int64> devices = 13;
+//	  repeated ExperimentStats experiments = 14;
+//	  repeated PageStats pages = 15;
+//	  repeated GoalStats goals = 16;
+//	}
+//
+//	message ScrollDepth {
+//	  string page_url = 1;
+//	  int64 sessions = 2;
+//	  double average_percent = 3;
+//	  int64 reached_25 = 4;
+//	  int64 reached_50 = 5;
+//	  int64 reached_75 = 6;
+//	  int64 reached_100 = 7;
+//	}
+//
+//	message PageDwell {
+//	  string page_url = 1;
+//	  int64 sessions = 2;
+//	  double average_seconds = 3;
+//	}
+//
+//	message ExperimentStats {
+//	  string name = 1;
+//	  repeated VariantStats variants = 2;
+//	}
+//
+//	message VariantStats {
+//	  string name = 1;
+//	  int64 exposures = 2;
+//	  int64 sessions = 3;
+//	  int64 events = 4;
+//	  int64 clicks = 5;
+//	}
+//
+//	message PageStats {
+//	  string page_url = 1;
+//	  int64 views = 2;
+//	  int64 clicks = 3;
+//	  double average_scroll_percent = 4;
+//	}
+//
+//	message GoalStats {
+//	  string name = 1;
+//	  int64 completions = 2;
+//	  double conversion_rate = 3;
+//	  repeated GoalPage pages = 4;
+//	}
+//
+//	message GoalPage {
+//	  string page_url = 1;
+//	  int64 completions = 2;
+//	  int64 views = 3;
+//	  double conversion_rate = 4;
+//	}
+func (s Stats) MarshalProto() ([]byte, error) {
+	var b []byte
+	for i, v := range []int64{
+		s.TotalClicks, s.PageViews, s.ActiveSessions, s.TotalSessions,
+		s.SessionsHour, s.SessionsDay, s.VisitorsHour, s.VisitorsDay,
+	} {
+		if v == 0 {
+			continue
+		}
+		b = protowire.AppendTag(b, protowire.Number(i+1), protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	}
+	for _, d := range s.ScrollDepth {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, d.marshalProto())
+	}
+	for _, d := range s.DwellTime {
+		b = protowire.AppendTag(b, 10, protowire.BytesType)
+		b = protowire.AppendBytes(b, d.marshalProto())
+	}
+	b = appendCountsProto(b, 11, s.Browsers)
+	b = appendCountsProto(b, 12, s.OperatingSystems)
+	b = appendCountsProto(b, 13, s.Devices)
+	for _, e := range s.Experiments {
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.marshalProto())
+	}
+	for _, p := range s.Pages {
+		b = protowire.AppendTag(b, 15, protowire.BytesType)
+		b = protowire.AppendBytes(b, p.marshalProto())
+	}
+	for _, g := range s.Goals {
+		b = protowire.AppendTag(b, 16, protowire.BytesType)
+		b = protowire.AppendBytes(b, g.marshalProto())
+	}
+	return b, nil
+}
+
+func New(opts ...Option) *Service {
 	tracer := otel.Tracer("worker-service")
 	meter := otel.Meter("worker-service")
 
@@ -78,94 +381,305 @@ func New() *Service {
 	clickRate, _ := meter.Int64Counter("worker_clicks_total",
 		metric.WithDescription("Total number of clicks recorded"))
 
-	cursorPositions, _ := meter.Int64Histogram("worker_cursor_positions",
+	events, _ := meter.Int64Counter("worker_events_total",
+		metric.WithDescription("Tracking events processed, by agent browser, OS and device class"))
+
+	botDecisions, _ := meter.Int64Counter("worker_bot_decisions_total",
+		metric.WithDescription("Bot filter decisions, by decision and reason"))
+
+	goalCompletions, _ := meter.Int64Counter("worker_goal_completions_total",
+		metric.WithDescription("Sessions completing each goal"))
+
+	anomalyCount, _ := meter.Int64Counter("worker_anomalies_total",
+		metric.WithDescription("Anomalous minutes detected, by signal"))
+
+	cursorPositions, _ := meter.Int64Histogram(MetricCursorPositions,
 		metric.WithDescription("Cursor position coordinates"))
 
-	requestDuration, _ := meter.Float64Histogram("worker_http_request_duration_seconds",
+	cursorPercent, _ := meter.Float64Histogram(MetricCursorPositionPercent,
+		metric.WithDescription("Click positions as a percentage of the viewport"),
+		metric.WithUnit("%"),
+		metric.WithExplicitBucketBoundaries(percentBuckets...))
+
+	requestDuration, _ := meter.Float64Histogram(MetricHTTPRequestDuration,
 		metric.WithDescription("HTTP request duration in seconds"))
 
 	activeUsers, _ := meter.Int64UpDownCounter("worker_active_users",
-		metric.WithDescription("Number of active users"))
+		metric.WithDescription("Sessions started and not yet expired or erased"))
 
 	httpRequests, _ := meter.Int64Counter("worker_http_requests_total",
 		metric.WithDescription("Total HTTP requests processed"))
 
-	return &Service{
+	sessionEvents, _ := meter.Int64Histogram(MetricSessionEvents,
+		metric.WithDescription("Events per session, recorded when the session expires"))
+
+	sessionDuration, _ := meter.Float64Histogram(MetricSessionDuration,
+		metric.WithDescription("Time from a session's first to last event, recorded when it expires"),
+		metric.WithUnit("s"))
+
+	sessionClicks, _ := meter.Int64Histogram(MetricSessionClicks,
+		metric.WithDescription("Clicks per session, recorded when the session expires"))
+
+	s := &Service{
 		startTime:       time.Now(),
-		sessions:        make(map[string]*SessionData),
+		sessions:        make(map[sessionKey]*SessionData),
+		tenants:         make(map[string]*tenantStats),
 		tracer:          tracer,
 		meter:           meter,
 		clickRate:       clickRate,
+		events:          events,
+		botDecisions:    botDecisions,
+		goalCompletions: goalCompletions,
+		anomalyCount:    anomalyCount,
 		cursorPositions: cursorPositions,
+		cursorPercent:   cursorPercent,
 		requestDuration: requestDuration,
 		activeUsers:     activeUsers,
 		httpRequests:    httpRequests,
+		sessionEvents:   sessionEvents,
+		sessionDuration: sessionDuration,
+		sessionClicks:   sessionClicks,
+		eventTypes:      NewEventTypeRegistry(false),
+		routeLabels:     newCardinalityLimiter(100),
+		idleThreshold:   DefaultIdleThreshold,
+		botMode:         BotFilterOff,
+		consentPolicy:   ConsentIgnore,
+		erasers:         make(map[string]Eraser),
+		erasures:        make(map[string]*Erasure),
+		visitorSecret:   make([]byte, 32),
+	}
+	_, _ = rand.Read(s.visitorSecret)
+	s.sampleRates.Store(&sampleRates{})
+	s.erasers["sessions"] = s.eraseSessions
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// Goals name pages as events do once normalized
+	if s.pageURLs != nil {
+		for i := range s.goals {
+			s.goals[i].PageURL = s.pageURLs.normalize(s.goals[i].PageURL)
+		}
+	}
+
+	s.registerSessionMetrics()
+	if s.slo != nil {
+		s.registerSLOMetrics()
 	}
+
+	return s
+}
+
+// EventTypes returns the custom event type registry
+func (s *Service) EventTypes() *EventTypeRegistry {
+	return s.eventTypes
 }
 
 func (s *Service) ProcessTrackingEvent(ctx context.Context, event TrackingEvent) error {
-	ctx, span := s.tracer.Start(ctx, "process_tracking_event")
+	rates := s.sampleRates.Load()
+	traceSpans := sampleEvent(rates.spans, event.EventType)
+	metricWeight := sampleWeight(rates.metrics, event.EventType)
+	recordMetrics := metricWeight > 0
+	if !traceSpans || !recordMetrics {
+		// Noted on the caller's span, since this event's own spans may be skipped
+		trace.SpanFromContext(ctx).AddEvent("event.sampled_out", trace.WithAttributes(
+			attribute.String("event.type", event.EventType),
+			attribute.Bool("event.spans", traceSpans),
+			attribute.Bool("event.metrics", recordMetrics),
+		))
+	}
+	if !traceSpans {
+		ctx = context.WithValue(ctx, untracedKey{}, true)
+	}
+
+	ctx, span := s.startEventSpan(ctx, "process_tracking_event")
 	defer span.End()
 
+	event.Tenant = s.tenantID(event.Tenant)
+	ts, err := s.statsFor(event.Tenant)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	s.enrich(&event)
+	if s.pageURLs != nil {
+		event.PageURL = s.pageURLs.normalize(event.PageURL)
+	}
+	s.runEnrichers(ctx, &event)
+
+	if s.botMode != BotFilterOff {
+		if reason := s.detectBot(event, time.Now()); reason != "" {
+			if s.botMode == BotFilterDrop {
+				s.recordBotDecision(ctx, event.Tenant, "dropped", reason)
+				span.SetAttributes(attribute.String("bot.reason", reason))
+				slog.DebugContext(ctx, "Dropped bot event", "reason", reason, "session_id", event.SessionID)
+				// A session over the event rate is told to slow down
+				if reason == BotReasonEventRate {
+					span.AddEvent("event.throttled", trace.WithAttributes(
+						attribute.String("session.id", event.SessionID),
+						attribute.Int("throttle.max_event_rate", s.botMaxEventRate),
+					))
+					return newError(ErrRateLimited, "session %q sent over %d events in a second", event.SessionID, s.botMaxEventRate)
+				}
+				return nil
+			}
+			s.recordBotDecision(ctx, event.Tenant, "tagged", reason)
+			event.Bot, event.BotReason = true, reason
+		} else {
+			s.recordBotDecision(ctx, event.Tenant, "human", "")
+		}
+	}
+
+	consented := s.Consented(event)
+	if !consented {
+		aggregateOnly(&event)
+	}
+	event.Experiments = nil
+	if event.SessionID != "" && len(s.experiments) > 0 {
+		event.Experiments = s.Assignments(event.SessionID)
+	}
+
+	// The visitor is told apart by the raw address, before it is scrubbed
+	visitor := s.visitorKey(event)
+	if s.privacy != nil {
+		s.privacy.scrub(&event)
+	}
+
 	// Add span attributes
 	span.SetAttributes(
 		attribute.String("event.type", event.EventType),
 		attribute.String("session.id", event.SessionID),
 		attribute.Int("cursor.x", event.CursorX),
 		attribute.Int("cursor.y", event.CursorY),
+		attribute.String("user_agent.browser", event.Browser),
+		attribute.String("user_agent.os", event.OS),
+		attribute.String("user_agent.device", event.Device),
+		attribute.Bool("bot", event.Bot),
+		attribute.Bool("consent.aggregate_only", !consented),
 	)
+	if s.tenancy != nil {
+		span.SetAttributes(attribute.String("tenant.id", event.Tenant))
+	}
+	if s.geo != nil {
+		span.SetAttributes(
+			attribute.String("geo.country", event.Country),
+			attribute.String("geo.region", event.Region),
+			attribute.String("geo.city", event.City),
+		)
+	}
 
-	// Update session data
-	s.updateSession(event)
+	// Update session data; aggregate-only events are not kept, and the
+	// session keeps replay events without their chunk
+	if event.EventType == EventTypeReplay && consented {
+		if err := s.recordReplay(ctx, event); err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+	event.Replay = nil
+	started := false
+	if consented {
+		started = s.updateSession(event, ts)
+	}
+	if started {
+		ts.agents.add(event)
+		s.activeUsers.Add(ctx, 1, metric.WithAttributes(s.tenantAttrs(event.Tenant)...))
+	}
+	recordExperiments(ts, event, started)
+	if consented {
+		s.recordGoals(ctx, event, ts)
+	}
+	if recordMetrics {
+		attrs := append(s.tenantAttrs(event.Tenant),
+			attribute.String("browser", event.Browser),
+			attribute.String("os", event.OS),
+			attribute.String("device", event.Device),
+			attribute.Bool("bot", event.Bot),
+		)
+		// Country alone, as region and city would multiply the series
+		if s.geo != nil {
+			attrs = append(attrs, attribute.String("country", event.Country))
+		}
+		s.events.Add(ctx, metricWeight, metric.WithAttributes(attrs...))
+	}
+	ts.rates.record(time.Now(), event.EventType == "click" && !event.Bot, started)
+	if s.anomalies != nil {
+		s.anomalies.recordEvent(time.Now(), event.EventType == "click" && !event.Bot, started)
+	}
+	if consented && !event.Bot {
+		ts.uniques.add(time.Now(), event.SessionID, visitor)
+	}
 
 	// Record different metrics based on event type
 	switch event.EventType {
 	case "click":
-		s.recordClick(ctx, event)
+		s.recordClick(ctx, event, ts, metricWeight)
 	case "mousemove":
-		s.recordCursorPosition(ctx, event)
+		if recordMetrics {
+			s.recordCursorPosition(ctx, event)
+		}
 	case "scroll":
-		s.recordScrollEvent(ctx, event)
+		if recordMetrics {
+			s.recordScrollEvent(ctx, event)
+		}
 	case "custom":
 		s.recordCustomEvent(ctx, event)
 	default:
-		slog.Warn("Unknown event type", "type", event.EventType)
+		if _, ok := s.eventTypes.Lookup(event.EventType); ok {
+			s.recordCustomEvent(ctx, event)
+		} else {
+			span.SetAttributes(attribute.Bool("event.unknown_type", true))
+			slog.WarnContext(ctx, "Accepted unknown event type", "type", event.EventType)
+		}
 	}
 
 	// Log event for structured logging
-	slog.Info("Event processed",
+	slog.InfoContext(ctx, "Event processed",
 		"event_type", event.EventType,
 		"session_id", event.SessionID,
 		"element_id", event.ElementID,
 		"timestamp", event.Timestamp,
 	)
 
+	s.publish(ctx, event)
 	return nil
 }
 
-func (s *Service) recordClick(ctx context.Context, event TrackingEvent) {
-	// Increment global click counter
-	atomic.AddInt64(&s.clickCounter, 1)
-
-	// Record click rate metric
-	s.clickRate.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("element_id", event.ElementID),
-		attribute.String("element_type", event.ElementType),
-		attribute.String("page_url", event.PageURL),
-	))
-
-	// Record cursor position at click
-	s.cursorPositions.Record(ctx, int64(event.CursorX), metric.WithAttributes(
-		attribute.String("coordinate", "x"),
-		attribute.String("event_type", "click"),
-	))
-	s.cursorPositions.Record(ctx, int64(event.CursorY), metric.WithAttributes(
-		attribute.String("coordinate", "y"),
-		attribute.String("event_type", "click"),
-	))
+// recordClick counts a click, and records its metrics when metricWeight,
+// the clicks it stands for after sampling, is not 0
+func (s *Service) recordClick(ctx context.Context, event TrackingEvent, ts *tenantStats, metricWeight int64) {
+	recordMetrics := metricWeight > 0
+	// Tagged bot clicks are kept in their session but not counted
+	if !event.Bot {
+		atomic.AddInt64(&ts.clickCounter, 1)
+		ts.elements.click(event.ElementID)
+	}
+
+	if recordMetrics && !event.Bot {
+		// Record click rate metric
+		s.clickRate.Add(ctx, metricWeight, metric.WithAttributes(append(s.tenantAttrs(event.Tenant),
+			attribute.String("element_id", event.ElementID),
+			attribute.String("element_type", event.ElementType),
+			attribute.String("page_url", event.PageURL),
+		)...))
+
+		// Record cursor position at click
+		s.recordPosition(ctx, clickX, clickY, event.CursorX, event.CursorY)
+	}
+
+	// Clicks as a share of the viewport line up across screen sizes
+	if x, y, ok := viewportPercent(event); ok && !event.Bot {
+		ts.heatmaps.add(event.PageURL, x, y)
+		if recordMetrics {
+			s.cursorPercent.Record(ctx, x, positionAttrs[clickX])
+			s.cursorPercent.Record(ctx, y, positionAttrs[clickY])
+		}
+	}
 
 	// Create custom span for click analytics
-	_, clickSpan := s.tracer.Start(ctx, "click_analytics")
+	_, clickSpan := s.startEventSpan(ctx, "click_analytics")
 	clickSpan.SetAttributes(
 		attribute.String("click.element_id", event.ElementID),
 		attribute.String("click.element_type", event.ElementType),
@@ -179,30 +693,16 @@ func (s *Service) recordClick(ctx context.Context, event TrackingEvent) {
 }
 
 func (s *Service) recordCursorPosition(ctx context.Context, event TrackingEvent) {
-	s.cursorPositions.Record(ctx, int64(event.CursorX), metric.WithAttributes(
-		attribute.String("coordinate", "x"),
-		attribute.String("event_type", "mousemove"),
-	))
-	s.cursorPositions.Record(ctx, int64(event.CursorY), metric.WithAttributes(
-		attribute.String("coordinate", "y"),
-		attribute.String("event_type", "mousemove"),
-	))
+	s.recordPosition(ctx, mousemoveX, mousemoveY, event.CursorX, event.CursorY)
 }
 
 func (s *Service) recordScrollEvent(ctx context.Context, event TrackingEvent) {
-	s.cursorPositions.Record(ctx, int64(event.ScrollX), metric.WithAttributes(
-		attribute.String("coordinate", "scroll_x"),
-		attribute.String("event_type", "scroll"),
-	))
-	s.cursorPositions.Record(ctx, int64(event.ScrollY), metric.WithAttributes(
-		attribute.String("coordinate", "scroll_y"),
-		attribute.String("event_type", "scroll"),
-	))
+	s.recordPosition(ctx, scrollX, scrollY, event.ScrollX, event.ScrollY)
 }
 
 func (s *Service) recordCustomEvent(ctx context.Context, event TrackingEvent) {
 	// Create custom span for analytics
-	_, customSpan := s.tracer.Start(ctx, "custom_event")
+	_, customSpan := s.startEventSpan(ctx, "custom_event")
 	customSpan.SetAttributes(
 		attribute.String("custom.element_id", event.ElementID),
 		attribute.String("custom.session_id", event.SessionID),
@@ -218,62 +718,129 @@ func (s *Service) recordCustomEvent(ctx context.Context, event TrackingEvent) {
 	customSpan.End()
 }
 
-func (s *Service) updateSession(event TrackingEvent) {
+// updateSession records event on its session, reporting whether the event
+// started it
+func (s *Service) updateSession(event TrackingEvent, ts *tenantStats) bool {
 	s.sessionMutex.Lock()
 	defer s.sessionMutex.Unlock()
 
-	session, exists := s.sessions[event.SessionID]
+	key := sessionKey{tenant: event.Tenant, id: event.SessionID}
+	session, exists := s.sessions[key]
 	if !exists {
 		// Create new session
 		session = &SessionData{
 			ID:         event.SessionID,
+			Tenant:     event.Tenant,
 			StartTime:  time.Now(),
 			LastActive: time.Now(),
 			ClickCount: 0,
 			Events:     make([]TrackingEvent, 0),
+			stats:      ts,
 		}
-		s.sessions[event.SessionID] = session
-		atomic.AddInt64(&s.sessionCounter, 1)
+		s.sessions[key] = session
+		atomic.AddInt64(&ts.sessionCounter, 1)
+		atomic.AddInt64(&ts.activeSessions, 1)
 	}
 
-	// Update session
-	session.LastActive = time.Now()
+	// Update session; arriving on a page counts as a view of it
+	if !event.Bot && (!exists || session.Events[len(session.Events)-1].PageURL != event.PageURL) {
+		ts.pages.view(event.PageURL)
+	}
+	now := time.Now()
+	s.updateDwell(session, event, now, !exists)
+	session.LastActive = now
 	session.Events = append(session.Events, event)
 
 	if event.EventType == "click" {
 		session.ClickCount++
+		// Counted with the views, so pages' click-through is like for like
+		if !event.Bot {
+			ts.pages.click(event.PageURL)
+		}
 	}
+	s.updateScrollDepth(session, event)
+	s.updateClickPath(session, event)
+	return !exists
 }
 
+// TrackPageView counts a page view for the tenant ctx names
 func (s *Service) TrackPageView(ctx context.Context) {
-	atomic.AddInt64(&s.pageViews, 1)
+	ts, err := s.statsFor(s.tenantID(TenantFromContext(ctx)))
+	if err != nil {
+		slog.WarnContext(ctx, "Page view not recorded", "error", err)
+		return
+	}
+	views := atomic.AddInt64(&ts.pageViews, 1)
 
 	_, span := s.tracer.Start(ctx, "page_view")
 	span.SetAttributes(
-		attribute.Int64("page_views.total", atomic.LoadInt64(&s.pageViews)),
+		attribute.Int64("page_views.total", views),
 		attribute.String("page.type", "homepage"),
 	)
 	span.End()
 
-	slog.Info("Page view recorded", "total_views", atomic.LoadInt64(&s.pageViews))
+	slog.InfoContext(ctx, "Page view recorded", "total_views", views)
 }
 
-func (s *Service) RecordHTTPMetrics(ctx context.Context, method, path string, statusCode int, duration time.Duration) {
-	// Record HTTP request counter
-	s.httpRequests.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("method", method),
-		attribute.String("path", path),
+// RecordHTTPMetrics records a served request. route should be the matched
+// route pattern rather than the request path; either way the number of
+// distinct routes recorded is capped. errorCode is empty for successes.
+func (s *Service) RecordHTTPMetrics(ctx context.Context, method, route string, statusCode int, errorCode string, duration time.Duration) {
+	labels := []attribute.KeyValue{
+		attribute.String("method", methodLabel(method)),
+		attribute.String("route", s.routeLabels.value(route)),
 		attribute.Int("status_code", statusCode),
-	))
+	}
+	attrs := metric.WithAttributes(labels...)
+
+	// Record HTTP request counter; failed requests also carry their error code
+	if errorCode != "" {
+		s.httpRequests.Add(ctx, 1, metric.WithAttributes(append(labels, attribute.String("error_code", errorCode))...))
+	} else {
+		s.httpRequests.Add(ctx, 1, attrs)
+	}
+	if class := statusCode / 100; class > 0 && class < len(s.responses) {
+		atomic.AddInt64(&s.responses[class], 1)
+	}
+	switch statusCode {
+	case 401, 403, 429:
+		atomic.AddInt64(&s.denied, 1)
+	}
 
 	// Record request duration
-	s.requestDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
-		attribute.String("method", method),
-		attribute.String("path", path),
-		attribute.Int("status_code", statusCode),
-	))
+	s.requestDuration.Record(ctx, duration.Seconds(), attrs)
+
+	if s.slo != nil {
+		s.slo.record(time.Now(), statusCode, duration)
+	}
+	if s.anomalies != nil {
+		s.anomalies.recordRequest(time.Now(), statusCode >= 500)
+	}
+}
+
+// RequestCounts tallies the responses recorded by RecordHTTPMetrics
+type RequestCounts struct {
+	Success      int64
+	Redirect     int64
+	ClientErrors int64
+	ServerErrors int64
+
+	// Denied counts 401, 403 and 429 responses, which are also client errors
+	Denied int64
 }
 
+// GetRequestCounts returns the responses recorded since startup
+func (s *Service) GetRequestCounts() RequestCounts {
+	return RequestCounts{
+		Success:      atomic.LoadInt64(&s.responses[2]),
+		Redirect:     atomic.LoadInt64(&s.responses[3]),
+		ClientErrors: atomic.LoadInt64(&s.responses[4]),
+		ServerErrors: atomic.LoadInt64(&s.responses[5]),
+		Denied:       atomic.LoadInt64(&s.denied),
+	}
+}
+
+// GetHealthMetrics returns totals over every tenant
 func (s *Service) GetHealthMetrics(ctx context.Context) HealthMetrics {
 	s.sessionMutex.RLock()
 	activeUsers := int64(len(s.sessions))
@@ -283,30 +850,113 @@ func (s *Service) GetHealthMetrics(ctx context.Context) HealthMetrics {
 
 	return HealthMetrics{
 		Uptime:        uptime.String(),
-		TotalClicks:   atomic.LoadInt64(&s.clickCounter),
-		PageViews:     atomic.LoadInt64(&s.pageViews),
+		TotalClicks:   s.totals(func(ts *tenantStats) *int64 { return &ts.clickCounter }),
+		PageViews:     s.totals(func(ts *tenantStats) *int64 { return &ts.pageViews }),
 		ActiveUsers:   activeUsers,
-		TotalSessions: atomic.LoadInt64(&s.sessionCounter),
+		TotalSessions: s.totals(func(ts *tenantStats) *int64 { return &ts.sessionCounter }),
 	}
 }
 
-func (s *Service) GetClickRate(duration time.Duration) float64 {
-	clicks := atomic.LoadInt64(&s.clickCounter)
-	minutes := duration.Minutes()
-	if minutes == 0 {
-		return 0
+// GetStats returns the aggregates of the tenant ctx names
+func (s *Service) GetStats(ctx context.Context) Stats {
+	_, span := s.tracer.Start(ctx, "get_stats")
+	defer span.End()
+
+	tenant := s.tenantID(TenantFromContext(ctx))
+	if s.tenancy != nil {
+		span.SetAttributes(attribute.String("tenant.id", tenant))
+	}
+	ts := s.lookupStats(tenant)
+	if ts == nil {
+		return Stats{}
 	}
-	return float64(clicks) / minutes
+
+	depths := ts.scrollDepths.depths()
+	return Stats{
+		TotalClicks:    atomic.LoadInt64(&ts.clickCounter),
+		PageViews:      atomic.LoadInt64(&ts.pageViews),
+		ActiveSessions: atomic.LoadInt64(&ts.activeSessions),
+		TotalSessions:  atomic.LoadInt64(&ts.sessionCounter),
+		Uniques:        ts.uniques.uniques(time.Now()),
+		ScrollDepth:    depths,
+		DwellTime:      ts.dwell.dwellTimes(),
+		Breakdown:      ts.agents.snapshot(),
+		Experiments:    ts.experiments.stats(s.experiments),
+		Pages:          ts.pages.stats(depths),
+		Goals:          ts.goals.stats(s.goals, ts.pages.views()),
+	}
+}
+
+// Uptime is the time since the service was created
+func (s *Service) Uptime() time.Duration {
+	return time.Since(s.startTime)
 }
 
+// CleanupOldSessions expires sessions idle for longer than maxAge and
+// records their totals in the session histograms
 func (s *Service) CleanupOldSessions(maxAge time.Duration) {
-	s.sessionMutex.Lock()
-	defer s.sessionMutex.Unlock()
+	type sessionTotals struct {
+		tenant   string
+		events   int64
+		clicks   int64
+		duration time.Duration
+	}
+	var expired []sessionTotals
 
+	s.sessionMutex.Lock()
 	now := time.Now()
-	for sessionID, session := range s.sessions {
+	for key, session := range s.sessions {
 		if now.Sub(session.LastActive) > maxAge {
-			delete(s.sessions, sessionID)
+			expired = append(expired, sessionTotals{
+				tenant:   session.Tenant,
+				events:   int64(len(session.Events)),
+				clicks:   session.ClickCount,
+				duration: session.LastActive.Sub(session.StartTime),
+			})
+			delete(s.sessions, key)
+			atomic.AddInt64(&session.stats.activeSessions, -1)
 		}
 	}
+	s.sessionMutex.Unlock()
+
+	ctx := context.Background()
+	for _, t := range expired {
+		attrs := metric.WithAttributes(s.tenantAttrs(t.tenant)...)
+		s.activeUsers.Add(ctx, -1, attrs)
+		s.sessionEvents.Record(ctx, t.events, attrs)
+		s.sessionClicks.Record(ctx, t.clicks, attrs)
+		s.sessionDuration.Record(ctx, t.duration.Seconds(), attrs)
+	}
+	if len(expired) > 0 {
+		slog.Debug("Expired idle sessions", "count", len(expired))
+	}
+}
+
+// registerSessionMetrics observes the live sessions, by tenant, from the
+// sessions themselves. It should always match worker_active_users, which
+// is kept up to date as sessions start and end.
+func (s *Service) registerSessionMetrics() {
+	live, _ := s.meter.Int64ObservableGauge("worker_sessions_live",
+		metric.WithDescription("Sessions held in memory, counted when observed"))
+
+	_, _ = s.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		// Tenants without sessions are observed as 0 rather than dropped
+		counts := map[string]int64{s.tenantID(""): 0}
+		s.tenantMutex.RLock()
+		for tenant := range s.tenants {
+			counts[tenant] = 0
+		}
+		s.tenantMutex.RUnlock()
+
+		s.sessionMutex.RLock()
+		for key := range s.sessions {
+			counts[key.tenant]++
+		}
+		s.sessionMutex.RUnlock()
+
+		for tenant, n := range counts {
+			o.ObserveInt64(live, n, metric.WithAttributes(s.tenantAttrs(tenant)...))
+		}
+		return nil
+	}, live)
 }
@@ -0,0 +1,24 @@
+package service
+
+import "context"
+
+// Sink receives every event the service processes, after bot, consent and
+// privacy rules are applied and without replay chunks, to forward it
+// elsewhere. Publish is called on the request path and must not block.
+type Sink interface {
+	Publish(ctx context.Context, event TrackingEvent)
+}
+
+// WithSink forwards processed events to sink, after those added before it
+func WithSink(sink Sink) Option {
+	return func(s *Service) {
+		s.sinks = append(s.sinks, sink)
+	}
+}
+
+// publish hands event to every sink
+func (s *Service) publish(ctx context.Context, event TrackingEvent) {
+	for _, sink := range s.sinks {
+		sink.Publish(ctx, event)
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// SLO names, as reported in SLOStatus and the slo metric label
+const (
+	SLOAvailability = "availability"
+	SLOLatency      = "latency"
+)
+
+// sloResolution is the width of the buckets requests are counted in, and
+// so the granularity at which windows roll
+const sloResolution = 10 * time.Second
+
+// SLOConfig sets the objectives RecordHTTPMetrics tracks. A request is good
+// for availability unless it answered 5xx, and good for latency when it took
+// at most LatencyThreshold.
+type SLOConfig struct {
+	AvailabilityTarget float64
+	LatencyTarget      float64
+	LatencyThreshold   time.Duration
+
+	// Windows are the rolling windows ratios and burn rates are computed
+	// over, typically the short and long windows of burn rate alerts
+	Windows []time.Duration
+}
+
+// WithSLOs tracks the given objectives and reports them as metrics
+func WithSLOs(cfg SLOConfig) Option {
+	return func(s *Service) {
+		s.slo = newSLOTracker(cfg)
+	}
+}
+
+// SLOStatus is one objective with its standing over each window
+type SLOStatus struct {
+	Name    string      `json:"name"`
+	Target  float64     `json:"target"`
+	Windows []SLOWindow `json:"windows"`
+}
+
+// SLOWindow reports an objective over one rolling window. BurnRate is the
+// rate the error budget is spent at: 1 spends exactly the budget over the
+// SLO period, 14.4 spends a 30 day budget in two days.
+type SLOWindow struct {
+	Window   string  `json:"window"`
+	Requests int64   `json:"requests"`
+	Ratio    float64 `json:"ratio"`
+	BurnRate float64 `json:"burn_rate"`
+}
+
+// GetSLOs returns the standing of each objective, or nil when none are
+// tracked
+func (s *Service) GetSLOs() []SLOStatus {
+	if s.slo == nil {
+		return nil
+	}
+	return s.slo.status(time.Now())
+}
+
+// ErrorRate is the share of requests answered 5xx over window, and the
+// requests it is taken from, which are only counted as far back as the
+// longest SLO window. It is 0 without traffic or when no SLOs are tracked.
+func (s *Service) ErrorRate(window time.Duration) (float64, int64) {
+	if s.slo == nil {
+		return 0, 0
+	}
+	counts := s.slo.sum(time.Now(), window)
+	if counts.total == 0 {
+		return 0, 0
+	}
+	return float64(counts.errors) / float64(counts.total), counts.total
+}
+
+type sloBucket struct {
+	index  int64
+	total  int64
+	errors int64
+	slow   int64
+}
+
+// sloTracker counts requests in a ring of fixed-width buckets covering the
+// longest window, so any window's totals are a sum over recent buckets
+type sloTracker struct {
+	cfg SLOConfig
+
+	mu      sync.Mutex
+	buckets []sloBucket
+}
+
+func newSLOTracker(cfg SLOConfig) *sloTracker {
+	var longest time.Duration
+	for _, w := range cfg.Windows {
+		longest = max(longest, w)
+	}
+	return &sloTracker{
+		cfg:     cfg,
+		buckets: make([]sloBucket, longest/sloResolution+1),
+	}
+}
+
+func (t *sloTracker) record(now time.Time, statusCode int, duration time.Duration) {
+	index := now.UnixNano() / int64(sloResolution)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[index%int64(len(t.buckets))]
+	if b.index != index {
+		*b = sloBucket{index: index}
+	}
+	b.total++
+	if statusCode >= 500 {
+		b.errors++
+	}
+	if duration > t.cfg.LatencyThreshold {
+		b.slow++
+	}
+}
+
+// sum totals the buckets that fall within window before now
+func (t *sloTracker) sum(now time.Time, window time.Duration) sloBucket {
+	current := now.UnixNano() / int64(sloResolution)
+	n := int64((window + sloResolution - 1) / sloResolution)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var total sloBucket
+	for index := current - n + 1; index <= current; index++ {
+		b := t.buckets[index%int64(len(t.buckets))]
+		if b.index != index {
+			continue
+		}
+		total.total += b.total
+		total.errors += b.errors
+		total.slow += b.slow
+	}
+	return total
+}
+
+func (t *sloTracker) status(now time.Time) []SLOStatus {
+	availability := SLOStatus{Name: SLOAvailability, Target: t.cfg.AvailabilityTarget}
+	latency := SLOStatus{Name: SLOLatency, Target: t.cfg.LatencyTarget}
+
+	for _, w := range t.cfg.Windows {
+		counts := t.sum(now, w)
+		availability.Windows = append(availability.Windows, sloWindow(w, counts.total, counts.errors, t.cfg.AvailabilityTarget))
+		latency.Windows = append(latency.Windows, sloWindow(w, counts.total, counts.slow, t.cfg.LatencyTarget))
+	}
+	return []SLOStatus{availability, latency}
+}
+
+func sloWindow(window time.Duration, total, bad int64, target float64) SLOWindow {
+	// With no traffic nothing was spent
+	ratio := 1.0
+	if total > 0 {
+		ratio = float64(total-bad) / float64(total)
+	}
+	return SLOWindow{
+		Window:   windowLabel(window),
+		Requests: total,
+		Ratio:    ratio,
+		BurnRate: (1 - ratio) / (1 - target),
+	}
+}
+
+// windowLabel formats a window the way alert rules name them, 5m rather
+// than 5m0s
+func windowLabel(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// registerSLOMetrics reports the objectives as gauges, so alerts are plain
+// thresholds on worker_slo_burn_rate
+func (s *Service) registerSLOMetrics() {
+	target, _ := s.meter.Float64ObservableGauge("worker_slo_target",
+		metric.WithDescription("Objective for the share of good requests"))
+	ratio, _ := s.meter.Float64ObservableGauge("worker_slo_ratio",
+		metric.WithDescription("Share of good requests over the window"))
+	burnRate, _ := s.meter.Float64ObservableGauge("worker_slo_burn_rate",
+		metric.WithDescription("Error budget burn rate over the window; 1 spends the budget exactly"))
+
+	_, _ = s.meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for _, slo := range s.slo.status(time.Now()) {
+			o.ObserveFloat64(target, slo.Target, metric.WithAttributes(attribute.String("slo", slo.Name)))
+			for _, w := range slo.Windows {
+				attrs := metric.WithAttributes(attribute.String("slo", slo.Name), attribute.String("window", w.Window))
+				o.ObserveFloat64(ratio, w.Ratio, attrs)
+				o.ObserveFloat64(burnRate, w.BurnRate, attrs)
+			}
+		}
+		return nil
+	}, target, ratio, burnRate)
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"maps"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Erasure states
+const (
+	ErasurePending   = "pending"
+	ErasureCompleted = "completed"
+	ErasureFailed    = "failed"
+)
+
+// SessionExport is everything kept about one session, for data subject
+// access requests. Aggregates such as counters and unique estimates hold
+// nothing that identifies a session and are not included.
+type SessionExport struct {
+	ID          string             `json:"id"`
+	Tenant      string             `json:"tenant,omitempty"`
+	StartTime   time.Time          `json:"start_time"`
+	LastActive  time.Time          `json:"last_active"`
+	Clicks      int64              `json:"clicks"`
+	ActiveTime  float64            `json:"active_seconds"`
+	IdleTime    float64            `json:"idle_seconds"`
+	ScrollDepth map[string]float64 `json:"scroll_depth,omitempty"`
+	Events      []TrackingEvent    `json:"events,omitempty"`
+	Replay      []ReplayChunk      `json:"replay,omitempty"`
+}
+
+// SubjectExport is everything kept about a data subject, across the
+// sessions holding its events
+type SubjectExport struct {
+	Subject  string          `json:"subject"`
+	Tenant   string          `json:"tenant,omitempty"`
+	Sessions []SessionExport `json:"sessions"`
+}
+
+// Subject is a data subject of one tenant: a visitor named by the ID
+// clients send as the session ID, or as the user_id custom field of events
+// in any number of sessions
+type Subject struct {
+	Tenant string
+	ID     string
+
+	// The sessions holding the subject's events when it was looked up,
+	// ID among them
+	Sessions []string
+}
+
+// subject looks up the sessions of a subject of a resolved tenant
+func (s *Service) subject(tenant, id string) Subject {
+	subject := Subject{Tenant: tenant, ID: id, Sessions: []string{id}}
+
+	s.sessionMutex.RLock()
+	defer s.sessionMutex.RUnlock()
+	for key, session := range s.sessions {
+		if key.tenant != tenant || key.id == id {
+			continue
+		}
+		for _, event := range session.Events {
+			if userID, _ := event.Custom["user_id"].(string); userID == id {
+				subject.Sessions = append(subject.Sessions, key.id)
+				break
+			}
+		}
+	}
+	sort.Strings(subject.Sessions[1:])
+	return subject
+}
+
+// ExportSubject returns the data kept about a subject of the tenant ctx
+// names, or an error matching ErrNotFound
+func (s *Service) ExportSubject(ctx context.Context, id string) (SubjectExport, error) {
+	subject := s.subject(s.tenantID(TenantFromContext(ctx)), id)
+	export := SubjectExport{Subject: id, Tenant: subject.Tenant}
+	for _, sessionID := range subject.Sessions {
+		if session, ok := s.exportSession(sessionKey{tenant: subject.Tenant, id: sessionID}); ok {
+			export.Sessions = append(export.Sessions, session)
+		}
+	}
+	if len(export.Sessions) == 0 {
+		return SubjectExport{}, newError(ErrNotFound, "no data is kept for subject %q", id)
+	}
+	return export, nil
+}
+
+// exportSession returns the data kept for a session, reporting whether
+// there is any
+func (s *Service) exportSession(key sessionKey) (SessionExport, bool) {
+	var replay []ReplayChunk
+	if s.replays != nil {
+		replay = s.replays.chunks(key)
+	}
+
+	s.sessionMutex.RLock()
+	defer s.sessionMutex.RUnlock()
+
+	session, ok := s.sessions[key]
+	if !ok {
+		if replay != nil {
+			// The recording can outlive the session
+			return SessionExport{ID: key.id, Tenant: key.tenant, Replay: replay}, true
+		}
+		return SessionExport{}, false
+	}
+	return SessionExport{
+		ID:          session.ID,
+		Tenant:      session.Tenant,
+		StartTime:   session.StartTime,
+		LastActive:  session.LastActive,
+		Clicks:      session.ClickCount,
+		ActiveTime:  session.ActiveTime.Seconds(),
+		IdleTime:    session.IdleTime.Seconds(),
+		ScrollDepth: maps.Clone(session.ScrollDepth),
+		Events:      append([]TrackingEvent(nil), session.Events...),
+		Replay:      replay,
+	}, true
+}
+
+// Erasure tracks a request to erase a subject from every store
+type Erasure struct {
+	ID          string     `json:"id"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Subject     string     `json:"subject"`
+	Sessions    []string   `json:"sessions"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	// Records erased per store
+	Stores map[string]int `json:"stores,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// erasureRetention is how long a finished erasure's status is kept
+const erasureRetention = 7 * 24 * time.Hour
+
+// erasureTimeout bounds the erasure from one store
+const erasureTimeout = 5 * time.Minute
+
+// Eraser removes a subject's records from one store, returning how many
+// it removed: those of the subject's sessions, and those carrying its ID
+// as user_id
+type Eraser func(ctx context.Context, subject Subject) (int, error)
+
+// WithEraser adds a store to erase subjects from, alongside the in-memory
+// sessions
+func WithEraser(store string, erase Eraser) Option {
+	return func(s *Service) {
+		s.erasers[store] = erase
+	}
+}
+
+// RequestErasure schedules the erasure of a subject of the tenant ctx
+// names from every store and returns the request, whose progress
+// GetErasure reports
+func (s *Service) RequestErasure(ctx context.Context, subjectID string) Erasure {
+	subject := s.subject(s.tenantID(TenantFromContext(ctx)), subjectID)
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	e := &Erasure{
+		ID:          hex.EncodeToString(id),
+		Tenant:      subject.Tenant,
+		Subject:     subject.ID,
+		Sessions:    subject.Sessions,
+		Status:      ErasurePending,
+		RequestedAt: time.Now(),
+	}
+
+	s.erasureMutex.Lock()
+	s.erasures[e.ID] = e
+	snapshot := *e
+	s.erasureMutex.Unlock()
+
+	go s.erase(e, subject)
+	return snapshot
+}
+
+// GetErasure returns an erasure request, or an error matching ErrNotFound
+func (s *Service) GetErasure(id string) (Erasure, error) {
+	s.erasureMutex.Lock()
+	defer s.erasureMutex.Unlock()
+
+	e, ok := s.erasures[id]
+	if !ok {
+		return Erasure{}, newError(ErrNotFound, "no erasure request %q", id)
+	}
+	snapshot := *e
+	return snapshot, nil
+}
+
+// CleanupOldErasures forgets erasure requests finished longer than the
+// retention ago
+func (s *Service) CleanupOldErasures() {
+	cutoff := time.Now().Add(-erasureRetention)
+
+	s.erasureMutex.Lock()
+	defer s.erasureMutex.Unlock()
+	for id, e := range s.erasures {
+		if e.CompletedAt != nil && e.CompletedAt.Before(cutoff) {
+			delete(s.erasures, id)
+		}
+	}
+}
+
+func (s *Service) erase(e *Erasure, subject Subject) {
+	stores := make([]string, 0, len(s.erasers))
+	for store := range s.erasers {
+		stores = append(stores, store)
+	}
+	sort.Strings(stores)
+
+	erased := make(map[string]int, len(stores))
+	var failed []string
+	for _, store := range stores {
+		ctx, cancel := context.WithTimeout(context.Background(), erasureTimeout)
+		n, err := s.erasers[store](ctx, subject)
+		cancel()
+		if err != nil {
+			slog.Error("Failed to erase subject", "erasure_id", e.ID, "store", store, "error", err)
+			failed = append(failed, store+": "+err.Error())
+			continue
+		}
+		erased[store] = n
+	}
+
+	now := time.Now()
+	s.erasureMutex.Lock()
+	defer s.erasureMutex.Unlock()
+	e.Stores = erased
+	e.CompletedAt = &now
+	e.Status = ErasureCompleted
+	if len(failed) > 0 {
+		e.Status = ErasureFailed
+		e.Error = strings.Join(failed, "; ")
+	}
+	slog.Info("Erasure finished", "erasure_id", e.ID, "status", e.Status, "stores", erased)
+}
+
+// eraseSessions is the Eraser for the in-memory sessions
+func (s *Service) eraseSessions(_ context.Context, subject Subject) (int, error) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+
+	erased := 0
+	for _, id := range subject.Sessions {
+		key := sessionKey{tenant: subject.Tenant, id: id}
+		session, ok := s.sessions[key]
+		if !ok {
+			continue
+		}
+		delete(s.sessions, key)
+		atomic.AddInt64(&session.stats.activeSessions, -1)
+		s.activeUsers.Add(context.Background(), -1, metric.WithAttributes(s.tenantAttrs(subject.Tenant)...))
+		erased += len(session.Events)
+	}
+	return erased, nil
+}
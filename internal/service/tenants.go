@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultTenant is the tenant of requests that name none, when tenancy is
+// enabled
+const DefaultTenant = "default"
+
+// maxTenantIDLength bounds tenant IDs, which appear in metric attributes
+const maxTenantIDLength = 64
+
+type tenantContextKey struct{}
+
+// ContextWithTenant returns a copy of ctx naming the tenant a request
+// belongs to
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant ctx names, or ""
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// ValidTenantID reports whether id can name a tenant: 1 to 64 ASCII
+// letters, digits, '.', '_' or '-'
+func ValidTenantID(id string) bool {
+	if id == "" || len(id) > maxTenantIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithTenancy keeps each tenant's sessions and aggregates apart and labels
+// event metrics with the tenant, so one deployment can serve several sites.
+// Events name their tenant in TrackingEvent.Tenant and reads name it with
+// ContextWithTenant; either falls back to DefaultTenant. Only the allowed
+// tenants are accepted, or when allowed is empty any valid ID up to
+// maxTenants distinct tenants.
+func WithTenancy(allowed []string, maxTenants int) Option {
+	return func(s *Service) {
+		t := &tenancy{maxTenants: maxTenants}
+		if len(allowed) > 0 {
+			t.allowed = make(map[string]bool, len(allowed))
+			for _, id := range allowed {
+				t.allowed[id] = true
+			}
+		}
+		s.tenancy = t
+	}
+}
+
+type tenancy struct {
+	allowed    map[string]bool
+	maxTenants int
+}
+
+// tenantStats are the aggregates kept for each tenant, or for the whole
+// service without tenancy
+type tenantStats struct {
+	clickCounter   int64
+	pageViews      int64
+	sessionCounter int64
+	activeSessions int64
+
+	// Interactions per minute over the last hour
+	rates rateTracker
+
+	// Furthest each session read each page
+	scrollDepths scrollTracker
+
+	// Active time per page
+	dwell dwellTracker
+
+	// Views and clicks per page
+	pages pageTracker
+
+	// Clicks per element
+	elements elementTracker
+
+	// Transitions between clicked elements
+	flows flowTracker
+
+	// Clicks per page by position in the viewport
+	heatmaps heatmapTracker
+
+	// Goal completions per page
+	goals goalTracker
+
+	// Sessions by user agent class
+	agents agentTracker
+
+	// Distinct sessions and visitors this hour and day
+	uniques uniqueCounter
+
+	// Exposures and activity per experiment variant
+	experiments experimentTracker
+}
+
+// sessionKey identifies a session within its tenant
+type sessionKey struct {
+	tenant string
+	id     string
+}
+
+// tenantID returns the tenant that name resolves to: "" without tenancy and
+// DefaultTenant when name is empty
+func (s *Service) tenantID(name string) string {
+	switch {
+	case s.tenancy == nil:
+		return ""
+	case name == "":
+		return DefaultTenant
+	}
+	return name
+}
+
+// statsFor returns the aggregates of a resolved tenant, creating them on
+// first use, or an error for a tenant that is invalid or not accepted
+func (s *Service) statsFor(tenant string) (*tenantStats, error) {
+	s.tenantMutex.RLock()
+	ts, ok := s.tenants[tenant]
+	s.tenantMutex.RUnlock()
+	if ok {
+		return ts, nil
+	}
+
+	if t := s.tenancy; t != nil {
+		if !ValidTenantID(tenant) {
+			return nil, newError(ErrInvalidRequest, "invalid tenant %q", tenant)
+		}
+		if t.allowed != nil && !t.allowed[tenant] && tenant != DefaultTenant {
+			return nil, newError(ErrForbidden, "unknown tenant %q", tenant)
+		}
+	}
+
+	s.tenantMutex.Lock()
+	defer s.tenantMutex.Unlock()
+	if ts, ok := s.tenants[tenant]; ok {
+		return ts, nil
+	}
+	if t := s.tenancy; t != nil && t.allowed == nil && t.maxTenants > 0 && len(s.tenants) >= t.maxTenants {
+		return nil, newError(ErrForbidden, "tenant limit of %d reached", t.maxTenants)
+	}
+	ts = &tenantStats{}
+	s.tenants[tenant] = ts
+	return ts, nil
+}
+
+// lookupStats returns the aggregates of a resolved tenant, or nil for one
+// that has sent nothing yet
+func (s *Service) lookupStats(tenant string) *tenantStats {
+	s.tenantMutex.RLock()
+	defer s.tenantMutex.RUnlock()
+	return s.tenants[tenant]
+}
+
+// Tenants returns the tenants that have sent events, by ID. Without tenancy
+// it is empty.
+func (s *Service) Tenants() []string {
+	if s.tenancy == nil {
+		return nil
+	}
+
+	s.tenantMutex.RLock()
+	tenants := make([]string, 0, len(s.tenants))
+	for id := range s.tenants {
+		tenants = append(tenants, id)
+	}
+	s.tenantMutex.RUnlock()
+
+	sort.Strings(tenants)
+	return tenants
+}
+
+// totals sums a counter over every tenant
+func (s *Service) totals(counter func(*tenantStats) *int64) int64 {
+	s.tenantMutex.RLock()
+	defer s.tenantMutex.RUnlock()
+
+	var total int64
+	for _, ts := range s.tenants {
+		total += atomic.LoadInt64(counter(ts))
+	}
+	return total
+}
+
+// tenantAttrs returns the metric attributes naming tenant, which are none
+// without tenancy
+func (s *Service) tenantAttrs(tenant string) []attribute.KeyValue {
+	if s.tenancy == nil {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("tenant", tenant)}
+}
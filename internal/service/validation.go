@@ -0,0 +1,144 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Validation limits for incoming tracking events
+const (
+	MaxCoordinate     = 100000
+	MaxFutureSkew     = 5 * time.Minute
+	MaxEventAge       = 24 * time.Hour
+	MaxEventTypeLen   = 64
+	MaxElementIDLen   = 256
+	MaxElementTypeLen = 64
+	MaxElementTextLen = 1024
+	MaxPageURLLen     = 2048
+	MaxSessionIDLen   = 128
+	MaxCustomKeyLen   = 64
+	MaxCustomValueLen = 1024
+)
+
+// FieldError describes a single invalid field of a tracking event
+type FieldError struct {
+	Field  string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ValidationError collects every invalid field found in a tracking event
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Reason)
+	}
+	return "invalid tracking event: " + strings.Join(parts, "; ")
+}
+
+// Is reports a ValidationError as ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+}
+
+// ValidateEvent checks an event against the schema limits and the registered
+// event types, returning a *ValidationError listing every invalid field, or
+// nil if the event is valid.
+func (s *Service) ValidateEvent(event TrackingEvent, now time.Time) error {
+	verr := &ValidationError{}
+	validateSchema(event, now, verr)
+	s.eventTypes.validate(event, verr)
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// CheckEvent validates an event of a batch as ValidateEvent does, and that
+// it has at most maxCustomFields custom fields, adding its problems to verr
+// with field names prefixed by prefix. Errors other than validation
+// failures are returned.
+func (s *Service) CheckEvent(event TrackingEvent, maxCustomFields int, prefix string, now time.Time, verr *ValidationError) error {
+	if len(event.Custom) > maxCustomFields {
+		verr.add(prefix+"custom", "must have at most %d keys", maxCustomFields)
+	}
+	var eventErr *ValidationError
+	err := s.ValidateEvent(event, now)
+	if !errors.As(err, &eventErr) {
+		return err
+	}
+	for _, f := range eventErr.Fields {
+		f.Field = prefix + f.Field
+		verr.Fields = append(verr.Fields, f)
+	}
+	return nil
+}
+
+func validateSchema(event TrackingEvent, now time.Time, verr *ValidationError) {
+	if event.EventType == "" {
+		verr.add("event_type", "is required")
+	}
+
+	checkLength(verr, "event_type", event.EventType, MaxEventTypeLen)
+	checkLength(verr, "element_id", event.ElementID, MaxElementIDLen)
+	checkLength(verr, "element_type", event.ElementType, MaxElementTypeLen)
+	checkLength(verr, "element_text", event.ElementText, MaxElementTextLen)
+	checkLength(verr, "page_url", event.PageURL, MaxPageURLLen)
+	checkLength(verr, "session_id", event.SessionID, MaxSessionIDLen)
+
+	checkRange(verr, "cursor_x", event.CursorX)
+	checkRange(verr, "cursor_y", event.CursorY)
+	checkRange(verr, "viewport_x", event.ViewportX)
+	checkRange(verr, "viewport_y", event.ViewportY)
+	checkRange(verr, "scroll_x", event.ScrollX)
+	checkRange(verr, "scroll_y", event.ScrollY)
+	checkRange(verr, "page_height", event.PageHeight)
+	validateReplay(event, verr)
+
+	// A zero timestamp is filled in by the handler, so only check supplied ones
+	if !event.Timestamp.IsZero() {
+		if event.Timestamp.After(now.Add(MaxFutureSkew)) {
+			verr.add("timestamp", "must not be more than %s in the future", MaxFutureSkew)
+		}
+		if event.Timestamp.Before(now.Add(-MaxEventAge)) {
+			verr.add("timestamp", "must not be more than %s in the past", MaxEventAge)
+		}
+	}
+
+	for _, key := range sortedKeys(event.Custom) {
+		value := event.Custom[key]
+		field := "custom." + key
+		if key == "" || len(key) > MaxCustomKeyLen {
+			verr.add(field, "key length must be between 1 and %d characters", MaxCustomKeyLen)
+		}
+		switch v := value.(type) {
+		case string:
+			checkLength(verr, field, v, MaxCustomValueLen)
+		case float64, bool:
+		default:
+			verr.add(field, "must be a string, number or boolean")
+		}
+	}
+}
+
+func checkLength(verr *ValidationError, field, value string, max int) {
+	if len(value) > max {
+		verr.add(field, "must be at most %d characters", max)
+	}
+}
+
+func checkRange(verr *ValidationError, field string, value int) {
+	if value < 0 || value > MaxCoordinate {
+		verr.add(field, "must be between 0 and %d", MaxCoordinate)
+	}
+}
@@ -0,0 +1,213 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"github.com/nats-io/nuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// errNacked reports messages the broker refused to take
+var errNacked = errors.New("amqp: broker nacked the message")
+
+// AMQPConfig configures the AMQP writer
+type AMQPConfig struct {
+	// amqp:// or amqps:// URL, which may hold credentials and the vhost
+	URL string
+
+	// Overrides the URL's password when set
+	Password string
+
+	// Exchange published to, which must exist
+	Exchange string
+
+	// Routing key template; placeholders such as {tenant} or {event_type}
+	// are replaced by the event's values
+	RoutingKey string
+}
+
+// AMQP publishes each event as a persistent JSON message to an exchange,
+// such as RabbitMQ's, with the routing key its template gives. Publishes
+// are retried until the broker confirms them or the write times out. A
+// lost connection is dialed again on the next write; each event keeps one
+// message ID across retries, for consumers to drop copies of events that
+// were stored before a confirmation was lost. Messages no queue is bound
+// for are dropped by the broker, unless the exchange has an alternate
+// exchange.
+type AMQP struct {
+	url        string
+	sasl       []amqp.Authentication
+	exchange   string
+	routingKey eventTemplate
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// NewAMQP checks the configuration. The broker is dialed on the first
+// write, so an unreachable one fails events to deliver rather than the
+// worker to start.
+func NewAMQP(cfg AMQPConfig) (*AMQP, error) {
+	routingKey, err := parseTemplate(cfg.RoutingKey, routingKeyWord)
+	if err != nil {
+		return nil, fmt.Errorf("routing key %w", err)
+	}
+	uri, err := amqp.ParseURI(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	a := &AMQP{url: cfg.URL, exchange: cfg.Exchange, routingKey: routingKey}
+	if cfg.Password != "" {
+		a.sasl = []amqp.Authentication{&amqp.PlainAuth{Username: uri.Username, Password: cfg.Password}}
+	}
+	return a, nil
+}
+
+// routingKeyWord makes a value a single routing key word, replacing
+// separators and the wildcards of topic exchange bindings
+func routingKeyWord(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '#', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+func (a *AMQP) Write(ctx context.Context, events []service.TrackingEvent) error {
+	now := time.Now()
+	pending := make([]amqp.Publishing, len(events))
+	keys := make(map[string]string, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		pending[i] = amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    nuid.Next(),
+			Timestamp:    now,
+			Type:         event.EventType,
+			Body:         data,
+		}
+		keys[pending[i].MessageId] = a.routingKey.fill(event)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	backoff := 100 * time.Millisecond
+	for {
+		var err error
+		pending, err = a.publish(ctx, pending, keys)
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &WriteError{Failed: len(pending), Err: err}
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Second)
+	}
+}
+
+// publish sends msgs and waits for their confirmations, returning the
+// messages that were not confirmed and the last error
+func (a *AMQP) publish(ctx context.Context, msgs []amqp.Publishing, keys map[string]string) ([]amqp.Publishing, error) {
+	ch, err := a.channel()
+	if err != nil {
+		return msgs, err
+	}
+
+	var failed []amqp.Publishing
+	var lastErr error
+	confirms := make([]*amqp.DeferredConfirmation, 0, len(msgs))
+	sent := make([]amqp.Publishing, 0, len(msgs))
+	for i, msg := range msgs {
+		dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, a.exchange, keys[msg.MessageId], false, false, msg)
+		if err != nil {
+			// The channel is closed, or ctx is done: no later publish
+			// would do better
+			failed, lastErr = append(failed, msgs[i:]...), err
+			break
+		}
+		confirms = append(confirms, dc)
+		sent = append(sent, msg)
+	}
+	for i, dc := range confirms {
+		acked, err := dc.WaitContext(ctx)
+		switch {
+		case err != nil:
+			failed, lastErr = append(failed, sent[i]), err
+		case !acked:
+			// Nacks also stand for confirmations lost with the channel
+			failed, lastErr = append(failed, sent[i]), errNacked
+		}
+	}
+	return failed, lastErr
+}
+
+// channel returns the open confirming channel, dialing the broker again
+// if it was lost
+func (a *AMQP) channel() (*amqp.Channel, error) {
+	if a.ch != nil && !a.ch.IsClosed() {
+		return a.ch, nil
+	}
+	if a.conn != nil && !a.conn.IsClosed() {
+		a.conn.Close()
+	}
+	a.conn, a.ch = nil, nil
+
+	props := amqp.NewConnectionProperties()
+	props.SetClientConnectionName("rate-limited-worker")
+	conn, err := amqp.DialConfig(a.url, amqp.Config{
+		SASL:       a.sasl,
+		Properties: props,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	closed := ch.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		if err := <-closed; err != nil {
+			slog.Warn("Lost AMQP channel, reconnecting on the next write", "error", err)
+		}
+	}()
+	a.conn, a.ch = conn, ch
+	return ch, nil
+}
+
+func (a *AMQP) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	a.conn, a.ch = nil, nil
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil
+	}
+	return err
+}
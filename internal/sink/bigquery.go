@@ -0,0 +1,358 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Storage Write API request limits, the byte limit with room for the
+// request's other fields
+const (
+	bigQueryMaxRows  = 10000
+	bigQueryMaxBytes = 9 << 20
+)
+
+// BigQueryConfig configures the BigQuery writer
+type BigQueryConfig struct {
+	Project string
+	Dataset string
+	Table   string
+
+	// Creates the table, partitioned by day on the event timestamp, and
+	// adds the columns it lacks
+	ManageSchema bool
+}
+
+// bigQueryColumn is a table column and its value for an event, nil
+// meaning NULL
+type bigQueryColumn struct {
+	name  string
+	typ   bigquery.FieldType
+	value func(service.TrackingEvent) interface{}
+}
+
+// bigQueryColumns are the table's columns. Timestamps are Unix
+// microseconds and JSON values strings, as the Storage Write API takes
+// them. Replay chunks are left out, being stored apart.
+var bigQueryColumns = []bigQueryColumn{
+	{"schema_version", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.SchemaVersion) }},
+	{"event_type", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return e.EventType }},
+	{"timestamp", bigquery.TimestampFieldType, func(e service.TrackingEvent) interface{} { return e.Timestamp.UnixMicro() }},
+	{"cursor_x", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.CursorX) }},
+	{"cursor_y", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.CursorY) }},
+	{"element_id", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return e.ElementID }},
+	{"element_type", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return e.ElementType }},
+	{"page_url", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return e.PageURL }},
+	{"user_agent", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return e.UserAgent }},
+	{"session_id", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.SessionID) }},
+	{"viewport_x", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.ViewportX) }},
+	{"viewport_y", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.ViewportY) }},
+	{"scroll_x", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.ScrollX) }},
+	{"scroll_y", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.ScrollY) }},
+	{"page_height", bigquery.IntegerFieldType, func(e service.TrackingEvent) interface{} { return int64(e.PageHeight) }},
+	{"element_text", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return e.ElementText }},
+	{"custom", bigquery.JSONFieldType, func(e service.TrackingEvent) interface{} { return nullJSON(len(e.Custom), e.Custom) }},
+	{"tenant", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.Tenant) }},
+	{"client_ip", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.ClientIP) }},
+	{"browser", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.Browser) }},
+	{"os", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.OS) }},
+	{"device", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.Device) }},
+	{"cursor_x_percent", bigquery.FloatFieldType, func(e service.TrackingEvent) interface{} { return nullFloat(e.CursorXPercent) }},
+	{"cursor_y_percent", bigquery.FloatFieldType, func(e service.TrackingEvent) interface{} { return nullFloat(e.CursorYPercent) }},
+	{"country", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.Country) }},
+	{"region", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.Region) }},
+	{"city", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.City) }},
+	{"consent", bigquery.BooleanFieldType, func(e service.TrackingEvent) interface{} {
+		if e.Consent == nil {
+			return nil
+		}
+		return *e.Consent
+	}},
+	{"do_not_track", bigquery.BooleanFieldType, func(e service.TrackingEvent) interface{} { return e.DoNotTrack }},
+	{"bot", bigquery.BooleanFieldType, func(e service.TrackingEvent) interface{} { return e.Bot }},
+	{"bot_reason", bigquery.StringFieldType, func(e service.TrackingEvent) interface{} { return nullString(e.BotReason) }},
+	{"experiments", bigquery.JSONFieldType, func(e service.TrackingEvent) interface{} { return nullJSON(len(e.Experiments), e.Experiments) }},
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullFloat(f *float64) interface{} {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+// nullJSON encodes a map of n entries, or is NULL for an empty one
+func nullJSON(n int, v interface{}) interface{} {
+	if n == 0 {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// BigQuery streams events into a table through the Storage Write API's
+// default stream, where rows can be queried as soon as they are
+// appended. Appends failing for quota or availability are sent again with
+// backoff until the write times out; other failures, such as a table
+// lacking a column, fail the batch. An append sent again after its
+// response was lost may store its rows twice.
+type BigQuery struct {
+	cfg        BigQueryConfig
+	bq         *bigquery.Client
+	writer     *managedwriter.Client
+	schema     bigquery.Schema
+	descriptor *descriptorpb.DescriptorProto
+	message    protoreflect.MessageDescriptor
+	fields     []protoreflect.FieldDescriptor
+
+	mu      sync.Mutex
+	managed bool
+	stream  *managedwriter.ManagedStream
+
+	throttled metric.Int64Counter
+	sinkAttrs metric.AddOption
+}
+
+// NewBigQuery creates the clients, with credentials from the application
+// default chain. The table is checked and streamed to on the first write.
+func NewBigQuery(cfg BigQueryConfig) (*BigQuery, error) {
+	b := &BigQuery{
+		cfg:       cfg,
+		throttled: newThrottledCounter(),
+		sinkAttrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("sink", "bigquery"))),
+	}
+	for _, col := range bigQueryColumns {
+		b.schema = append(b.schema, &bigquery.FieldSchema{Name: col.name, Type: col.typ})
+	}
+	tableSchema, err := adapt.BQSchemaToStorageTableSchema(b.schema)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := adapt.StorageSchemaToProto2Descriptor(tableSchema, "root")
+	if err != nil {
+		return nil, err
+	}
+	b.message = desc.(protoreflect.MessageDescriptor)
+	if b.descriptor, err = adapt.NormalizeDescriptor(b.message); err != nil {
+		return nil, err
+	}
+	for _, col := range bigQueryColumns {
+		b.fields = append(b.fields, b.message.Fields().ByName(protoreflect.Name(col.name)))
+	}
+
+	ctx := context.Background()
+	if b.bq, err = bigquery.NewClient(ctx, cfg.Project); err != nil {
+		return nil, err
+	}
+	if b.writer, err = managedwriter.NewClient(ctx, cfg.Project); err != nil {
+		b.bq.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *BigQuery) Write(ctx context.Context, events []service.TrackingEvent) error {
+	stream, err := b.managedStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	records := make([]putRecord, len(events))
+	for i, event := range events {
+		row := dynamicpb.NewMessage(b.message)
+		for j, col := range bigQueryColumns {
+			if v := col.value(event); v != nil {
+				row.Set(b.fields[j], protoreflect.ValueOf(v))
+			}
+		}
+		data, err := proto.Marshal(row)
+		if err != nil {
+			return err
+		}
+		records[i] = putRecord{data: data, events: 1}
+	}
+
+	return putWithBackoff(ctx, records, bigQueryMaxRows, bigQueryMaxBytes,
+		func(ctx context.Context, records []putRecord) ([]putRecord, error, error) {
+			return b.appendRows(ctx, stream, records)
+		})
+}
+
+// Erase deletes the subject's rows with a DML statement. BigQuery refuses
+// to delete rows still in the streaming buffer, for up to about half an
+// hour after they were appended; the erasure then fails and can be
+// requested again.
+func (b *BigQuery) Erase(ctx context.Context, subject service.Subject) (int, error) {
+	table := b.bq.Dataset(b.cfg.Dataset).Table(b.cfg.Table)
+	q := b.bq.Query(fmt.Sprintf("DELETE FROM `%s.%s.%s` "+
+		"WHERE IFNULL(tenant, '') = @tenant "+
+		"AND (session_id IN UNNEST(@sessions) OR JSON_VALUE(custom, '$.user_id') = @subject)",
+		table.ProjectID, table.DatasetID, table.TableID))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "tenant", Value: subject.Tenant},
+		{Name: "sessions", Value: subject.Sessions},
+		{Name: "subject", Value: subject.ID},
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+	js, err := job.Wait(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := js.Err(); err != nil {
+		return 0, err
+	}
+	stats, ok := js.Statistics.Details.(*bigquery.QueryStatistics)
+	if !ok {
+		return 0, nil
+	}
+	return int(stats.NumDMLAffectedRows), nil
+}
+
+// appendRows appends one request of rows, returning them to send again
+// when BigQuery is out of quota or unavailable
+func (b *BigQuery) appendRows(ctx context.Context, stream *managedwriter.ManagedStream, records []putRecord) (failed []putRecord, recordErr, err error) {
+	rows := make([][]byte, len(records))
+	for i, r := range records {
+		rows[i] = r.data
+	}
+	result, err := stream.AppendRows(ctx, rows)
+	if err == nil {
+		_, err = result.GetResult(ctx)
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		return nil, nil, nil
+	case codes.ResourceExhausted:
+		b.throttled.Add(ctx, int64(len(records)), b.sinkAttrs)
+		return records, err, nil
+	case codes.Unavailable, codes.Aborted, codes.Internal:
+		return records, err, nil
+	}
+	return nil, nil, err
+}
+
+// managedStream returns the default stream of the table, first managing
+// the table's schema when configured to
+func (b *BigQuery) managedStream(ctx context.Context) (*managedwriter.ManagedStream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cfg.ManageSchema && !b.managed {
+		b.ensureTable(ctx)
+	}
+	if b.stream != nil {
+		return b.stream, nil
+	}
+
+	stream, err := b.writer.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(b.cfg.Project, b.cfg.Dataset, b.cfg.Table)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(b.descriptor),
+		managedwriter.EnableWriteRetries(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	b.stream = stream
+	return stream, nil
+}
+
+// ensureTable creates the table, or adds the columns it lacks, until it
+// succeeds. Failing to is logged rather than failing the write, which
+// succeeds if the table has the columns already. The dataset must exist,
+// as its location and access are not the worker's to choose.
+func (b *BigQuery) ensureTable(ctx context.Context) {
+	table := b.bq.Dataset(b.cfg.Dataset).Table(b.cfg.Table)
+	md, err := table.Metadata(ctx)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		err = table.Create(ctx, &bigquery.TableMetadata{
+			Schema: b.schema,
+			TimePartitioning: &bigquery.TimePartitioning{
+				Type:  bigquery.DayPartitioningType,
+				Field: "timestamp",
+			},
+			Clustering: &bigquery.Clustering{Fields: []string{"tenant", "event_type"}},
+		})
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+			// Created meanwhile by another worker, whose columns are ours
+			err = nil
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to create BigQuery table", "table", b.cfg.Table, "error", err)
+			return
+		}
+		b.managed = true
+		slog.InfoContext(ctx, "Created BigQuery table", "dataset", b.cfg.Dataset, "table", b.cfg.Table)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read BigQuery table schema", "table", b.cfg.Table, "error", err)
+		return
+	}
+
+	have := make(map[string]bool, len(md.Schema))
+	for _, f := range md.Schema {
+		have[f.Name] = true
+	}
+	schema := md.Schema
+	var added []string
+	for _, f := range b.schema {
+		if !have[f.Name] {
+			schema = append(schema, f)
+			added = append(added, f.Name)
+		}
+	}
+	if len(added) > 0 {
+		if _, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, md.ETag); err != nil {
+			slog.WarnContext(ctx, "Failed to add BigQuery table columns", "table", b.cfg.Table, "columns", added, "error", err)
+			return
+		}
+		slog.InfoContext(ctx, "Added BigQuery table columns", "table", b.cfg.Table, "columns", added)
+	}
+	b.managed = true
+}
+
+func (b *BigQuery) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var errs []error
+	if b.stream != nil {
+		errs = append(errs, b.stream.Close())
+	}
+	errs = append(errs, b.writer.Close(), b.bq.Close())
+	return errors.Join(errs...)
+}
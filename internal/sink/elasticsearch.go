@@ -0,0 +1,365 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Elasticsearch index modes
+const (
+	IndexModeDaily      = "daily"
+	IndexModeDataStream = "data_stream"
+)
+
+// Bulk request limits
+const (
+	bulkMaxDocuments = 1000
+	bulkMaxBytes     = 5 << 20
+)
+
+var elasticsearchClient = &http.Client{Timeout: 30 * time.Second}
+
+// ElasticsearchConfig configures the Elasticsearch writer
+type ElasticsearchConfig struct {
+	// Cluster nodes, tried in turn
+	URLs []string
+
+	// Index name prefix in daily mode, data stream name in data stream mode
+	Index string
+
+	// IndexModeDaily or IndexModeDataStream
+	IndexMode string
+
+	// Basic authentication, or an API key, which wins when both are set
+	Username string
+	Password string
+	APIKey   string
+
+	// Installs an index template mapping the event fields
+	ManageTemplate bool
+}
+
+// Elasticsearch indexes events with the bulk API, which Elasticsearch and
+// OpenSearch share. Documents are the events' JSON with an "@timestamp"
+// field, in a daily index named after the event's date or in a data
+// stream. Each document keeps one ID across retries, and is only created,
+// so one stored before a response was lost is not stored twice. Requests
+// failing with 429, 5xx or a network error, and documents rejected with
+// 429 or 503, are sent again with backoff until the write times out;
+// other rejections are final.
+type Elasticsearch struct {
+	cfg  ElasticsearchConfig
+	next atomic.Uint32
+
+	templateMu sync.Mutex
+	templated  bool
+
+	throttled metric.Int64Counter
+	sinkAttrs metric.AddOption
+}
+
+// NewElasticsearch checks the configuration; the cluster is only
+// contacted when writing
+func NewElasticsearch(cfg ElasticsearchConfig) (*Elasticsearch, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("no Elasticsearch URLs")
+	}
+	if cfg.IndexMode != IndexModeDaily && cfg.IndexMode != IndexModeDataStream {
+		return nil, fmt.Errorf("unknown index mode %q", cfg.IndexMode)
+	}
+	urls := make([]string, len(cfg.URLs))
+	for i, u := range cfg.URLs {
+		urls[i] = strings.TrimSuffix(u, "/")
+	}
+	cfg.URLs = urls
+	return &Elasticsearch{
+		cfg:       cfg,
+		throttled: newThrottledCounter(),
+		sinkAttrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("sink", "elasticsearch"))),
+	}, nil
+}
+
+func (e *Elasticsearch) Write(ctx context.Context, events []service.TrackingEvent) error {
+	if e.cfg.ManageTemplate {
+		e.ensureTemplate(ctx)
+	}
+
+	records := make([]putRecord, len(events))
+	for i, event := range events {
+		doc, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		index := e.cfg.Index
+		if e.cfg.IndexMode == IndexModeDaily {
+			index += "-" + event.Timestamp.UTC().Format("2006.01.02")
+		}
+		id := make([]byte, 12)
+		_, _ = crand.Read(id)
+		action, _ := json.Marshal(map[string]interface{}{
+			"create": map[string]string{"_index": index, "_id": hex.EncodeToString(id)},
+		})
+
+		// The action line, then the document with @timestamp spliced in
+		var b bytes.Buffer
+		b.Write(action)
+		b.WriteString("\n{\"@timestamp\":")
+		ts, _ := json.Marshal(event.Timestamp)
+		b.Write(ts)
+		if len(doc) > 2 {
+			b.WriteByte(',')
+		}
+		b.Write(doc[1:])
+		b.WriteByte('\n')
+		records[i] = putRecord{data: b.Bytes(), events: 1}
+	}
+
+	var rejected bulkRejections
+	err := putWithBackoff(ctx, records, bulkMaxDocuments, bulkMaxBytes,
+		func(ctx context.Context, records []putRecord) ([]putRecord, error, error) {
+			return e.bulk(ctx, records, &rejected)
+		})
+	if rejected.count == 0 {
+		return err
+	}
+	slog.WarnContext(ctx, "Elasticsearch rejected documents", "documents", rejected.count, "error", rejected.last)
+	failed := rejected.count
+	var werr *WriteError
+	if errors.As(err, &werr) {
+		failed += werr.Failed
+	}
+	return &WriteError{Failed: failed, Err: rejected.last}
+}
+
+// Erase deletes the subject's documents with a delete by query over the
+// events' indices or data stream
+func (e *Elasticsearch) Erase(ctx context.Context, subject service.Subject) (int, error) {
+	target := e.cfg.Index
+	if e.cfg.IndexMode == IndexModeDaily {
+		target += "-*"
+	}
+	tenant := map[string]interface{}{"term": map[string]string{"tenant": subject.Tenant}}
+	if subject.Tenant == "" {
+		tenant = map[string]interface{}{"bool": map[string]interface{}{
+			"must_not": map[string]interface{}{"exists": map[string]string{"field": "tenant"}},
+		}}
+	}
+	query, _ := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"filter": []interface{}{tenant},
+			"should": []interface{}{
+				map[string]interface{}{"terms": map[string][]string{"session_id": subject.Sessions}},
+				map[string]interface{}{"term": map[string]string{"custom.user_id": subject.ID}},
+			},
+			"minimum_should_match": 1,
+		}},
+	})
+
+	resp, err := e.do(ctx, http.MethodPost, "/"+target+"/_delete_by_query?conflicts=proceed&refresh=true", "application/json", query)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return 0, fmt.Errorf("delete by query: unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Deleted  int               `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("delete by query response: %w", err)
+	}
+	if len(result.Failures) > 0 {
+		return result.Deleted, fmt.Errorf("delete by query: %d failures, the first %s", len(result.Failures), result.Failures[0])
+	}
+	return result.Deleted, nil
+}
+
+// bulkRejections counts documents rejected for good, such as for mapping
+// conflicts, which would fail alike when sent again
+type bulkRejections struct {
+	count int
+	last  error
+}
+
+// bulk makes one bulk request, returning the documents to send again and
+// the last of their errors. Requests failing with 429, 5xx or a network
+// error come back whole, to be sent again to the next node.
+func (e *Elasticsearch) bulk(ctx context.Context, records []putRecord, rejected *bulkRejections) (failed []putRecord, recordErr, err error) {
+	var body bytes.Buffer
+	for _, r := range records {
+		body.Write(r.data)
+	}
+	resp, err := e.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+		return records, err, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode == http.StatusTooManyRequests {
+			e.throttled.Add(ctx, int64(len(records)), e.sinkAttrs)
+		}
+		return records, fmt.Errorf("bulk request: unexpected status %s", resp.Status), nil
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, nil, fmt.Errorf("bulk request: unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, fmt.Errorf("bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil, nil
+	}
+
+	for i, item := range result.Items {
+		res := item["create"]
+		switch {
+		case res.Status < 300 || res.Status == http.StatusConflict:
+			// A conflict is a document stored by an earlier attempt
+		case res.Status == http.StatusTooManyRequests || res.Status == http.StatusServiceUnavailable:
+			failed = append(failed, records[i])
+			recordErr = fmt.Errorf("%s: %s", res.Error.Type, res.Error.Reason)
+			if res.Status == http.StatusTooManyRequests {
+				e.throttled.Add(ctx, 1, e.sinkAttrs)
+			}
+		default:
+			rejected.count++
+			rejected.last = fmt.Errorf("%s: %s", res.Error.Type, res.Error.Reason)
+		}
+	}
+	return failed, recordErr, nil
+}
+
+// do sends a request to the next node, authenticated as configured
+func (e *Elasticsearch) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	base := e.cfg.URLs[int(e.next.Add(1)-1)%len(e.cfg.URLs)]
+	req, err := http.NewRequestWithContext(ctx, method, base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case e.cfg.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+e.cfg.APIKey)
+	case e.cfg.Username != "":
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+	return elasticsearchClient.Do(req)
+}
+
+// ensureTemplate installs the index template until it succeeds. Failing
+// to is logged rather than failing the write: the cluster's dynamic
+// mappings still index the events, though data streams need the template
+// to be created.
+func (e *Elasticsearch) ensureTemplate(ctx context.Context) {
+	e.templateMu.Lock()
+	defer e.templateMu.Unlock()
+	if e.templated {
+		return
+	}
+
+	body, _ := json.Marshal(e.template())
+	resp, err := e.do(ctx, http.MethodPut, "/_index_template/"+e.cfg.Index, "application/json", body)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to install Elasticsearch index template", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode >= 300 {
+		slog.WarnContext(ctx, "Failed to install Elasticsearch index template",
+			"status", resp.StatusCode, "response", string(bytes.TrimSpace(msg)))
+		return
+	}
+	e.templated = true
+	slog.InfoContext(ctx, "Installed Elasticsearch index template", "template", e.cfg.Index)
+}
+
+// template is the composable index template for the events' indices.
+// Strings are keywords, for aggregations in dashboards, except the
+// element text, which is searched as text.
+func (e *Elasticsearch) template() map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword", "ignore_above": 1024}
+	properties := map[string]interface{}{
+		"@timestamp":       map[string]string{"type": "date"},
+		"timestamp":        map[string]string{"type": "date"},
+		"element_text":     map[string]string{"type": "text"},
+		"cursor_x":         map[string]string{"type": "integer"},
+		"cursor_y":         map[string]string{"type": "integer"},
+		"viewport_x":       map[string]string{"type": "integer"},
+		"viewport_y":       map[string]string{"type": "integer"},
+		"scroll_x":         map[string]string{"type": "integer"},
+		"scroll_y":         map[string]string{"type": "integer"},
+		"page_height":      map[string]string{"type": "integer"},
+		"cursor_x_percent": map[string]string{"type": "float"},
+		"cursor_y_percent": map[string]string{"type": "float"},
+	}
+	for _, field := range []string{"event_type", "element_id", "element_type", "page_url", "user_agent",
+		"session_id", "tenant", "client_ip", "browser", "os", "device", "country", "region", "city"} {
+		properties[field] = keyword
+	}
+
+	tmpl := map[string]interface{}{
+		"index_patterns": []string{e.cfg.Index + "-*"},
+		"priority":       200,
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"dynamic_templates": []interface{}{
+					map[string]interface{}{"strings_as_keywords": map[string]interface{}{
+						"match_mapping_type": "string",
+						"mapping":            keyword,
+					}},
+				},
+				"properties": properties,
+			},
+		},
+		"_meta": map[string]string{"managed_by": "rate-limited-worker"},
+	}
+	if e.cfg.IndexMode == IndexModeDataStream {
+		tmpl["index_patterns"] = []string{e.cfg.Index}
+		tmpl["data_stream"] = map[string]interface{}{}
+	}
+	return tmpl
+}
+
+func (e *Elasticsearch) Close() error {
+	elasticsearchClient.CloseIdleConnections()
+	return nil
+}
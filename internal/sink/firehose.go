@@ -0,0 +1,117 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	"github.com/aws/aws-sdk-go-v2/service/firehose/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Firehose limits
+const (
+	firehoseMaxRecords      = 500
+	firehoseMaxRequestBytes = 4 << 20
+	firehoseMaxRecordBytes  = 1000 << 10
+)
+
+// FirehoseConfig configures the Data Firehose writer
+type FirehoseConfig struct {
+	DeliveryStream string
+
+	// Packs several events into each record
+	Aggregate bool
+}
+
+// Firehose puts events into a Data Firehose delivery stream as JSON lines,
+// so objects it writes to S3 are newline-delimited JSON. With aggregation,
+// a record carries as many lines as fit, which Firehose bills and
+// throttles far less than a record per event. Records the stream throttles
+// are put again with backoff until the write times out. AWS credentials and
+// region come from the default chain.
+type Firehose struct {
+	client    *firehose.Client
+	stream    string
+	aggregate bool
+
+	throttled metric.Int64Counter
+	sinkAttrs metric.AddOption
+}
+
+// NewFirehose creates the client, with credentials from the default AWS chain
+func NewFirehose(cfg FirehoseConfig) (*Firehose, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &Firehose{
+		client:    firehose.NewFromConfig(awsCfg),
+		stream:    cfg.DeliveryStream,
+		aggregate: cfg.Aggregate,
+		throttled: newThrottledCounter(),
+		sinkAttrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("sink", "firehose"))),
+	}, nil
+}
+
+func (f *Firehose) Write(ctx context.Context, events []service.TrackingEvent) error {
+	var records []putRecord
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+
+		if n := len(records); f.aggregate && n > 0 && len(records[n-1].data)+len(data) <= firehoseMaxRecordBytes {
+			records[n-1].data = append(records[n-1].data, data...)
+			records[n-1].events++
+			continue
+		}
+		records = append(records, putRecord{data: data, events: 1})
+	}
+
+	return putWithBackoff(ctx, records, firehoseMaxRecords, firehoseMaxRequestBytes, f.put)
+}
+
+// put makes one PutRecordBatch request, returning the records that failed
+// and the last of their errors
+func (f *Firehose) put(ctx context.Context, records []putRecord) (failed []putRecord, recordErr, err error) {
+	in := &firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(f.stream),
+		Records:            make([]types.Record, len(records)),
+	}
+	for i, r := range records {
+		in.Records[i] = types.Record{Data: r.data}
+	}
+
+	out, err := f.client.PutRecordBatch(ctx, in)
+	if err != nil {
+		return records, nil, err
+	}
+	if aws.ToInt32(out.FailedPutCount) == 0 {
+		return nil, nil, nil
+	}
+	for i, result := range out.RequestResponses {
+		code := aws.ToString(result.ErrorCode)
+		if code == "" {
+			continue
+		}
+		failed = append(failed, records[i])
+		recordErr = errors.New(code + ": " + aws.ToString(result.ErrorMessage))
+		if throttleCodes[code] {
+			f.throttled.Add(ctx, 1, f.sinkAttrs)
+		}
+	}
+	return failed, recordErr, nil
+}
+
+func (f *Firehose) Close() error {
+	return nil
+}
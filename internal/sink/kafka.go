@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaConfig configures the Kafka writer
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+
+	// none, gzip, snappy, lz4 or zstd; empty is none
+	Compression string
+
+	// Messages sent to a partition at a time, at most
+	BatchSize int
+}
+
+// Kafka produces each event as a JSON message keyed by its session ID, so
+// a session's events land on one partition in order. Events without a
+// session ID are spread over partitions. The tenant is sent as a header.
+type Kafka struct {
+	w *kafka.Writer
+}
+
+// NewKafka creates the writer. Brokers are dialed on the first write, so
+// unreachable ones fail events to deliver rather than the worker to start.
+func NewKafka(cfg KafkaConfig) (*Kafka, error) {
+	var codec kafka.Compression
+	switch cfg.Compression {
+	case "", "none":
+	case "gzip":
+		codec = kafka.Gzip
+	case "snappy":
+		codec = kafka.Snappy
+	case "lz4":
+		codec = kafka.Lz4
+	case "zstd":
+		codec = kafka.Zstd
+	default:
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+
+	return &Kafka{w: &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Hash{},
+		Compression:  codec,
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    cfg.BatchSize,
+		// Batches arrive whole from the Batcher, so a partition's share is
+		// sent without waiting for more
+		BatchTimeout: 10 * time.Millisecond,
+	}}, nil
+}
+
+// Write produces events, reporting the ones a partition rejected
+func (k *Kafka) Write(ctx context.Context, events []service.TrackingEvent) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		msgs[i].Value = value
+		if event.SessionID != "" {
+			msgs[i].Key = []byte(event.SessionID)
+		}
+		if event.Tenant != "" {
+			msgs[i].Headers = []kafka.Header{{Key: "tenant", Value: []byte(event.Tenant)}}
+		}
+	}
+
+	err := k.w.WriteMessages(ctx, msgs...)
+	var werrs kafka.WriteErrors
+	if errors.As(err, &werrs) {
+		return &WriteError{Failed: werrs.Count(), Err: err}
+	}
+	return err
+}
+
+// Close flushes pending messages and closes the broker connections
+func (k *Kafka) Close() error {
+	return k.w.Close()
+}
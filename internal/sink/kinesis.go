@@ -0,0 +1,414 @@
+package sink
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/big"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/nats-io/nuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Kinesis Data Streams limits
+const (
+	kinesisMaxRecords      = 500
+	kinesisMaxRequestBytes = 5 << 20
+	kinesisMaxRecordBytes  = 1 << 20
+)
+
+// kinesisShardRefresh is how long a stream's shard map is trusted
+const kinesisShardRefresh = time.Minute
+
+// kplMagic starts records in the Kinesis Producer Library's aggregated
+// format, which the KCL and Lambda's deaggregation libraries unpack
+var kplMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// throttleCodes are per-record error codes that mean the stream, or its
+// key, is taking more than it may
+var throttleCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"KMSThrottlingException":                 true,
+	"ServiceUnavailableException":            true,
+}
+
+// KinesisConfig configures the Kinesis Data Streams writer
+type KinesisConfig struct {
+	// Stream name or ARN
+	Stream string
+
+	// Packs the events bound for one shard into KPL aggregated records
+	Aggregate bool
+}
+
+// Kinesis puts each event as JSON into a Kinesis data stream, partitioned
+// by session ID so a session's events reach one shard in order. Events
+// without a session ID get a random partition key. With aggregation, the
+// events of a batch that hash to the same shard are packed into few
+// records, which cuts the records a shard's throughput limit counts.
+// Records the stream throttles are put again with backoff until the write
+// times out. AWS credentials and region come from the default chain.
+type Kinesis struct {
+	client    *kinesis.Client
+	stream    string
+	aggregate bool
+
+	throttled metric.Int64Counter
+	sinkAttrs metric.AddOption
+
+	mu       sync.Mutex
+	shards   []kinesisShard
+	shardsAt time.Time
+}
+
+// kinesisShard is the hash key range of an open shard
+type kinesisShard struct {
+	start, end *big.Int
+}
+
+// putRecord is a record to put, and the events it carries
+type putRecord struct {
+	data         []byte
+	partitionKey string
+	hashKey      string
+	events       int
+}
+
+// NewKinesis creates the client, with credentials from the default AWS chain
+func NewKinesis(cfg KinesisConfig) (*Kinesis, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &Kinesis{
+		client:    kinesis.NewFromConfig(awsCfg),
+		stream:    cfg.Stream,
+		aggregate: cfg.Aggregate,
+		throttled: newThrottledCounter(),
+		sinkAttrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("sink", "kinesis"))),
+	}, nil
+}
+
+// newThrottledCounter creates the counter of records AWS throttled
+func newThrottledCounter() metric.Int64Counter {
+	c, _ := otel.Meter("worker-sink").Int64Counter("worker_sink_throttled_records_total",
+		metric.WithDescription("Records a sink's service throttled and that were put again, by sink"),
+	)
+	return c
+}
+
+func (k *Kinesis) Write(ctx context.Context, events []service.TrackingEvent) error {
+	records := make([]putRecord, 0, len(events))
+	var groups map[string]*kplAggregate
+	var order []string
+	if k.aggregate {
+		groups = make(map[string]*kplAggregate)
+	}
+	shards := k.shardMap(ctx)
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		key := event.SessionID
+		if key == "" {
+			key = nuid.Next()
+		}
+		if !k.aggregate {
+			records = append(records, putRecord{data: data, partitionKey: key, events: 1})
+			continue
+		}
+
+		// Records of one shard share an aggregate; without a shard map,
+		// records of one partition key do
+		hash := partitionHash(key)
+		group := "key:" + key
+		if i, ok := shardFor(shards, hash); ok {
+			group = "shard:" + shards[i].start.String()
+		}
+		agg, ok := groups[group]
+		if !ok {
+			order = append(order, group)
+		} else if !agg.fits(key, data) {
+			records = append(records, agg.record())
+			ok = false
+		}
+		if !ok {
+			agg = newKPLAggregate(key, hash.String())
+			groups[group] = agg
+		}
+		agg.add(key, data)
+	}
+	for _, group := range order {
+		records = append(records, groups[group].record())
+	}
+
+	return putWithBackoff(ctx, records, kinesisMaxRecords, kinesisMaxRequestBytes, k.put)
+}
+
+// put makes one PutRecords request, returning the records that failed and
+// the last of their errors
+func (k *Kinesis) put(ctx context.Context, records []putRecord) (failed []putRecord, recordErr, err error) {
+	in := &kinesis.PutRecordsInput{Records: make([]types.PutRecordsRequestEntry, len(records))}
+	if strings.HasPrefix(k.stream, "arn:") {
+		in.StreamARN = aws.String(k.stream)
+	} else {
+		in.StreamName = aws.String(k.stream)
+	}
+	for i, r := range records {
+		in.Records[i] = types.PutRecordsRequestEntry{Data: r.data, PartitionKey: aws.String(r.partitionKey)}
+		if r.hashKey != "" {
+			in.Records[i].ExplicitHashKey = aws.String(r.hashKey)
+		}
+	}
+
+	out, err := k.client.PutRecords(ctx, in)
+	if err != nil {
+		return records, nil, err
+	}
+	if aws.ToInt32(out.FailedRecordCount) == 0 {
+		return nil, nil, nil
+	}
+	for i, result := range out.Records {
+		code := aws.ToString(result.ErrorCode)
+		if code == "" {
+			continue
+		}
+		failed = append(failed, records[i])
+		recordErr = errors.New(code + ": " + aws.ToString(result.ErrorMessage))
+		if throttleCodes[code] {
+			k.throttled.Add(ctx, 1, k.sinkAttrs)
+		}
+	}
+	return failed, recordErr, nil
+}
+
+// shardMap returns the stream's open shards sorted by hash key, listing
+// them again once kinesisShardRefresh has passed. It returns nil when the
+// shards cannot be listed, for instance for lack of kinesis:ListShards.
+// The listing runs outside the lock; callers meanwhile get the old map.
+func (k *Kinesis) shardMap(ctx context.Context) []kinesisShard {
+	if !k.aggregate {
+		return nil
+	}
+	k.mu.Lock()
+	if time.Since(k.shardsAt) < kinesisShardRefresh {
+		defer k.mu.Unlock()
+		return k.shards
+	}
+	// Claimed before listing so only one caller lists at a time
+	k.shardsAt = time.Now()
+	k.mu.Unlock()
+
+	shards, err := k.listShards(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list Kinesis shards, aggregating by partition key", "error", err)
+		shards = nil
+	}
+	k.mu.Lock()
+	k.shards = shards
+	k.mu.Unlock()
+	return shards
+}
+
+func (k *Kinesis) listShards(ctx context.Context) ([]kinesisShard, error) {
+	in := &kinesis.ListShardsInput{ShardFilter: &types.ShardFilter{Type: types.ShardFilterTypeAtLatest}}
+	if strings.HasPrefix(k.stream, "arn:") {
+		in.StreamARN = aws.String(k.stream)
+	} else {
+		in.StreamName = aws.String(k.stream)
+	}
+
+	var shards []kinesisShard
+	for {
+		out, err := k.client.ListShards(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range out.Shards {
+			if s.HashKeyRange == nil {
+				continue
+			}
+			start, ok1 := new(big.Int).SetString(aws.ToString(s.HashKeyRange.StartingHashKey), 10)
+			end, ok2 := new(big.Int).SetString(aws.ToString(s.HashKeyRange.EndingHashKey), 10)
+			if ok1 && ok2 {
+				shards = append(shards, kinesisShard{start: start, end: end})
+			}
+		}
+		if out.NextToken == nil {
+			break
+		}
+		// Continuation requests take the token alone
+		in = &kinesis.ListShardsInput{NextToken: out.NextToken}
+	}
+	slices.SortFunc(shards, func(a, b kinesisShard) int { return a.start.Cmp(b.start) })
+	return shards, nil
+}
+
+// partitionHash is the 128-bit hash key Kinesis maps a partition key to
+func partitionHash(key string) *big.Int {
+	sum := md5.Sum([]byte(key))
+	return new(big.Int).SetBytes(sum[:])
+}
+
+// shardFor finds the shard whose range holds hash
+func shardFor(shards []kinesisShard, hash *big.Int) (int, bool) {
+	i, found := slices.BinarySearchFunc(shards, hash, func(s kinesisShard, h *big.Int) int {
+		return s.start.Cmp(h)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || shards[i].end.Cmp(hash) < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// kplAggregate builds a record in the KPL aggregated format: kplMagic, an
+// AggregatedRecord protobuf message and the message's MD5. The record is
+// put with the first event's partition key and hash key, which place it
+// on the shard of all its events.
+type kplAggregate struct {
+	partitionKey string
+	hashKey      string
+	keys         map[string]uint64
+	msg          []byte
+	first        []byte
+	events       int
+}
+
+func newKPLAggregate(partitionKey, hashKey string) *kplAggregate {
+	return &kplAggregate{partitionKey: partitionKey, hashKey: hashKey, keys: make(map[string]uint64)}
+}
+
+// fits reports whether adding data under key keeps the record within
+// kinesisMaxRecordBytes
+func (a *kplAggregate) fits(key string, data []byte) bool {
+	size := len(kplMagic) + len(a.msg) + md5.Size + len(a.partitionKey)
+	if _, ok := a.keys[key]; !ok {
+		size += protowire.SizeTag(1) + protowire.SizeBytes(len(key))
+	}
+	return size+kplEntrySize(uint64(len(a.keys)), data) <= kinesisMaxRecordBytes
+}
+
+// add appends data as a user record under key. Fields of a protobuf
+// message may come in any order, so table entries and records are
+// appended as they come.
+func (a *kplAggregate) add(key string, data []byte) {
+	index, ok := a.keys[key]
+	if !ok {
+		index = uint64(len(a.keys))
+		a.keys[key] = index
+		a.msg = protowire.AppendTag(a.msg, 1, protowire.BytesType)
+		a.msg = protowire.AppendString(a.msg, key)
+	}
+
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.VarintType)
+	entry = protowire.AppendVarint(entry, index)
+	entry = protowire.AppendTag(entry, 3, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, data)
+	a.msg = protowire.AppendTag(a.msg, 3, protowire.BytesType)
+	a.msg = protowire.AppendBytes(a.msg, entry)
+	if a.events == 0 {
+		a.first = data
+	}
+	a.events++
+}
+
+// kplEntrySize is the encoded size of a user record
+func kplEntrySize(index uint64, data []byte) int {
+	entry := protowire.SizeTag(1) + protowire.SizeVarint(index) + protowire.SizeTag(3) + protowire.SizeBytes(len(data))
+	return protowire.SizeTag(3) + protowire.SizeBytes(entry)
+}
+
+// record finishes the aggregate. A lone event is put as a plain record,
+// as the KPL does.
+func (a *kplAggregate) record() putRecord {
+	if a.events == 1 {
+		return putRecord{data: a.first, partitionKey: a.partitionKey, hashKey: a.hashKey, events: 1}
+	}
+	sum := md5.Sum(a.msg)
+	data := make([]byte, 0, len(kplMagic)+len(a.msg)+len(sum))
+	data = append(append(append(data, kplMagic...), a.msg...), sum[:]...)
+	return putRecord{data: data, partitionKey: a.partitionKey, hashKey: a.hashKey, events: a.events}
+}
+
+// putWithBackoff puts records in requests of at most maxRecords records
+// and maxBytes bytes. Records put returns as failed, mostly for
+// throttling, are put again with jittered exponential backoff until all
+// are accepted or ctx is done. An error from put ends the write: the AWS
+// SDK, for one, has already retried the request.
+func putWithBackoff(ctx context.Context, records []putRecord, maxRecords, maxBytes int,
+	put func(context.Context, []putRecord) (failed []putRecord, recordErr, err error)) error {
+	backoff := 100 * time.Millisecond
+	for {
+		var failed []putRecord
+		var lastErr error
+		for start := 0; start < len(records); {
+			end, size := start, 0
+			for end < len(records) && end-start < maxRecords {
+				n := len(records[end].data) + len(records[end].partitionKey)
+				if end > start && size+n > maxBytes {
+					break
+				}
+				size += n
+				end++
+			}
+
+			f, recordErr, err := put(ctx, records[start:end])
+			if err != nil {
+				failed = append(failed, records[start:]...)
+				return &WriteError{Failed: countEvents(failed), Err: err}
+			}
+			failed = append(failed, f...)
+			if recordErr != nil {
+				lastErr = recordErr
+			}
+			start = end
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+
+		records = failed
+		slog.DebugContext(ctx, "Putting failed records again", "records", len(records), "error", lastErr)
+		wait := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-ctx.Done():
+			return &WriteError{Failed: countEvents(records), Err: lastErr}
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, 5*time.Second)
+	}
+}
+
+func countEvents(records []putRecord) int {
+	n := 0
+	for _, r := range records {
+		n += r.events
+	}
+	return n
+}
+
+func (k *Kinesis) Close() error {
+	return nil
+}
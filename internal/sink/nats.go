@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nuid"
+)
+
+// NATSConfig configures the NATS JetStream writer
+type NATSConfig struct {
+	URL string
+
+	// Subject template; placeholders such as {tenant} or {event_type} are
+	// replaced by the event's values
+	Subject string
+
+	// Credentials file for NATS authentication, if any
+	CredentialsFile string
+}
+
+// NATS publishes each event as JSON to a JetStream stream, on the subject
+// its template gives. Publishes are retried until acknowledged or the
+// write times out; each event keeps one message ID across retries, so the
+// stream's duplicate window drops copies of events that were stored
+// before an acknowledgement was lost.
+type NATS struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject eventTemplate
+}
+
+// NewNATS connects to NATS. An unreachable server is retried in the
+// background, so events fail to deliver rather than the worker to start.
+func NewNATS(cfg NATSConfig) (*NATS, error) {
+	subject, err := parseTemplate(cfg.Subject, subjectToken)
+	if err != nil {
+		return nil, fmt.Errorf("subject %w", err)
+	}
+
+	opts := []nats.Option{
+		nats.Name("rate-limited-worker"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	}
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &NATS{conn: conn, js: js, subject: subject}, nil
+}
+
+// subjectToken makes a value a single subject token, replacing
+// separators and wildcards
+func subjectToken(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+func (n *NATS) Write(ctx context.Context, events []service.TrackingEvent) error {
+	pending := make([]*nats.Msg, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		pending[i] = &nats.Msg{Subject: n.subject.fill(event), Data: data, Header: nats.Header{}}
+		pending[i].Header.Set(jetstream.MsgIDHeader, nuid.Next())
+	}
+
+	backoff := 100 * time.Millisecond
+	for {
+		var err error
+		pending, err = n.publish(ctx, pending)
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &WriteError{Failed: len(pending), Err: err}
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Second)
+	}
+}
+
+// publish sends msgs and waits for their acknowledgements, returning the
+// messages that were not acknowledged and the last error
+func (n *NATS) publish(ctx context.Context, msgs []*nats.Msg) ([]*nats.Msg, error) {
+	var failed []*nats.Msg
+	var lastErr error
+	futures := make([]jetstream.PubAckFuture, 0, len(msgs))
+	for _, msg := range msgs {
+		f, err := n.js.PublishMsgAsync(msg)
+		if err != nil {
+			failed, lastErr = append(failed, msg), err
+			continue
+		}
+		futures = append(futures, f)
+	}
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			failed, lastErr = append(failed, f.Msg()), err
+		case <-ctx.Done():
+			failed, lastErr = append(failed, f.Msg()), ctx.Err()
+		}
+	}
+	return failed, lastErr
+}
+
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig configures the Redis pub/sub writer
+type RedisConfig struct {
+	// redis:// or rediss:// URL, which may hold credentials and the
+	// database
+	URL string
+
+	// Overrides the URL's password when set
+	Password string
+
+	// Channel template; placeholders such as {tenant} or {event_type} are
+	// replaced by the event's values
+	Channel string
+}
+
+// Redis publishes each event as JSON to the channel its template gives,
+// for services reacting to events as they happen. Pub/sub keeps nothing:
+// subscribers that are away miss the events published meanwhile, and an
+// event published to a channel nobody listens on is delivered.
+type Redis struct {
+	client  *redis.Client
+	channel eventTemplate
+}
+
+// NewRedis creates the client. Connections are made when publishing, so
+// an unreachable server fails writes rather than the worker to start.
+func NewRedis(cfg RedisConfig) (*Redis, error) {
+	channel, err := parseTemplate(cfg.Channel, channelToken)
+	if err != nil {
+		return nil, fmt.Errorf("channel %w", err)
+	}
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Password != "" {
+		opts.Password = cfg.Password
+	}
+	return &Redis{client: redis.NewClient(opts), channel: channel}, nil
+}
+
+// channelToken keeps a value from adding channel segments or matching
+// more than itself in subscribers' PSUBSCRIBE patterns
+func channelToken(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '*', '?', '[', ']', '\\', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+// Write publishes the events in one pipeline. The client retries it on
+// network errors; publishing again after that may repeat events
+// subscribers already got, so failures are left to the batcher to count.
+func (r *Redis) Write(ctx context.Context, events []service.TrackingEvent) error {
+	pipe := r.client.Pipeline()
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		pipe.Publish(ctx, r.channel.fill(event), data)
+	}
+	cmds, err := pipe.Exec(ctx)
+	if err == nil {
+		return nil
+	}
+	failed := 0
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			failed++
+		}
+	}
+	if failed < len(events) {
+		return &WriteError{Failed: failed, Err: err}
+	}
+	return err
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
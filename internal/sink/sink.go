@@ -0,0 +1,297 @@
+// Package sink forwards processed tracking events to external systems.
+// A Batcher queues events off the request path and hands them in batches
+// to a Writer, which delivers them to one system.
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Writer delivers batches of events to one system
+type Writer interface {
+	// Write delivers events, in order, before ctx is done. An error
+	// means none were delivered unless it is a *WriteError. The slice is
+	// reused once Write returns.
+	Write(ctx context.Context, events []service.TrackingEvent) error
+
+	// Close releases the writer's connections
+	Close() error
+}
+
+// Eraser is a Writer whose system can delete the events it stored
+type Eraser interface {
+	// Erase deletes the events of the subject's sessions, and those
+	// carrying its ID as user_id, returning how many it deleted
+	Erase(ctx context.Context, subject service.Subject) (int, error)
+}
+
+// WriteError reports that only some events of a batch were not delivered
+type WriteError struct {
+	Failed int
+	Err    error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("%d events not delivered: %v", e.Failed, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// Config sets how a Batcher queues and batches events
+type Config struct {
+	// Events waiting to be written; more are dropped
+	QueueSize int
+
+	// Events written at a time, at most
+	BatchSize int
+
+	// How long a partial batch waits for more events
+	FlushInterval time.Duration
+
+	// How long a write may take
+	WriteTimeout time.Duration
+}
+
+// Delivery results counted by worker_sink_events_total
+const (
+	ResultDelivered = "delivered"
+	ResultFailed    = "failed"
+	ResultDropped   = "dropped"
+)
+
+// writeBuckets are the bucket boundaries of worker_sink_write_duration_seconds
+var writeBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// queued is an event waiting in a Batcher, with the span it was published
+// in and when
+type queued struct {
+	event service.TrackingEvent
+	span  trace.SpanContext
+	at    time.Time
+}
+
+// Batcher is a service.Sink writing events through a Writer in the
+// background. Events are written BatchSize at a time, or every
+// FlushInterval, and a batch that fails is not retried; writers retry
+// where their system makes that safe. Each write is traced in a span
+// linked to the spans the batch's events were published in.
+type Batcher struct {
+	name   string
+	writer Writer
+	cfg    Config
+
+	queue chan queued
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	tracer    trace.Tracer
+	events    metric.Int64Counter
+	duration  metric.Float64Histogram
+	results   map[string]metric.AddOption
+	sinkAttrs metric.MeasurementOption
+}
+
+// New starts a Batcher writing to w, named name in its metrics and logs
+func New(name string, w Writer, cfg Config) *Batcher {
+	b := &Batcher{
+		name:      name,
+		writer:    w,
+		cfg:       cfg,
+		queue:     make(chan queued, cfg.QueueSize),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		results:   make(map[string]metric.AddOption),
+		sinkAttrs: metric.WithAttributeSet(attribute.NewSet(attribute.String("sink", name))),
+	}
+	for _, result := range []string{ResultDelivered, ResultFailed, ResultDropped} {
+		b.results[result] = metric.WithAttributeSet(attribute.NewSet(
+			attribute.String("sink", name),
+			attribute.String("result", result),
+		))
+	}
+
+	b.tracer = otel.Tracer("worker-sink")
+	meter := otel.Meter("worker-sink")
+	b.events, _ = meter.Int64Counter("worker_sink_events_total",
+		metric.WithDescription("Processed events handed to sinks, by sink and result"),
+	)
+	b.duration, _ = meter.Float64Histogram("worker_sink_write_duration_seconds",
+		metric.WithDescription("Time taken to write a batch of events to a sink"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(writeBuckets...),
+	)
+	queued, _ := meter.Int64ObservableGauge("worker_sink_queue_length",
+		metric.WithDescription("Events waiting to be written to a sink"),
+	)
+	_, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		o.ObserveInt64(queued, int64(len(b.queue)), b.sinkAttrs)
+		return nil
+	}, queued)
+
+	go b.run()
+	return b
+}
+
+// Publish queues event, dropping it when the queue is full. Either is
+// noted on the span in ctx.
+func (b *Batcher) Publish(ctx context.Context, event service.TrackingEvent) {
+	span := trace.SpanFromContext(ctx)
+	select {
+	case b.queue <- queued{event: event, span: span.SpanContext(), at: time.Now()}:
+		if span.IsRecording() {
+			span.AddEvent("sink.enqueued", trace.WithAttributes(
+				attribute.String("sink", b.name),
+				attribute.Int("sink.queue_length", len(b.queue)),
+			))
+		}
+	default:
+		span.AddEvent("sink.dropped", trace.WithAttributes(
+			attribute.String("sink", b.name),
+			attribute.String("sink.reason", "queue full"),
+		))
+		b.events.Add(ctx, 1, b.results[ResultDropped])
+		slog.DebugContext(ctx, "Dropped event, sink queue full", "sink", b.name)
+	}
+}
+
+// Name returns the sink's name
+func (b *Batcher) Name() string {
+	return b.name
+}
+
+// QueueLength returns the number of events waiting to be written
+func (b *Batcher) QueueLength() int {
+	return len(b.queue)
+}
+
+// Eraser returns the erasure of subjects from the sink's system, when it
+// can delete events. Events still queued are written after it runs.
+func (b *Batcher) Eraser() (service.Eraser, bool) {
+	e, ok := b.writer.(Eraser)
+	if !ok {
+		return nil, false
+	}
+	return e.Erase, true
+}
+
+// Close writes the queued events, waiting until ctx is done at most, and
+// closes the writer. Events published after Close are not written.
+func (b *Batcher) Close(ctx context.Context) error {
+	b.stopOnce.Do(func() { close(b.stop) })
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return fmt.Errorf("sink %s: %w", b.name, ctx.Err())
+	}
+	return b.writer.Close()
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]service.TrackingEvent, 0, b.cfg.BatchSize)
+	links := make([]trace.Link, 0, b.cfg.BatchSize)
+	flush := func() {
+		b.write(batch, links)
+		batch, links = batch[:0], links[:0]
+	}
+	add := func(q queued) {
+		batch = append(batch, q.event)
+		if q.span.IsValid() {
+			// The dequeue time is the write span's start
+			links = append(links, trace.Link{
+				SpanContext: q.span,
+				Attributes: []attribute.KeyValue{
+					attribute.String("sink.enqueued_at", q.at.UTC().Format(time.RFC3339Nano)),
+				},
+			})
+		}
+		if len(batch) >= b.cfg.BatchSize {
+			flush()
+		}
+	}
+
+	for {
+		select {
+		case q := <-b.queue:
+			add(q)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				flush()
+			}
+		case <-b.stop:
+			for drained := false; !drained; {
+				select {
+				case q := <-b.queue:
+					add(q)
+				default:
+					drained = true
+				}
+			}
+			if len(batch) > 0 {
+				flush()
+			}
+			return
+		}
+	}
+}
+
+// write delivers a batch, traced in a span linked to the spans its events
+// were published in, and counts the outcome
+func (b *Batcher) write(batch []service.TrackingEvent, links []trace.Link) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.WriteTimeout)
+	defer cancel()
+
+	ctx, span := b.tracer.Start(ctx, "sink.write",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("sink", b.name),
+			attribute.Int("sink.batch_size", len(batch)),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := b.writer.Write(ctx, batch)
+	b.duration.Record(ctx, time.Since(start).Seconds(), b.sinkAttrs)
+
+	failed := 0
+	if err != nil {
+		failed = len(batch)
+		var werr *WriteError
+		if errors.As(err, &werr) {
+			failed = min(werr.Failed, len(batch))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "write failed")
+		span.SetAttributes(attribute.Int("sink.failed", failed))
+		slog.ErrorContext(ctx, "Failed to write events to sink", "sink", b.name, "events", len(batch), "failed", failed, "error", err)
+	}
+	if delivered := len(batch) - failed; delivered > 0 {
+		b.events.Add(ctx, int64(delivered), b.results[ResultDelivered])
+	}
+	if failed > 0 {
+		b.events.Add(ctx, int64(failed), b.results[ResultFailed])
+	}
+}
@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// templateFields are the placeholders destination templates accept
+var templateFields = map[string]func(service.TrackingEvent) string{
+	"tenant":     func(e service.TrackingEvent) string { return e.Tenant },
+	"event_type": func(e service.TrackingEvent) string { return e.EventType },
+	"device":     func(e service.TrackingEvent) string { return e.Device },
+	"country":    func(e service.TrackingEvent) string { return e.Country },
+}
+
+// eventTemplate names a destination, such as a NATS subject or a Redis
+// channel, from an event's values
+type eventTemplate struct {
+	parts []templatePart
+
+	// clean makes a value safe to place in the destination
+	clean func(string) string
+}
+
+// templatePart is literal text, or a field when field is set
+type templatePart struct {
+	literal string
+	field   func(service.TrackingEvent) string
+}
+
+// parseTemplate splits a template into literals and fields
+func parseTemplate(tmpl string, clean func(string) string) (eventTemplate, error) {
+	t := eventTemplate{clean: clean}
+	for rest := tmpl; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return t, fmt.Errorf("%q has an unclosed placeholder", tmpl)
+		}
+		name := rest[open+1 : open+end]
+		field, ok := templateFields[name]
+		if !ok {
+			return t, fmt.Errorf("%q has unknown placeholder {%s}", tmpl, name)
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		t.parts = append(t.parts, templatePart{field: field})
+		rest = rest[open+end+1:]
+	}
+	if len(t.parts) == 0 {
+		return t, fmt.Errorf("template is empty")
+	}
+	return t, nil
+}
+
+// fill fills in the template for event. Empty values are "_".
+func (t eventTemplate) fill(event service.TrackingEvent) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == nil {
+			b.WriteString(p.literal)
+			continue
+		}
+		v := p.field(event)
+		if v == "" {
+			v = "_"
+		}
+		b.WriteString(t.clean(v))
+	}
+	return b.String()
+}
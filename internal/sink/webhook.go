@@ -0,0 +1,238 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/niquet/rate-limited-worker/internal/middleware"
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WebhookIDHeader carries an ID that stays the same across retries of a
+// batch, so receivers can drop ones they already handled
+const WebhookIDHeader = "X-Webhook-ID"
+
+// Webhook delivery results counted by worker_webhook_deliveries_total
+const (
+	WebhookDelivered   = "delivered"
+	WebhookRetried     = "retried"
+	WebhookFailed      = "failed"
+	WebhookCircuitOpen = "circuit_open"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookConfig configures the webhook writer
+type WebhookConfig struct {
+	URLs []string
+
+	// Signs bodies as the worker's own signature middleware checks them,
+	// when set
+	Secret []byte
+
+	// Attempts per batch and endpoint, including the first
+	MaxAttempts int
+
+	// An endpoint failing BreakerThreshold batches in a row is skipped for
+	// BreakerCooldown, then tried with one batch
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// Webhook POSTs each batch as {"events": [...]} to every endpoint. Network
+// errors, 429 and 5xx responses are retried with exponential backoff
+// until MaxAttempts or the write times out; other responses are final. A
+// batch counts as failed unless every endpoint accepted it.
+type Webhook struct {
+	cfg       WebhookConfig
+	endpoints []*webhookEndpoint
+
+	deliveries metric.Int64Counter
+}
+
+// webhookEndpoint is a URL and its circuit breaker
+type webhookEndpoint struct {
+	url     string
+	host    string
+	results map[string]metric.AddOption
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewWebhook checks the endpoint URLs; nothing is sent until the first write
+func NewWebhook(cfg WebhookConfig) (*Webhook, error) {
+	w := &Webhook{cfg: cfg}
+	for _, raw := range cfg.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", raw)
+		}
+		e := &webhookEndpoint{url: raw, host: u.Host, results: make(map[string]metric.AddOption)}
+		// The host alone, as paths and queries may carry tokens
+		for _, result := range []string{WebhookDelivered, WebhookRetried, WebhookFailed, WebhookCircuitOpen} {
+			e.results[result] = metric.WithAttributeSet(attribute.NewSet(
+				attribute.String("endpoint", u.Host),
+				attribute.String("result", result),
+			))
+		}
+		w.endpoints = append(w.endpoints, e)
+	}
+
+	w.deliveries, _ = otel.Meter("worker-sink").Int64Counter("worker_webhook_deliveries_total",
+		metric.WithDescription("Webhook batch delivery attempts, by endpoint host and result"),
+	)
+	return w, nil
+}
+
+func (w *Webhook) Write(ctx context.Context, events []service.TrackingEvent) error {
+	body, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	_, _ = crand.Read(id)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(w.endpoints))
+	for i, e := range w.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.deliver(ctx, e, hex.EncodeToString(id), body); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", e.host, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// deliver sends body to one endpoint, retrying as Webhook describes
+func (w *Webhook) deliver(ctx context.Context, e *webhookEndpoint, id string, body []byte) error {
+	if !e.allow(time.Now(), w.cfg.BreakerCooldown) {
+		w.deliveries.Add(ctx, 1, e.results[WebhookCircuitOpen])
+		return errors.New("circuit open")
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, e.url, id, body)
+		if err == nil {
+			w.deliveries.Add(ctx, 1, e.results[WebhookDelivered])
+			e.record(true, time.Now(), w.cfg.BreakerThreshold)
+			return nil
+		}
+		if !retry || attempt >= w.cfg.MaxAttempts {
+			w.deliveries.Add(ctx, 1, e.results[WebhookFailed])
+			e.record(false, time.Now(), w.cfg.BreakerThreshold)
+			return err
+		}
+
+		w.deliveries.Add(ctx, 1, e.results[WebhookRetried])
+		slog.DebugContext(ctx, "Retrying webhook", "endpoint", e.host, "attempt", attempt, "error", err)
+		// Jitter keeps endpoints that failed together from retrying together
+		wait := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-ctx.Done():
+			w.deliveries.Add(ctx, 1, e.results[WebhookFailed])
+			e.record(false, time.Now(), w.cfg.BreakerThreshold)
+			return fmt.Errorf("%w, last attempt: %w", ctx.Err(), err)
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt, reporting whether a failure is worth retrying
+func (w *Webhook) post(ctx context.Context, endpoint, id string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, id)
+	if len(w.cfg.Secret) > 0 {
+		ts := time.Now().Unix()
+		req.Header.Set(middleware.SignatureHeader, middleware.SignPayload(w.cfg.Secret, ts, body))
+		req.Header.Set(middleware.SignatureTimestampHeader, strconv.FormatInt(ts, 10))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// allow reports whether a batch may be sent. Once the cool-down after the
+// circuit opened has passed, one batch at a time probes the endpoint.
+func (e *webhookEndpoint) allow(now time.Time, cooldown time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.openedAt.IsZero() {
+		return true
+	}
+	if e.probing || now.Sub(e.openedAt) < cooldown {
+		return false
+	}
+	e.probing = true
+	return true
+}
+
+// record counts a delivery's outcome, closing the circuit on success and
+// opening it after threshold failures in a row or a failed probe
+func (e *webhookEndpoint) record(ok bool, now time.Time, threshold int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	wasOpen := !e.openedAt.IsZero()
+	e.probing = false
+	if ok {
+		if wasOpen {
+			slog.Info("Webhook circuit closed", "endpoint", e.host)
+		}
+		e.failures, e.openedAt = 0, time.Time{}
+		return
+	}
+
+	e.failures++
+	if wasOpen || e.failures >= threshold {
+		if !wasOpen {
+			slog.Warn("Webhook circuit opened", "endpoint", e.host, "failures", e.failures)
+		}
+		e.openedAt = now
+	}
+}
+
+func (w *Webhook) Close() error {
+	webhookClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"errors"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+	trackingv1 "github.com/niquet/rate-limited-worker/proto/tracking/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements trackingv1.EventServiceServer over a Hub
+type Server struct {
+	trackingv1.UnimplementedEventServiceServer
+	hub *Hub
+}
+
+// NewServer serves the events published to hub
+func NewServer(hub *Hub) *Server {
+	return &Server{hub: hub}
+}
+
+// Subscribe streams the hub's events matching the request until the
+// client goes away, falls behind or the hub closes. A subscriber whose
+// context names a tenant only receives that tenant's events, whatever the
+// request asks for.
+func (s *Server) Subscribe(req *trackingv1.SubscribeRequest, stream grpc.ServerStreamingServer[trackingv1.SubscribeResponse]) error {
+	tenant := req.Tenant
+	if scoped := service.TenantFromContext(stream.Context()); scoped != "" {
+		if tenant != "" && tenant != scoped {
+			return status.Error(codes.PermissionDenied, "tenant not accessible")
+		}
+		tenant = scoped
+	}
+
+	sub, err := s.hub.Subscribe(Filter{
+		EventTypes:  req.EventTypes,
+		Tenant:      tenant,
+		SessionID:   req.SessionId,
+		IncludeBots: req.IncludeBots,
+	})
+	if errors.Is(err, ErrTooManySubscribers) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer sub.Close()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case event, ok := <-sub.Events():
+			if !ok {
+				if sub.Evicted() {
+					return status.Error(codes.ResourceExhausted, "subscriber fell behind and was evicted")
+				}
+				return status.Error(codes.Unavailable, "event feed closed")
+			}
+			if err := stream.Send(&trackingv1.SubscribeResponse{Event: toProto(event)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// toProto maps a processed event onto its message. Custom values are
+// strings, numbers or booleans, which structpb takes.
+func toProto(event service.TrackingEvent) *trackingv1.ProcessedEvent {
+	msg := &trackingv1.TrackingEvent{
+		SchemaVersion: int32(event.SchemaVersion),
+		EventType:     event.EventType,
+		CursorX:       int32(event.CursorX),
+		CursorY:       int32(event.CursorY),
+		ElementId:     event.ElementID,
+		ElementType:   event.ElementType,
+		PageUrl:       event.PageURL,
+		UserAgent:     event.UserAgent,
+		SessionId:     event.SessionID,
+		ViewportX:     int32(event.ViewportX),
+		ViewportY:     int32(event.ViewportY),
+		ScrollX:       int32(event.ScrollX),
+		ScrollY:       int32(event.ScrollY),
+		PageHeight:    int32(event.PageHeight),
+		ElementText:   event.ElementText,
+		Consent:       event.Consent,
+	}
+	if !event.Timestamp.IsZero() {
+		msg.Timestamp = timestamppb.New(event.Timestamp)
+	}
+	if len(event.Custom) > 0 {
+		msg.Custom, _ = structpb.NewStruct(event.Custom)
+	}
+
+	return &trackingv1.ProcessedEvent{
+		Event:          msg,
+		Tenant:         event.Tenant,
+		ClientIp:       event.ClientIP,
+		Browser:        event.Browser,
+		Os:             event.OS,
+		Device:         event.Device,
+		Country:        event.Country,
+		Region:         event.Region,
+		City:           event.City,
+		DoNotTrack:     event.DoNotTrack,
+		Bot:            event.Bot,
+		BotReason:      event.BotReason,
+		Experiments:    event.Experiments,
+		CursorXPercent: event.CursorXPercent,
+		CursorYPercent: event.CursorYPercent,
+	}
+}
@@ -0,0 +1,188 @@
+// Package stream fans processed events out to live subscribers. A Hub is
+// a service.Sink handing each event to the subscribers whose filter it
+// matches, through a buffer per subscriber; one whose buffer fills is
+// evicted rather than slowing the others or the request path.
+package stream
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ErrTooManySubscribers is returned by Subscribe when the hub has its
+// maximum of subscribers
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// Config sets how many subscribers a Hub takes and how far each may fall
+// behind
+type Config struct {
+	// Events buffered per subscriber; a subscriber with a full buffer is
+	// evicted
+	BufferSize int
+
+	// Subscribers at once, at most
+	MaxSubscribers int
+}
+
+// Filter selects the events a subscriber receives. Zero fields match
+// every event, except that events the bot filter tagged are only received
+// with IncludeBots.
+type Filter struct {
+	EventTypes  []string
+	Tenant      string
+	SessionID   string
+	IncludeBots bool
+}
+
+func (f Filter) match(event service.TrackingEvent) bool {
+	switch {
+	case len(f.EventTypes) > 0 && !slices.Contains(f.EventTypes, event.EventType):
+		return false
+	case f.Tenant != "" && event.Tenant != f.Tenant:
+		return false
+	case f.SessionID != "" && event.SessionID != f.SessionID:
+		return false
+	case event.Bot && !f.IncludeBots:
+		return false
+	}
+	return true
+}
+
+// Hub delivers published events to its subscribers
+type Hub struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	evictions metric.Int64Counter
+}
+
+// NewHub returns a Hub without subscribers
+func NewHub(cfg Config) *Hub {
+	h := &Hub{cfg: cfg, subs: make(map[*Subscription]struct{})}
+
+	meter := otel.Meter("worker-stream")
+	h.evictions, _ = meter.Int64Counter("worker_stream_evictions_total",
+		metric.WithDescription("Event feed subscribers evicted for falling behind"),
+	)
+	subscribers, _ := meter.Int64ObservableGauge("worker_stream_subscribers",
+		metric.WithDescription("Event feed subscribers connected"),
+	)
+	_, _ = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		o.ObserveInt64(subscribers, int64(len(h.subs)))
+		return nil
+	}, subscribers)
+	return h
+}
+
+// Subscription receives the events matching its filter until it is
+// closed, evicted or the hub closes
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	events chan service.TrackingEvent
+
+	// Set before events is closed
+	evicted bool
+}
+
+// Subscribe adds a subscriber receiving the events published from now on
+// that match filter
+func (h *Hub) Subscribe(filter Filter) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errors.New("event feed closed")
+	}
+	if len(h.subs) >= h.cfg.MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	sub := &Subscription{
+		hub:    h,
+		filter: filter,
+		events: make(chan service.TrackingEvent, h.cfg.BufferSize),
+	}
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Publish hands event to every matching subscriber without blocking,
+// evicting those whose buffer is full
+func (h *Hub) Publish(ctx context.Context, event service.TrackingEvent) {
+	var slow []*Subscription
+
+	h.mu.RLock()
+	for sub := range h.subs {
+		if !sub.filter.match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, sub := range slow {
+		if h.remove(sub, true) {
+			h.evictions.Add(ctx, 1)
+		}
+	}
+}
+
+// remove ends sub, reporting whether it was still subscribed
+func (h *Hub) remove(sub *Subscription, evicted bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; !ok {
+		return false
+	}
+	delete(h.subs, sub)
+	sub.evicted = evicted
+	close(sub.events)
+	return true
+}
+
+// Close ends every subscription; later ones fail
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// Events returns the subscription's events, closed when it ends
+func (s *Subscription) Events() <-chan service.TrackingEvent {
+	return s.events
+}
+
+// Evicted reports whether the subscription ended because its buffer
+// filled. It is only meaningful once Events is closed.
+func (s *Subscription) Evicted() bool {
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	return s.evicted
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.hub.remove(s, false)
+}
@@ -0,0 +1,50 @@
+package telemetry
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// BaggageSessionID is the baggage key carrying the tracking session ID
+const BaggageSessionID = "session.id"
+
+// WithSessionID returns ctx carrying id as baggage. Spans started from it
+// get a session.id attribute, and outgoing requests propagate it, so one
+// session's processing can be followed past the request that received it.
+func WithSessionID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	member, err := baggage.NewMemberRaw(BaggageSessionID, id)
+	if err != nil {
+		return ctx
+	}
+	b, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, b)
+}
+
+// SessionID returns the session ID carried as baggage in ctx, if any
+func SessionID(ctx context.Context) string {
+	return baggage.FromContext(ctx).Member(BaggageSessionID).Value()
+}
+
+// baggageSpanProcessor copies the session ID from the baggage of the
+// context a span starts in onto the span. Only that key is copied, since
+// incoming baggage is client controlled.
+type baggageSpanProcessor struct{}
+
+func (baggageSpanProcessor) OnStart(ctx context.Context, s trace.ReadWriteSpan) {
+	if id := SessionID(ctx); id != "" {
+		s.SetAttributes(attribute.String(BaggageSessionID, id))
+	}
+}
+
+func (baggageSpanProcessor) OnEnd(trace.ReadOnlySpan)         {}
+func (baggageSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (baggageSpanProcessor) ForceFlush(context.Context) error { return nil }
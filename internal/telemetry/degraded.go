@@ -0,0 +1,60 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// Start is SetupOTelSDK for a server that must not depend on telemetry.
+// When setup fails the global no-op providers stay in place. Setup only
+// fails on configuration the exporters reject, as they connect lazily, so
+// it is not retried; a collector that is down is retried by the exporters
+// on each export. Export errors are logged at most once per errorInterval.
+func Start(serviceName, serviceVersion, otelEndpoint string, errorInterval time.Duration, opts ...Option) (shutdown func(context.Context) error) {
+	otel.SetErrorHandler(newErrorLogger(errorInterval))
+
+	stop, err := SetupOTelSDK(context.Background(), serviceName, serviceVersion, otelEndpoint, opts...)
+	if err != nil {
+		slog.Warn("Telemetry unavailable, serving without it", "error", err)
+		return func(context.Context) error { return nil }
+	}
+	return stop
+}
+
+// errorLogger reports errors from the SDK, such as failed exports, without
+// logging every one while a collector is down
+type errorLogger struct {
+	interval time.Duration
+
+	mu         sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+func newErrorLogger(interval time.Duration) *errorLogger {
+	return &errorLogger{interval: interval}
+}
+
+func (l *errorLogger) Handle(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.last) < l.interval {
+		l.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := l.suppressed
+	l.last, l.suppressed = now, 0
+	l.mu.Unlock()
+
+	slog.Warn("Telemetry export failed", "error", err, "suppressed", suppressed)
+}
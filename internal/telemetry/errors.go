@@ -0,0 +1,102 @@
+package telemetry
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrorEntry is one record logged at ERROR or above
+type ErrorEntry struct {
+	Time    time.Time
+	Message string
+	Attrs   string
+	TraceID string
+}
+
+// RecentErrors keeps the last records logged at ERROR or above so they can
+// be shown without a log backend. Install Handler alongside the usual
+// log handler.
+type RecentErrors struct {
+	mu      sync.Mutex
+	entries []ErrorEntry
+	next    int
+	full    bool
+}
+
+func NewRecentErrors(n int) *RecentErrors {
+	return &RecentErrors{entries: make([]ErrorEntry, n)}
+}
+
+// Entries returns the kept records, newest first
+func (e *RecentErrors) Entries() []ErrorEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	count := e.next
+	if e.full {
+		count = len(e.entries)
+	}
+	out := make([]ErrorEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		out = append(out, e.entries[(e.next-i+len(e.entries))%len(e.entries)])
+	}
+	return out
+}
+
+func (e *RecentErrors) add(entry ErrorEntry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.entries[e.next] = entry
+	e.next = (e.next + 1) % len(e.entries)
+	if e.next == 0 {
+		e.full = true
+	}
+}
+
+// Handler returns a slog handler that records into e
+func (e *RecentErrors) Handler() slog.Handler {
+	return &errorsHandler{errors: e}
+}
+
+type errorsHandler struct {
+	errors *RecentErrors
+	attrs  []string
+	group  string
+}
+
+func (h *errorsHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= slog.LevelError
+}
+
+func (h *errorsHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := append([]string(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, h.group+a.Key+"="+a.Value.String())
+		return true
+	})
+
+	entry := ErrorEntry{Time: r.Time, Message: r.Message, Attrs: strings.Join(attrs, " ")}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry.TraceID = sc.TraceID().String()
+	}
+	h.errors.add(entry)
+	return nil
+}
+
+func (h *errorsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &errorsHandler{errors: h.errors, attrs: append([]string(nil), h.attrs...), group: h.group}
+	for _, a := range attrs {
+		next.attrs = append(next.attrs, h.group+a.Key+"="+a.Value.String())
+	}
+	return next
+}
+
+func (h *errorsHandler) WithGroup(name string) slog.Handler {
+	return &errorsHandler{errors: h.errors, attrs: h.attrs, group: h.group + name + "."}
+}
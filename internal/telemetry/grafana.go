@@ -0,0 +1,403 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+// instrumentInfo describes an instrument the meter provider created
+type instrumentInfo struct {
+	Scope       string
+	Name        string
+	Description string
+	Unit        string
+	Kind        metric.InstrumentKind
+}
+
+// instruments records every instrument as the meter provider creates it,
+// so the Grafana dashboard lists what the code actually registers
+var instruments = struct {
+	sync.Mutex
+	byName map[string]instrumentInfo
+}{byName: map[string]instrumentInfo{}}
+
+// recordInstruments is a view that matches nothing, called by the SDK for
+// each new instrument
+func recordInstruments(inst metric.Instrument) (metric.Stream, bool) {
+	instruments.Lock()
+	defer instruments.Unlock()
+	instruments.byName[inst.Name] = instrumentInfo{
+		Scope:       inst.Scope.Name,
+		Name:        inst.Name,
+		Description: inst.Description,
+		Unit:        inst.Unit,
+		Kind:        inst.Kind,
+	}
+	return metric.Stream{}, false
+}
+
+// registeredInstruments returns the recorded instruments by scope and name
+func registeredInstruments() []instrumentInfo {
+	instruments.Lock()
+	list := make([]instrumentInfo, 0, len(instruments.byName))
+	for _, info := range instruments.byName {
+		list = append(list, info)
+	}
+	instruments.Unlock()
+
+	slices.SortFunc(list, func(a, b instrumentInfo) int {
+		if c := strings.Compare(a.Scope, b.Scope); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return list
+}
+
+// isCounter reports whether an instrument is exported as a Prometheus counter
+func (i instrumentInfo) isCounter() bool {
+	return i.Kind == metric.InstrumentKindCounter || i.Kind == metric.InstrumentKindObservableCounter
+}
+
+func (i instrumentInfo) isHistogram() bool {
+	return i.Kind == metric.InstrumentKindHistogram
+}
+
+// promNames caches the names promName found, by instrument name
+var promNames sync.Map
+
+// promName is the name the Prometheus exporter gives the instrument, with
+// its unit and counter suffixes, as scraped in the classic format. It is
+// taken from an exporter of its own that the instrument is recorded into
+// once, so it follows whatever naming rules the exporter applies.
+func (i instrumentInfo) promName() (string, error) {
+	if name, ok := promNames.Load(i.Name); ok {
+		return name.(string), nil
+	}
+
+	registry := prometheus.NewRegistry()
+	exporter, err := newPromExporter(registry)
+	if err != nil {
+		return "", err
+	}
+	provider := metric.NewMeterProvider(metric.WithReader(exporter))
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	meter := provider.Meter(i.Scope)
+	desc, unit := otelmetric.WithDescription(i.Description), otelmetric.WithUnit(i.Unit)
+	observe := func(_ context.Context, o otelmetric.Float64Observer) error {
+		o.Observe(0)
+		return nil
+	}
+	switch i.Kind {
+	case metric.InstrumentKindCounter:
+		c, err := meter.Float64Counter(i.Name, desc, unit)
+		if err != nil {
+			return "", err
+		}
+		c.Add(context.Background(), 0)
+	case metric.InstrumentKindUpDownCounter:
+		c, err := meter.Float64UpDownCounter(i.Name, desc, unit)
+		if err != nil {
+			return "", err
+		}
+		c.Add(context.Background(), 0)
+	case metric.InstrumentKindHistogram:
+		h, err := meter.Float64Histogram(i.Name, desc, unit)
+		if err != nil {
+			return "", err
+		}
+		h.Record(context.Background(), 0)
+	case metric.InstrumentKindGauge:
+		g, err := meter.Float64Gauge(i.Name, desc, unit)
+		if err != nil {
+			return "", err
+		}
+		g.Record(context.Background(), 0)
+	case metric.InstrumentKindObservableCounter:
+		_, err = meter.Float64ObservableCounter(i.Name, desc, unit, otelmetric.WithFloat64Callback(observe))
+	case metric.InstrumentKindObservableUpDownCounter:
+		_, err = meter.Float64ObservableUpDownCounter(i.Name, desc, unit, otelmetric.WithFloat64Callback(observe))
+	default:
+		_, err = meter.Float64ObservableGauge(i.Name, desc, unit, otelmetric.WithFloat64Callback(observe))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		return "", err
+	}
+	for _, family := range families {
+		switch family.GetName() {
+		case "target_info", "otel_scope_info":
+		default:
+			// Classic scrapes escape what the exporter leaves in UTF-8
+			name := model.EscapeName(family.GetName(), model.UnderscoreEscaping)
+			promNames.Store(i.Name, name)
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("Prometheus exporter did not export %s", i.Name)
+}
+
+// grafanaUnit is the Grafana unit of the instrument's values, or of their
+// rate per second for counters. Instruments without a unit may still name
+// one, as worker_http_request_duration_seconds does.
+func (i instrumentInfo) grafanaUnit() string {
+	unit := i.Unit
+	if unit == "" && strings.HasSuffix(i.Name, "_seconds") {
+		unit = "s"
+	}
+	switch unit {
+	case "s":
+		if i.isCounter() {
+			// Seconds per second, the share of time spent
+			return "percentunit"
+		}
+		return "s"
+	case "ms":
+		return "ms"
+	case "By":
+		if i.isCounter() {
+			return "Bps"
+		}
+		return "bytes"
+	case "%":
+		return "percent"
+	case "1":
+		return "percentunit"
+	}
+	if i.isCounter() {
+		return "ops"
+	}
+	return "short"
+}
+
+// Dashboard panels are laid out two to a row
+const (
+	panelWidth  = 12
+	panelHeight = 8
+)
+
+type grafanaDashboard struct {
+	UID           string           `json:"uid"`
+	Title         string           `json:"title"`
+	Tags          []string         `json:"tags"`
+	Timezone      string           `json:"timezone"`
+	SchemaVersion int              `json:"schemaVersion"`
+	Refresh       string           `json:"refresh"`
+	Time          grafanaTimeRange `json:"time"`
+	Templating    struct {
+		List []map[string]interface{} `json:"list"`
+	} `json:"templating"`
+	Panels []grafanaPanel `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaPanel struct {
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	GridPos     grafanaGridPos    `json:"gridPos"`
+	Datasource  *grafanaRef       `json:"datasource,omitempty"`
+	FieldConfig *grafanaFieldConf `json:"fieldConfig,omitempty"`
+	Targets     []grafanaTarget   `json:"targets,omitempty"`
+	Collapsed   *bool             `json:"collapsed,omitempty"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaFieldConf struct {
+	Defaults struct {
+		Unit string `json:"unit"`
+	} `json:"defaults"`
+	Overrides []interface{} `json:"overrides"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+}
+
+// promDatasource refers to the data source picked in the dashboard
+var promDatasource = &grafanaRef{Type: "prometheus", UID: "${datasource}"}
+
+// grafanaDashboardFor builds a dashboard with a panel per instrument,
+// grouped in rows by meter. Series are selected by the Prometheus job of
+// the service picked from target_info, serviceName by default.
+func grafanaDashboardFor(serviceName string, list []instrumentInfo) (grafanaDashboard, error) {
+	d := grafanaDashboard{
+		UID:           serviceName,
+		Title:         serviceName,
+		Tags:          []string{serviceName, "generated"},
+		Timezone:      "browser",
+		SchemaVersion: 39,
+		Refresh:       "30s",
+		Time:          grafanaTimeRange{From: "now-1h", To: "now"},
+	}
+	d.Templating.List = []map[string]interface{}{
+		{
+			"name":  "datasource",
+			"label": "Data source",
+			"type":  "datasource",
+			"query": "prometheus",
+		},
+		{
+			"name":       "service",
+			"label":      "Service",
+			"type":       "query",
+			"datasource": promDatasource,
+			"definition": "label_values(target_info, service_name)",
+			"query":      map[string]string{"query": "label_values(target_info, service_name)", "refId": "service"},
+			"refresh":    1,
+			"current":    map[string]string{"text": serviceName, "value": serviceName},
+		},
+		{
+			"name":       "job",
+			"type":       "query",
+			"hide":       2,
+			"datasource": promDatasource,
+			"definition": `label_values(target_info{service_name="$service"}, job)`,
+			"query":      map[string]string{"query": `label_values(target_info{service_name="$service"}, job)`, "refId": "job"},
+			"refresh":    1,
+			"multi":      true,
+			"includeAll": true,
+			"current":    map[string]string{"text": "All", "value": "$__all"},
+		},
+	}
+
+	var id, x, y int
+	var scope string
+	for _, inst := range list {
+		if inst.Scope != scope || len(d.Panels) == 0 {
+			scope = inst.Scope
+			if x != 0 {
+				y += panelHeight
+			}
+			id++
+			collapsed := false
+			d.Panels = append(d.Panels, grafanaPanel{
+				ID:        id,
+				Type:      "row",
+				Title:     scope,
+				GridPos:   grafanaGridPos{H: 1, W: 24, Y: y},
+				Collapsed: &collapsed,
+			})
+			y++
+			x = 0
+		}
+
+		targets, err := grafanaTargets(inst)
+		if err != nil {
+			return grafanaDashboard{}, fmt.Errorf("instrument %s: %w", inst.Name, err)
+		}
+		id++
+		panel := grafanaPanel{
+			ID:          id,
+			Type:        "timeseries",
+			Title:       inst.Name,
+			Description: inst.Description,
+			GridPos:     grafanaGridPos{H: panelHeight, W: panelWidth, X: x, Y: y},
+			Datasource:  promDatasource,
+			FieldConfig: &grafanaFieldConf{Overrides: []interface{}{}},
+			Targets:     targets,
+		}
+		panel.FieldConfig.Defaults.Unit = inst.grafanaUnit()
+		d.Panels = append(d.Panels, panel)
+
+		if x += panelWidth; x == 2*panelWidth {
+			x = 0
+			y += panelHeight
+		}
+	}
+	return d, nil
+}
+
+// grafanaTargets queries counters as per second rates, histograms as
+// quantiles and everything else as is
+func grafanaTargets(inst instrumentInfo) ([]grafanaTarget, error) {
+	name, err := inst.promName()
+	if err != nil {
+		return nil, err
+	}
+	selector := name + `{job=~"$job"}`
+	switch {
+	case inst.isCounter():
+		return []grafanaTarget{{
+			RefID:        "A",
+			Expr:         fmt.Sprintf("sum without (instance) (rate(%s[$__rate_interval]))", selector),
+			LegendFormat: "__auto",
+		}}, nil
+	case inst.isHistogram():
+		var targets []grafanaTarget
+		for i, q := range []struct{ quantile, legend string }{{"0.5", "p50"}, {"0.95", "p95"}, {"0.99", "p99"}} {
+			targets = append(targets, grafanaTarget{
+				RefID:        string(rune('A' + i)),
+				Expr:         fmt.Sprintf(`histogram_quantile(%s, sum by (le) (rate(%s_bucket{job=~"$job"}[$__rate_interval])))`, q.quantile, name),
+				LegendFormat: q.legend,
+			})
+		}
+		return targets, nil
+	default:
+		return []grafanaTarget{{RefID: "A", Expr: selector, LegendFormat: "__auto"}}, nil
+	}
+}
+
+// GrafanaDashboardHandler serves a Grafana dashboard with a panel for each
+// instrument registered, querying the names the Prometheus exporter gives
+// them. Instruments are registered as their components are built, used or
+// not, so the dashboard covers every one the configured components have.
+func GrafanaDashboardHandler(serviceName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dashboard, err := grafanaDashboardFor(serviceName, registeredInstruments())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to build Grafana dashboard", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		body, err := json.MarshalIndent(dashboard, "", "  ")
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to encode Grafana dashboard", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(body); err != nil {
+			slog.DebugContext(r.Context(), "Failed to write Grafana dashboard", "error", err)
+		}
+	})
+}
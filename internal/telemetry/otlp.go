@@ -0,0 +1,346 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// OTLP transport protocols
+const (
+	ProtocolGRPC         = "grpc"
+	ProtocolHTTPProtobuf = "http/protobuf"
+)
+
+// OTLPConfig configures how traces, metrics and logs reach the collector.
+// Without WithOTLP, SetupOTelSDK uses plaintext gRPC with retries.
+type OTLPConfig struct {
+	Protocol string
+
+	// Insecure disables TLS. It is ignored when the endpoint is a URL,
+	// whose scheme decides instead; OTLP/HTTP URLs are base URLs that get
+	// /v1/traces, /v1/metrics and /v1/logs appended.
+	Insecure bool
+
+	// PEM files for a custom CA and for client certificate authentication
+	CAFile         string
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// Headers are sent with every export, e.g. a vendor API key
+	Headers map[string]string
+
+	// Compression is "gzip" or empty for none
+	Compression string
+
+	// Timeout bounds each export including retries; zero keeps the default
+	Timeout time.Duration
+
+	// Retry controls retrying failed exports with exponential backoff for
+	// at most RetryMaxElapsed
+	Retry           bool
+	RetryMaxElapsed time.Duration
+}
+
+// WithOTLP configures the OTLP exporters for traces, metrics and logs
+func WithOTLP(cfg OTLPConfig) Option {
+	return func(o *options) {
+		o.otlp = cfg
+	}
+}
+
+// ParseHeaders parses OTEL_EXPORTER_OTLP_HEADERS style "key=value" pairs
+// separated by commas, with percent-encoded values as the spec defines
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for i, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			// Entries are numbered rather than quoted since values are secret
+			return nil, fmt.Errorf("invalid header entry %d, expected key=value", i+1)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for header %q: %w", key, err)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
+}
+
+func (c OTLPConfig) tlsConfig() (*tls.Config, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read OTLP CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("OTLP CA file contains no certificates")
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if c.ClientCertFile != "" || c.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load OTLP client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// isURL reports whether the endpoint carries its own scheme and path
+func isURL(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://")
+}
+
+// useTLS decides transport security: a URL endpoint's scheme wins over
+// the Insecure setting
+func (c OTLPConfig) useTLS(endpoint string) bool {
+	if isURL(endpoint) {
+		return strings.HasPrefix(endpoint, "https://")
+	}
+	return !c.Insecure
+}
+
+// signalURL appends the per-signal path to a base URL endpoint, as
+// OTLP/HTTP does for OTEL_EXPORTER_OTLP_ENDPOINT
+func signalURL(endpoint, signal string) string {
+	return strings.TrimRight(endpoint, "/") + "/v1/" + signal
+}
+
+// Backoff between retried exports, growing up to retryMaxInterval
+const (
+	retryInitialInterval = 5 * time.Second
+	retryMaxInterval     = 30 * time.Second
+)
+
+// exporter is how one OTLP exporter package is configured and created, so
+// that every signal and protocol is built from OTLPConfig the same way
+type exporter[O, E any] struct {
+	new         func(context.Context, ...O) (E, error)
+	headers     func(map[string]string) O
+	retry       func(enabled bool, maxElapsed time.Duration) O
+	endpoint    func(string) O
+	endpointURL func(string) O
+	tls         func(*tls.Config) O
+	insecure    func() O
+	gzip        func() O
+	timeout     func(time.Duration) O
+}
+
+// build creates the exporter for endpoint, a full URL for OTLP/HTTP
+func (e exporter[O, E]) build(ctx context.Context, endpoint string, cfg OTLPConfig, tlsCfg *tls.Config) (E, error) {
+	opts := []O{
+		e.headers(cfg.Headers),
+		e.retry(cfg.Retry, cfg.RetryMaxElapsed),
+	}
+	if isURL(endpoint) {
+		opts = append(opts, e.endpointURL(endpoint))
+	} else {
+		opts = append(opts, e.endpoint(endpoint))
+	}
+	if cfg.useTLS(endpoint) {
+		opts = append(opts, e.tls(tlsCfg))
+	} else {
+		opts = append(opts, e.insecure())
+	}
+	if cfg.Compression == "gzip" {
+		opts = append(opts, e.gzip())
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, e.timeout(cfg.Timeout))
+	}
+	return e.new(ctx, opts...)
+}
+
+// newExporter creates a signal's exporter over cfg's protocol, from the
+// gRPC or the HTTP package exporting it
+func newExporter[G, H, E any](ctx context.Context, endpoint, signal string, cfg OTLPConfig, grpc exporter[G, E], http exporter[H, E]) (E, error) {
+	var zero E
+	tlsCfg, err := cfg.tlsConfig()
+	if err != nil {
+		return zero, err
+	}
+
+	switch cfg.Protocol {
+	case ProtocolGRPC, "":
+		return grpc.build(ctx, endpoint, cfg, tlsCfg)
+	case ProtocolHTTPProtobuf:
+		if isURL(endpoint) {
+			endpoint = signalURL(endpoint, signal)
+		}
+		return http.build(ctx, endpoint, cfg, tlsCfg)
+	default:
+		return zero, fmt.Errorf("unsupported OTLP protocol %q", cfg.Protocol)
+	}
+}
+
+func newTraceExporter(ctx context.Context, endpoint string, cfg OTLPConfig) (trace.SpanExporter, error) {
+	return newExporter(ctx, endpoint, "traces", cfg, traceGRPC, traceHTTP)
+}
+
+func newMetricExporter(ctx context.Context, endpoint string, cfg OTLPConfig) (metric.Exporter, error) {
+	return newExporter(ctx, endpoint, "metrics", cfg, metricGRPC, metricHTTP)
+}
+
+func newLogExporter(ctx context.Context, endpoint string, cfg OTLPConfig) (sdklog.Exporter, error) {
+	return newExporter(ctx, endpoint, "logs", cfg, logGRPC, logHTTP)
+}
+
+var traceGRPC = exporter[otlptracegrpc.Option, trace.SpanExporter]{
+	new: func(ctx context.Context, opts ...otlptracegrpc.Option) (trace.SpanExporter, error) {
+		return otlptracegrpc.New(ctx, opts...)
+	},
+	headers: otlptracegrpc.WithHeaders,
+	retry: func(enabled bool, maxElapsed time.Duration) otlptracegrpc.Option {
+		return otlptracegrpc.WithRetry(otlptracegrpc.RetryConfig{
+			Enabled:         enabled,
+			InitialInterval: retryInitialInterval,
+			MaxInterval:     retryMaxInterval,
+			MaxElapsedTime:  maxElapsed,
+		})
+	},
+	endpoint:    otlptracegrpc.WithEndpoint,
+	endpointURL: otlptracegrpc.WithEndpointURL,
+	tls: func(c *tls.Config) otlptracegrpc.Option {
+		return otlptracegrpc.WithTLSCredentials(credentials.NewTLS(c))
+	},
+	insecure: otlptracegrpc.WithInsecure,
+	gzip:     func() otlptracegrpc.Option { return otlptracegrpc.WithCompressor("gzip") },
+	timeout:  otlptracegrpc.WithTimeout,
+}
+
+var traceHTTP = exporter[otlptracehttp.Option, trace.SpanExporter]{
+	new: func(ctx context.Context, opts ...otlptracehttp.Option) (trace.SpanExporter, error) {
+		return otlptracehttp.New(ctx, opts...)
+	},
+	headers: otlptracehttp.WithHeaders,
+	retry: func(enabled bool, maxElapsed time.Duration) otlptracehttp.Option {
+		return otlptracehttp.WithRetry(otlptracehttp.RetryConfig{
+			Enabled:         enabled,
+			InitialInterval: retryInitialInterval,
+			MaxInterval:     retryMaxInterval,
+			MaxElapsedTime:  maxElapsed,
+		})
+	},
+	endpoint:    otlptracehttp.WithEndpoint,
+	endpointURL: otlptracehttp.WithEndpointURL,
+	tls:         otlptracehttp.WithTLSClientConfig,
+	insecure:    otlptracehttp.WithInsecure,
+	gzip:        func() otlptracehttp.Option { return otlptracehttp.WithCompression(otlptracehttp.GzipCompression) },
+	timeout:     otlptracehttp.WithTimeout,
+}
+
+var metricGRPC = exporter[otlpmetricgrpc.Option, metric.Exporter]{
+	new: func(ctx context.Context, opts ...otlpmetricgrpc.Option) (metric.Exporter, error) {
+		return otlpmetricgrpc.New(ctx, opts...)
+	},
+	headers: otlpmetricgrpc.WithHeaders,
+	retry: func(enabled bool, maxElapsed time.Duration) otlpmetricgrpc.Option {
+		return otlpmetricgrpc.WithRetry(otlpmetricgrpc.RetryConfig{
+			Enabled:         enabled,
+			InitialInterval: retryInitialInterval,
+			MaxInterval:     retryMaxInterval,
+			MaxElapsedTime:  maxElapsed,
+		})
+	},
+	endpoint:    otlpmetricgrpc.WithEndpoint,
+	endpointURL: otlpmetricgrpc.WithEndpointURL,
+	tls: func(c *tls.Config) otlpmetricgrpc.Option {
+		return otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(c))
+	},
+	insecure: otlpmetricgrpc.WithInsecure,
+	gzip:     func() otlpmetricgrpc.Option { return otlpmetricgrpc.WithCompressor("gzip") },
+	timeout:  otlpmetricgrpc.WithTimeout,
+}
+
+var metricHTTP = exporter[otlpmetrichttp.Option, metric.Exporter]{
+	new: func(ctx context.Context, opts ...otlpmetrichttp.Option) (metric.Exporter, error) {
+		return otlpmetrichttp.New(ctx, opts...)
+	},
+	headers: otlpmetrichttp.WithHeaders,
+	retry: func(enabled bool, maxElapsed time.Duration) otlpmetrichttp.Option {
+		return otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig{
+			Enabled:         enabled,
+			InitialInterval: retryInitialInterval,
+			MaxInterval:     retryMaxInterval,
+			MaxElapsedTime:  maxElapsed,
+		})
+	},
+	endpoint:    otlpmetrichttp.WithEndpoint,
+	endpointURL: otlpmetrichttp.WithEndpointURL,
+	tls:         otlpmetrichttp.WithTLSClientConfig,
+	insecure:    otlpmetrichttp.WithInsecure,
+	gzip:        func() otlpmetrichttp.Option { return otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression) },
+	timeout:     otlpmetrichttp.WithTimeout,
+}
+
+var logGRPC = exporter[otlploggrpc.Option, sdklog.Exporter]{
+	new: func(ctx context.Context, opts ...otlploggrpc.Option) (sdklog.Exporter, error) {
+		return otlploggrpc.New(ctx, opts...)
+	},
+	headers: otlploggrpc.WithHeaders,
+	retry: func(enabled bool, maxElapsed time.Duration) otlploggrpc.Option {
+		return otlploggrpc.WithRetry(otlploggrpc.RetryConfig{
+			Enabled:         enabled,
+			InitialInterval: retryInitialInterval,
+			MaxInterval:     retryMaxInterval,
+			MaxElapsedTime:  maxElapsed,
+		})
+	},
+	endpoint:    otlploggrpc.WithEndpoint,
+	endpointURL: otlploggrpc.WithEndpointURL,
+	tls: func(c *tls.Config) otlploggrpc.Option {
+		return otlploggrpc.WithTLSCredentials(credentials.NewTLS(c))
+	},
+	insecure: otlploggrpc.WithInsecure,
+	gzip:     func() otlploggrpc.Option { return otlploggrpc.WithCompressor("gzip") },
+	timeout:  otlploggrpc.WithTimeout,
+}
+
+var logHTTP = exporter[otlploghttp.Option, sdklog.Exporter]{
+	new: func(ctx context.Context, opts ...otlploghttp.Option) (sdklog.Exporter, error) {
+		return otlploghttp.New(ctx, opts...)
+	},
+	headers: otlploghttp.WithHeaders,
+	retry: func(enabled bool, maxElapsed time.Duration) otlploghttp.Option {
+		return otlploghttp.WithRetry(otlploghttp.RetryConfig{
+			Enabled:         enabled,
+			InitialInterval: retryInitialInterval,
+			MaxInterval:     retryMaxInterval,
+			MaxElapsedTime:  maxElapsed,
+		})
+	},
+	endpoint:    otlploghttp.WithEndpoint,
+	endpointURL: otlploghttp.WithEndpointURL,
+	tls:         otlploghttp.WithTLSClientConfig,
+	insecure:    otlploghttp.WithInsecure,
+	gzip:        func() otlploghttp.Option { return otlploghttp.WithCompression(otlploghttp.GzipCompression) },
+	timeout:     otlploghttp.WithTimeout,
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package telemetry
+
+import "time"
+
+// processCPUTime is unavailable where getrusage does not exist
+func processCPUTime() (user, system time.Duration, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// Kubernetes metadata is read from variables a pod spec sets through the
+// downward API, for example:
+//
+//	env:
+//	  - name: K8S_POD_NAME
+//	    valueFrom: {fieldRef: {fieldPath: metadata.name}}
+var k8sEnv = []struct {
+	env  string
+	attr func(string) attribute.KeyValue
+}{
+	{"K8S_POD_NAME", semconv.K8SPodName},
+	{"K8S_POD_UID", semconv.K8SPodUID},
+	{"K8S_NAMESPACE_NAME", semconv.K8SNamespaceName},
+	{"K8S_NODE_NAME", semconv.K8SNodeName},
+	{"K8S_CONTAINER_NAME", semconv.K8SContainerName},
+}
+
+// WithEnvironment records the deployment environment on all telemetry
+func WithEnvironment(name string) Option {
+	return func(o *options) {
+		o.environment = name
+	}
+}
+
+// k8sDetector describes the pod from downward API variables
+type k8sDetector struct{}
+
+func (k8sDetector) Detect(context.Context) (*resource.Resource, error) {
+	var attrs []attribute.KeyValue
+	for _, e := range k8sEnv {
+		if v := os.Getenv(e.env); v != "" {
+			attrs = append(attrs, e.attr(v))
+		}
+	}
+	if len(attrs) == 0 {
+		return resource.Empty(), nil
+	}
+	return resource.NewWithAttributes(semconv.SchemaURL, attrs...), nil
+}
+
+// instanceDetector names the replica: the pod when running in Kubernetes,
+// otherwise the host
+type instanceDetector struct{}
+
+func (instanceDetector) Detect(context.Context) (*resource.Resource, error) {
+	id := os.Getenv("K8S_POD_NAME")
+	if id == "" {
+		var err error
+		if id, err = os.Hostname(); err != nil {
+			return resource.Empty(), err
+		}
+	}
+	return resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceInstanceID(id)), nil
+}
+
+// newResource describes this process. Detected attributes come first,
+// then OTEL_RESOURCE_ATTRIBUTES, which can override any of them, then the
+// service identity.
+func newResource(ctx context.Context, serviceName, serviceVersion, environment string) (*resource.Resource, error) {
+	attrs := []attribute.KeyValue{
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(serviceVersion),
+	}
+	if environment != "" {
+		attrs = append(attrs, semconv.DeploymentEnvironmentName(environment))
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithOS(),
+		resource.WithContainer(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithProcessPID(),
+		resource.WithDetectors(instanceDetector{}, k8sDetector{}),
+		resource.WithFromEnv(),
+		resource.WithAttributes(attrs...),
+	)
+	// A detector that finds nothing, such as the container ID outside a
+	// container, leaves a partial resource that is still worth using
+	if errors.Is(err, resource.ErrPartialResource) {
+		slog.Debug("Some resource attributes could not be detected", "error", err)
+		err = nil
+	}
+	return res, err
+}
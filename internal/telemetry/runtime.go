@@ -0,0 +1,100 @@
+package telemetry
+
+import (
+	"context"
+	"os"
+	"runtime/metrics"
+	"sync"
+
+	otelruntime "go.opentelemetry.io/contrib/instrumentation/runtime"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WithRuntimeMetrics reports Go runtime and process metrics through the
+// meter provider: goroutines, heap and GC from the runtime instrumentation,
+// plus GC pause time, process CPU time and open file descriptors
+func WithRuntimeMetrics(enabled bool) Option {
+	return func(o *options) {
+		o.runtimeMetrics = enabled
+	}
+}
+
+// Runtime samples read for the metrics the runtime instrumentation lacks
+const (
+	gcPauseSample  = "/cpu/classes/gc/pause:cpu-seconds"
+	gcCyclesSample = "/gc/cycles/total:gc-cycles"
+)
+
+func startRuntimeMetrics(mp metric.MeterProvider) error {
+	if err := otelruntime.Start(otelruntime.WithMeterProvider(mp)); err != nil {
+		return err
+	}
+
+	meter := mp.Meter("worker-runtime")
+
+	gcPause, err := meter.Float64ObservableCounter("go.gc.pause.time",
+		metric.WithDescription("CPU time spent in stop-the-world GC pauses"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	gcCycles, err := meter.Int64ObservableCounter("go.gc.cycles",
+		metric.WithDescription("Completed GC cycles"),
+		metric.WithUnit("{cycle}"))
+	if err != nil {
+		return err
+	}
+	cpuTime, err := meter.Float64ObservableCounter("process.cpu.time",
+		metric.WithDescription("CPU time used by the process"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	openFDs, err := meter.Int64ObservableUpDownCounter("process.open_file_descriptor.count",
+		metric.WithDescription("File descriptors open in the process"),
+		metric.WithUnit("{file_descriptor}"))
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	samples := []metrics.Sample{{Name: gcPauseSample}, {Name: gcCyclesSample}}
+	user := metric.WithAttributeSet(attribute.NewSet(attribute.String("cpu.mode", "user")))
+	system := metric.WithAttributeSet(attribute.NewSet(attribute.String("cpu.mode", "system")))
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		mu.Lock()
+		metrics.Read(samples)
+		if samples[0].Value.Kind() == metrics.KindFloat64 {
+			o.ObserveFloat64(gcPause, samples[0].Value.Float64())
+		}
+		if samples[1].Value.Kind() == metrics.KindUint64 {
+			o.ObserveInt64(gcCycles, int64(samples[1].Value.Uint64()))
+		}
+		mu.Unlock()
+
+		// Process statistics are skipped where the platform has none
+		if u, s, ok := processCPUTime(); ok {
+			o.ObserveFloat64(cpuTime, u.Seconds(), user)
+			o.ObserveFloat64(cpuTime, s.Seconds(), system)
+		}
+		if n, ok := openFileDescriptors(); ok {
+			o.ObserveInt64(openFDs, n)
+		}
+		return nil
+	}, gcPause, gcCycles, cpuTime, openFDs)
+	return err
+}
+
+// openFileDescriptors counts the entries of the process's descriptor
+// directory, /proc/self/fd on Linux and /dev/fd on the BSDs and macOS
+func openFileDescriptors() (int64, bool) {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		if entries, err := os.ReadDir(dir); err == nil {
+			// Reading the directory holds one descriptor open itself
+			return int64(len(entries)) - 1, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,158 @@
+package telemetry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Samplers selectable in SamplingConfig, named as in OTEL_TRACES_SAMPLER
+const (
+	SamplerAlwaysOn                = "always_on"
+	SamplerAlwaysOff               = "always_off"
+	SamplerTraceIDRatio            = "traceidratio"
+	SamplerParentBasedAlwaysOn     = "parentbased_always_on"
+	SamplerParentBasedAlwaysOff    = "parentbased_always_off"
+	SamplerParentBasedTraceIDRatio = "parentbased_traceidratio"
+)
+
+// SamplingConfig decides which traces are exported. Without WithSampling
+// every trace is.
+type SamplingConfig struct {
+	Sampler string
+
+	// Ratio is the fraction of traces kept by the ratio samplers
+	Ratio float64
+
+	// RouteRatios replace the root sampler for traces starting at a
+	// matching URL path. A path ending in "/" matches everything below it
+	// and the longest match wins, as with http.ServeMux. Parent-based
+	// samplers still follow an incoming sampling decision.
+	RouteRatios map[string]float64
+
+	// Errors exports spans that end with an error status even when their
+	// trace was not sampled. Unsampled spans are then recorded, which costs
+	// some allocation, and an exported error span may lack its parent.
+	Errors bool
+
+	// Tail keeps whole traces rather than single spans; it includes what
+	// Errors does
+	Tail TailSamplingConfig
+}
+
+// WithSampling configures trace sampling
+func WithSampling(cfg SamplingConfig) Option {
+	return func(o *options) {
+		o.sampling = cfg
+	}
+}
+
+func newSampler(cfg SamplingConfig) (trace.Sampler, error) {
+	var root trace.Sampler
+	switch strings.TrimPrefix(cfg.Sampler, "parentbased_") {
+	case SamplerAlwaysOn:
+		root = trace.AlwaysSample()
+	case SamplerAlwaysOff:
+		root = trace.NeverSample()
+	case SamplerTraceIDRatio:
+		root = trace.TraceIDRatioBased(cfg.Ratio)
+	default:
+		return nil, fmt.Errorf("unknown trace sampler %q", cfg.Sampler)
+	}
+
+	if len(cfg.RouteRatios) > 0 {
+		routes := &routeSampler{fallback: root, samplers: make(map[string]trace.Sampler, len(cfg.RouteRatios))}
+		for path, ratio := range cfg.RouteRatios {
+			routes.paths = append(routes.paths, path)
+			routes.samplers[path] = trace.TraceIDRatioBased(ratio)
+		}
+		// Longest first so the first match is the most specific
+		sort.Slice(routes.paths, func(i, j int) bool { return len(routes.paths[i]) > len(routes.paths[j]) })
+		root = routes
+	}
+
+	sampler := root
+	if strings.HasPrefix(cfg.Sampler, "parentbased_") {
+		sampler = trace.ParentBased(root)
+	}
+	if cfg.Errors || cfg.Tail.Enabled {
+		sampler = recordingSampler{sampler}
+	}
+	return sampler, nil
+}
+
+// routeSampler picks a sampler by the url.path attribute otelhttp sets
+// when it starts the server span
+type routeSampler struct {
+	fallback trace.Sampler
+	paths    []string
+	samplers map[string]trace.Sampler
+}
+
+func (s *routeSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	for _, attr := range p.Attributes {
+		if attr.Key != semconv.URLPathKey {
+			continue
+		}
+		path := attr.Value.AsString()
+		for _, pattern := range s.paths {
+			if path == pattern || (strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern)) {
+				return s.samplers[pattern].ShouldSample(p)
+			}
+		}
+		break
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s *routeSampler) Description() string {
+	return fmt.Sprintf("RouteSampler{routes:%d,fallback:%s}", len(s.paths), s.fallback.Description())
+}
+
+// recordingSampler records the spans its sampler drops, so that the error
+// and tail span processors can still export the ones that matter
+type recordingSampler struct {
+	trace.Sampler
+}
+
+func (s recordingSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if result.Decision == trace.Drop {
+		result.Decision = trace.RecordOnly
+	}
+	return result
+}
+
+func (s recordingSampler) Description() string {
+	return "RecordingSampler{" + s.Sampler.Description() + "}"
+}
+
+// errorSpanProcessor passes spans to the wrapped processor, marking unsampled spans that
+// ended in error as sampled so they are exported too
+type errorSpanProcessor struct {
+	trace.SpanProcessor
+}
+
+func (p errorSpanProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		if s.Status().Code != codes.Error {
+			return
+		}
+		s = sampledSpan{s}
+	}
+	p.SpanProcessor.OnEnd(s)
+}
+
+type sampledSpan struct {
+	trace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() oteltrace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
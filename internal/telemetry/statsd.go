@@ -0,0 +1,305 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// StatsD line formats selectable in StatsDConfig
+const (
+	StatsDFlavorStatsD    = "statsd"
+	StatsDFlavorDogStatsD = "dogstatsd"
+)
+
+// statsdMaxPacket keeps datagrams within a typical network MTU
+const statsdMaxPacket = 1432
+
+// StatsDConfig configures metrics emission over StatsD
+type StatsDConfig struct {
+	// host:port for UDP, or unix:///path for a Unix datagram socket such
+	// as the Datadog agent's
+	Addr string
+
+	// Prepended to every metric name
+	Prefix string
+
+	// StatsDFlavorStatsD or StatsDFlavorDogStatsD. Plain StatsD has no
+	// tags, so a metric's series are merged into one.
+	Flavor string
+
+	// name:value tags added to every metric, DogStatsD only
+	Tags []string
+
+	// How often metrics are sent
+	Interval time.Duration
+}
+
+// WithStatsD also sends metrics over StatsD, alongside the exporter chosen
+// with WithMetricsExporter, which may be none
+func WithStatsD(cfg StatsDConfig) Option {
+	return func(o *options) {
+		o.statsd = cfg
+	}
+}
+
+// statsdExporter writes metric data as StatsD lines. Counters are sent as
+// the increase since the last export, gauges and up-down counters as
+// their value. StatsD timings are single measurements, which the SDK has
+// already aggregated into histogram buckets, so each non-empty bucket is
+// sent as one measurement at its midpoint with a sample rate of one over
+// its count: the server counts every measurement, and percentiles are as
+// precise as the buckets.
+type statsdExporter struct {
+	cfg  StatsDConfig
+	tags string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newStatsDExporter(cfg StatsDConfig) (*statsdExporter, error) {
+	network, addr := "udp", cfg.Addr
+	if path, ok := strings.CutPrefix(cfg.Addr, "unix://"); ok {
+		network, addr = "unixgram", path
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+
+	e := &statsdExporter{cfg: cfg, conn: conn}
+	if cfg.Flavor == StatsDFlavorDogStatsD {
+		tags := make([]string, len(cfg.Tags))
+		for i, tag := range cfg.Tags {
+			tags[i] = statsdSanitize(tag, ",|#@")
+		}
+		e.tags = strings.Join(tags, ",")
+	}
+	return e, nil
+}
+
+// Temporality makes sums and histograms deltas, as StatsD servers add up
+// what they receive, except for values that are not added up
+func (e *statsdExporter) Temporality(kind metric.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case metric.InstrumentKindUpDownCounter, metric.InstrumentKindObservableUpDownCounter,
+		metric.InstrumentKindGauge, metric.InstrumentKindObservableGauge:
+		return metricdata.CumulativeTemporality
+	}
+	return metricdata.DeltaTemporality
+}
+
+func (e *statsdExporter) Aggregation(kind metric.InstrumentKind) metric.Aggregation {
+	return metric.DefaultAggregationSelector(kind)
+}
+
+func (e *statsdExporter) Export(_ context.Context, rm *metricdata.ResourceMetrics) error {
+	var lines []string
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			lines = e.appendMetric(lines, m)
+		}
+	}
+	return e.send(lines)
+}
+
+// appendMetric appends the lines for one metric
+func (e *statsdExporter) appendMetric(lines []string, m metricdata.Metrics) []string {
+	name := statsdSanitize(e.cfg.Prefix+m.Name, ":|@#")
+	switch data := m.Data.(type) {
+	case metricdata.Sum[int64]:
+		lines = appendSum(e, lines, name, data)
+	case metricdata.Sum[float64]:
+		lines = appendSum(e, lines, name, data)
+	case metricdata.Gauge[int64]:
+		for _, dp := range data.DataPoints {
+			lines = append(lines, e.line(name, float64(dp.Value), "g", 1, dp.Attributes))
+		}
+	case metricdata.Gauge[float64]:
+		for _, dp := range data.DataPoints {
+			lines = append(lines, e.line(name, dp.Value, "g", 1, dp.Attributes))
+		}
+	case metricdata.Histogram[int64]:
+		lines = appendHistogram(e, lines, name, statsdUnit(m), data)
+	case metricdata.Histogram[float64]:
+		lines = appendHistogram(e, lines, name, statsdUnit(m), data)
+	}
+	return lines
+}
+
+// statsdUnit is the metric's unit, taken from a _seconds suffix for
+// instruments created without one
+func statsdUnit(m metricdata.Metrics) string {
+	if m.Unit == "" && strings.HasSuffix(m.Name, "_seconds") {
+		return "s"
+	}
+	return m.Unit
+}
+
+func appendSum[N int64 | float64](e *statsdExporter, lines []string, name string, data metricdata.Sum[N]) []string {
+	for _, dp := range data.DataPoints {
+		if !data.IsMonotonic || data.Temporality != metricdata.DeltaTemporality {
+			lines = append(lines, e.line(name, float64(dp.Value), "g", 1, dp.Attributes))
+		} else if dp.Value != 0 {
+			lines = append(lines, e.line(name, float64(dp.Value), "c", 1, dp.Attributes))
+		}
+	}
+	return lines
+}
+
+func appendHistogram[N int64 | float64](e *statsdExporter, lines []string, name, unit string, data metricdata.Histogram[N]) []string {
+	// Durations become timings in milliseconds; anything else is a
+	// histogram where the flavor has them
+	kind, scale := "ms", 1.0
+	switch unit {
+	case "s":
+		scale = 1000
+	case "ms":
+	default:
+		if e.cfg.Flavor == StatsDFlavorDogStatsD {
+			kind = "h"
+		}
+	}
+
+	for _, dp := range data.DataPoints {
+		minimum, hasMin := dp.Min.Value()
+		maximum, hasMax := dp.Max.Value()
+		for i, count := range dp.BucketCounts {
+			if count == 0 {
+				continue
+			}
+			// Buckets are (bounds[i-1], bounds[i]]; the outer ones are
+			// closed by the extremes
+			lo, hi := 0.0, 0.0
+			switch {
+			case len(dp.Bounds) == 0:
+				lo, hi = float64(minimum), float64(maximum)
+			case i == 0:
+				lo, hi = dp.Bounds[0], dp.Bounds[0]
+				if hasMin {
+					lo = float64(minimum)
+				}
+			case i == len(dp.Bounds):
+				lo, hi = dp.Bounds[i-1], dp.Bounds[i-1]
+				if hasMax {
+					hi = float64(maximum)
+				}
+			default:
+				lo, hi = dp.Bounds[i-1], dp.Bounds[i]
+			}
+			if hasMin {
+				lo = max(lo, float64(minimum))
+			}
+			if hasMax {
+				hi = min(hi, float64(maximum))
+			}
+			lines = append(lines, e.line(name, (lo+hi)/2*scale, kind, 1/float64(count), dp.Attributes))
+		}
+	}
+	return lines
+}
+
+// line formats one StatsD line, with tags for DogStatsD
+func (e *statsdExporter) line(name string, value float64, kind string, rate float64, attrs attribute.Set) string {
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(kind)
+	if rate < 1 {
+		b.WriteString("|@")
+		b.WriteString(strconv.FormatFloat(rate, 'g', 6, 64))
+	}
+	if e.cfg.Flavor != StatsDFlavorDogStatsD || (attrs.Len() == 0 && e.tags == "") {
+		return b.String()
+	}
+
+	b.WriteString("|#")
+	b.WriteString(e.tags)
+	sep := e.tags != ""
+	for iter := attrs.Iter(); iter.Next(); {
+		kv := iter.Attribute()
+		if sep {
+			b.WriteByte(',')
+		}
+		sep = true
+		b.WriteString(statsdSanitize(string(kv.Key), ",|#@:"))
+		b.WriteByte(':')
+		b.WriteString(statsdSanitize(kv.Value.Emit(), ",|#@"))
+	}
+	return b.String()
+}
+
+// send writes lines in datagrams of at most statsdMaxPacket bytes. A line
+// too long for one datagram is sent alone.
+func (e *statsdExporter) send(lines []string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return errors.New("statsd: exporter is shut down")
+	}
+
+	var errs []error
+	var packet bytes.Buffer
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(packet.Bytes()); err != nil {
+			errs = append(errs, err)
+		}
+		packet.Reset()
+	}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+	if len(errs) > 0 {
+		// Failed datagrams usually fail alike, such as when nothing listens
+		return fmt.Errorf("statsd: %d of the datagrams not sent: %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+func (e *statsdExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *statsdExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// statsdSanitize replaces the characters StatsD lines use as separators
+func statsdSanitize(s, reserved string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(reserved, r) || r == '\n' {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package telemetry
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// NewSyslogHandler fails where log/syslog does not exist
+func NewSyslogHandler(network, addr, tag string, opts *slog.HandlerOptions) (slog.Handler, func() error, error) {
+	return nil, nil, errors.New("syslog is not supported on this platform")
+}
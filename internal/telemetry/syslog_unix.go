@@ -0,0 +1,146 @@
+//go:build unix
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"log/syslog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// syslogQueueSize is how many messages may wait for a slow syslog
+	// server before new ones are dropped
+	syslogQueueSize = 1024
+
+	// syslogCloseTimeout bounds how long closing waits for queued
+	// messages to be sent
+	syslogCloseTimeout = 5 * time.Second
+)
+
+// NewSyslogHandler sends records as JSON to syslog, with a severity
+// following their level. An empty network dials the local syslog socket,
+// which journald also serves; otherwise addr is a host:port reached over
+// network, udp or tcp. Records are sent in the background, so a stalled
+// server never holds up logging; when it falls behind, records are dropped
+// and their number reported once it catches up. The returned function
+// sends what is queued and closes the connection.
+func NewSyslogHandler(network, addr, tag string, opts *slog.HandlerOptions) (slog.Handler, func() error, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := &syslogOutput{
+		w:     w,
+		queue: make(chan syslogMessage, syslogQueueSize),
+		done:  make(chan struct{}),
+	}
+	go out.run()
+	return &syslogHandler{Handler: slog.NewJSONHandler(&out.buf, opts), out: out}, out.close, nil
+}
+
+type syslogMessage struct {
+	level slog.Level
+	text  string
+}
+
+// syslogOutput is shared by a handler and those derived from it: each
+// record is formatted into buf under mu and queued as one message
+type syslogOutput struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+
+	queue   chan syslogMessage
+	dropped atomic.Int64
+	done    chan struct{}
+	w       *syslog.Writer
+}
+
+// run sends queued messages until the queue is closed. Send errors are
+// not logged, as logging them would come back here.
+func (o *syslogOutput) run() {
+	defer close(o.done)
+	for m := range o.queue {
+		_ = o.send(m)
+		if n := o.dropped.Swap(0); n > 0 {
+			_ = o.w.Warning(fmt.Sprintf(`{"time":%q,"level":"WARN","msg":"Dropped syslog messages, server too slow","dropped":%d}`,
+				time.Now().Format(time.RFC3339Nano), n))
+		}
+	}
+}
+
+func (o *syslogOutput) send(m syslogMessage) error {
+	switch {
+	case m.level >= slog.LevelError:
+		return o.w.Err(m.text)
+	case m.level >= slog.LevelWarn:
+		return o.w.Warning(m.text)
+	case m.level >= slog.LevelInfo:
+		return o.w.Info(m.text)
+	default:
+		return o.w.Debug(m.text)
+	}
+}
+
+func (o *syslogOutput) close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	close(o.queue)
+	o.mu.Unlock()
+
+	closed := make(chan error, 1)
+	go func() {
+		<-o.done
+		closed <- o.w.Close()
+	}()
+	select {
+	case err := <-closed:
+		return err
+	case <-time.After(syslogCloseTimeout):
+		return errors.New("syslog server did not take the queued messages in time")
+	}
+}
+
+type syslogHandler struct {
+	slog.Handler
+	out *syslogOutput
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	if h.out.closed {
+		return nil
+	}
+
+	h.out.buf.Reset()
+	if err := h.Handler.Handle(ctx, r); err != nil {
+		return err
+	}
+	m := syslogMessage{level: r.Level, text: string(bytes.TrimSuffix(h.out.buf.Bytes(), []byte("\n")))}
+	select {
+	case h.out.queue <- m:
+	default:
+		h.out.dropped.Add(1)
+	}
+	return nil
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), out: h.out}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), out: h.out}
+}
@@ -0,0 +1,151 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// TailSamplingConfig keeps traces the head sampler dropped when they turn
+// out to be interesting: a span ended in error or took at least Latency.
+// Their spans are held in memory until the local root span ends, or for
+// at most Wait, so spans that end later are lost.
+type TailSamplingConfig struct {
+	Enabled bool
+	Latency time.Duration
+	Wait    time.Duration
+
+	// MaxTraces bounds the traces held at once; spans of further traces
+	// are dropped until some are decided
+	MaxTraces int
+}
+
+// tailSpanProcessor buffers unsampled spans per trace and passes the whole
+// trace to the wrapped processor once it is known to be worth keeping.
+// Sampled spans go straight through.
+type tailSpanProcessor struct {
+	trace.SpanProcessor
+	cfg TailSamplingConfig
+
+	mu     sync.Mutex
+	traces map[oteltrace.TraceID]*pendingTrace
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+type pendingTrace struct {
+	spans   []trace.ReadOnlySpan
+	keep    bool
+	expires time.Time
+}
+
+func newTailSpanProcessor(next trace.SpanProcessor, cfg TailSamplingConfig) *tailSpanProcessor {
+	p := &tailSpanProcessor{
+		SpanProcessor: next,
+		cfg:           cfg,
+		traces:        make(map[oteltrace.TraceID]*pendingTrace),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go p.expireLoop()
+	return p
+}
+
+func (p *tailSpanProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.SpanProcessor.OnEnd(s)
+		return
+	}
+
+	id := s.SpanContext().TraceID()
+	p.mu.Lock()
+	t, ok := p.traces[id]
+	if !ok {
+		if len(p.traces) >= p.cfg.MaxTraces {
+			p.mu.Unlock()
+			return
+		}
+		t = &pendingTrace{expires: time.Now().Add(p.cfg.Wait)}
+		p.traces[id] = t
+	}
+	t.spans = append(t.spans, s)
+	if s.Status().Code == codes.Error || s.EndTime().Sub(s.StartTime()) >= p.cfg.Latency {
+		t.keep = true
+	}
+
+	// The local root ends last, so the trace is complete as far as this
+	// process can tell
+	root := !s.Parent().IsValid() || s.Parent().IsRemote()
+	if root {
+		delete(p.traces, id)
+	}
+	p.mu.Unlock()
+
+	if root {
+		p.export(t)
+	}
+}
+
+func (p *tailSpanProcessor) export(t *pendingTrace) {
+	if !t.keep {
+		return
+	}
+	for _, s := range t.spans {
+		p.SpanProcessor.OnEnd(sampledSpan{s})
+	}
+}
+
+// expireLoop decides traces whose root has not ended within Wait
+func (p *tailSpanProcessor) expireLoop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(max(p.cfg.Wait/4, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			var expired []*pendingTrace
+			p.mu.Lock()
+			for id, t := range p.traces {
+				if now.After(t.expires) {
+					expired = append(expired, t)
+					delete(p.traces, id)
+				}
+			}
+			p.mu.Unlock()
+
+			for _, t := range expired {
+				p.export(t)
+			}
+		}
+	}
+}
+
+// drain exports the pending traces already known to be kept. Only
+// shutdown does this, since later spans of a drained trace would be lost.
+func (p *tailSpanProcessor) drain() {
+	p.mu.Lock()
+	pending := p.traces
+	p.traces = make(map[oteltrace.TraceID]*pendingTrace)
+	p.mu.Unlock()
+
+	for _, t := range pending {
+		p.export(t)
+	}
+}
+
+func (p *tailSpanProcessor) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	<-p.done
+	p.drain()
+	return p.SpanProcessor.Shutdown(ctx)
+}
@@ -3,23 +3,119 @@ package telemetry
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/exemplar"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
+// Metrics exporters selectable with WithMetricsExporter
+const (
+	MetricsExporterOTLP       = "otlp"
+	MetricsExporterPrometheus = "prometheus"
+	MetricsExporterBoth       = "both"
+
+	// Neither, for metrics sent over StatsD alone; see WithStatsD
+	MetricsExporterNone = "none"
+)
+
+// promRegistry holds the instruments exported to Prometheus. It is global
+// like the meter provider that feeds it.
+var promRegistry = prometheus.NewRegistry()
+
+// newPromExporter creates a Prometheus exporter registering with reg. The
+// Grafana dashboard uses one to find the names scraped.
+func newPromExporter(reg prometheus.Registerer) (*otelprom.Exporter, error) {
+	return otelprom.New(otelprom.WithRegisterer(reg))
+}
+
+type options struct {
+	metricsExporter string
+	otlp            OTLPConfig
+	sampling        SamplingConfig
+	runtimeMetrics  bool
+	buckets         map[string][]float64
+	exemplarFilter  string
+	logs            bool
+	environment     string
+	statsd          StatsDConfig
+}
+
+// Option configures optional parts of the telemetry pipeline
+type Option func(*options)
+
+// WithMetricsExporter selects where metrics go: pushed over OTLP, exposed
+// for Prometheus to scrape through MetricsHandler, both, or neither. The
+// default is OTLP.
+func WithMetricsExporter(exporter string) Option {
+	return func(o *options) {
+		o.metricsExporter = exporter
+	}
+}
+
+// WithHistogramBuckets sets explicit bucket boundaries for histograms by
+// instrument name, replacing the SDK defaults
+func WithHistogramBuckets(buckets map[string][]float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// Exemplar filters selectable with WithExemplarFilter, named as in
+// OTEL_METRICS_EXEMPLAR_FILTER
+const (
+	ExemplarFilterTraceBased = "trace_based"
+	ExemplarFilterAlwaysOn   = "always_on"
+	ExemplarFilterAlwaysOff  = "always_off"
+)
+
+// WithExemplarFilter selects which measurements may become exemplars. The
+// default, trace_based, keeps those recorded within a sampled span, so
+// each exemplar links a histogram bucket to a trace that was exported.
+func WithExemplarFilter(filter string) Option {
+	return func(o *options) {
+		o.exemplarFilter = filter
+	}
+}
+
+// WithLogs exports log records over OTLP through a logger provider
+// installed globally; see NewLogBridge
+func WithLogs(enabled bool) Option {
+	return func(o *options) {
+		o.logs = enabled
+	}
+}
+
+// MetricsHandler serves the metrics registered with the Prometheus
+// exporter. Scrapers that negotiate OpenMetrics also get exemplars.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
 // SetupOTelSDK bootstraps the OpenTelemetry pipeline.
 // If it does not return an error, make sure to call shutdown for proper cleanup.
-func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion, otelEndpoint string) (shutdown func(context.Context) error, err error) {
+func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion, otelEndpoint string, opts ...Option) (shutdown func(context.Context) error, err error) {
+	o := options{
+		metricsExporter: MetricsExporterOTLP,
+		exemplarFilter:  ExemplarFilterTraceBased,
+		otlp:            OTLPConfig{Protocol: ProtocolGRPC, Insecure: true, Retry: true},
+		sampling:        SamplingConfig{Sampler: SamplerAlwaysOn},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	var shutdownFuncs []func(context.Context) error
 
 	// shutdown calls cleanup functions registered via shutdownFuncs.
@@ -40,7 +136,7 @@ func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion, otelEndpoint
 	}
 
 	// Set up resource.
-	res, err := newResource(serviceName, serviceVersion)
+	res, err := newResource(ctx, serviceName, serviceVersion, o.environment)
 	if err != nil {
 		handleErr(err)
 		return
@@ -51,34 +147,50 @@ func SetupOTelSDK(ctx context.Context, serviceName, serviceVersion, otelEndpoint
 	otel.SetTextMapPropagator(prop)
 
 	// Set up trace provider.
-	tracerProvider, err := newTraceProvider(res, otelEndpoint)
+	tracerProvider, err := newTraceProvider(ctx, res, otelEndpoint, o.otlp, o.sampling)
 	if err != nil {
 		handleErr(err)
 		return
 	}
 	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
-	otel.SetTracerProvider(tracerProvider)
 
 	// Set up meter provider.
-	meterProvider, err := newMeterProvider(res, otelEndpoint)
+	meterProvider, err := newMeterProvider(ctx, res, otelEndpoint, o)
 	if err != nil {
 		handleErr(err)
 		return
 	}
 	shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
+
+	if o.runtimeMetrics {
+		if err = startRuntimeMetrics(meterProvider); err != nil {
+			handleErr(err)
+			return
+		}
+	}
+
+	// Set up logger provider.
+	var loggerProvider *sdklog.LoggerProvider
+	if o.logs {
+		loggerProvider, err = newLoggerProvider(ctx, res, otelEndpoint, o.otlp)
+		if err != nil {
+			handleErr(err)
+			return
+		}
+		shutdownFuncs = append(shutdownFuncs, loggerProvider.Shutdown)
+	}
+
+	// Providers are installed only once the whole pipeline is up, so a
+	// failed setup leaves the no-op globals in place
+	otel.SetTracerProvider(tracerProvider)
 	otel.SetMeterProvider(meterProvider)
+	if loggerProvider != nil {
+		global.SetLoggerProvider(loggerProvider)
+	}
 
 	return
 }
 
-func newResource(serviceName, serviceVersion string) (*resource.Resource, error) {
-	return resource.Merge(resource.Default(),
-		resource.NewWithAttributes(semconv.SchemaURL,
-			semconv.ServiceName(serviceName),
-			semconv.ServiceVersion(serviceVersion),
-		))
-}
-
 func newPropagator() propagation.TextMapPropagator {
 	return propagation.NewCompositeTextMapPropagator(
 		propagation.TraceContext{},
@@ -86,55 +198,111 @@ func newPropagator() propagation.TextMapPropagator {
 	)
 }
 
-func newTraceProvider(res *resource.Resource, otelEndpoint string) (*trace.TracerProvider, error) {
-	// Create connection to OTEL Collector
-	conn, err := grpc.DialContext(context.Background(), otelEndpoint,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
+func newTraceProvider(ctx context.Context, res *resource.Resource, otelEndpoint string, otlp OTLPConfig, sampling SamplingConfig) (*trace.TracerProvider, error) {
+	sampler, err := newSampler(sampling)
 	if err != nil {
 		return nil, err
 	}
 
-	traceExporter, err := otlptracegrpc.New(context.Background(),
-		otlptracegrpc.WithGRPCConn(conn),
-	)
+	// The exporter connects lazily and retries, so a collector that is
+	// down at startup does not hold up the server
+	traceExporter, err := newTraceExporter(ctx, otelEndpoint, otlp)
 	if err != nil {
 		return nil, err
 	}
 
+	var processor trace.SpanProcessor = trace.NewBatchSpanProcessor(traceExporter,
+		// Default is 5s. Set to 1s for demonstrative purposes.
+		trace.WithBatchTimeout(time.Second))
+	switch {
+	case sampling.Tail.Enabled:
+		processor = newTailSpanProcessor(processor, sampling.Tail)
+	case sampling.Errors:
+		processor = errorSpanProcessor{processor}
+	}
+
 	traceProvider := trace.NewTracerProvider(
-		trace.WithBatcher(traceExporter,
-			// Default is 5s. Set to 1s for demonstrative purposes.
-			trace.WithBatchTimeout(time.Second)),
+		trace.WithSpanProcessor(baggageSpanProcessor{}),
+		trace.WithSpanProcessor(processor),
 		trace.WithResource(res),
-		trace.WithSampler(trace.AlwaysSample()),
+		trace.WithSampler(sampler),
 	)
 	return traceProvider, nil
 }
 
-func newMeterProvider(res *resource.Resource, otelEndpoint string) (*metric.MeterProvider, error) {
-	// Create connection to OTEL Collector
-	conn, err := grpc.DialContext(context.Background(), otelEndpoint,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
+func newLoggerProvider(ctx context.Context, res *resource.Resource, otelEndpoint string, otlp OTLPConfig) (*sdklog.LoggerProvider, error) {
+	logExporter, err := newLogExporter(ctx, otelEndpoint, otlp)
 	if err != nil {
 		return nil, err
 	}
 
-	metricExporter, err := otlpmetricgrpc.New(context.Background(),
-		otlpmetricgrpc.WithGRPCConn(conn),
-	)
-	if err != nil {
-		return nil, err
+	return sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
+		sdklog.WithResource(res),
+	), nil
+}
+
+func newMeterProvider(ctx context.Context, res *resource.Resource, otelEndpoint string, o options) (*metric.MeterProvider, error) {
+	exporter := o.metricsExporter
+
+	var filter exemplar.Filter
+	switch o.exemplarFilter {
+	case ExemplarFilterTraceBased:
+		filter = exemplar.TraceBasedFilter
+	case ExemplarFilterAlwaysOn:
+		filter = exemplar.AlwaysOnFilter
+	case ExemplarFilterAlwaysOff:
+		filter = exemplar.AlwaysOffFilter
+	default:
+		return nil, fmt.Errorf("unknown exemplar filter %q", o.exemplarFilter)
 	}
 
-	meterProvider := metric.NewMeterProvider(
-		metric.WithReader(metric.NewPeriodicReader(metricExporter,
-			// Default is 1m. Set to 3s for demonstrative purposes.
-			metric.WithInterval(3*time.Second))),
+	providerOpts := []metric.Option{
 		metric.WithResource(res),
-	)
-	return meterProvider, nil
+		metric.WithExemplarFilter(filter),
+		metric.WithView(recordInstruments),
+	}
+	for name, boundaries := range o.buckets {
+		providerOpts = append(providerOpts, metric.WithView(metric.NewView(
+			metric.Instrument{Name: name},
+			metric.Stream{Aggregation: metric.AggregationExplicitBucketHistogram{Boundaries: boundaries}},
+		)))
+	}
+
+	switch exporter {
+	case MetricsExporterOTLP, MetricsExporterBoth, MetricsExporterPrometheus, MetricsExporterNone:
+	default:
+		return nil, fmt.Errorf("unknown metrics exporter %q", exporter)
+	}
+
+	if exporter == MetricsExporterOTLP || exporter == MetricsExporterBoth {
+		metricExporter, err := newMetricExporter(ctx, otelEndpoint, o.otlp)
+		if err != nil {
+			return nil, err
+		}
+
+		providerOpts = append(providerOpts, metric.WithReader(metric.NewPeriodicReader(metricExporter,
+			// Default is 1m. Set to 3s for demonstrative purposes.
+			metric.WithInterval(3*time.Second))))
+	}
+
+	if exporter == MetricsExporterPrometheus || exporter == MetricsExporterBoth {
+		// The Prometheus exporter is a pull reader collected on each scrape
+		promExporter, err := newPromExporter(promRegistry)
+		if err != nil {
+			return nil, err
+		}
+		providerOpts = append(providerOpts, metric.WithReader(promExporter))
+	}
+
+	if o.statsd.Addr != "" {
+		statsdExporter, err := newStatsDExporter(o.statsd)
+		if err != nil {
+			return nil, err
+		}
+		providerOpts = append(providerOpts, metric.WithReader(metric.NewPeriodicReader(statsdExporter,
+			metric.WithInterval(o.statsd.Interval))))
+	}
+
+	return metric.NewMeterProvider(providerOpts...), nil
 }
@@ -0,0 +1,108 @@
+// Package useragent classifies User-Agent headers into a browser, an
+// operating system and a device class. It recognises the common families
+// by their tokens rather than parsing versions, so every value comes from
+// a small fixed set and is safe to use as a metric attribute.
+package useragent
+
+import "strings"
+
+// Device classes
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceBot     = "bot"
+)
+
+// Other is the browser or operating system of agents matching no family
+const Other = "other"
+
+// Info is the classification of a User-Agent
+type Info struct {
+	Browser string `json:"browser"`
+	OS      string `json:"os"`
+	Device  string `json:"device"`
+}
+
+type family struct {
+	name   string
+	tokens []string
+}
+
+// Browsers are matched in order, since most agents also name the engines
+// they are compatible with: Edge and Opera claim Chrome, Chrome claims
+// Safari.
+var browsers = []family{
+	{"edge", []string{"Edg/", "Edge/", "EdgA/", "EdgiOS/"}},
+	{"opera", []string{"OPR/", "Opera"}},
+	{"samsung", []string{"SamsungBrowser/"}},
+	{"firefox", []string{"Firefox/", "FxiOS/"}},
+	{"chrome", []string{"Chrome/", "CriOS/"}},
+	{"safari", []string{"Safari/"}},
+	{"ie", []string{"MSIE ", "Trident/"}},
+}
+
+// Operating systems are matched in order: Android claims Linux, iOS claims
+// Mac OS X
+var operatingSystems = []family{
+	{"windows", []string{"Windows"}},
+	{"ios", []string{"iPhone", "iPad", "iPod"}},
+	{"android", []string{"Android"}},
+	{"chromeos", []string{"CrOS"}},
+	{"macos", []string{"Macintosh", "Mac OS X"}},
+	{"linux", []string{"Linux", "X11"}},
+}
+
+// botTokens mark crawlers, monitors, HTTP libraries and headless browsers;
+// they are matched case-insensitively
+var botTokens = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "monitor", "headless",
+	"phantomjs", "lighthouse", "curl/", "wget/", "httpie/", "python-",
+	"go-http-client", "java/", "okhttp", "axios/", "node-fetch",
+	"facebookexternalhit", "libwww",
+}
+
+// mobileTokens mark phones and tablets
+var mobileTokens = []string{"Mobi", "Android", "iPhone", "iPad", "iPod", "Windows Phone", "Tablet"}
+
+// Parse classifies ua. An empty agent is a bot, since every browser sends
+// one.
+func Parse(ua string) Info {
+	info := Info{
+		Browser: match(ua, browsers),
+		OS:      match(ua, operatingSystems),
+		Device:  DeviceDesktop,
+	}
+	switch {
+	case IsBot(ua):
+		info.Device = DeviceBot
+	case containsAny(ua, mobileTokens):
+		info.Device = DeviceMobile
+	}
+	return info
+}
+
+// IsBot reports whether ua belongs to an automated client
+func IsBot(ua string) bool {
+	if ua == "" {
+		return true
+	}
+	return containsAny(strings.ToLower(ua), botTokens)
+}
+
+func match(ua string, families []family) string {
+	for _, f := range families {
+		if containsAny(ua, f.tokens) {
+			return f.name
+		}
+	}
+	return Other
+}
+
+func containsAny(s string, tokens []string) bool {
+	for _, t := range tokens {
+		if strings.Contains(s, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+// Package enrich lets code outside this module add custom enrichment to
+// the worker's events. An enricher registers itself from an init
+// function, and a build of the worker imports its package for the side
+// effect:
+//
+//	func init() {
+//		enrich.Register("customer_id", enrich.Func(
+//			func(ctx context.Context, event *enrich.Event) error {
+//				...
+//			}))
+//	}
+package enrich
+
+import (
+	"sync"
+
+	"github.com/niquet/rate-limited-worker/internal/service"
+)
+
+// Event is a tracking event as enrichers see it
+type Event = service.TrackingEvent
+
+// Enricher adds to events before the worker processes them. Enrich is
+// called concurrently, may change any field but Tenant, and only has its
+// errors logged.
+type Enricher = service.Enricher
+
+// Func adapts a function to Enricher
+type Func = service.EnricherFunc
+
+// Registration is an enricher and the name it is logged and traced under
+type Registration struct {
+	Name     string
+	Enricher Enricher
+}
+
+var (
+	mu         sync.Mutex
+	registered []Registration
+)
+
+// Register adds an enricher to the worker, run after the built-in
+// enrichment and the enrichers registered before it. It must be called
+// before the worker starts, such as from an init function.
+func Register(name string, e Enricher) {
+	mu.Lock()
+	defer mu.Unlock()
+	registered = append(registered, Registration{Name: name, Enricher: e})
+}
+
+// Registered returns the registered enrichers, in the order registered
+func Registered() []Registration {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Registration(nil), registered...)
+}
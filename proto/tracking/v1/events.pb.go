@@ -0,0 +1,406 @@
+// The live feed of processed events, served over gRPC to internal
+// consumers as a typed alternative to polling the HTTP API. Events are
+// those forwarded to sinks: after bot, consent and privacy rules, and
+// without replay chunks.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: tracking/v1/events.proto
+
+package trackingv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Which events a subscriber receives; every event when empty
+type SubscribeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Event types to receive; all when empty
+	EventTypes []string `protobuf:"bytes,1,rep,name=event_types,json=eventTypes,proto3" json:"event_types,omitempty"`
+	// Only the events of this tenant, when tenancy is enabled
+	Tenant string `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// Only the events of this session
+	SessionId string `protobuf:"bytes,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	// Also receive the events the bot filter tagged
+	IncludeBots   bool `protobuf:"varint,4,opt,name=include_bots,json=includeBots,proto3" json:"include_bots,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_tracking_v1_events_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracking_v1_events_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_tracking_v1_events_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscribeRequest) GetEventTypes() []string {
+	if x != nil {
+		return x.EventTypes
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetIncludeBots() bool {
+	if x != nil {
+		return x.IncludeBots
+	}
+	return false
+}
+
+type SubscribeResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Event         *ProcessedEvent        `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeResponse) Reset() {
+	*x = SubscribeResponse{}
+	mi := &file_tracking_v1_events_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeResponse) ProtoMessage() {}
+
+func (x *SubscribeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tracking_v1_events_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeResponse.ProtoReflect.Descriptor instead.
+func (*SubscribeResponse) Descriptor() ([]byte, []int) {
+	return file_tracking_v1_events_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubscribeResponse) GetEvent() *ProcessedEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+// A tracking event with the fields the server sets
+type ProcessedEvent struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Event    *TrackingEvent         `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	Tenant   string                 `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	ClientIp string                 `protobuf:"bytes,3,opt,name=client_ip,json=clientIp,proto3" json:"client_ip,omitempty"`
+	Browser  string                 `protobuf:"bytes,4,opt,name=browser,proto3" json:"browser,omitempty"`
+	Os       string                 `protobuf:"bytes,5,opt,name=os,proto3" json:"os,omitempty"`
+	Device   string                 `protobuf:"bytes,6,opt,name=device,proto3" json:"device,omitempty"`
+	Country  string                 `protobuf:"bytes,7,opt,name=country,proto3" json:"country,omitempty"`
+	Region   string                 `protobuf:"bytes,8,opt,name=region,proto3" json:"region,omitempty"`
+	City     string                 `protobuf:"bytes,9,opt,name=city,proto3" json:"city,omitempty"`
+	// Set from the DNT and Sec-GPC headers
+	DoNotTrack bool `protobuf:"varint,10,opt,name=do_not_track,json=doNotTrack,proto3" json:"do_not_track,omitempty"`
+	// Set by the bot filter when the event looks automated
+	Bot       bool   `protobuf:"varint,11,opt,name=bot,proto3" json:"bot,omitempty"`
+	BotReason string `protobuf:"bytes,12,opt,name=bot_reason,json=botReason,proto3" json:"bot_reason,omitempty"`
+	// The session's variant of each experiment
+	Experiments map[string]string `protobuf:"bytes,13,rep,name=experiments,proto3" json:"experiments,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// The cursor position as a percentage of the viewport, when the event
+	// gives the viewport size
+	CursorXPercent *float64 `protobuf:"fixed64,14,opt,name=cursor_x_percent,json=cursorXPercent,proto3,oneof" json:"cursor_x_percent,omitempty"`
+	CursorYPercent *float64 `protobuf:"fixed64,15,opt,name=cursor_y_percent,json=cursorYPercent,proto3,oneof" json:"cursor_y_percent,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ProcessedEvent) Reset() {
+	*x = ProcessedEvent{}
+	mi := &file_tracking_v1_events_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProcessedEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProcessedEvent) ProtoMessage() {}
+
+func (x *ProcessedEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_tracking_v1_events_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProcessedEvent.ProtoReflect.Descriptor instead.
+func (*ProcessedEvent) Descriptor() ([]byte, []int) {
+	return file_tracking_v1_events_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ProcessedEvent) GetEvent() *TrackingEvent {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *ProcessedEvent) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetClientIp() string {
+	if x != nil {
+		return x.ClientIp
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetBrowser() string {
+	if x != nil {
+		return x.Browser
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetOs() string {
+	if x != nil {
+		return x.Os
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetDevice() string {
+	if x != nil {
+		return x.Device
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetCity() string {
+	if x != nil {
+		return x.City
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetDoNotTrack() bool {
+	if x != nil {
+		return x.DoNotTrack
+	}
+	return false
+}
+
+func (x *ProcessedEvent) GetBot() bool {
+	if x != nil {
+		return x.Bot
+	}
+	return false
+}
+
+func (x *ProcessedEvent) GetBotReason() string {
+	if x != nil {
+		return x.BotReason
+	}
+	return ""
+}
+
+func (x *ProcessedEvent) GetExperiments() map[string]string {
+	if x != nil {
+		return x.Experiments
+	}
+	return nil
+}
+
+func (x *ProcessedEvent) GetCursorXPercent() float64 {
+	if x != nil && x.CursorXPercent != nil {
+		return *x.CursorXPercent
+	}
+	return 0
+}
+
+func (x *ProcessedEvent) GetCursorYPercent() float64 {
+	if x != nil && x.CursorYPercent != nil {
+		return *x.CursorYPercent
+	}
+	return 0
+}
+
+var File_tracking_v1_events_proto protoreflect.FileDescriptor
+
+const file_tracking_v1_events_proto_rawDesc = "" +
+	"\n" +
+	"\x18tracking/v1/events.proto\x12\x12worker.tracking.v1\x1a\x1atracking/v1/tracking.proto\"\x8d\x01\n" +
+	"\x10SubscribeRequest\x12\x1f\n" +
+	"\vevent_types\x18\x01 \x03(\tR\n" +
+	"eventTypes\x12\x16\n" +
+	"\x06tenant\x18\x02 \x01(\tR\x06tenant\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\tR\tsessionId\x12!\n" +
+	"\finclude_bots\x18\x04 \x01(\bR\vincludeBots\"M\n" +
+	"\x11SubscribeResponse\x128\n" +
+	"\x05event\x18\x01 \x01(\v2\".worker.tracking.v1.ProcessedEventR\x05event\"\xf8\x04\n" +
+	"\x0eProcessedEvent\x127\n" +
+	"\x05event\x18\x01 \x01(\v2!.worker.tracking.v1.TrackingEventR\x05event\x12\x16\n" +
+	"\x06tenant\x18\x02 \x01(\tR\x06tenant\x12\x1b\n" +
+	"\tclient_ip\x18\x03 \x01(\tR\bclientIp\x12\x18\n" +
+	"\abrowser\x18\x04 \x01(\tR\abrowser\x12\x0e\n" +
+	"\x02os\x18\x05 \x01(\tR\x02os\x12\x16\n" +
+	"\x06device\x18\x06 \x01(\tR\x06device\x12\x18\n" +
+	"\acountry\x18\a \x01(\tR\acountry\x12\x16\n" +
+	"\x06region\x18\b \x01(\tR\x06region\x12\x12\n" +
+	"\x04city\x18\t \x01(\tR\x04city\x12 \n" +
+	"\fdo_not_track\x18\n" +
+	" \x01(\bR\n" +
+	"doNotTrack\x12\x10\n" +
+	"\x03bot\x18\v \x01(\bR\x03bot\x12\x1d\n" +
+	"\n" +
+	"bot_reason\x18\f \x01(\tR\tbotReason\x12U\n" +
+	"\vexperiments\x18\r \x03(\v23.worker.tracking.v1.ProcessedEvent.ExperimentsEntryR\vexperiments\x12-\n" +
+	"\x10cursor_x_percent\x18\x0e \x01(\x01H\x00R\x0ecursorXPercent\x88\x01\x01\x12-\n" +
+	"\x10cursor_y_percent\x18\x0f \x01(\x01H\x01R\x0ecursorYPercent\x88\x01\x01\x1a>\n" +
+	"\x10ExperimentsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x13\n" +
+	"\x11_cursor_x_percentB\x13\n" +
+	"\x11_cursor_y_percent2j\n" +
+	"\fEventService\x12Z\n" +
+	"\tSubscribe\x12$.worker.tracking.v1.SubscribeRequest\x1a%.worker.tracking.v1.SubscribeResponse0\x01BDZBgithub.com/niquet/rate-limited-worker/proto/tracking/v1;trackingv1b\x06proto3"
+
+var (
+	file_tracking_v1_events_proto_rawDescOnce sync.Once
+	file_tracking_v1_events_proto_rawDescData []byte
+)
+
+func file_tracking_v1_events_proto_rawDescGZIP() []byte {
+	file_tracking_v1_events_proto_rawDescOnce.Do(func() {
+		file_tracking_v1_events_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tracking_v1_events_proto_rawDesc), len(file_tracking_v1_events_proto_rawDesc)))
+	})
+	return file_tracking_v1_events_proto_rawDescData
+}
+
+var file_tracking_v1_events_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_tracking_v1_events_proto_goTypes = []any{
+	(*SubscribeRequest)(nil),  // 0: worker.tracking.v1.SubscribeRequest
+	(*SubscribeResponse)(nil), // 1: worker.tracking.v1.SubscribeResponse
+	(*ProcessedEvent)(nil),    // 2: worker.tracking.v1.ProcessedEvent
+	nil,                       // 3: worker.tracking.v1.ProcessedEvent.ExperimentsEntry
+	(*TrackingEvent)(nil),     // 4: worker.tracking.v1.TrackingEvent
+}
+var file_tracking_v1_events_proto_depIdxs = []int32{
+	2, // 0: worker.tracking.v1.SubscribeResponse.event:type_name -> worker.tracking.v1.ProcessedEvent
+	4, // 1: worker.tracking.v1.ProcessedEvent.event:type_name -> worker.tracking.v1.TrackingEvent
+	3, // 2: worker.tracking.v1.ProcessedEvent.experiments:type_name -> worker.tracking.v1.ProcessedEvent.ExperimentsEntry
+	0, // 3: worker.tracking.v1.EventService.Subscribe:input_type -> worker.tracking.v1.SubscribeRequest
+	1, // 4: worker.tracking.v1.EventService.Subscribe:output_type -> worker.tracking.v1.SubscribeResponse
+	4, // [4:5] is the sub-list for method output_type
+	3, // [3:4] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_tracking_v1_events_proto_init() }
+func file_tracking_v1_events_proto_init() {
+	if File_tracking_v1_events_proto != nil {
+		return
+	}
+	file_tracking_v1_tracking_proto_init()
+	file_tracking_v1_events_proto_msgTypes[2].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tracking_v1_events_proto_rawDesc), len(file_tracking_v1_events_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tracking_v1_events_proto_goTypes,
+		DependencyIndexes: file_tracking_v1_events_proto_depIdxs,
+		MessageInfos:      file_tracking_v1_events_proto_msgTypes,
+	}.Build()
+	File_tracking_v1_events_proto = out.File
+	file_tracking_v1_events_proto_goTypes = nil
+	file_tracking_v1_events_proto_depIdxs = nil
+}
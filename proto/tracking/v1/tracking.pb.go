@@ -0,0 +1,379 @@
+// Tracking events as POST /api/track takes them with Content-Type
+// application/x-protobuf. Fields mean what the JSON fields of the same
+// names do; those the server sets, such as the client IP, browser and
+// country, are left out.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: tracking/v1/tracking.proto
+
+package trackingv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TrackingEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Payload schema version, at most the server's current one. Protobuf
+	// payloads have one form whatever the version.
+	SchemaVersion int32  `protobuf:"varint,1,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	EventType     string `protobuf:"bytes,2,opt,name=event_type,json=eventType,proto3" json:"event_type,omitempty"`
+	// Time of the event; the server's receive time when unset
+	Timestamp   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	CursorX     int32                  `protobuf:"varint,4,opt,name=cursor_x,json=cursorX,proto3" json:"cursor_x,omitempty"`
+	CursorY     int32                  `protobuf:"varint,5,opt,name=cursor_y,json=cursorY,proto3" json:"cursor_y,omitempty"`
+	ElementId   string                 `protobuf:"bytes,6,opt,name=element_id,json=elementId,proto3" json:"element_id,omitempty"`
+	ElementType string                 `protobuf:"bytes,7,opt,name=element_type,json=elementType,proto3" json:"element_type,omitempty"`
+	PageUrl     string                 `protobuf:"bytes,8,opt,name=page_url,json=pageUrl,proto3" json:"page_url,omitempty"`
+	// Replaced by the request's User-Agent header
+	UserAgent   string           `protobuf:"bytes,9,opt,name=user_agent,json=userAgent,proto3" json:"user_agent,omitempty"`
+	SessionId   string           `protobuf:"bytes,10,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	ViewportX   int32            `protobuf:"varint,11,opt,name=viewport_x,json=viewportX,proto3" json:"viewport_x,omitempty"`
+	ViewportY   int32            `protobuf:"varint,12,opt,name=viewport_y,json=viewportY,proto3" json:"viewport_y,omitempty"`
+	ScrollX     int32            `protobuf:"varint,13,opt,name=scroll_x,json=scrollX,proto3" json:"scroll_x,omitempty"`
+	ScrollY     int32            `protobuf:"varint,14,opt,name=scroll_y,json=scrollY,proto3" json:"scroll_y,omitempty"`
+	PageHeight  int32            `protobuf:"varint,15,opt,name=page_height,json=pageHeight,proto3" json:"page_height,omitempty"`
+	ElementText string           `protobuf:"bytes,16,opt,name=element_text,json=elementText,proto3" json:"element_text,omitempty"`
+	Custom      *structpb.Struct `protobuf:"bytes,17,opt,name=custom,proto3" json:"custom,omitempty"`
+	// Grants or declines full processing; unset leaves it to the consent
+	// policy and the request's DNT and Sec-GPC headers
+	Consent *bool `protobuf:"varint,18,opt,name=consent,proto3,oneof" json:"consent,omitempty"`
+	// The recording chunk of a replay event
+	Replay        *ReplayChunk `protobuf:"bytes,19,opt,name=replay,proto3" json:"replay,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TrackingEvent) Reset() {
+	*x = TrackingEvent{}
+	mi := &file_tracking_v1_tracking_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackingEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackingEvent) ProtoMessage() {}
+
+func (x *TrackingEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_tracking_v1_tracking_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackingEvent.ProtoReflect.Descriptor instead.
+func (*TrackingEvent) Descriptor() ([]byte, []int) {
+	return file_tracking_v1_tracking_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TrackingEvent) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetEventType() string {
+	if x != nil {
+		return x.EventType
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *TrackingEvent) GetCursorX() int32 {
+	if x != nil {
+		return x.CursorX
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetCursorY() int32 {
+	if x != nil {
+		return x.CursorY
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetElementId() string {
+	if x != nil {
+		return x.ElementId
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetElementType() string {
+	if x != nil {
+		return x.ElementType
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetPageUrl() string {
+	if x != nil {
+		return x.PageUrl
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetUserAgent() string {
+	if x != nil {
+		return x.UserAgent
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetViewportX() int32 {
+	if x != nil {
+		return x.ViewportX
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetViewportY() int32 {
+	if x != nil {
+		return x.ViewportY
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetScrollX() int32 {
+	if x != nil {
+		return x.ScrollX
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetScrollY() int32 {
+	if x != nil {
+		return x.ScrollY
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetPageHeight() int32 {
+	if x != nil {
+		return x.PageHeight
+	}
+	return 0
+}
+
+func (x *TrackingEvent) GetElementText() string {
+	if x != nil {
+		return x.ElementText
+	}
+	return ""
+}
+
+func (x *TrackingEvent) GetCustom() *structpb.Struct {
+	if x != nil {
+		return x.Custom
+	}
+	return nil
+}
+
+func (x *TrackingEvent) GetConsent() bool {
+	if x != nil && x.Consent != nil {
+		return *x.Consent
+	}
+	return false
+}
+
+func (x *TrackingEvent) GetReplay() *ReplayChunk {
+	if x != nil {
+		return x.Replay
+	}
+	return nil
+}
+
+type ReplayChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sequence      int32                  `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Encoding      string                 `protobuf:"bytes,2,opt,name=encoding,proto3" json:"encoding,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplayChunk) Reset() {
+	*x = ReplayChunk{}
+	mi := &file_tracking_v1_tracking_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplayChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplayChunk) ProtoMessage() {}
+
+func (x *ReplayChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_tracking_v1_tracking_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplayChunk.ProtoReflect.Descriptor instead.
+func (*ReplayChunk) Descriptor() ([]byte, []int) {
+	return file_tracking_v1_tracking_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReplayChunk) GetSequence() int32 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *ReplayChunk) GetEncoding() string {
+	if x != nil {
+		return x.Encoding
+	}
+	return ""
+}
+
+func (x *ReplayChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+var File_tracking_v1_tracking_proto protoreflect.FileDescriptor
+
+const file_tracking_v1_tracking_proto_rawDesc = "" +
+	"\n" +
+	"\x1atracking/v1/tracking.proto\x12\x12worker.tracking.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xad\x05\n" +
+	"\rTrackingEvent\x12%\n" +
+	"\x0eschema_version\x18\x01 \x01(\x05R\rschemaVersion\x12\x1d\n" +
+	"\n" +
+	"event_type\x18\x02 \x01(\tR\teventType\x128\n" +
+	"\ttimestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x19\n" +
+	"\bcursor_x\x18\x04 \x01(\x05R\acursorX\x12\x19\n" +
+	"\bcursor_y\x18\x05 \x01(\x05R\acursorY\x12\x1d\n" +
+	"\n" +
+	"element_id\x18\x06 \x01(\tR\telementId\x12!\n" +
+	"\felement_type\x18\a \x01(\tR\velementType\x12\x19\n" +
+	"\bpage_url\x18\b \x01(\tR\apageUrl\x12\x1d\n" +
+	"\n" +
+	"user_agent\x18\t \x01(\tR\tuserAgent\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\n" +
+	" \x01(\tR\tsessionId\x12\x1d\n" +
+	"\n" +
+	"viewport_x\x18\v \x01(\x05R\tviewportX\x12\x1d\n" +
+	"\n" +
+	"viewport_y\x18\f \x01(\x05R\tviewportY\x12\x19\n" +
+	"\bscroll_x\x18\r \x01(\x05R\ascrollX\x12\x19\n" +
+	"\bscroll_y\x18\x0e \x01(\x05R\ascrollY\x12\x1f\n" +
+	"\vpage_height\x18\x0f \x01(\x05R\n" +
+	"pageHeight\x12!\n" +
+	"\felement_text\x18\x10 \x01(\tR\velementText\x12/\n" +
+	"\x06custom\x18\x11 \x01(\v2\x17.google.protobuf.StructR\x06custom\x12\x1d\n" +
+	"\aconsent\x18\x12 \x01(\bH\x00R\aconsent\x88\x01\x01\x127\n" +
+	"\x06replay\x18\x13 \x01(\v2\x1f.worker.tracking.v1.ReplayChunkR\x06replayB\n" +
+	"\n" +
+	"\b_consent\"Y\n" +
+	"\vReplayChunk\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x05R\bsequence\x12\x1a\n" +
+	"\bencoding\x18\x02 \x01(\tR\bencoding\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04dataBDZBgithub.com/niquet/rate-limited-worker/proto/tracking/v1;trackingv1b\x06proto3"
+
+var (
+	file_tracking_v1_tracking_proto_rawDescOnce sync.Once
+	file_tracking_v1_tracking_proto_rawDescData []byte
+)
+
+func file_tracking_v1_tracking_proto_rawDescGZIP() []byte {
+	file_tracking_v1_tracking_proto_rawDescOnce.Do(func() {
+		file_tracking_v1_tracking_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tracking_v1_tracking_proto_rawDesc), len(file_tracking_v1_tracking_proto_rawDesc)))
+	})
+	return file_tracking_v1_tracking_proto_rawDescData
+}
+
+var file_tracking_v1_tracking_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_tracking_v1_tracking_proto_goTypes = []any{
+	(*TrackingEvent)(nil),         // 0: worker.tracking.v1.TrackingEvent
+	(*ReplayChunk)(nil),           // 1: worker.tracking.v1.ReplayChunk
+	(*timestamppb.Timestamp)(nil), // 2: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),       // 3: google.protobuf.Struct
+}
+var file_tracking_v1_tracking_proto_depIdxs = []int32{
+	2, // 0: worker.tracking.v1.TrackingEvent.timestamp:type_name -> google.protobuf.Timestamp
+	3, // 1: worker.tracking.v1.TrackingEvent.custom:type_name -> google.protobuf.Struct
+	1, // 2: worker.tracking.v1.TrackingEvent.replay:type_name -> worker.tracking.v1.ReplayChunk
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_tracking_v1_tracking_proto_init() }
+func file_tracking_v1_tracking_proto_init() {
+	if File_tracking_v1_tracking_proto != nil {
+		return
+	}
+	file_tracking_v1_tracking_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tracking_v1_tracking_proto_rawDesc), len(file_tracking_v1_tracking_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_tracking_v1_tracking_proto_goTypes,
+		DependencyIndexes: file_tracking_v1_tracking_proto_depIdxs,
+		MessageInfos:      file_tracking_v1_tracking_proto_msgTypes,
+	}.Build()
+	File_tracking_v1_tracking_proto = out.File
+	file_tracking_v1_tracking_proto_goTypes = nil
+	file_tracking_v1_tracking_proto_depIdxs = nil
+}